@@ -4,6 +4,7 @@ import (
 	"github.com/IlianBuh/filemanager-server/internal/app"
 	"github.com/IlianBuh/filemanager-server/internal/config"
 	"github.com/IlianBuh/filemanager-server/internal/lib/logger/slogpretty"
+	"github.com/IlianBuh/filemanager-server/internal/lib/tracing"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -23,16 +24,43 @@ func main() {
 
 	log.Info("logger initialized", slog.Any("cfg", cfg))
 
-	application := app.New(log, cfg.GRPCObj.Port, cfg.RootPath, cfg.GRPCObj.Timeout)
+	tracer := setUpTracing(cfg, log)
+
+	application := app.New(
+		log,
+		cfg.GRPCObj.Port,
+		cfg.RootPath,
+		cfg.GRPCObj.Timeout,
+		cfg.Metrics.Port,
+		cfg.Metrics.DiskUsageInterval,
+		cfg.Health.Interval,
+		cfg.Health.MinFreeSpace,
+		cfg.Replication,
+		cfg.Journal,
+		cfg.Watch,
+		cfg.Compression,
+		cfg.Encryption,
+		cfg.Dedup,
+	)
 
 	go application.GRPCApp.MustRun()
+	go application.MetricsApp.MustRun()
+	go application.Replicator.MustRun()
+	go application.Journal.MustRun()
+	go application.Watcher.MustRun()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	sign := <-stop
 	log.Info("received signal", slog.Any("signal", sign))
+	// watch streams end only when watcher is stopped
+	application.Watcher.Stop()
 	application.GRPCApp.Stop()
+	application.Replicator.Stop()
+	application.Journal.Stop()
+	application.MetricsApp.Stop()
+	tracer.Stop()
 }
 
 func setUpLogger(cfg *config.Config) *slog.Logger {
@@ -54,6 +82,17 @@ func setUpLogger(cfg *config.Config) *slog.Logger {
 	return log
 }
 
+func setUpTracing(cfg *config.Config, log *slog.Logger) *tracing.Provider {
+	return tracing.MustNew(log, tracing.Options{
+		ServiceName: "filemanager",
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		FilePath:    cfg.Tracing.FilePath,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+}
+
 func setUpPrettyLogger() *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
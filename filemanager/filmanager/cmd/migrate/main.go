@@ -0,0 +1,64 @@
+// Command migrate converts files in the root of filemanager to compression,
+// encryption and deduplication which are selected for them by the config.
+//
+// Stop filemanager, change compression, encryption or dedup in its config
+// and run migrate with the same config. Interrupted migration is continued by
+// running it again.
+//
+// To rotate master key, put the new key first and keep the old ones, run
+// migrate, which rewraps data keys of files without re-encrypting them,
+// and remove the old keys.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/IlianBuh/filemanager-server/internal/app"
+	"github.com/IlianBuh/filemanager-server/internal/config"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report files which have to be converted")
+
+	cfg := config.New()
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store := app.NewStore(cfg.Compression, cfg.Encryption, cfg.Dedup)
+	fm := filemanager.New(log, cfg.RootPath, store, cfg.GRPCObj.Timeout)
+
+	res, err := fm.Migrate(ctx, *dryRun)
+	if err != nil {
+		log.Error("migration is not finished", sl.Err(err), slog.Int("converted", res.Converted))
+		os.Exit(1)
+	}
+
+	log.Info("migration is finished",
+		slog.Int("files", res.Files),
+		slog.Int("converted", res.Converted),
+		slog.Int("rewrapped", res.Rewrapped),
+		slog.Int("chunks", res.Chunks),
+		slog.Int64("before", res.Before),
+		slog.Int64("after", res.After),
+	)
+
+	if store.Dedup() {
+		stats := store.DedupStats()
+		log.Info("deduplication",
+			slog.Int("chunks", stats.Chunks),
+			slog.Int64("logical", stats.Logical),
+			slog.Int64("unique", stats.Unique),
+			slog.Int64("stored", stats.Stored),
+			slog.Float64("ratio", stats.Ratio()),
+		)
+	}
+}
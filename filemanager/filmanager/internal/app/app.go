@@ -2,13 +2,24 @@ package app
 
 import (
 	grpcapp "github.com/IlianBuh/filemanager-server/internal/app/grpc"
+	metricsapp "github.com/IlianBuh/filemanager-server/internal/app/metrics"
+	"github.com/IlianBuh/filemanager-server/internal/config"
+	"github.com/IlianBuh/filemanager-server/internal/lib/health"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
 	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"github.com/IlianBuh/filemanager-server/internal/services/journal"
+	"github.com/IlianBuh/filemanager-server/internal/services/replication"
+	"github.com/IlianBuh/filemanager-server/internal/services/watch"
 	"log/slog"
 	"time"
 )
 
 type App struct {
-	GRPCApp *grpcapp.App
+	GRPCApp    *grpcapp.App
+	MetricsApp *metricsapp.App
+	Replicator *replication.Replicator
+	Journal    *journal.Journal
+	Watcher    *watch.Watcher
 }
 
 func New(
@@ -16,13 +27,90 @@ func New(
 	port string,
 	rootPath string,
 	timeout time.Duration,
-
+	metricsPort string,
+	diskUsageInterval time.Duration,
+	healthInterval time.Duration,
+	minFreeSpace uint64,
+	replCfg config.Replication,
+	journalCfg config.Journal,
+	watchCfg config.Watch,
+	compressionCfg config.Compression,
+	encryptionCfg config.Encryption,
+	dedupCfg config.Dedup,
 ) *App {
 
-	fm := filemanager.New(log, rootPath, timeout)
+	store := NewStore(compressionCfg, encryptionCfg, dedupCfg)
+	fm := filemanager.New(log, rootPath, store, timeout)
+
+	journal, err := journal.New(log, journal.Options{
+		Dir:             journalCfg.Dir,
+		SegmentSize:     journalCfg.SegmentSize,
+		Retention:       journalCfg.Retention,
+		CompactInterval: journalCfg.CompactInterval,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fm.Observe(journal)
+
+	watcher, err := watch.New(log, rootPath, watch.Options{
+		Inotify:  watchCfg.Inotify,
+		Buffer:   watchCfg.Buffer,
+		Debounce: watchCfg.Debounce,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fm.Observe(watcher)
+
+	replicator, err := replication.New(log, rootPath, store, replication.Options{
+		Peers:         replCfg.Peers,
+		Ack:           replCfg.Ack,
+		QueueDir:      replCfg.QueueDir,
+		QueueLimit:    replCfg.QueueLimit,
+		SyncTimeout:   replCfg.SyncTimeout,
+		Timeout:       replCfg.Timeout,
+		RetryInterval: replCfg.RetryInterval,
+		Secret:        replCfg.Secret,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fm.Observe(replicator)
+	checker := health.New(log, rootPath, minFreeSpace)
 
-	grpcapp := grpcapp.New(log, port, fm)
+	grpcapp := grpcapp.New(log, port, fm, journal, watcher, checker, healthInterval)
+	metricsapp := metricsapp.New(log, metricsPort, rootPath, diskUsageInterval, checker, store)
 	return &App{
-		GRPCApp: grpcapp,
+		GRPCApp:    grpcapp,
+		MetricsApp: metricsapp,
+		Replicator: replicator,
+		Journal:    journal,
+		Watcher:    watcher,
 	}
 }
+
+// NewStore creates store which encodes files as compression,
+// encryption and dedup configs select
+func NewStore(
+	compressionCfg config.Compression,
+	encryptionCfg config.Encryption,
+	dedupCfg config.Dedup,
+) *storage.Store {
+	keys, err := storage.LoadKeys(encryptionCfg.KeyFile, encryptionCfg.Keys)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := storage.New(storage.Policy{
+		Default: compressionCfg.Algorithm,
+		Dirs:    compressionCfg.Dirs,
+		Encrypt: encryptionCfg.Enabled,
+		Dedup:   dedupCfg.Enabled,
+	}, keys)
+	if err != nil {
+		panic(err)
+	}
+
+	return store
+}
@@ -3,31 +3,57 @@ package grpcapp
 import (
 	"fmt"
 	grpcfm "github.com/IlianBuh/filemanager-server/internal/grpc"
+	"github.com/IlianBuh/filemanager-server/internal/lib/health"
 	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/metrics"
 	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"log/slog"
 	"net"
+	"time"
 )
 
 type App struct {
-	log     *slog.Logger
-	port    string
-	gRPCSrv *grpc.Server
+	log            *slog.Logger
+	port           string
+	gRPCSrv        *grpc.Server
+	healthSrv      *grpchealth.Server
+	checker        *health.Checker
+	healthInterval time.Duration
+	done           chan struct{}
 }
 
 func New(
 	log *slog.Logger,
 	port string,
 	fm *filemanager.FileManager,
+	journal grpcfm.Journal,
+	watcher grpcfm.Watcher,
+	checker *health.Checker,
+	healthInterval time.Duration,
 ) *App {
-	grpcsrv := grpc.NewServer()
-	grpcfm.Register(grpcsrv, fm)
+	grpcsrv := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(metrics.StreamServerInterceptor()),
+	)
+	grpcfm.Register(grpcsrv, fm, journal, watcher)
+
+	healthsrv := grpchealth.NewServer()
+	healthsrv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcsrv, healthsrv)
 
 	return &App{
-		log:     log,
-		port:    port,
-		gRPCSrv: grpcsrv,
+		log:            log,
+		port:           port,
+		gRPCSrv:        grpcsrv,
+		healthSrv:      healthsrv,
+		checker:        checker,
+		healthInterval: healthInterval,
+		done:           make(chan struct{}),
 	}
 }
 
@@ -48,6 +74,8 @@ func (a *App) Run() error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	go a.checker.Watch(a.healthSrv, a.healthInterval, a.done)
+
 	log.Info("application started")
 	if err := a.gRPCSrv.Serve(lis); err != nil {
 		log.Error("failed to serve", sl.Err(err))
@@ -62,5 +90,7 @@ func (a *App) Stop() {
 
 	a.log.Info("stopping grpc application", slog.String("op", op))
 
+	close(a.done)
+	a.healthSrv.Shutdown()
 	a.gRPCSrv.GracefulStop()
 }
@@ -0,0 +1,129 @@
+package metricsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/health"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/metrics"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+)
+
+type App struct {
+	log               *slog.Logger
+	httpSrv           *http.Server
+	rootPath          string
+	diskUsageInterval time.Duration
+	store             *storage.Store
+	done              chan struct{}
+}
+
+func New(
+	log *slog.Logger,
+	port string,
+	rootPath string,
+	diskUsageInterval time.Duration,
+	checker *health.Checker,
+	store *storage.Store,
+) *App {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthz(log, checker))
+
+	return &App{
+		log: log,
+		httpSrv: &http.Server{
+			Addr:    ":" + port,
+			Handler: mux,
+		},
+		rootPath:          rootPath,
+		diskUsageInterval: diskUsageInterval,
+		store:             store,
+		done:              make(chan struct{}),
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run starts disk usage and deduplication collection and serves metrics listener
+func (a *App) Run() error {
+	const op = "metricsapp.Run"
+	log := a.log.With(slog.String("op", op))
+	log.Info("starting metrics application", slog.String("addr", a.httpSrv.Addr))
+
+	go a.collectDiskUsage()
+
+	if err := a.httpSrv.ListenAndServe(); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		log.Error("metrics server stopped with error", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "metricsapp.Stop"
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping metrics application")
+
+	close(a.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := a.httpSrv.Shutdown(ctx); err != nil {
+		log.Error("failed to stop metrics application", sl.Err(err))
+	}
+}
+
+func (a *App) collectDiskUsage() {
+	const op = "metricsapp.collectDiskUsage"
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(a.diskUsageInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := metrics.UpdateDiskUsage(a.rootPath); err != nil {
+			log.Error("failed to update disk usage", sl.Err(err))
+		}
+		metrics.SetDedupStats(a.store.DedupStats())
+
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthz reports the same health status as grpc health service
+// for the probes which cannot speak grpc
+func healthz(log *slog.Logger, checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checker.Check(); err != nil {
+			log.Warn("health check failed", sl.Err(err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
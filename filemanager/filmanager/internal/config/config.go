@@ -12,9 +12,18 @@ const (
 )
 
 type Config struct {
-	Env      string     `yaml:"env" env-default:"local"`
-	RootPath string     `yaml:"root-path" env-required:"true"`
-	GRPCObj  GRPCObject `yaml:"grpc"`
+	Env         string      `yaml:"env" env-default:"local"`
+	RootPath    string      `yaml:"root-path" env-required:"true"`
+	GRPCObj     GRPCObject  `yaml:"grpc"`
+	Metrics     Metrics     `yaml:"metrics"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Health      Health      `yaml:"health"`
+	Replication Replication `yaml:"replication"`
+	Journal     Journal     `yaml:"journal"`
+	Watch       Watch       `yaml:"watch"`
+	Compression Compression `yaml:"compression"`
+	Encryption  Encryption  `yaml:"encryption"`
+	Dedup       Dedup       `yaml:"dedup"`
 }
 
 type GRPCObject struct {
@@ -22,6 +31,85 @@ type GRPCObject struct {
 	Timeout time.Duration `yaml:"timeout" env-default:"20s"`
 }
 
+type Metrics struct {
+	Port              string        `yaml:"port" env-default:"20203"`
+	DiskUsageInterval time.Duration `yaml:"disk-usage-interval" env-default:"1m"`
+}
+
+// Health configures the check behind grpc health service.
+// MinFreeSpace is in bytes
+type Health struct {
+	Interval     time.Duration `yaml:"interval" env-default:"10s"`
+	MinFreeSpace uint64        `yaml:"min-free-space" env-default:"104857600"`
+}
+
+// Replication forwards committed changes to peer filemanagers.
+// Ack is "async" to queue changes or "sync" to wait until peers apply them.
+// Changes applied by peers are not forwarded further, so every
+// filemanager lists all the others as peers. Secret is shared by all
+// peers and recognizes their requests, it is required with peers
+type Replication struct {
+	Peers         []string      `yaml:"peers" env:"REPLICATION_PEERS" env-separator:","`
+	Ack           string        `yaml:"ack" env:"REPLICATION_ACK" env-default:"async"`
+	QueueDir      string        `yaml:"queue-dir" env-default:"./replication"`
+	QueueLimit    int           `yaml:"queue-limit" env-default:"100000"`
+	SyncTimeout   time.Duration `yaml:"sync-timeout" env-default:"30s"`
+	Timeout       time.Duration `yaml:"timeout" env-default:"10m"`
+	RetryInterval time.Duration `yaml:"retry-interval" env-default:"5s"`
+	Secret        string        `yaml:"secret" env:"REPLICATION_SECRET" json:"-"`
+}
+
+// Journal keeps committed changes for incremental sync.
+// SegmentSize is in bytes
+type Journal struct {
+	Dir             string        `yaml:"dir" env-default:"./journal"`
+	SegmentSize     int64         `yaml:"segment-size" env-default:"67108864"`
+	Retention       time.Duration `yaml:"retention" env-default:"168h"`
+	CompactInterval time.Duration `yaml:"compact-interval" env-default:"1h"`
+}
+
+// Watch configures notifications of changes. Inotify enables detection of
+// changes made in root directly, Debounce merges repeated writes of them
+type Watch struct {
+	Inotify  bool          `yaml:"inotify" env-default:"true"`
+	Buffer   int           `yaml:"buffer" env-default:"1024"`
+	Debounce time.Duration `yaml:"debounce" env-default:"1s"`
+}
+
+// Compression configures compression of files at rest: "none", "zstd"
+// or "gzip". Dirs overrides it for files of listed directories, the deepest
+// one is used. Existing files are converted by command migrate
+type Compression struct {
+	Algorithm string            `yaml:"algorithm" env:"COMPRESSION" env-default:"none"`
+	Dirs      map[string]string `yaml:"dirs"`
+}
+
+// Encryption configures encryption of files at rest. Master keys are 32
+// bytes encoded by base64, given in MASTER_KEYS separated by comma or one
+// per line of KeyFile. The first key wraps data keys of new files, the
+// others are kept to read files until command migrate rewraps their keys
+type Encryption struct {
+	Enabled bool     `yaml:"enabled" env:"ENCRYPTION" env-default:"false"`
+	KeyFile string   `yaml:"key-file" env:"MASTER_KEY_FILE"`
+	Keys    []string `yaml:"keys" env:"MASTER_KEYS" env-separator:"," json:"-"`
+}
+
+// Dedup configures deduplication of files at rest. Files are cut into chunks
+// by their contents, every distinct chunk is stored once. Existing files
+// are converted by command migrate
+type Dedup struct {
+	Enabled bool `yaml:"enabled" env:"DEDUP" env-default:"false"`
+}
+
+// Tracing configures span exporter: "none", "otlp" or "file"
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" env-default:"localhost:4317"`
+	Insecure    bool    `yaml:"insecure" env-default:"true"`
+	FilePath    string  `yaml:"file-path" env-default:"./traces.json"`
+	SampleRatio float64 `yaml:"sample-ratio" env-default:"1"`
+}
+
 func New() *Config {
 
 	var cfg Config
@@ -6,17 +6,22 @@ import (
 
 	"github.com/IlianBuh/filemanager-server/internal/grpc/wrappers"
 	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"github.com/IlianBuh/filemanager-server/internal/services/journal"
+	"github.com/IlianBuh/filemanager-server/internal/services/watch"
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
 	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 type FileManager interface {
 	GetFile(
-		context.Context,
-		string,
-		filemanager.Sender,
+		ctx context.Context,
+		fileName string,
+		opts filemanager.ReadOptions,
+		stream filemanager.FileSender,
 	) error
 	PostFile(
 		ctx context.Context,
@@ -30,26 +35,96 @@ type FileManager interface {
 		ctx context.Context,
 		recv filemanager.Receiver,
 	) error
+	ListFiles(
+		ctx context.Context,
+		dir string,
+		recursive bool,
+	) ([]filemanager.FileInfo, error)
+	Stat(
+		ctx context.Context,
+		name string,
+	) (filemanager.FileInfo, error)
+	Mkdir(
+		ctx context.Context,
+		name string,
+	) error
+	Move(
+		ctx context.Context,
+		from string,
+		to string,
+	) error
+	Archive(
+		ctx context.Context,
+		dir string,
+		filter filemanager.Filter,
+		stream filemanager.ArchiveSender,
+	) error
+	Extract(
+		ctx context.Context,
+		recv filemanager.ExtractReceiver,
+	) ([]filemanager.ExtractResult, error)
+	Signature(
+		ctx context.Context,
+		fileName string,
+		blockSize int,
+		stream filemanager.SignatureSender,
+	) error
+	PatchFile(
+		ctx context.Context,
+		recv filemanager.PatchReceiver,
+	) error
+}
+
+type Journal interface {
+	ListChanges(
+		ctx context.Context,
+		since uint64,
+		limit int,
+	) ([]journal.Entry, uint64, bool, error)
+}
+
+type Watcher interface {
+	Subscribe(filter watch.Filter) *watch.Subscription
+	Unsubscribe(sub *watch.Subscription)
 }
 
 type serverAPI struct {
-	fm FileManager
+	fm      FileManager
+	journal Journal
+	watcher Watcher
 	filemanagerv1.UnimplementedFileManagerServer
 }
 
-func Register(gRPC *grpc.Server, FM FileManager) {
-	filemanagerv1.RegisterFileManagerServer(gRPC, &serverAPI{fm: FM})
+func Register(gRPC *grpc.Server, FM FileManager, journal Journal, watcher Watcher) {
+	filemanagerv1.RegisterFileManagerServer(gRPC, &serverAPI{fm: FM, journal: journal, watcher: watcher})
 }
 
+// GetFile sends size and modification time of the file
+// and then its content from the requested offset
+//
+// API error codes: NotFound, FailedPrecondition, OutOfRange, Internal
 func (s *serverAPI) GetFile(req *filemanagerv1.GetFileRequest, stream grpc.ServerStreamingServer[filemanagerv1.GetFileResponse]) error {
 
 	err := s.fm.GetFile(
-		context.Background(),
+		stream.Context(),
 		req.GetFileName(),
+		filemanager.ReadOptions{
+			Offset:    req.GetOffset(),
+			IfSize:    req.GetIfSize(),
+			IfModTime: req.GetIfModTime(),
+		},
 		&wrappers.MyGetFileResponse{Stream: stream},
 	)
 	if err != nil {
-		if errors.Is(err, filemanager.ErrBadRequest) {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
+		switch {
+		case errors.Is(err, filemanager.ErrChanged):
+			return status.Error(codes.FailedPrecondition, "file is changed")
+		case errors.Is(err, filemanager.ErrOutOfRange):
+			return status.Error(codes.OutOfRange, "offset is out of file")
+		case errors.Is(err, filemanager.ErrBadRequest):
 			return status.Error(codes.NotFound, "bad request")
 		}
 
@@ -59,9 +134,17 @@ func (s *serverAPI) GetFile(req *filemanagerv1.GetFileRequest, stream grpc.Serve
 	return nil
 }
 
+// uploadIDKey is metadata key of id which client sends with every attempt
+// of one upload, so the replayed attempt is not refused with AlreadyExists
+const uploadIDKey = "x-fm-upload-id"
+
+// createParentsKey is metadata key of upload which creates missing parent
+// directories of the file, see filemanager.WithParents
+const createParentsKey = "x-fm-create-parents"
+
 // PostFile gets stream from the grpc client and receives data
 //
-// API error codes: DataLoss, Internal, InvalidArgument
+// API error codes: DataLoss, Internal, InvalidArgument, AlreadyExists
 func (s *serverAPI) PostFile(
 	stream grpc.ClientStreamingServer[
 		filemanagerv1.PostFileRequest,
@@ -69,19 +152,29 @@ func (s *serverAPI) PostFile(
 	],
 ) error {
 
-	defer func() {
-		stream.SendAndClose(nil)
-	}()
-	err := s.fm.PostFile(
-		context.Background(),
+	ctx := stream.Context()
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(uploadIDKey)) > 0 {
+		ctx = filemanager.WithUploadID(ctx, md.Get(uploadIDKey)[0])
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(createParentsKey)) > 0 {
+		ctx = filemanager.WithParents(ctx)
+	}
+
+	err := committed(s.fm.PostFile(
+		ctx,
 		&wrappers.MyPostFileProvider{Stream: stream},
-	)
+	))
 	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
 		switch {
 		case errors.Is(err, filemanager.ErrReceiveFile):
 			return status.Error(codes.DataLoss, "failed to get chunk")
 		case errors.Is(err, filemanager.ErrInternal):
 			return status.Error(codes.Internal, "internal error")
+		case errors.Is(err, filemanager.ErrExists):
+			return status.Error(codes.AlreadyExists, "file already exists")
 		case errors.Is(err, filemanager.ErrBadRequest):
 			return status.Error(codes.InvalidArgument, "bad request")
 		}
@@ -89,6 +182,7 @@ func (s *serverAPI) PostFile(
 		return status.Error(codes.Internal, "failed to save file")
 	}
 
+	stream.SendAndClose(nil)
 	return nil
 }
 
@@ -97,8 +191,11 @@ func (s *serverAPI) DeleteFile(
 	req *filemanagerv1.DeleteFileRequest,
 ) (*filemanagerv1.DeleteFileResponse, error) {
 
-	err := s.fm.DeleteFile(ctx, req.GetFileName())
+	err := committed(s.fm.DeleteFile(ctx, req.GetFileName()))
 	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
 		if errors.Is(err, filemanager.ErrBadRequest) {
 			return nil, status.Error(codes.InvalidArgument, "file not found")
 		}
@@ -112,11 +209,14 @@ func (s *serverAPI) DeleteFile(
 func (s *serverAPI) PutFile(
 	stream grpc.ClientStreamingServer[filemanagerv1.PutFileRequest, filemanagerv1.PutFileResponse],
 ) error {
-	err := s.fm.PutFile(
-		context.Background(),
+	err := committed(s.fm.PutFile(
+		stream.Context(),
 		&wrappers.MyPutFileProvider{Stream: stream},
-	)
+	))
 	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
 		switch {
 		case errors.Is(err, filemanager.ErrReceiveFile):
 			return status.Error(codes.DataLoss, "failed to get chunk")
@@ -132,3 +232,363 @@ func (s *serverAPI) PutFile(
 	stream.SendAndClose(nil)
 	return nil
 }
+
+// ListFiles returns entries of the directory
+//
+// API error codes: NotFound, Internal
+func (s *serverAPI) ListFiles(
+	ctx context.Context,
+	req *filemanagerv1.ListFilesRequest,
+) (*filemanagerv1.ListFilesResponse, error) {
+
+	files, err := s.fm.ListFiles(ctx, req.GetPath(), req.GetRecursive())
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, filemanager.ErrBadRequest) {
+			return nil, status.Error(codes.NotFound, "directory not found")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &filemanagerv1.ListFilesResponse{
+		Files: make([]*filemanagerv1.FileInfo, 0, len(files)),
+	}
+	for _, f := range files {
+		resp.Files = append(resp.Files, &filemanagerv1.FileInfo{
+			Name:    f.Name,
+			Size:    f.Size,
+			IsDir:   f.IsDir,
+			ModTime: f.ModTime.Unix(),
+		})
+	}
+
+	return resp, nil
+}
+
+// Stat returns description of file or directory
+//
+// API error codes: NotFound, Internal
+func (s *serverAPI) Stat(
+	ctx context.Context,
+	req *filemanagerv1.StatRequest,
+) (*filemanagerv1.StatResponse, error) {
+
+	f, err := s.fm.Stat(ctx, req.GetName())
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, filemanager.ErrBadRequest) {
+			return nil, status.Error(codes.NotFound, "file not found")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &filemanagerv1.StatResponse{
+		File: &filemanagerv1.FileInfo{
+			Name:    f.Name,
+			Size:    f.Size,
+			IsDir:   f.IsDir,
+			ModTime: f.ModTime.Unix(),
+		},
+	}, nil
+}
+
+// Mkdir creates directory and its missing parents
+//
+// API error codes: AlreadyExists, InvalidArgument, Internal
+func (s *serverAPI) Mkdir(
+	ctx context.Context,
+	req *filemanagerv1.MkdirRequest,
+) (*filemanagerv1.MkdirResponse, error) {
+
+	err := committed(s.fm.Mkdir(ctx, req.GetName()))
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		switch {
+		case errors.Is(err, filemanager.ErrExists):
+			return nil, status.Error(codes.AlreadyExists, "file already exists")
+		case errors.Is(err, filemanager.ErrBadRequest):
+			return nil, status.Error(codes.InvalidArgument, "bad request")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &filemanagerv1.MkdirResponse{}, nil
+}
+
+// Move renames file or directory
+//
+// API error codes: NotFound, AlreadyExists, Internal
+func (s *serverAPI) Move(
+	ctx context.Context,
+	req *filemanagerv1.MoveRequest,
+) (*filemanagerv1.MoveResponse, error) {
+
+	err := committed(s.fm.Move(ctx, req.GetFrom(), req.GetTo()))
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		switch {
+		case errors.Is(err, filemanager.ErrExists):
+			return nil, status.Error(codes.AlreadyExists, "destination already exists")
+		case errors.Is(err, filemanager.ErrBadRequest):
+			return nil, status.Error(codes.NotFound, "file not found")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &filemanagerv1.MoveResponse{}, nil
+}
+
+// Archive streams files of directory selected by include and exclude patterns
+//
+// API error codes: NotFound, InvalidArgument, Internal
+func (s *serverAPI) Archive(
+	req *filemanagerv1.ArchiveRequest,
+	stream grpc.ServerStreamingServer[filemanagerv1.ArchiveEntry],
+) error {
+	filter := filemanager.Filter{Include: req.GetInclude(), Exclude: req.GetExclude()}
+	if err := filter.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.fm.Archive(
+		stream.Context(),
+		req.GetPath(),
+		filter,
+		&wrappers.MyArchiveResponse{Stream: stream},
+	)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, filemanager.ErrBadRequest) {
+			return status.Error(codes.NotFound, "directory not found")
+		}
+
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	return nil
+}
+
+// Extract writes entries of archive unpacked by client and reports
+// result of every entry
+//
+// API error codes: DataLoss, InvalidArgument, Internal
+func (s *serverAPI) Extract(
+	stream grpc.ClientStreamingServer[filemanagerv1.ExtractRequest, filemanagerv1.ExtractResponse],
+) error {
+	results, err := s.fm.Extract(
+		stream.Context(),
+		&wrappers.MyExtractProvider{Stream: stream},
+	)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
+		switch {
+		case errors.Is(err, filemanager.ErrReceiveFile):
+			return status.Error(codes.DataLoss, "failed to get entry")
+		case errors.Is(err, filemanager.ErrBadRequest):
+			return status.Error(codes.InvalidArgument, "bad request")
+		}
+
+		return status.Error(codes.Internal, "failed to extract archive")
+	}
+
+	resp := &filemanagerv1.ExtractResponse{
+		Results: make([]*filemanagerv1.ExtractResult, 0, len(results)),
+	}
+	for _, r := range results {
+		resp.Results = append(resp.Results, &filemanagerv1.ExtractResult{
+			Name:   r.Name,
+			Status: r.Status,
+			Error:  r.Error,
+		})
+	}
+
+	return stream.SendAndClose(resp)
+}
+
+// Signature streams signatures of blocks of file, which client uses
+// to send only changed parts of the new content by PatchFile
+//
+// API error codes: NotFound, InvalidArgument, Internal
+func (s *serverAPI) Signature(
+	req *filemanagerv1.SignatureRequest,
+	stream grpc.ServerStreamingServer[filemanagerv1.SignatureResponse],
+) error {
+	blockSize := int(req.GetBlockSize())
+	if blockSize != 0 && !delta.ValidBlockSize(blockSize) {
+		return status.Error(codes.InvalidArgument, "invalid block size")
+	}
+
+	err := s.fm.Signature(
+		stream.Context(),
+		req.GetFileName(),
+		blockSize,
+		&wrappers.MySignatureResponse{Stream: stream},
+	)
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, filemanager.ErrBadRequest) {
+			return status.Error(codes.NotFound, "file not found")
+		}
+
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	return nil
+}
+
+// PatchFile replaces file by content built from its current content by ops
+// of delta. FailedPrecondition means that the file is changed since its
+// signature was sent, client should send the whole file by PutFile
+//
+// API error codes: DataLoss, FailedPrecondition, InvalidArgument, Internal
+func (s *serverAPI) PatchFile(
+	stream grpc.ClientStreamingServer[filemanagerv1.PatchFileRequest, filemanagerv1.PatchFileResponse],
+) error {
+	err := committed(s.fm.PatchFile(
+		stream.Context(),
+		&wrappers.MyPatchFileProvider{Stream: stream},
+	))
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return ctxErr
+		}
+		switch {
+		case errors.Is(err, filemanager.ErrReceiveFile):
+			return status.Error(codes.DataLoss, "failed to get ops")
+		case errors.Is(err, filemanager.ErrMismatch):
+			return status.Error(codes.FailedPrecondition, "patched file does not match checksum")
+		case errors.Is(err, filemanager.ErrBadRequest):
+			return status.Error(codes.InvalidArgument, "bad request")
+		}
+
+		return status.Error(codes.Internal, "failed to patch file")
+	}
+
+	return stream.SendAndClose(&filemanagerv1.PatchFileResponse{})
+}
+
+// ListChanges returns journaled changes after cursor
+//
+// API error codes: OutOfRange, InvalidArgument, Internal
+func (s *serverAPI) ListChanges(
+	ctx context.Context,
+	req *filemanagerv1.ListChangesRequest,
+) (*filemanagerv1.ListChangesResponse, error) {
+
+	entries, cursor, hasMore, err := s.journal.ListChanges(ctx, req.GetSince(), int(req.GetLimit()))
+	if err != nil {
+		if ctxErr := contextError(err); ctxErr != nil {
+			return nil, ctxErr
+		}
+		switch {
+		case errors.Is(err, journal.ErrCursorExpired):
+			return nil, status.Error(codes.OutOfRange, "cursor is expired")
+		case errors.Is(err, journal.ErrInvalidLimit):
+			return nil, status.Error(codes.InvalidArgument, "invalid limit")
+		}
+
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &filemanagerv1.ListChangesResponse{
+		Changes: make([]*filemanagerv1.Change, 0, len(entries)),
+		Cursor:  cursor,
+		HasMore: hasMore,
+	}
+	for _, e := range entries {
+		resp.Changes = append(resp.Changes, &filemanagerv1.Change{
+			Seq:  e.Seq,
+			Op:   string(e.Op),
+			Name: e.Name,
+			Time: e.Time.Unix(),
+			From: e.From,
+		})
+	}
+
+	return resp, nil
+}
+
+// Watch streams changes matching the request until client cancels the call
+//
+// API error codes: InvalidArgument, ResourceExhausted, Unavailable
+func (s *serverAPI) Watch(
+	req *filemanagerv1.WatchRequest,
+	stream grpc.ServerStreamingServer[filemanagerv1.WatchEvent],
+) error {
+
+	filter, err := watch.NewFilter(req.GetPrefix(), req.GetRecursive(), req.GetOps())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	sub := s.watcher.Subscribe(filter)
+	defer s.watcher.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return contextError(stream.Context().Err())
+		case e, ok := <-sub.Events():
+			if !ok {
+				if sub.Lagged() {
+					return status.Error(codes.ResourceExhausted, "events are not received in time")
+				}
+				return status.Error(codes.Unavailable, "filemanager is stopping")
+			}
+
+			err := stream.Send(&filemanagerv1.WatchEvent{
+				Op:     string(e.Op),
+				Name:   e.Name,
+				Time:   e.Time.Unix(),
+				Source: e.Source,
+				From:   e.From,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// contextError converts cancellation or deadline of the client call
+// into corresponding grpc status. It returns nil if err is not context error
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "request is cancelled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	}
+
+	return nil
+}
+
+// committed drops ErrNotPropagated. It means that the change is committed
+// and only an observer failed, e.g. sync replication timed out. The failure
+// is logged and queued changes are replicated later, while client which got
+// an error would retry the change and fail on its own result
+func committed(err error) error {
+	if errors.Is(err, filemanager.ErrNotPropagated) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,26 @@
+package wrappers
+
+import (
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+)
+
+type aentry = filemanagerv1.ArchiveEntry
+
+type MyArchiveResponse struct {
+	Stream grpc.ServerStreamingServer[aentry]
+}
+
+func (g *MyArchiveResponse) SendEntry(e filemanager.ArchiveEntry) error {
+	return g.Stream.Send(&aentry{
+		Name:    e.Name,
+		Size:    e.Size,
+		IsDir:   e.IsDir,
+		ModTime: e.ModTime.Unix(),
+	})
+}
+
+func (g *MyArchiveResponse) MySend(chunk []byte) error {
+	return g.Stream.Send(&aentry{Chunk: chunk})
+}
@@ -0,0 +1,18 @@
+package wrappers
+
+import (
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+)
+
+type exreq = filemanagerv1.ExtractRequest
+type exres = filemanagerv1.ExtractResponse
+
+type MyExtractProvider struct {
+	Stream grpc.ClientStreamingServer[exreq, exres]
+}
+
+func (g *MyExtractProvider) MyReceive() (filemanager.ExtractEntry, error) {
+	return g.Stream.Recv()
+}
@@ -3,6 +3,7 @@ package wrappers
 import (
 	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
 	"google.golang.org/grpc"
+	"time"
 )
 
 type gfres = filemanagerv1.GetFileResponse
@@ -13,3 +14,8 @@ type MyGetFileResponse struct {
 func (g *MyGetFileResponse) MySend(chunk []byte) error {
 	return g.Stream.Send(&gfres{Chunk: chunk})
 }
+
+// SendInfo sends the first message, which describes the whole file
+func (g *MyGetFileResponse) SendInfo(size int64, modTime time.Time) error {
+	return g.Stream.Send(&gfres{Size: size, ModTime: modTime.Unix()})
+}
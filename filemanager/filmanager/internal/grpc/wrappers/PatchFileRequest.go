@@ -0,0 +1,34 @@
+package wrappers
+
+import (
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+)
+
+type patchreq = filemanagerv1.PatchFileRequest
+type patchres = filemanagerv1.PatchFileResponse
+
+type MyPatchFileProvider struct {
+	Stream grpc.ClientStreamingServer[patchreq, patchres]
+}
+
+func (g *MyPatchFileProvider) MyReceive() (filemanager.PatchMessage, error) {
+	req, err := g.Stream.Recv()
+	if err != nil {
+		return filemanager.PatchMessage{}, err
+	}
+
+	ops := make([]delta.Op, 0, len(req.GetOps()))
+	for _, o := range req.GetOps() {
+		ops = append(ops, delta.Op{Offset: o.GetOffset(), Length: o.GetLength(), Data: o.GetData()})
+	}
+
+	return filemanager.PatchMessage{
+		Name:   req.GetFileName(),
+		Size:   req.GetSize(),
+		Sha256: req.GetSha256(),
+		Ops:    ops,
+	}, nil
+}
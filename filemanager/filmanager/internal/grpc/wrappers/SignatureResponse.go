@@ -0,0 +1,26 @@
+package wrappers
+
+import (
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+)
+
+type sigres = filemanagerv1.SignatureResponse
+
+type MySignatureResponse struct {
+	Stream grpc.ServerStreamingServer[sigres]
+}
+
+func (g *MySignatureResponse) SendSignature(sig delta.Signature) error {
+	blocks := make([]*filemanagerv1.BlockSignature, 0, len(sig.Blocks))
+	for _, b := range sig.Blocks {
+		blocks = append(blocks, &filemanagerv1.BlockSignature{Weak: b.Weak, Strong: b.Strong})
+	}
+
+	return g.Stream.Send(&sigres{
+		BlockSize: int32(sig.BlockSize),
+		Size:      sig.Size,
+		Blocks:    blocks,
+	})
+}
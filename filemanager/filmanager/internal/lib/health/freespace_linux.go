@@ -0,0 +1,16 @@
+//go:build linux
+
+package health
+
+import "syscall"
+
+// freeSpace returns number of bytes available to unprivileged user on
+// the file system of path
+func freeSpace(path string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), true, nil
+}
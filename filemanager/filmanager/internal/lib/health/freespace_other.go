@@ -0,0 +1,8 @@
+//go:build !linux
+
+package health
+
+// freeSpace is not supported on this platform, so disk space is not checked
+func freeSpace(string) (uint64, bool, error) {
+	return 0, false, nil
+}
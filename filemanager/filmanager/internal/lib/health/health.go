@@ -0,0 +1,93 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	ErrRootUnreadable = errors.New("root directory is unreadable")
+	ErrDiskFull       = errors.New("not enough free disk space")
+)
+
+// Checker checks if filemanager is able to serve requests:
+// root directory is readable and disk has enough free space
+type Checker struct {
+	log          *slog.Logger
+	rootPath     string
+	minFreeSpace uint64
+}
+
+func New(log *slog.Logger, rootPath string, minFreeSpace uint64) *Checker {
+	return &Checker{
+		log:          log,
+		rootPath:     rootPath,
+		minFreeSpace: minFreeSpace,
+	}
+}
+
+// Check returns nil if filemanager is healthy
+func (c *Checker) Check() error {
+	const op = "health.Check"
+
+	dir, err := os.Open(c.rootPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrRootUnreadable, err)
+	}
+	defer dir.Close()
+
+	if _, err = dir.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w: %w", op, ErrRootUnreadable, err)
+	}
+
+	free, ok, err := freeSpace(c.rootPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrRootUnreadable, err)
+	}
+	if ok && free < c.minFreeSpace {
+		return fmt.Errorf("%s: %w: %d bytes left", op, ErrDiskFull, free)
+	}
+
+	return nil
+}
+
+// Watch runs Check every interval and reports result to the health
+// server until done is closed. Empty service name stands for the
+// whole server.
+func (c *Checker) Watch(srv *grpchealth.Server, interval time.Duration, done <-chan struct{}) {
+	const op = "health.Watch"
+	log := c.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := c.Check(); err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			if last != status {
+				log.Error("filemanager is unhealthy", sl.Err(err))
+			}
+		} else if last != status {
+			log.Info("filemanager is healthy")
+		}
+
+		srv.SetServingStatus("", status)
+		last = status
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
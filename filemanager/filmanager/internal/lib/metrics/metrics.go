@@ -0,0 +1,311 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	namespace = "filemanager"
+
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_server",
+			Name:      "requests_total",
+			Help:      "Number of handled grpc calls by status code.",
+		},
+		[]string{"grpc_method", "grpc_code"},
+	)
+	grpcDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_server",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of handled grpc calls.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+		},
+		[]string{"grpc_method"},
+	)
+	grpcInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_server",
+			Name:      "in_flight_streams",
+			Help:      "Number of open grpc streams.",
+		},
+		[]string{"grpc_method"},
+	)
+
+	transferBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfer_bytes_total",
+			Help:      "Number of file bytes uploaded to and downloaded from filemanager.",
+		},
+		[]string{"grpc_method", "direction"},
+	)
+	transferSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transfer_size_bytes",
+			Help:      "Size of files transferred in one grpc stream.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 12),
+		},
+		[]string{"grpc_method", "direction"},
+	)
+
+	rootUsage = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "root",
+			Name:      "disk_usage_bytes",
+			Help:      "Total size of files inside root directory.",
+		},
+	)
+	rootFiles = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "root",
+			Name:      "files",
+			Help:      "Number of files inside root directory.",
+		},
+	)
+)
+
+// chunkProvider is implemented by every file transfer message
+type chunkProvider interface {
+	GetChunk() []byte
+}
+
+// UnaryServerInterceptor collects metrics of unary calls
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		t1 := time.Now()
+		resp, err := handler(ctx, req)
+
+		grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(t1).Seconds())
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor collects metrics of streaming calls
+// including number of transferred file bytes
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		method := info.FullMethod
+
+		grpcInFlight.WithLabelValues(method).Inc()
+		defer grpcInFlight.WithLabelValues(method).Dec()
+
+		t1 := time.Now()
+		stream := &serverStream{ServerStream: ss, method: method}
+		err := handler(srv, stream)
+
+		grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+		grpcDuration.WithLabelValues(method).Observe(time.Since(t1).Seconds())
+
+		if received := stream.received.Load(); received > 0 {
+			transferSize.WithLabelValues(method, directionUpload).Observe(float64(received))
+		}
+		if sent := stream.sent.Load(); sent > 0 {
+			transferSize.WithLabelValues(method, directionDownload).Observe(float64(sent))
+		}
+
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	method   string
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if c, ok := m.(chunkProvider); ok && err == nil {
+		n := len(c.GetChunk())
+		s.sent.Add(int64(n))
+		transferBytes.WithLabelValues(s.method, directionDownload).Add(float64(n))
+	}
+
+	return err
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if c, ok := m.(chunkProvider); ok && err == nil {
+		n := len(c.GetChunk())
+		s.received.Add(int64(n))
+		transferBytes.WithLabelValues(s.method, directionUpload).Add(float64(n))
+	}
+
+	return err
+}
+
+// UpdateDiskUsage walks root directory and updates its usage gauges
+func UpdateDiskUsage(rootPath string) error {
+	var (
+		size  int64
+		files int64
+	)
+
+	err := filepath.WalkDir(rootPath, func(_ string, d fs.DirEntry, err error) error {
+		// files can be removed while walking
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rootUsage.Set(float64(size))
+	rootFiles.Set(float64(files))
+	return nil
+}
+
+var (
+	dedupChunks = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dedup",
+			Name:      "chunks",
+			Help:      "Number of distinct chunks of deduplicated files.",
+		},
+	)
+	dedupLogical = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dedup",
+			Name:      "logical_bytes",
+			Help:      "Size of contents of deduplicated files.",
+		},
+	)
+	dedupUnique = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dedup",
+			Name:      "unique_bytes",
+			Help:      "Size of distinct chunks of deduplicated files.",
+		},
+	)
+	dedupStored = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dedup",
+			Name:      "stored_bytes",
+			Help:      "Size of distinct chunks of deduplicated files on disk.",
+		},
+	)
+	dedupRatio = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dedup",
+			Name:      "ratio",
+			Help:      "Size of contents of deduplicated files divided by size of their distinct chunks.",
+		},
+	)
+)
+
+// SetDedupStats updates statistics of the blob store
+func SetDedupStats(stats storage.DedupStats) {
+	dedupChunks.Set(float64(stats.Chunks))
+	dedupLogical.Set(float64(stats.Logical))
+	dedupUnique.Set(float64(stats.Unique))
+	dedupStored.Set(float64(stats.Stored))
+	dedupRatio.Set(stats.Ratio())
+}
+
+var (
+	replicationPending = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "pending_changes",
+			Help:      "Number of changes which are not applied on the peer yet.",
+		},
+		[]string{"peer"},
+	)
+	replicationLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "lag_seconds",
+			Help:      "Age of the oldest change which is not applied on the peer yet.",
+		},
+		[]string{"peer"},
+	)
+	replicationChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "changes_total",
+			Help:      "Number of attempts to apply change on the peer by result.",
+		},
+		[]string{"peer", "result"},
+	)
+)
+
+// SetReplicationLag updates replication lag of the peer
+func SetReplicationLag(peer string, pending int, lag time.Duration) {
+	replicationPending.WithLabelValues(peer).Set(float64(pending))
+	replicationLag.WithLabelValues(peer).Set(lag.Seconds())
+}
+
+// CountReplicatedChange counts attempt to apply change on the peer
+func CountReplicatedChange(peer string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	replicationChanges.WithLabelValues(peer, result).Inc()
+}
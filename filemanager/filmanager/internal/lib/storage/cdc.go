@@ -0,0 +1,86 @@
+package storage
+
+const (
+	minChunk = 256 << 10
+	maxBlob  = 4 << 20
+	// cutMask selects top bits of the rolling hash, chunks
+	// are cut on average every 1MiB after minChunk
+	cutMask = uint64(1<<20-1) << 44
+	// window is the number of last bytes which the rolling hash depends on
+	window = 64
+)
+
+// gear maps bytes to random values of the rolling hash. It is generated
+// from the fixed seed, so the same content is cut the same way by every
+// version of the store
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	x := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker cuts content into chunks by content defined boundaries, so
+// inserting or removing bytes changes only the chunks around the change.
+// Chunks are at least minChunk and at most maxBlob bytes, except the last one
+type chunker struct {
+	buf  []byte
+	pos  int
+	hash uint64
+	emit func([]byte) error
+}
+
+func newChunker(emit func([]byte) error) *chunker {
+	return &chunker{emit: emit}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		cut := c.next()
+		if cut < 0 {
+			return len(p), nil
+		}
+		if err := c.emit(c.buf[:cut]); err != nil {
+			return 0, err
+		}
+
+		c.buf = append(c.buf[:0], c.buf[cut:]...)
+		c.pos, c.hash = 0, 0
+	}
+}
+
+// next returns length of the next chunk in buf or -1 if it is not cut yet
+func (c *chunker) next() int {
+	for ; c.pos < len(c.buf); c.pos++ {
+		if c.pos >= minChunk-window {
+			c.hash = c.hash<<1 + gear[c.buf[c.pos]]
+		}
+
+		size := c.pos + 1
+		if size >= maxBlob || size >= minChunk && c.hash&cutMask == 0 {
+			c.pos = size
+			return size
+		}
+	}
+
+	return -1
+}
+
+// Close emits the rest of content as the last chunk
+func (c *chunker) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	err := c.emit(c.buf)
+	c.buf = nil
+	return err
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+func validCompression(c string) bool {
+	switch c {
+	case CompressionNone, CompressionZstd, CompressionGzip:
+		return true
+	}
+
+	return false
+}
+
+func newEncoder(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	}
+
+	return nil, ErrUnknownCompression
+}
+
+func newDecoder(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionZstd:
+		// single goroutine decoder does not need to be closed to stop workers
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	}
+
+	return nil, ErrUnknownCompression
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,674 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// BlobDir is directory of the root which keeps chunks of deduplicated files.
+// Chunks are named by SHA-256 of their contents, or by HMAC-SHA256 if store
+// has keys, so names do not reveal contents. They are encoded like files
+const BlobDir = ".fmblobs"
+
+const (
+	entrySize      = sha256.Size + 4
+	blobTempSuffix = ".tmp"
+)
+
+// IsBlob reports whether name belongs to the blob store
+func IsBlob(name string) bool {
+	return name == BlobDir || strings.HasPrefix(name, BlobDir+"/")
+}
+
+// entry of manifest references chunk of deduplicated file
+type entry struct {
+	sum  [sha256.Size]byte
+	size uint32
+}
+
+func (e entry) marshal() []byte {
+	buf := make([]byte, entrySize)
+	copy(buf, e.sum[:])
+	binary.BigEndian.PutUint32(buf[sha256.Size:], e.size)
+	return buf
+}
+
+// blobName returns name of chunk sum in the root
+func blobName(sum [sha256.Size]byte) string {
+	h := hex.EncodeToString(sum[:])
+	return path.Join(BlobDir, h[:2], h)
+}
+
+// parseBlob returns sum of chunk kept in file name of the blob store
+// and whether the file is temporary file of unfinished write
+func parseBlob(name string) ([sha256.Size]byte, bool, bool) {
+	var sum [sha256.Size]byte
+
+	base := path.Base(name)
+	temp := strings.HasSuffix(base, blobTempSuffix)
+	raw, err := hex.DecodeString(strings.TrimSuffix(base, blobTempSuffix))
+	if err != nil || len(raw) != sha256.Size {
+		return sum, temp, false
+	}
+
+	copy(sum[:], raw)
+	return sum, temp, true
+}
+
+// readEntries reads entries of manifest, which start at offset of file.
+// Part of entry left by interrupted write is ignored
+func readEntries(file *os.File, offset int64, stored int64) ([]entry, error) {
+	r := bufio.NewReader(io.NewSectionReader(file, offset, stored-offset))
+
+	entries := make([]entry, 0, (stored-offset)/entrySize)
+	buf := make([]byte, entrySize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+
+		var e entry
+		copy(e.sum[:], buf)
+		e.size = binary.BigEndian.Uint32(buf[sha256.Size:])
+		entries = append(entries, e)
+	}
+}
+
+// blob is chunk kept in the blob store. Refs counts entries of manifests
+// which reference it. Written is closed when the chunk is written or its
+// writing failed, ok tells which
+type blob struct {
+	refs    int
+	size    int64
+	stored  int64
+	written chan struct{}
+	ok      bool
+}
+
+func (b *blob) pending() bool {
+	select {
+	case <-b.written:
+		return false
+	default:
+		return true
+	}
+}
+
+// closed is written of chunks which are found on disk
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// blobIndex counts references of manifests to chunks. Until references
+// are loaded, chunks are never removed, since files written before
+// may reference them. Entries are written into manifests and manifests
+// are removed or replaced under the lock, so references always match
+// manifests on disk
+type blobIndex struct {
+	mu     sync.Mutex
+	blobs  map[[sha256.Size]byte]*blob
+	loaded bool
+}
+
+// acquire records entry e by ref and takes reference to its chunk.
+// Owner has to write the chunk and report it by done, others wait
+// until it is written
+func (x *blobIndex) acquire(e entry, ref func(entry) error) (*blob, bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := ref(e); err != nil {
+		return nil, false, err
+	}
+
+	// chunk which failed to be written is written again by the next owner
+	b := x.blobs[e.sum]
+	owner := b == nil || !b.pending() && !b.ok
+	if owner {
+		refs := 0
+		if b != nil {
+			refs = b.refs
+		}
+		b = &blob{refs: refs, size: int64(e.size), written: make(chan struct{})}
+		x.blobs[e.sum] = b
+	}
+	b.refs++
+
+	return b, owner, nil
+}
+
+func (x *blobIndex) done(b *blob, stored int64, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	b.ok = err == nil
+	b.stored = stored
+	close(b.written)
+}
+
+// release drops references of entries. Chunks which are not referenced
+// anymore are removed, chunks which fail to be removed are left
+// to garbage collection. It requires the lock
+func (x *blobIndex) release(root *os.Root, entries []entry) {
+	for _, e := range entries {
+		b := x.blobs[e.sum]
+		if b == nil {
+			continue
+		}
+		b.refs--
+		if b.refs > 0 {
+			continue
+		}
+
+		delete(x.blobs, e.sum)
+		if x.loaded {
+			_ = root.Remove(blobName(e.sum))
+		}
+	}
+}
+
+// Load counts references of manifests of root to chunks, so chunks are
+// removed with the last file which references them. It has to be called
+// before files of root are written or removed. Roots without the blob
+// store are not read unless store deduplicates files
+func (s *Store) Load(root *os.Root) error {
+	const op = "storage.Load"
+
+	if !s.policy.Dedup {
+		if _, err := root.Stat(BlobDir); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	}
+
+	blobs, err := s.countRefs(root)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	s.index.blobs = blobs
+	s.index.loaded = true
+	return nil
+}
+
+// countRefs counts references of manifests of root to chunks
+func (s *Store) countRefs(root *os.Root) (map[[sha256.Size]byte]*blob, error) {
+	blobs := make(map[[sha256.Size]byte]*blob)
+	err := fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && IsBlob(name) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		entries, err := s.manifest(root, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		for _, e := range entries {
+			b := blobs[e.sum]
+			if b == nil {
+				b = &blob{size: int64(e.size), written: closed, ok: true}
+				blobs[e.sum] = b
+			}
+			b.refs++
+		}
+		return nil
+	})
+
+	return blobs, err
+}
+
+// manifest returns entries of file name if it is deduplicated
+func (s *Store) manifest(root *os.Root, name string) ([]entry, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, h, offset, err := readHeader(file)
+	if err != nil || !h.Manifest {
+		return nil, err
+	}
+
+	return readEntries(file, offset, info.Stored)
+}
+
+// references returns entries of file name which has to be released
+// when it is removed. Unreadable files release nothing, so their
+// chunks are kept until garbage collection. It requires the lock
+func (s *Store) references(root *os.Root, name string) []entry {
+	if !s.index.loaded {
+		return nil
+	}
+	if stat, err := root.Lstat(name); err != nil || !stat.Mode().IsRegular() {
+		return nil
+	}
+
+	entries, _ := s.manifest(root, name)
+	return entries
+}
+
+// Remove removes file name of root and releases chunks which it references
+func (s *Store) Remove(root *os.Root, name string) error {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	entries := s.references(root, name)
+	if err := root.Remove(name); err != nil {
+		return err
+	}
+
+	s.index.release(root, entries)
+	return nil
+}
+
+// Rename renames file from to to and releases chunks which
+// are referenced by file to if it is replaced
+func (s *Store) Rename(root *os.Root, from string, to string) error {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	entries := s.references(root, to)
+	if err := root.Rename(from, to); err != nil {
+		return err
+	}
+
+	s.index.release(root, entries)
+	return nil
+}
+
+// RenameNoReplace renames file or directory from to to, failing with
+// error matching fs.ErrExist if to exists. Unlike Rename, it never
+// replaces file created at to concurrently: file is linked to the new
+// name before the old one is removed. Directory is renamed as is, because
+// rename never replaces anything by directory except empty directory
+func (s *Store) RenameNoReplace(root *os.Root, from string, to string) error {
+	stat, err := root.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		return root.Rename(from, to)
+	}
+
+	// while file has both names, its references are counted twice
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	if err := root.Link(from, to); err != nil {
+		return err
+	}
+	return root.Remove(from)
+}
+
+// chunkHash returns hash of chunks of manifest which names them
+// by master key id, or SHA-256 if the manifest has no key
+func (s *Store) chunkHash(keyID string) (hash.Hash, error) {
+	if keyID == "" {
+		return sha256.New(), nil
+	}
+
+	for _, mk := range s.keys.keyList() {
+		if mk.id == keyID {
+			return hmac.New(sha256.New, mk.names), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoKey, keyID)
+}
+
+// putChunk takes reference to chunk e of file name, which is recorded by
+// ref, and writes the chunk unless the blob store has it already
+func (s *Store) putChunk(root *os.Root, name string, chunk []byte, e entry, ref func(entry) error) error {
+	b, owner, err := s.index.acquire(e, ref)
+	if err != nil {
+		return err
+	}
+	if !owner {
+		<-b.written
+		if !b.ok {
+			return fmt.Errorf("chunk %s is not written", blobName(e.sum))
+		}
+		return nil
+	}
+
+	stored, err := s.writeBlob(root, e.sum, s.Compression(name), bytes.NewReader(chunk))
+	s.index.done(b, stored, err)
+	return err
+}
+
+// writeBlob writes content of chunk sum into the blob store with
+// compression. It returns size of the chunk on disk
+func (s *Store) writeBlob(root *os.Root, sum [sha256.Size]byte, compression string, r io.Reader) (int64, error) {
+	name := blobName(sum)
+	if err := root.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return 0, err
+	}
+
+	tmpName := name + blobTempSuffix
+	file, err := root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+
+	var stat os.FileInfo
+	w, err := s.encodedWriter(file, compression)
+	if err == nil {
+		_, err = io.Copy(w, r)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		stat, err = file.Stat()
+	}
+	err = errors.Join(err, file.Close())
+	if err == nil {
+		err = root.Rename(tmpName, name)
+	}
+	if err != nil {
+		_ = root.Remove(tmpName)
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
+
+// Collected describes garbage collection of the blob store.
+// Missing counts referenced chunks which are not found
+type Collected struct {
+	Removed int
+	Freed   int64
+	Missing int
+}
+
+// CollectGarbage removes chunks which no file references and temporary
+// files left by interrupted writes of chunks. References are counted
+// again from manifests on disk, so references of files removed not by
+// the store are dropped too. It requires loaded references. The blob
+// store is locked while it runs
+func (s *Store) CollectGarbage(root *os.Root) (Collected, error) {
+	const op = "storage.CollectGarbage"
+
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	var res Collected
+	if !s.index.loaded {
+		return res, fmt.Errorf("%s: references of chunks are not loaded", op)
+	}
+
+	blobs, err := s.countRefs(root)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+	// chunks which are being written keep their state,
+	// since their writers and readers wait for them
+	for sum, b := range blobs {
+		if old := s.index.blobs[sum]; old != nil {
+			old.refs = b.refs
+			blobs[sum] = old
+		}
+	}
+	s.index.blobs = blobs
+
+	seen := make(map[[sha256.Size]byte]bool)
+	err = fs.WalkDir(root.FS(), BlobDir, func(name string, d fs.DirEntry, err error) error {
+		if name == BlobDir && errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		sum, temp, ok := parseBlob(name)
+		b := s.index.blobs[sum]
+		if ok && b != nil {
+			if temp && b.pending() {
+				return nil
+			}
+			if !temp {
+				seen[sum] = true
+				b.stored = info.Size()
+				return nil
+			}
+		}
+		if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		res.Removed++
+		res.Freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for sum, b := range s.index.blobs {
+		if !seen[sum] && !b.pending() {
+			res.Missing++
+		}
+	}
+
+	return res, nil
+}
+
+// DedupStats describes the blob store. Logical is size of contents
+// of deduplicated files, Unique is size of their distinct chunks
+// and Stored is size of the chunks on disk
+type DedupStats struct {
+	Chunks  int
+	Logical int64
+	Unique  int64
+	Stored  int64
+}
+
+// Ratio returns how many times deduplication reduces size of contents
+func (d DedupStats) Ratio() float64 {
+	if d.Unique == 0 {
+		return 1
+	}
+
+	return float64(d.Logical) / float64(d.Unique)
+}
+
+// DedupStats counts chunks of the blob store by loaded references
+func (s *Store) DedupStats() DedupStats {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	var stats DedupStats
+	for _, b := range s.index.blobs {
+		if b.pending() || !b.ok {
+			continue
+		}
+
+		stats.Chunks++
+		stats.Logical += int64(b.refs) * b.size
+		stats.Unique += b.size
+		stats.Stored += b.stored
+	}
+
+	return stats
+}
+
+// MigrateBlobs converts chunks which are encrypted other than
+// store encrypts new files and rewraps data keys of Stale chunks.
+// Chunks keep their compression. Nobody else may change files while
+// it runs. With dryRun chunks are only counted
+func (s *Store) MigrateBlobs(ctx context.Context, root *os.Root, dryRun bool) (int, int, error) {
+	const op = "storage.MigrateBlobs"
+
+	converted, rewrapped := 0, 0
+	err := fs.WalkDir(root.FS(), BlobDir, func(name string, d fs.DirEntry, err error) error {
+		if name == BlobDir && errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sum, temp, ok := parseBlob(name)
+		if d.IsDir() || temp || !ok {
+			return nil
+		}
+
+		info, err := s.Stat(root, name)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", name, err)
+		}
+		if info.Encrypted() == s.policy.Encrypt {
+			if !s.Stale(info) {
+				return nil
+			}
+			rewrapped++
+			if dryRun {
+				return nil
+			}
+			if _, err := s.Rewrap(root, name); err != nil {
+				return fmt.Errorf("chunk %s: %w", name, err)
+			}
+			return nil
+		}
+
+		converted++
+		if dryRun {
+			return nil
+		}
+		r, _, err := s.Open(root, name)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", name, err)
+		}
+		stored, err := s.writeBlob(root, sum, info.Compression, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", name, err)
+		}
+
+		s.index.mu.Lock()
+		if b := s.index.blobs[sum]; b != nil {
+			b.stored = stored
+		}
+		s.index.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return converted, rewrapped, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return converted, rewrapped, nil
+}
+
+// manifestReader reads contents of chunks referenced by manifest
+// one after another and checks their sizes and sums
+type manifestReader struct {
+	store   *Store
+	root    *os.Root
+	entries []entry
+	cur     io.ReadCloser
+	entry   entry
+	hash    hash.Hash
+	read    int64
+}
+
+func (m *manifestReader) Read(p []byte) (int, error) {
+	for {
+		if m.cur == nil {
+			if len(m.entries) == 0 {
+				return 0, io.EOF
+			}
+			if err := m.next(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := m.cur.Read(p)
+		m.hash.Write(p[:n])
+		m.read += int64(n)
+		if m.read > int64(m.entry.size) {
+			return n, fmt.Errorf("%w: chunk %s is longer than referenced", ErrCorrupted, blobName(m.entry.sum))
+		}
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+
+		if m.read != int64(m.entry.size) || [sha256.Size]byte(m.hash.Sum(nil)) != m.entry.sum {
+			return n, fmt.Errorf("%w: chunk %s does not match its reference", ErrCorrupted, blobName(m.entry.sum))
+		}
+		closeErr := m.cur.Close()
+		m.cur = nil
+		if closeErr != nil {
+			return n, closeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// next opens the next chunk
+func (m *manifestReader) next() error {
+	m.entry = m.entries[0]
+	m.entries = m.entries[1:]
+
+	r, _, err := m.store.Open(m.root, blobName(m.entry.sum))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %s: %w", ErrCorrupted, blobName(m.entry.sum), err)
+	}
+
+	m.cur = r
+	m.hash.Reset()
+	m.read = 0
+	return nil
+}
+
+func (m *manifestReader) Close() error {
+	if m.cur == nil {
+		return nil
+	}
+
+	return m.cur.Close()
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+)
+
+func testRoot(t *testing.T, s *Store) *os.Root {
+	t.Helper()
+
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	if err := s.Load(root); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	return root
+}
+
+// blobNames returns base names of chunks of the blob store
+func blobNames(t *testing.T, root *os.Root) []string {
+	t.Helper()
+
+	var names []string
+	err := fs.WalkDir(root.FS(), BlobDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			names = append(names, path.Base(name))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+func TestChunkNames(t *testing.T) {
+	content := testContent(1000)
+	sum := sha256.Sum256(content)
+	plain := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		policy Policy
+		keys   int
+		hashed bool
+	}{
+		{name: "without key", policy: Policy{Default: CompressionNone, Dedup: true}, hashed: true},
+		{name: "with key", policy: Policy{Default: CompressionNone, Dedup: true}, keys: 1},
+		{name: "encrypted", policy: Policy{Default: CompressionNone, Dedup: true, Encrypt: true}, keys: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for range tt.keys {
+				keys = append(keys, testKey(t))
+			}
+			s := testStore(t, tt.policy, keys...)
+			root := testRoot(t, s)
+
+			writeFile(t, s, root, "a", content)
+			writeFile(t, s, root, "b", content)
+
+			names := blobNames(t, root)
+			if len(names) != 1 {
+				t.Fatalf("blob store has %d chunks, want 1", len(names))
+			}
+			if hashed := names[0] == plain; hashed != tt.hashed {
+				t.Errorf("chunk is named by SHA-256 of content = %v, want %v", hashed, tt.hashed)
+			}
+			for _, name := range []string{"a", "b"} {
+				if got := readFile(t, s, root, name); !bytes.Equal(got, content) {
+					t.Errorf("content of %s differs from written one", name)
+				}
+			}
+		})
+	}
+}
+
+// TestFailedChunk checks that chunk which failed to be written
+// is written again by the next file with the same content
+func TestFailedChunk(t *testing.T) {
+	s := testStore(t, Policy{Default: CompressionNone, Dedup: true})
+	root := testRoot(t, s)
+	content := testContent(1000)
+
+	// file in place of directory of the chunk fails its write
+	dir := path.Dir(blobName(sha256.Sum256(content)))
+	if err := root.MkdirAll(BlobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := root.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.NewWriter(root, file, "a")
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	_, err = w.Write(content)
+	if err == nil {
+		err = w.Close()
+	}
+	file.Close()
+	if err == nil {
+		t.Fatalf("write of chunk did not fail")
+	}
+
+	if err := root.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, s, root, "b", content)
+	if got := readFile(t, s, root, "b"); !bytes.Equal(got, content) {
+		t.Errorf("content differs from written one")
+	}
+}
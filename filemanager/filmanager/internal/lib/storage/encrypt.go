@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	keySize   = 32
+	chunkSize = 64 << 10
+	maxChunk  = 16 << 20
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid master key")
+	ErrNoKey      = errors.New("master key is not found")
+)
+
+// encryption describes encryption of content. Key is data key of the
+// file wrapped by master key KeyID. Content is sealed by chunks of Chunk
+// bytes, so any range of it is decrypted without reading the rest
+type encryption struct {
+	KeyID string `json:"key_id"`
+	Key   []byte `json:"key"`
+	Chunk int    `json:"chunk"`
+}
+
+// Keyring keeps master keys. The first key wraps data keys of new files,
+// the others only unwrap data keys of files written before rotation
+type Keyring struct {
+	keys []masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+	// names is key of HMAC which names chunks of deduplicated files
+	names []byte
+}
+
+// LoadKeys reads master keys, which are 32 bytes encoded by base64. Keys
+// go first, then keys of keyFile, one per line. Empty lines and lines
+// starting with # are skipped. Empty keyFile is not read
+func LoadKeys(keyFile string, keys []string) (*Keyring, error) {
+	const op = "storage.LoadKeys"
+
+	encoded := append([]string(nil), keys...)
+	if keyFile != "" {
+		file, err := os.Open(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			encoded = append(encoded, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	k := &Keyring{}
+	for i, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("%s: %w: key %d", op, ErrInvalidKey, i+1)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		sum := sha256.Sum256(key)
+		k.keys = append(k.keys, masterKey{id: hex.EncodeToString(sum[:8]), aead: aead, names: namesKey(key)})
+	}
+
+	return k, nil
+}
+
+// Len returns number of master keys
+func (k *Keyring) Len() int {
+	if k == nil {
+		return 0
+	}
+
+	return len(k.keys)
+}
+
+func (k *Keyring) current() masterKey {
+	return k.keys[0]
+}
+
+// wrap seals new data key by the current master key
+func (k *Keyring) wrap(dataKey []byte) (*encryption, error) {
+	mk := k.current()
+
+	nonce := make([]byte, mk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &encryption{
+		KeyID: mk.id,
+		Key:   mk.aead.Seal(nonce, nonce, dataKey, []byte(mk.id)),
+		Chunk: chunkSize,
+	}, nil
+}
+
+// unwrap opens data key by master key which wrapped it
+func (k *Keyring) unwrap(e *encryption) ([]byte, error) {
+	for _, mk := range k.keyList() {
+		if mk.id != e.KeyID {
+			continue
+		}
+
+		size := mk.aead.NonceSize()
+		if len(e.Key) < size {
+			return nil, ErrCorrupted
+		}
+		key, err := mk.aead.Open(nil, e.Key[:size], e.Key[size:], []byte(mk.id))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("%w: data key is not unwrapped", ErrCorrupted)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoKey, e.KeyID)
+}
+
+func (k *Keyring) keyList() []masterKey {
+	if k == nil {
+		return nil
+	}
+
+	return k.keys
+}
+
+// namesKey derives key naming chunks from master key,
+// so the master key itself is used only by AEAD
+func namesKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("filemanager chunk names"))
+
+	return mac.Sum(nil)
+}
+
+// newDataKey generates data key of a new file
+func newDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce makes nonce of chunk from its number and flag of the last
+// chunk, so reordered, cut or appended chunks are not opened. Data key
+// is unique for every file, so nonces are never reused with the same key
+func chunkNonce(seq int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(seq))
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
+
+// sealer encrypts content by chunks. The last chunk is sealed by Close,
+// it is empty only if the whole content is empty
+type sealer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	chunk int
+	buf   []byte
+	out   []byte
+	seq   int64
+}
+
+func newSealer(w io.Writer, aead cipher.AEAD, chunk int) *sealer {
+	return &sealer{
+		w:     w,
+		aead:  aead,
+		chunk: chunk,
+		buf:   make([]byte, 0, chunk),
+		out:   make([]byte, 0, chunk+aead.Overhead()),
+	}
+}
+
+func (s *sealer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == s.chunk {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(s.chunk-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (s *sealer) Close() error {
+	return s.seal(true)
+}
+
+func (s *sealer) seal(last bool) error {
+	s.out = s.aead.Seal(s.out[:0], chunkNonce(s.seq, last), s.buf, nil)
+	s.seq++
+	s.buf = s.buf[:0]
+
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// opener decrypts content sealed by chunks. It reads any range of the
+// content opening only chunks which the range covers. It is not safe
+// for concurrent use
+type opener struct {
+	r    io.ReaderAt
+	aead cipher.AEAD
+	// total is size of sealed content, size is size of opened content
+	total  int64
+	size   int64
+	sealed int64
+	chunk  int64
+	last   int64
+	// plain is opened chunk number cached, buf keeps it sealed
+	plain  []byte
+	cached int64
+	buf    []byte
+	pos    int64
+}
+
+// newOpener opens content of size bytes sealed by chunks in r
+func newOpener(r io.ReaderAt, size int64, aead cipher.AEAD, chunk int) (*opener, error) {
+	if chunk <= 0 || chunk > maxChunk {
+		return nil, fmt.Errorf("%w: invalid chunk size", ErrCorrupted)
+	}
+
+	sealed := int64(chunk + aead.Overhead())
+	count := (size + sealed - 1) / sealed
+	if count == 0 || size-(count-1)*sealed < int64(aead.Overhead()) {
+		return nil, fmt.Errorf("%w: content is cut", ErrCorrupted)
+	}
+
+	return &opener{
+		r:      r,
+		aead:   aead,
+		total:  size,
+		sealed: sealed,
+		chunk:  int64(chunk),
+		last:   count - 1,
+		size:   size - count*int64(aead.Overhead()),
+		cached: -1,
+		buf:    make([]byte, sealed),
+	}, nil
+}
+
+// load opens chunk seq
+func (o *opener) load(seq int64) error {
+	if o.cached == seq {
+		return nil
+	}
+
+	off := seq * o.sealed
+	buf := o.buf[:min(o.sealed, o.total-off)]
+	n, err := o.r.ReadAt(buf, off)
+	if n < len(buf) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	o.plain, err = o.aead.Open(o.plain[:0], chunkNonce(seq, seq == o.last), buf, nil)
+	if err != nil {
+		o.cached = -1
+		return fmt.Errorf("%w: chunk %d is not authentic", ErrCorrupted, seq)
+	}
+	o.cached = seq
+	return nil
+}
+
+func (o *opener) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	n := 0
+	for n < len(p) && off < o.size {
+		seq := off / o.chunk
+		if err := o.load(seq); err != nil {
+			return n, err
+		}
+
+		k := copy(p[n:], o.plain[off-seq*o.chunk:])
+		n += k
+		off += int64(k)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	n, err := o.ReadAt(p, o.pos)
+	o.pos += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	return n, err
+}
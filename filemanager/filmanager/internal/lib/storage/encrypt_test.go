@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// testChunk is chunk size small enough to build contents of many chunks
+const testChunk = 16
+
+func TestSealOpen(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk int
+		size  int
+	}{
+		{name: "empty", chunk: testChunk, size: 0},
+		{name: "one byte", chunk: testChunk, size: 1},
+		{name: "less than chunk", chunk: testChunk, size: testChunk - 1},
+		{name: "one chunk", chunk: testChunk, size: testChunk},
+		{name: "more than chunk", chunk: testChunk, size: testChunk + 1},
+		{name: "multiple of chunk", chunk: testChunk, size: 5 * testChunk},
+		{name: "many chunks", chunk: testChunk, size: 5*testChunk + 7},
+		{name: "empty with default chunk", chunk: chunkSize, size: 0},
+		{name: "default chunk", chunk: chunkSize, size: chunkSize},
+		{name: "multiple of default chunk", chunk: chunkSize, size: 3 * chunkSize},
+		{name: "many default chunks", chunk: chunkSize, size: 3*chunkSize + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aead := testAEAD(t)
+			content := testContent(tt.size)
+			sealed := seal(t, aead, content, tt.chunk)
+
+			chunks := max((tt.size+tt.chunk-1)/tt.chunk, 1)
+			if want := tt.size + chunks*aead.Overhead(); len(sealed) != want {
+				t.Errorf("sealed %d bytes, want %d", len(sealed), want)
+			}
+
+			o, err := newOpener(bytes.NewReader(sealed), int64(len(sealed)), aead, tt.chunk)
+			if err != nil {
+				t.Fatalf("newOpener() error = %v", err)
+			}
+			if o.size != int64(tt.size) {
+				t.Errorf("size = %d, want %d", o.size, tt.size)
+			}
+
+			got, err := io.ReadAll(o)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("opened content differs from sealed one")
+			}
+		})
+	}
+}
+
+func TestSealerWrites(t *testing.T) {
+	aead := testAEAD(t)
+	content := testContent(7*testChunk + 3)
+	want := seal(t, aead, content, testChunk)
+
+	// the same content written by pieces which do not
+	// match chunks is sealed the same way
+	for _, piece := range []int{1, 3, testChunk - 1, testChunk, testChunk + 5, len(content)} {
+		var b bytes.Buffer
+		s := newSealer(&b, aead, testChunk)
+		for p := content; len(p) > 0; {
+			n := min(piece, len(p))
+			if _, err := s.Write(p[:n]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			p = p[n:]
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		if !bytes.Equal(b.Bytes(), want) {
+			t.Errorf("content written by %d bytes is sealed differently", piece)
+		}
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	aead := testAEAD(t)
+	content := testContent(3*testChunk + 5)
+	sealed := seal(t, aead, content, testChunk)
+	size := testChunk + aead.Overhead()
+
+	// chunk returns copy of sealed chunk i
+	chunk := func(i int) []byte {
+		return bytes.Clone(sealed[i*size : min((i+1)*size, len(sealed))])
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	full := seal(t, aead, testContent(4*testChunk), testChunk)
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		aead   cipher.AEAD
+	}{
+		{name: "empty", sealed: nil},
+		{name: "last chunk removed", sealed: sealed[:3*size]},
+		{name: "last chunk cut", sealed: sealed[:len(sealed)-1]},
+		{name: "last chunk shorter than tag", sealed: sealed[:3*size+aead.Overhead()-1]},
+		{name: "first chunk removed", sealed: sealed[size:]},
+		{name: "chunks reordered", sealed: join(chunk(1), chunk(0), chunk(2), chunk(3))},
+		{name: "chunk repeated", sealed: join(chunk(0), chunk(0), chunk(2), chunk(3))},
+		{name: "chunk appended", sealed: join(sealed, chunk(1))},
+		{name: "last chunk of other content appended", sealed: join(full[:4*size], chunk(3))},
+		{name: "byte appended", sealed: join(sealed, []byte{0})},
+		{name: "full last chunk cut off", sealed: full[:3*size]},
+		{name: "bit flipped", sealed: flip(sealed, size+3)},
+		{name: "tag flipped", sealed: flip(sealed, len(sealed)-1)},
+		{name: "other key", sealed: sealed, aead: testAEAD(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := aead
+			if tt.aead != nil {
+				a = tt.aead
+			}
+
+			o, err := newOpener(bytes.NewReader(tt.sealed), int64(len(tt.sealed)), a, testChunk)
+			if err == nil {
+				_, err = io.ReadAll(o)
+			}
+			if !errors.Is(err, ErrCorrupted) {
+				t.Fatalf("error = %v, want %v", err, ErrCorrupted)
+			}
+		})
+	}
+}
+
+func TestOpenerReadAt(t *testing.T) {
+	aead := testAEAD(t)
+	content := testContent(5*testChunk + 3)
+	sealed := seal(t, aead, content, testChunk)
+	end := len(content)
+
+	o, err := newOpener(bytes.NewReader(sealed), int64(len(sealed)), aead, testChunk)
+	if err != nil {
+		t.Fatalf("newOpener() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		off  int
+		len  int
+		// n is number of read bytes, which is len if it is -1
+		n   int
+		eof bool
+	}{
+		{name: "start of chunk", off: 0, len: 4, n: -1},
+		{name: "inside chunk", off: testChunk + 2, len: 5, n: -1},
+		{name: "whole chunk", off: 2 * testChunk, len: testChunk, n: -1},
+		{name: "across boundary", off: testChunk - 2, len: 4, n: -1},
+		{name: "across many chunks", off: 3, len: 4*testChunk + 1, n: -1},
+		{name: "back to first chunk", off: 1, len: testChunk, n: -1},
+		{name: "last chunk", off: 5 * testChunk, len: 3, n: -1},
+		{name: "whole content", off: 0, len: end, n: -1},
+		{name: "past end", off: end - 2, len: 5, n: 2, eof: true},
+		{name: "at end", off: end, len: 1, n: 0, eof: true},
+		{name: "after end", off: end + testChunk, len: 1, n: 0, eof: true},
+		{name: "empty", off: 7, len: 0, n: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.n
+			if want == -1 {
+				want = tt.len
+			}
+
+			p := make([]byte, tt.len)
+			n, err := o.ReadAt(p, int64(tt.off))
+			if n != want {
+				t.Fatalf("ReadAt() = %d, want %d", n, want)
+			}
+			if tt.eof != errors.Is(err, io.EOF) || (!tt.eof && err != nil) {
+				t.Fatalf("ReadAt() error = %v, want eof %v", err, tt.eof)
+			}
+			if !bytes.Equal(p[:n], content[min(tt.off, end):min(tt.off, end)+n]) {
+				t.Errorf("ReadAt() read other data")
+			}
+		})
+	}
+
+	if _, err := o.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Errorf("ReadAt() at negative offset returns no error")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKey, newKey := testKey(t), testKey(t)
+	policy := Policy{Default: CompressionNone, Encrypt: true}
+	content := testContent(2*chunkSize + 7)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	old := testStore(t, policy, oldKey)
+	writeFile(t, old, root, "file", content)
+	if err := root.Chtimes("file", modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// after rotation the old key only unwraps data keys
+	rotated := testStore(t, policy, newKey, oldKey)
+	info, err := rotated.Stat(root, "file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !rotated.Stale(info) {
+		t.Fatalf("file wrapped by old key is not stale")
+	}
+	if got := readFile(t, rotated, root, "file"); !bytes.Equal(got, content) {
+		t.Fatalf("content read before rewrap differs")
+	}
+
+	ok, err := rotated.Rewrap(root, "file")
+	if err != nil || !ok {
+		t.Fatalf("Rewrap() = %v, %v, want true", ok, err)
+	}
+
+	info, err = rotated.Stat(root, "file")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if rotated.Stale(info) {
+		t.Errorf("rewrapped file is stale")
+	}
+	if info.KeyID != rotated.keys.current().id {
+		t.Errorf("KeyID = %s, want %s", info.KeyID, rotated.keys.current().id)
+	}
+	if !info.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime, modTime)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Size = %d, want %d", info.Size, len(content))
+	}
+
+	if ok, err := rotated.Rewrap(root, "file"); err != nil || ok {
+		t.Errorf("second Rewrap() = %v, %v, want false", ok, err)
+	}
+
+	// the old key is not needed any more
+	current := testStore(t, policy, newKey)
+	if got := readFile(t, current, root, "file"); !bytes.Equal(got, content) {
+		t.Errorf("content read after rewrap differs")
+	}
+
+	r, _, err := current.OpenAt(root, "file")
+	if err != nil {
+		t.Fatalf("OpenAt() error = %v", err)
+	}
+	defer r.Close()
+	p := make([]byte, 10)
+	if _, err := r.ReadAt(p, chunkSize-5); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if !bytes.Equal(p, content[chunkSize-5:chunkSize+5]) {
+		t.Errorf("ReadAt() across chunks read other data")
+	}
+
+	if _, _, err := old.Open(root, "file"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open() by removed key error = %v, want %v", err, ErrNoKey)
+	}
+}
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+
+	key, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return aead
+}
+
+// testContent returns size bytes which differ in every chunk
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/testChunk)
+	}
+
+	return content
+}
+
+func seal(t *testing.T, aead cipher.AEAD, content []byte, chunk int) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	s := newSealer(&b, aead, chunk)
+	if _, err := s.Write(content); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	return b.Bytes()
+}
+
+func flip(b []byte, i int) []byte {
+	b = bytes.Clone(b)
+	b[i] ^= 1
+	return b
+}
+
+// testKey returns master key encoded by base64
+func testKey(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testStore(t *testing.T, policy Policy, keys ...string) *Store {
+	t.Helper()
+
+	ring, err := LoadKeys("", keys)
+	if err != nil {
+		t.Fatalf("LoadKeys() error = %v", err)
+	}
+	s, err := New(policy, ring)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return s
+}
+
+func writeFile(t *testing.T, s *Store, root *os.Root, name string, content []byte) {
+	t.Helper()
+
+	file, err := root.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	w, err := s.NewWriter(root, file, name)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func readFile(t *testing.T, s *Store, root *os.Root, name string) []byte {
+	t.Helper()
+
+	r, _, err := s.Open(root, name)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	return content
+}
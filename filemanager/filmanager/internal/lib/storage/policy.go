@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// Policy selects compression of files by their paths. Dirs maps
+// directories to compression of files inside them, the deepest listed
+// directory of file decides. Files of other directories use Default.
+// If Encrypt is true, all files are encrypted. If Dedup is true, files
+// are deduplicated by chunks, which are compressed by policy of the file
+// which writes them first
+type Policy struct {
+	Default string
+	Dirs    map[string]string
+	Encrypt bool
+	Dedup   bool
+}
+
+// Validate checks that compressions are known and directories are valid paths
+func (p Policy) Validate() error {
+	if !validCompression(p.Default) {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, p.Default)
+	}
+	for dir, c := range p.Dirs {
+		if !fs.ValidPath(dir) {
+			return fmt.Errorf("invalid directory %q", dir)
+		}
+		if !validCompression(c) {
+			return fmt.Errorf("%w: %q of directory %q", ErrUnknownCompression, c, dir)
+		}
+	}
+
+	return nil
+}
+
+// For returns compression of file name
+func (p Policy) For(name string) string {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if c, ok := p.Dirs[dir]; ok {
+			return c
+		}
+		if dir == "." {
+			return p.Default
+		}
+	}
+}
@@ -0,0 +1,741 @@
+// Package storage encodes contents of files kept in the root of filemanager.
+//
+// Encoded file starts with a prefix: magic, logical size of the content as
+// uint64 and length of JSON header as uint32, followed by the header and
+// the encoded content. Content is compressed and then encrypted by chunks
+// with data key of the file, which is kept in the header wrapped by master
+// key. File without the prefix is stored as it is.
+//
+// Deduplicated file is a manifest: its header has no compression and its
+// content is a list of SHA-256 sums and sizes of chunks, which are kept once
+// in BlobDir of the root and are encoded like files.
+package storage
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrCorrupted = errors.New("stored file is corrupted")
+	ErrOffset    = errors.New("offset is out of content")
+)
+
+// magic starts every encoded file
+var magic = []byte("\x89FMS\r\n\x1a\n")
+
+const (
+	prefixSize    = 8 + 8 + 4
+	sizeOffset    = 8
+	maxHeaderSize = 64 << 10
+)
+
+// header describes how content of file is encoded
+type header struct {
+	Compression string      `json:"compression"`
+	Encryption  *encryption `json:"encryption,omitempty"`
+	Manifest    bool        `json:"manifest,omitempty"`
+	// ChunkKey is id of master key which names chunks of manifest
+	ChunkKey string `json:"chunk_key,omitempty"`
+}
+
+// Info describes stored file. Size is logical size of the content,
+// Stored is size of the file on disk
+type Info struct {
+	Size        int64
+	Stored      int64
+	ModTime     time.Time
+	Compression string
+	// KeyID is id of master key which wrapped data key of encrypted file
+	KeyID string
+	// Encoded is false if file is stored as it is
+	Encoded bool
+	// Deduplicated is true if file is manifest of chunks
+	Deduplicated bool
+}
+
+// Encrypted reports whether content of file is encrypted
+func (i Info) Encrypted() bool {
+	return i.KeyID != ""
+}
+
+// ReadAtCloser reads logical content of file by random access
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Store reads and writes files of root encoded by policy
+type Store struct {
+	policy Policy
+	keys   *Keyring
+	index  blobIndex
+}
+
+// New creates store which writes files by policy. Keys unwrap data keys
+// of encrypted files, policy which encrypts files requires a key
+func New(policy Policy, keys *Keyring) (*Store, error) {
+	const op = "storage.New"
+
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if policy.Encrypt && keys.Len() == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoKey)
+	}
+
+	s := &Store{policy: policy, keys: keys}
+	s.index.blobs = make(map[[sha256.Size]byte]*blob)
+	return s, nil
+}
+
+// Compression returns compression which file name is written with
+func (s *Store) Compression(name string) string {
+	return s.policy.For(name)
+}
+
+// Encrypted reports whether new files are encrypted
+func (s *Store) Encrypted() bool {
+	return s.policy.Encrypt
+}
+
+// Dedup reports whether new files are deduplicated
+func (s *Store) Dedup() bool {
+	return s.policy.Dedup
+}
+
+// Outdated reports whether file name described by info is stored other
+// way than store writes it now. Chunks of deduplicated files are checked
+// by MigrateBlobs
+func (s *Store) Outdated(name string, info Info) bool {
+	if info.Deduplicated || s.policy.Dedup {
+		return info.Deduplicated != s.policy.Dedup
+	}
+
+	return info.Compression != s.Compression(name) || info.Encrypted() != s.policy.Encrypt
+}
+
+// Stale reports whether data key of encrypted file is wrapped
+// by master key which is not the current one
+func (s *Store) Stale(info Info) bool {
+	return info.Encrypted() && s.keys.Len() > 0 && info.KeyID != s.keys.current().id
+}
+
+// Stat describes file name reading only its header
+func (s *Store) Stat(root *os.Root, name string) (Info, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return Info{}, err
+	}
+	defer file.Close()
+
+	info, _, _, err := readHeader(file)
+	return info, err
+}
+
+// Open opens file name for reading its logical content. Closing the reader
+// closes the file. Reader of file which is not encoded is the *os.File
+func (s *Store) Open(root *os.Root, name string) (io.ReadCloser, Info, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	r, info, err := s.decode(root, file)
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+
+	return r, info, nil
+}
+
+// OpenFrom opens file name like Open, but the reader starts at offset of its
+// logical content. Content stored as is or only encrypted is read from
+// offset, compressed and deduplicated content is decoded from the start and
+// skipped up to offset. Offset beyond the content fails with ErrOffset
+func (s *Store) OpenFrom(root *os.Root, name string, offset int64) (io.ReadCloser, Info, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	r, info, err := s.decodeFrom(root, file, offset)
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+
+	return r, info, nil
+}
+
+func (s *Store) decodeFrom(root *os.Root, file *os.File, offset int64) (io.ReadCloser, Info, error) {
+	info, h, start, err := readHeader(file)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if offset < 0 || offset > info.Size {
+		return nil, Info{}, fmt.Errorf("%w: %d of %d bytes", ErrOffset, offset, info.Size)
+	}
+
+	if !info.Encoded {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, Info{}, err
+		}
+		return file, info, nil
+	}
+
+	if h.Compression == CompressionNone && !h.Manifest {
+		content, size, err := s.content(file, h, start, info.Stored-start)
+		if err != nil {
+			return nil, Info{}, err
+		}
+		dec := io.NopCloser(io.NewSectionReader(content, offset, size-offset))
+		return &reader{dec: dec, file: file, left: info.Size - offset}, info, nil
+	}
+
+	r, _, err := s.decode(root, file)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, Info{}, err
+	}
+
+	return r, info, nil
+}
+
+// OpenAt opens file name for random access to its logical content.
+// Compressed and deduplicated content has no random access, so it is decoded into temporary
+// file out of the root. The copy is encrypted by key which is kept only
+// in memory, it is removed when the reader is closed
+func (s *Store) OpenAt(root *os.Root, name string) (ReadAtCloser, Info, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	info, h, offset, err := readHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+	if !info.Encoded {
+		return file, info, nil
+	}
+
+	if h.Compression == CompressionNone && !h.Manifest {
+		content, size, err := s.content(file, h, offset, info.Stored-offset)
+		if err == nil && size != info.Size {
+			err = fmt.Errorf("%w: content is not of recorded size", ErrCorrupted)
+		}
+		if err != nil {
+			file.Close()
+			return nil, Info{}, err
+		}
+		return &contentCloser{content: content, file: file}, info, nil
+	}
+
+	r, _, err := s.decode(root, file)
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+	defer r.Close()
+
+	sp, err := spool(r)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	return sp, info, nil
+}
+
+// NewWriter returns writer encoding content of file name of root into file,
+// which is empty. File has to be written only through the writer, its Close
+// finishes encoding, but does not close the file. Chunks of deduplicated
+// file are referenced as soon as they are written, so file which is not
+// finished has to be removed by Remove
+func (s *Store) NewWriter(root *os.Root, file *os.File, name string) (*Writer, error) {
+	if s.policy.Dedup {
+		return s.manifestWriter(root, file, name)
+	}
+
+	return s.encodedWriter(file, s.Compression(name))
+}
+
+// encodedWriter returns writer encoding content with compression
+// and encryption of the policy
+func (s *Store) encodedWriter(file *os.File, compression string) (*Writer, error) {
+	h := header{Compression: compression}
+	if !s.policy.Encrypt {
+		return newWriter(file, h, nil)
+	}
+
+	key, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	h.Encryption, err = s.keys.wrap(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(file, h, aead)
+}
+
+// Rewrap wraps data key of encrypted file name by the current master key.
+// Only header of the file is rewritten, its content and modification time
+// stay as they are. It returns false if the file is not Stale
+func (s *Store) Rewrap(root *os.Root, name string) (bool, error) {
+	file, err := root.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, h, offset, err := readHeader(file)
+	if err != nil {
+		return false, err
+	}
+	if !s.Stale(info) {
+		return false, nil
+	}
+
+	key, err := s.keys.unwrap(h.Encryption)
+	if err != nil {
+		return false, err
+	}
+	wrapped, err := s.keys.wrap(key)
+	if err != nil {
+		return false, err
+	}
+	wrapped.Chunk = h.Encryption.Chunk
+	h.Encryption = wrapped
+
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return false, err
+	}
+	// ids of master keys and wrapped keys have fixed size,
+	// so header is rewritten in place
+	if prefixSize+int64(len(raw)) != offset {
+		return false, errors.New("header of rewrapped key changes its size")
+	}
+	if _, err := file.WriteAt(raw, prefixSize); err != nil {
+		return false, err
+	}
+	if err := file.Sync(); err != nil {
+		return false, err
+	}
+
+	if err := root.Chtimes(name, info.ModTime, info.ModTime); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// readHeader reads prefix and header of file.
+// It returns offset of the content in file
+func readHeader(file *os.File) (Info, header, int64, error) {
+	var h header
+
+	stat, err := file.Stat()
+	if err != nil {
+		return Info{}, h, 0, err
+	}
+	info := Info{
+		Size:        stat.Size(),
+		Stored:      stat.Size(),
+		ModTime:     stat.ModTime(),
+		Compression: CompressionNone,
+	}
+
+	prefix := make([]byte, prefixSize)
+	n, err := file.ReadAt(prefix, 0)
+	if n < len(magic) || !bytes.Equal(prefix[:len(magic)], magic) {
+		return info, h, 0, nil
+	}
+	if n < prefixSize {
+		return Info{}, h, 0, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	size := binary.BigEndian.Uint64(prefix[sizeOffset:])
+	length := binary.BigEndian.Uint32(prefix[sizeOffset+8:])
+	if length > maxHeaderSize || prefixSize+int64(length) > stat.Size() {
+		return Info{}, h, 0, fmt.Errorf("%w: invalid header length", ErrCorrupted)
+	}
+
+	raw := make([]byte, length)
+	if _, err := file.ReadAt(raw, prefixSize); err != nil {
+		return Info{}, h, 0, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return Info{}, h, 0, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	if !validCompression(h.Compression) {
+		return Info{}, h, 0, fmt.Errorf("%w: %w: %q", ErrCorrupted, ErrUnknownCompression, h.Compression)
+	}
+
+	info.Size = int64(size)
+	info.Compression = h.Compression
+	if h.Encryption != nil {
+		info.KeyID = h.Encryption.KeyID
+	}
+	info.Encoded = true
+	info.Deduplicated = h.Manifest
+	return info, h, prefixSize + int64(length), nil
+}
+
+// decode returns reader of logical content of file of root
+func (s *Store) decode(root *os.Root, file *os.File) (io.ReadCloser, Info, error) {
+	info, h, offset, err := readHeader(file)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if !info.Encoded {
+		return file, info, nil
+	}
+	if h.Manifest {
+		entries, err := readEntries(file, offset, info.Stored)
+		if err != nil {
+			return nil, Info{}, fmt.Errorf("%w: %w", ErrCorrupted, err)
+		}
+		sums, err := s.chunkHash(h.ChunkKey)
+		if err != nil {
+			return nil, Info{}, err
+		}
+		m := &manifestReader{store: s, root: root, entries: entries, hash: sums}
+		return &reader{dec: m, file: file, left: info.Size}, info, nil
+	}
+
+	content, _, err := s.content(file, h, offset, info.Stored-offset)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	dec, err := newDecoder(h.Compression, content)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	return &reader{dec: dec, file: file, left: info.Size}, info, nil
+}
+
+// contentReader reads encoded content of file, decrypted if it is encrypted
+type contentReader interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// content returns content of file, which starts at offset and has size
+// bytes on disk, and size of the content after decryption
+func (s *Store) content(file *os.File, h header, offset int64, size int64) (contentReader, int64, error) {
+	body := io.NewSectionReader(file, offset, size)
+	if h.Encryption == nil {
+		return body, size, nil
+	}
+
+	key, err := s.keys.unwrap(h.Encryption)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	o, err := newOpener(body, size, aead, h.Encryption.Chunk)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return o, o.size, nil
+}
+
+// contentCloser reads content by random access and closes its file
+type contentCloser struct {
+	content contentReader
+	file    *os.File
+}
+
+func (c *contentCloser) ReadAt(p []byte, off int64) (int, error) {
+	return c.content.ReadAt(p, off)
+}
+
+func (c *contentCloser) Close() error {
+	return c.file.Close()
+}
+
+// spooled is a temporary copy of content encrypted by key kept in memory
+type spooled struct {
+	*opener
+	file *os.File
+}
+
+func (s *spooled) Close() error {
+	return errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+}
+
+// spool copies r into temporary file out of the root
+func spool(r io.Reader) (*spooled, error) {
+	key, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "fm-spool-*")
+	if err != nil {
+		return nil, err
+	}
+
+	sealer := newSealer(file, aead, chunkSize)
+	_, err = io.Copy(sealer, r)
+	if err == nil {
+		err = sealer.Close()
+	}
+	var o *opener
+	if err == nil {
+		var stat os.FileInfo
+		if stat, err = file.Stat(); err == nil {
+			o, err = newOpener(file, stat.Size(), aead, chunkSize)
+		}
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &spooled{opener: o, file: file}, nil
+}
+
+// reader reads decoded content and checks that it has the recorded size
+type reader struct {
+	dec  io.ReadCloser
+	file *os.File
+	left int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.dec.Read(p)
+	r.left -= int64(n)
+	switch {
+	case r.left < 0:
+		return n, fmt.Errorf("%w: content is longer than recorded", ErrCorrupted)
+	case errors.Is(err, io.EOF) && r.left > 0:
+		return n, fmt.Errorf("%w: content is shorter than recorded", ErrCorrupted)
+	case err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, ErrCorrupted):
+		return n, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return errors.Join(r.dec.Close(), r.file.Close())
+}
+
+// Writer encodes content written into it. Logical size
+// of the content is recorded into the prefix by Close
+type Writer struct {
+	file   *os.File
+	header header
+	aead   cipher.AEAD
+	enc    io.WriteCloser
+	seal   *sealer
+	chunks *chunker
+	out    io.Writer
+	size   int64
+	done   bool
+	// head keeps the start of content stored as it is until it
+	// is known whether the content looks like encoded file
+	head []byte
+}
+
+func newWriter(file *os.File, h header, aead cipher.AEAD) (*Writer, error) {
+	w := &Writer{file: file, header: h, aead: aead}
+	if h.Compression == CompressionNone && h.Encryption == nil {
+		return w, nil
+	}
+
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// manifestWriter returns writer which cuts content into chunks, writes
+// them into the blob store of root and their entries into file
+func (s *Store) manifestWriter(root *os.Root, file *os.File, name string) (*Writer, error) {
+	h := header{Compression: CompressionNone, Manifest: true}
+	if s.keys.Len() > 0 {
+		h.ChunkKey = s.keys.current().id
+	}
+	sums, err := s.chunkHash(h.ChunkKey)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{file: file, header: h}
+	if err := w.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	w.chunks = newChunker(func(chunk []byte) error {
+		sums.Reset()
+		sums.Write(chunk)
+		e := entry{sum: [sha256.Size]byte(sums.Sum(nil)), size: uint32(len(chunk))}
+
+		return s.putChunk(root, name, chunk, e, func(e entry) error {
+			_, err := file.Write(e.marshal())
+			return err
+		})
+	})
+	w.out = w.chunks
+	return w, nil
+}
+
+// writeHeader writes prefix with header
+func (w *Writer) writeHeader() error {
+	raw, err := json.Marshal(w.header)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, prefixSize, prefixSize+len(raw))
+	copy(prefix, magic)
+	binary.BigEndian.PutUint32(prefix[sizeOffset+8:], uint32(len(raw)))
+	_, err = w.file.Write(append(prefix, raw...))
+	return err
+}
+
+// start writes prefix with header and prepares encoder of the content
+func (w *Writer) start() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	var out io.Writer = w.file
+	if w.header.Encryption != nil {
+		w.seal = newSealer(w.file, w.aead, w.header.Encryption.Chunk)
+		out = w.seal
+	}
+	enc, err := newEncoder(w.header.Compression, out)
+	if err != nil {
+		return err
+	}
+	w.enc = enc
+	w.out = enc
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.out == nil {
+		w.head = append(w.head, p...)
+		if len(w.head) < len(magic) {
+			return len(p), nil
+		}
+		if err := w.flushHead(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	n, err := w.out.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// flushHead writes the start of content stored as it is. Content which
+// starts like encoded file is written with header, so it is not decoded
+func (w *Writer) flushHead() error {
+	if bytes.HasPrefix(w.head, magic) {
+		if err := w.start(); err != nil {
+			return err
+		}
+	} else {
+		w.out = w.file
+	}
+
+	head := w.head
+	w.head = nil
+	n, err := w.out.Write(head)
+	w.size += int64(n)
+	return err
+}
+
+// Close finishes encoding and records logical size of the content
+func (w *Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	if w.chunks != nil {
+		if err := w.chunks.Close(); err != nil {
+			return err
+		}
+		return w.writeSize()
+	}
+	if w.out == nil {
+		if err := w.flushHead(); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+
+	if err := w.enc.Close(); err != nil {
+		return err
+	}
+	if w.seal != nil {
+		if err := w.seal.Close(); err != nil {
+			return err
+		}
+	}
+
+	return w.writeSize()
+}
+
+// Abort stops writing of content which is not finished, so encoder
+// does not keep its resources. Content which is not written yet is
+// dropped, the file has to be removed by Remove. It does nothing
+// after Close
+func (w *Writer) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+
+	if w.chunks != nil {
+		w.chunks.buf = nil
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+	}
+}
+
+// writeSize records logical size of the content into the prefix
+func (w *Writer) writeSize() error {
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(w.size))
+	_, err := w.file.WriteAt(size, sizeOffset)
+	return err
+}
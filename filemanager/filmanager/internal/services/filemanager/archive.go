@@ -0,0 +1,235 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry describes file or directory of archived directory.
+// Name is a path relative to the archived directory
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+}
+
+// ArchiveSender sends archived directory. Every entry is sent by SendEntry
+// and data of file follows it as chunks sent by MySend
+type ArchiveSender interface {
+	Sender
+	SendEntry(ArchiveEntry) error
+}
+
+// Filter selects files by glob patterns of path.Match. Pattern containing
+// slash matches path relative to the archived directory, other patterns
+// match base name. File is selected if it matches any of Include, or
+// Include is empty, and matches none of Exclude. Excluded directory
+// is skipped with all its contents
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// Validate checks syntax of patterns
+func (f Filter) Validate() error {
+	for _, p := range append(f.Include, f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+func (f Filter) included(name string) bool {
+	return len(f.Include) == 0 || matchAny(f.Include, name)
+}
+
+func (f Filter) excluded(name string) bool {
+	return matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		target := name
+		if !strings.Contains(p, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Archive walks directory dir and sends its files selected by filter
+// without staging them anywhere. Directories are sent only if Include
+// is empty, so empty directories are kept in full archives. The walk
+// is not limited by timeout of filemanager, because large directories
+// take long, it is stopped when ctx is done
+func (f *FileManager) Archive(
+	ctx context.Context,
+	dir string,
+	filter Filter,
+	stream ArchiveSender,
+) (err error) {
+	const op = "filemanager.Archive"
+	log := f.log.With(slog.String("op", op))
+	log.Info("starting to archive directory", slog.String("dir", dir))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	span := startTransferSpan(ctx, "filemanager.archive", dir)
+	defer func() {
+		span.end(err)
+	}()
+
+	if dir == "" {
+		dir = "."
+	}
+	if !fs.ValidPath(dir) || IsHidden(dir) {
+		log.Warn("invalid directory path", slog.String("dir", dir))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+	if err := filter.Validate(); err != nil {
+		log.Warn("invalid filter", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	stat, err := f.root.Stat(dir)
+	if err != nil || !stat.IsDir() {
+		log.Warn("directory not found", slog.String("dir", dir))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	files := 0
+	buf := make([]byte, bufSize)
+	err = fs.WalkDir(f.root.FS(), dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if IsHidden(name) && d.IsDir() {
+			return fs.SkipDir
+		}
+		if name == dir || IsHidden(name) {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(name, dir+"/")
+		if dir == "." {
+			rel = name
+		}
+
+		if filter.excluded(rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if len(filter.Include) > 0 {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return stream.SendEntry(ArchiveEntry{Name: rel, IsDir: true, ModTime: info.ModTime()})
+		}
+		if !d.Type().IsRegular() || !filter.included(rel) {
+			return nil
+		}
+
+		sent, err := f.archiveFile(name, rel, stream, buf, span)
+		if err != nil {
+			return err
+		}
+		span.bytes += sent
+		files++
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			log.Warn("archiving is stopped", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to archive directory", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInternal)
+	}
+
+	log.Info("finished archiving directory", slog.Int("files", files), slog.Int64("sent", span.bytes))
+	return nil
+}
+
+// archiveFile sends entry of file and its decoded data. Size of the entry
+// is size of content of the opened file, so exactly that many bytes follow
+// the entry. File which is deleted while directory is walked is skipped
+func (f *FileManager) archiveFile(
+	name string,
+	rel string,
+	stream ArchiveSender,
+	buf []byte,
+	span *transferSpan,
+) (int64, error) {
+	file, info, err := f.store.Open(f.root, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	err = stream.SendEntry(ArchiveEntry{Name: rel, Size: info.Size, ModTime: info.ModTime})
+	if err != nil {
+		return 0, err
+	}
+
+	r := io.LimitReader(file, info.Size)
+	sent := int64(0)
+	for {
+		t1 := time.Now()
+		n, err := r.Read(buf)
+		span.measureDisk(t1)
+		if n > 0 {
+			t1 = time.Now()
+			if err := stream.MySend(buf[:n]); err != nil {
+				return sent, err
+			}
+			span.measureStream(t1)
+			sent += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	if sent != info.Size {
+		return sent, fmt.Errorf("file %s is truncated while it is archived", name)
+	}
+
+	return sent, nil
+}
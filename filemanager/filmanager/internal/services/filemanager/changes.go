@@ -0,0 +1,79 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"log/slog"
+	"time"
+)
+
+// Op is a kind of change of the file
+type Op string
+
+const (
+	OpCreate Op = "create"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+	// OpMove is a rename of file or directory From to Name
+	OpMove  Op = "move"
+	OpMkdir Op = "mkdir"
+)
+
+// Valid reports whether o is a known kind of change
+func (o Op) Valid() bool {
+	switch o {
+	case OpCreate, OpUpdate, OpDelete, OpMove, OpMkdir:
+		return true
+	}
+
+	return false
+}
+
+// Change describes committed change of the file.
+// Name is a path relative to the root, From is the previous path of moved file
+type Change struct {
+	Op   Op        `json:"op"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	From string    `json:"from,omitempty"`
+}
+
+// Observer is notified about every committed change.
+// If observer fails, client gets ErrNotPropagated though the change is committed
+type Observer interface {
+	OnChange(ctx context.Context, change Change) error
+}
+
+// Observe registers observer of committed changes.
+// It has to be called before file manager serves requests
+func (f *FileManager) Observe(o Observer) {
+	f.observers = append(f.observers, o)
+}
+
+// notify passes committed change to every observer
+func (f *FileManager) notify(ctx context.Context, log *slog.Logger, op Op, name string) error {
+	return f.propagate(ctx, log, Change{Op: op, Name: name, Time: time.Now()})
+}
+
+// propagate passes change to every observer, even if some of them fail,
+// so failure of one observer does not hide the change from the others
+func (f *FileManager) propagate(ctx context.Context, log *slog.Logger, change Change) error {
+	var errs []error
+	for _, o := range f.observers {
+		if err := o.OnChange(ctx, change); err != nil {
+			log.Error("failed to propagate change",
+				sl.Err(err),
+				slog.String("op", string(change.Op)),
+				slog.String("file name", change.Name),
+			)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrNotPropagated, errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -6,4 +6,15 @@ var (
 	ErrReceiveFile = errors.New("failed to download file chunk")
 	ErrBadRequest  = errors.New("bad request")
 	ErrInternal    = errors.New("internal error occurred")
+	ErrExists      = errors.New("file already exists")
+	// ErrNotPropagated means that change is committed,
+	// but one of observers failed to process it
+	ErrNotPropagated = errors.New("change is not propagated")
+	// ErrMismatch means that patched file does not match checksum of the
+	// new content, so the file is changed since its signature was sent
+	ErrMismatch = errors.New("patched file does not match checksum")
+	// ErrChanged means that read file does not match
+	// size and modification time required by ReadOptions
+	ErrChanged    = errors.New("file is changed")
+	ErrOutOfRange = errors.New("offset is out of file")
 )
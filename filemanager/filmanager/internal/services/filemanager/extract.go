@@ -0,0 +1,297 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Statuses of extracted entries
+const (
+	ExtractCreated  = "created"
+	ExtractReplaced = "replaced"
+	ExtractSkipped  = "skipped"
+	ExtractFailed   = "failed"
+)
+
+// ExtractEntry starts file or directory of extracted archive if name is
+// set, otherwise its chunk continues data of the last started file.
+// Name is a path relative to the root
+type ExtractEntry interface {
+	GetName() string
+	GetSize() int64
+	GetIsDir() bool
+	GetModTime() int64
+	GetOverwrite() bool
+	GetChunk() []byte
+}
+
+type ExtractReceiver interface {
+	MyReceive() (ExtractEntry, error)
+}
+
+// ExtractResult reports what is done with entry of extracted archive
+type ExtractResult struct {
+	Name   string
+	Status string
+	Error  string
+}
+
+// extracted is a file of archive which is being received
+type extracted struct {
+	name    string
+	size    int64
+	modTime time.Time
+	exists  bool
+	file    *os.File
+	w       *storage.Writer
+	tmpName string
+	written int64
+}
+
+// Extract receives entries of archive unpacked by client and writes them
+// into the root. Every file is received into temporary file and committed
+// when it is complete, so broken extraction leaves only whole files.
+// Entries which can not be written are reported and skipped, extraction
+// goes on. Existing files are replaced only if entry asks to overwrite them.
+// Like Archive, Extract is not limited by timeout of filemanager
+func (f *FileManager) Extract(ctx context.Context, recv ExtractReceiver) (results []ExtractResult, err error) {
+	const op = "filemanager.Extract"
+	log := f.log.With(slog.String("op", op))
+	log.Info("starting to extract archive")
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	span := startTransferSpan(ctx, "filemanager.extract", "")
+	defer func() {
+		span.end(err)
+	}()
+
+	var (
+		cur     *extracted
+		discard bool
+	)
+	defer func() {
+		if cur != nil {
+			f.abandon(log, cur)
+		}
+	}()
+
+	for {
+		t1 := time.Now()
+		msg, err := recv.MyReceive()
+		span.measureStream(t1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				log.Warn("context error", sl.Err(ctxErr))
+				return nil, fmt.Errorf("%s: %w", op, ctxErr)
+			}
+			log.Error("failed to receive archive entry", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, ErrReceiveFile)
+		}
+
+		if msg.GetName() == "" {
+			if discard {
+				continue
+			}
+			if cur == nil {
+				log.Warn("chunk is received before any file")
+				return nil, fmt.Errorf("%s: %w", op, ErrBadRequest)
+			}
+
+			t1 = time.Now()
+			n, err := cur.w.Write(msg.GetChunk())
+			span.measureDisk(t1)
+			cur.written += int64(n)
+			span.bytes += int64(n)
+			if err == nil && cur.written > cur.size {
+				err = fmt.Errorf("file is larger than %d bytes", cur.size)
+			}
+			if err != nil {
+				log.Warn("failed to write extracted file", sl.Err(err), slog.String("file name", cur.name))
+				results = append(results, ExtractResult{Name: cur.name, Status: ExtractFailed, Error: err.Error()})
+				f.abandon(log, cur)
+				cur, discard = nil, true
+			}
+			continue
+		}
+
+		if cur != nil {
+			results = append(results, f.finishExtracted(ctx, log, cur))
+			cur = nil
+		}
+		discard = true
+
+		var res ExtractResult
+		cur, res = f.startExtracted(ctx, log, msg)
+		if cur != nil {
+			discard = false
+			continue
+		}
+		results = append(results, res)
+	}
+
+	if cur != nil {
+		results = append(results, f.finishExtracted(ctx, log, cur))
+		cur = nil
+	}
+
+	log.Info("archive is extracted", slog.Int("entries", len(results)), slog.Int64("written", span.bytes))
+	return results, nil
+}
+
+// startExtracted creates directory of entry or temporary file receiving
+// file of entry. If entry is done at once, its result is returned
+func (f *FileManager) startExtracted(
+	ctx context.Context,
+	log *slog.Logger,
+	msg ExtractEntry,
+) (*extracted, ExtractResult) {
+	name := msg.GetName()
+	res := ExtractResult{Name: name, Status: ExtractFailed}
+
+	if !fs.ValidPath(name) || name == "." || IsHidden(name) || isMember(name) {
+		log.Warn("invalid path of extracted entry", slog.String("file name", name))
+		res.Error = "invalid path"
+		return nil, res
+	}
+
+	stat, err := f.root.Lstat(name)
+	exists := err == nil
+	if exists && stat.Mode()&fs.ModeSymlink != 0 {
+		log.Warn("extracted entry is a symlink in root", slog.String("file name", name))
+		res.Error = "path is a symlink"
+		return nil, res
+	}
+
+	if msg.GetIsDir() {
+		if exists {
+			if !stat.IsDir() {
+				res.Error = "file with the same name exists"
+				return nil, res
+			}
+			res.Status = ExtractSkipped
+			return nil, res
+		}
+
+		if err := f.root.MkdirAll(name, 0o755); err != nil {
+			log.Warn("failed to create directory", sl.Err(err))
+			res.Error = "failed to create directory"
+			return nil, res
+		}
+		res.Status = ExtractCreated
+		if err := f.notify(ctx, log, OpMkdir, name); err != nil {
+			res.Error = err.Error()
+		}
+		return nil, res
+	}
+
+	if exists && stat.IsDir() {
+		res.Error = "directory with the same name exists"
+		return nil, res
+	}
+	if exists && !msg.GetOverwrite() {
+		res.Status = ExtractSkipped
+		res.Error = "file already exists"
+		return nil, res
+	}
+
+	// archive may have no entries of directories of its files
+	file, tmpName, err := f.createTemp(WithParents(ctx), log, name, exists)
+	if err != nil {
+		res.Error = err.Error()
+		return nil, res
+	}
+
+	e := &extracted{
+		name:    name,
+		size:    msg.GetSize(),
+		exists:  exists,
+		file:    file,
+		tmpName: tmpName,
+	}
+	e.w, err = f.store.NewWriter(f.root, file, name)
+	if err != nil {
+		log.Error("failed to start encoding file", sl.Err(err))
+		res.Error = ErrInternal.Error()
+		f.abandon(log, e)
+		return nil, res
+	}
+	if msg.GetModTime() != 0 {
+		e.modTime = time.Unix(msg.GetModTime(), 0)
+	}
+
+	return e, ExtractResult{}
+}
+
+// finishExtracted commits received file with modification time of entry.
+// Entry without modification time keeps time of receiving
+func (f *FileManager) finishExtracted(ctx context.Context, log *slog.Logger, e *extracted) ExtractResult {
+	res := ExtractResult{Name: e.name, Status: ExtractFailed}
+
+	if e.written != e.size {
+		log.Warn("extracted file is truncated", slog.String("file name", e.name))
+		res.Error = fmt.Sprintf("received %d of %d bytes", e.written, e.size)
+		f.abandon(log, e)
+		return res
+	}
+
+	err := errors.Join(e.w.Close(), e.file.Close())
+	e.file = nil
+	if err == nil && !e.modTime.IsZero() {
+		err = f.root.Chtimes(e.tmpName, e.modTime, e.modTime)
+	}
+	if err != nil {
+		log.Error("failed to finish extracted file", sl.Err(err))
+		res.Error = ErrInternal.Error()
+		f.abandon(log, e)
+		return res
+	}
+
+	if err := f.commit(ctx, log, e.tmpName, e.name, e.exists); err != nil {
+		res.Error = err.Error()
+		f.abandon(log, e)
+		return res
+	}
+
+	res.Status = ExtractCreated
+	op := OpCreate
+	if e.exists {
+		res.Status = ExtractReplaced
+		op = OpUpdate
+	}
+	if err := f.notify(ctx, log, op, e.name); err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// abandon removes temporary file of entry which is not committed
+func (f *FileManager) abandon(log *slog.Logger, e *extracted) {
+	if e.w != nil {
+		e.w.Abort()
+	}
+	if e.file != nil {
+		if err := e.file.Close(); err != nil {
+			log.Error("failed to close file", sl.Err(err))
+		}
+		e.file = nil
+	}
+	if err := f.store.Remove(f.root, e.tmpName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error("failed to remove temporary file", sl.Err(err))
+	}
+}
@@ -2,14 +2,19 @@ package filemanager
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
 	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
 	"io"
 	"io/fs"
 	"log/slog"
 	"os"
+	"path"
+	"strings"
 	"time"
 )
 
@@ -19,6 +24,27 @@ type Sender interface {
 	MySend([]byte) error
 }
 
+// FileSender sends content of file. Size and modification time
+// of the whole file are sent by SendInfo before the content
+type FileSender interface {
+	Sender
+	SendInfo(size int64, modTime time.Time) error
+}
+
+// ReadOptions select part of file to read. If IfModTime is set, file is read
+// only if it still has size IfSize and modification time IfModTime in unix
+// seconds, so download can be resumed from Offset of the same content
+type ReadOptions struct {
+	Offset    int64
+	IfSize    int64
+	IfModTime int64
+}
+
+// changed reports whether file of size and modTime does not match o
+func (o ReadOptions) changed(size int64, modTime time.Time) bool {
+	return o.IfModTime != 0 && (size != o.IfSize || modTime.Unix() != o.IfModTime)
+}
+
 type Receiver interface {
 	MyReceive() (FileProvider, error)
 }
@@ -29,18 +55,25 @@ type FileProvider interface {
 }
 
 type FileManager struct {
-	log     *slog.Logger
-	root    *os.Root
-	timeout time.Duration
+	log       *slog.Logger
+	root      *os.Root
+	store     *storage.Store
+	timeout   time.Duration
+	observers []Observer
+	uploads   *uploads
 }
 
 const (
-	bufSize = 4096
+	bufSize    = 4096
+	tempPrefix = ".fmtmp-"
 )
 
+// New creates file manager of directory rootPath.
+// Contents of files are encoded on disk by store
 func New(
 	log *slog.Logger,
 	rootPath string,
+	store *storage.Store,
 	timeout time.Duration,
 ) *FileManager {
 	const op = "filemanager.New"
@@ -50,6 +83,9 @@ func New(
 		panic("cannot open root directory: " + err.Error())
 	}
 
+	removeTemps(log, root)
+	loadBlobs(log, root, store)
+
 	log.Info("created file manager",
 		slog.String("root path", rootPath),
 		slog.String("op", op),
@@ -58,18 +94,27 @@ func New(
 	return &FileManager{
 		log:     log,
 		root:    root,
+		store:   store,
 		timeout: timeout,
+		uploads: newUploads(),
 	}
 }
 
+// GetFile sends content of file from opts.Offset. It fails with ErrChanged
+// if the file does not match preconditions of opts and with ErrOutOfRange
+// if offset is beyond the end of the file
 func (f *FileManager) GetFile(
 	ctx context.Context,
 	fileName string,
-	stream Sender,
-) error {
+	opts ReadOptions,
+	stream FileSender,
+) (err error) {
 	const op = "filemanager.GetFile"
 	log := f.log.With(slog.String("op", op))
-	log.Info("starting to upload file", slog.String("file-name", fileName))
+	log.Info("starting to upload file",
+		slog.String("file-name", fileName),
+		slog.Int64("offset", opts.Offset),
+	)
 
 	if err := ctx.Err(); err != nil {
 		log.Error("context error", sl.Err(ctx.Err()))
@@ -79,22 +124,13 @@ func (f *FileManager) GetFile(
 	ctx, cancel := context.WithTimeout(ctx, f.timeout)
 	defer cancel()
 
-	stat, err := f.root.Stat(fileName)
-	if err != nil {
-		log.Error("failed to get stat file",
-			sl.Err(err),
-			slog.String("file Name: ", fileName),
-		)
-		return fmt.Errorf("%s: %w", op, ErrBadRequest)
-	}
-	if stat.IsDir() {
-		log.Warn("try open directory")
-		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	if opts.Offset < 0 || (opts.IfModTime != 0 && opts.Offset > opts.IfSize) {
+		log.Warn("invalid offset", slog.Int64("offset", opts.Offset))
+		return fmt.Errorf("%s: %w", op, ErrOutOfRange)
 	}
 
-	file, err := f.root.Open(fileName)
+	file, info, err := f.openReader(ctx, log, fileName, opts)
 	if err != nil {
-		log.Warn("failed to open file", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer func() {
@@ -103,10 +139,23 @@ func (f *FileManager) GetFile(
 			log.Error("failed to close file", sl.Err(err))
 		}
 	}()
+	size := info.Size
+
+	span := startTransferSpan(ctx, "filemanager.read", fileName)
+	defer func() {
+		span.end(err)
+	}()
+
+	if err = stream.SendInfo(info.Size, info.ModTime); err != nil {
+		log.Error("failed to send file info", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
 	var n int
+	var readErr error
+	var t1 time.Time
 	buf := make([]byte, bufSize)
-	size := int64(0)
+	sent := int64(0)
 	for {
 		select {
 		case <-ctx.Done():
@@ -120,38 +169,52 @@ func (f *FileManager) GetFile(
 		default:
 		}
 
-		n, err = file.Read(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
+		t1 = time.Now()
+		n, readErr = file.Read(buf)
+		span.measureDisk(t1)
+
+		// readers of archive members return the last data along with EOF
+		if n > 0 {
+			sent += int64(n)
+			span.bytes = sent
+			t1 = time.Now()
+			err = stream.MySend(buf[:n])
+			span.measureStream(t1)
+			if err != nil {
+				log.Error(
+					"failed to send file",
+					sl.Err(err),
+					slog.Int64("sent", sent),
+				)
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
 				log.Info("EOF is received")
 				break
 			}
 
+			err = readErr
 			log.Error("failed to read file", sl.Err(err))
 			return fmt.Errorf("%s: %w", op, err)
 		}
-
-		size += int64(n)
-		err = stream.MySend(buf[:n])
-		if err != nil {
-			log.Error(
-				"failed to send file",
-				sl.Err(err),
-				slog.Int64("sent", size),
-			)
-			return fmt.Errorf("%s: %w", op, err)
-		}
 	}
 
 	log.Info(
 		"finished getting file",
-		slog.Int64("sent", size),
-		slog.Int64("total", stat.Size()),
+		slog.Int64("sent", sent),
+		slog.Int64("offset", opts.Offset),
+		slog.Int64("total", size),
 	)
 
 	return nil
 }
 
+// PostFile receives new file into temporary file and moves it to the
+// requested path only when the whole file is received. If ctx is cancelled
+// or receiving fails, temporary file is removed and nothing is created.
 func (f *FileManager) PostFile(
 	ctx context.Context,
 	recv Receiver,
@@ -168,88 +231,8 @@ func (f *FileManager) PostFile(
 	ctx, cancel := context.WithTimeout(ctx, f.timeout)
 	defer cancel()
 
-	var (
-		chunk      []byte
-		writeCount int
-		err        error
-		req        FileProvider
-		filepath   string
-		file       *os.File
-		totalSize  uint64
-		success    bool = true
-	)
-	req, err = recv.MyReceive()
-	if err != nil {
-		log.Error("failed to receive file chunk", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, ErrReceiveFile)
-	}
-
-	filepath = req.GetFileName()
-	if _, err = f.root.Stat(filepath); err == nil {
-		log.Warn("trying to create file with existing file name")
-		return fmt.Errorf("%s: %w", op, ErrBadRequest)
-	}
-
-	file, err = f.root.Create(filepath)
-	if err != nil {
-		var pathError *fs.PathError
-		if errors.As(err, &pathError) {
-			log.Warn("invalid file path", sl.Err(err))
-			return fmt.Errorf("%s: %w", op, ErrBadRequest)
-		}
-
-		log.Error("failed to create file", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, ErrInternal)
-	}
-	defer func() {
-		if err = file.Close(); err != nil {
-			log.Error("failed to close file", sl.Err(err))
-		}
-		if !success {
-			if err = os.Remove(filepath); err != nil {
-				log.Error("failed to remove file", sl.Err(err))
-			}
-		}
-	}()
-
-	for {
-		select {
-		case <-ctx.Done():
-			if err = ctx.Err(); err != nil {
-				log.Error("context error", sl.Err(err))
-				return fmt.Errorf("%s: %w", op, err)
-			} else {
-				log.Warn("context is done, failed to all file")
-				return fmt.Errorf("%s: %w", op, ErrInternal)
-			}
-		default:
-		}
-
-		chunk = req.GetChunk()
-		writeCount, err = file.Write(chunk)
-		if err != nil {
-			log.Error(
-				"failed to write into file",
-				sl.Err(err),
-				slog.String("file name", filepath),
-			)
-			success = false
-			return fmt.Errorf("%s: %w", op, ErrInternal)
-		}
-		totalSize += uint64(writeCount)
-
-		req, err = recv.MyReceive()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				log.Info("end of receiving file")
-				break
-			}
-			log.Error("failed to receive file chunk", sl.Err(err))
-			success = false
-			return fmt.Errorf("%s: %w", op, ErrReceiveFile)
-		}
-
-		filepath = req.GetFileName()
+	if err := f.receiveFile(ctx, log, recv, false); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	log.Info("successfully get file")
@@ -269,6 +252,23 @@ func (f *FileManager) DeleteFile(
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	_, span := startSpan(ctx, "filemanager.remove", filename)
+	var err error
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if isMember(filename) {
+		log.Warn("member of archive is read-only", slog.String("file name", filename))
+		err = ErrBadRequest
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if IsHidden(filename) {
+		log.Warn("invalid file path", slog.String("file name", filename))
+		err = ErrBadRequest
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	stat, err := f.root.Stat(filename)
 	if err != nil {
 		log.Error("failed to get file stat",
@@ -279,21 +279,29 @@ func (f *FileManager) DeleteFile(
 	}
 
 	if stat.IsDir() {
-		err = checkDirToDelete(f.log, filename)
+		err = checkDirToDelete(f.log, f.root, filename)
 		if err != nil {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 	}
 
-	err = f.root.Remove(filename)
+	err = f.store.Remove(f.root, filename)
 	if err != nil {
+		log.Error("failed to remove file", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, ErrInternal)
 	}
 
+	if err = f.notify(ctx, log, OpDelete, filename); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	log.Info("deleted file")
 	return nil
 }
 
+// PutFile replaces existing file. New content is received into temporary
+// file which atomically replaces the old one only when the whole file is
+// received, so cancelled update leaves the old file untouched.
 func (f *FileManager) PutFile(
 	ctx context.Context,
 	recv Receiver,
@@ -310,93 +318,469 @@ func (f *FileManager) PutFile(
 	ctx, cancel := context.WithTimeout(ctx, f.timeout)
 	defer cancel()
 
+	if err := f.receiveFile(ctx, log, recv, true); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully update file")
+	return nil
+}
+
+// receiveFile receives file from recv into temporary file, encoded as store
+// selects for it, and renames it to the received file name. If exists is
+// true the file must exist and is replaced, otherwise it must not exist.
+func (f *FileManager) receiveFile(
+	ctx context.Context,
+	log *slog.Logger,
+	recv Receiver,
+	exists bool,
+) (err error) {
 	var (
 		chunk      []byte
 		writeCount int
-		err        error
 		req        FileProvider
 		filepath   string
+		tmpName    string
 		file       *os.File
 		totalSize  uint64
 	)
 	req, err = recv.MyReceive()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			log.Warn("context error", sl.Err(ctxErr))
+			return ctxErr
+		}
 		log.Error("failed to receive file chunk", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, ErrReceiveFile)
+		return ErrReceiveFile
 	}
 
 	filepath = req.GetFileName()
-	stat, err := f.root.Stat(filepath)
-	if err != nil {
-		log.Error("failed to get stat file",
-			sl.Err(err),
-			slog.String("file name: ", filepath),
-		)
-		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	file, tmpName, err = f.createTemp(ctx, log, filepath, exists)
+	if errors.Is(err, ErrExists) && f.createdByUpload(ctx, filepath) {
+		log.Info("file is created by the previous attempt of upload", slog.String("file name", filepath))
+		return nil
 	}
-	if stat.IsDir() {
-		log.Warn("try update directory")
-		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	if err != nil {
+		return err
 	}
+	closed, committed := false, false
+	defer func() {
+		if committed {
+			return
+		}
 
-	file, err = f.root.Create(filepath)
-	if err != nil {
-		var pathError *fs.PathError
-		if errors.As(err, &pathError) {
-			log.Warn("invalid file path", sl.Err(err))
-			return fmt.Errorf("%s: %w", op, ErrBadRequest)
+		if !closed {
+			if err := file.Close(); err != nil {
+				log.Error("failed to close file", sl.Err(err))
+			}
+		}
+		if err := f.store.Remove(f.root, tmpName); err != nil {
+			log.Error("failed to remove temporary file", sl.Err(err))
 		}
+		log.Warn("receiving is rolled back", slog.String("file name", filepath))
+	}()
 
-		log.Error("failed to create file", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, ErrInternal)
+	w, err := f.store.NewWriter(f.root, file, filepath)
+	if err != nil {
+		log.Error("failed to start encoding file", sl.Err(err))
+		return ErrInternal
 	}
+	defer w.Abort()
+
+	span := startTransferSpan(ctx, "filemanager.write", filepath)
 	defer func() {
-		if err := file.Close(); err != nil {
-			log.Error("failed to close file", sl.Err(err))
-		}
+		span.end(err)
 	}()
 
+	var t1 time.Time
 	for {
-		select {
-		case <-ctx.Done():
-			if err = ctx.Err(); err != nil {
-				log.Error("context error", sl.Err(err))
-				return fmt.Errorf("%s: %w", op, err)
-			} else {
-				log.Warn("context is done, failed to all file")
-				return fmt.Errorf("%s: %w", op, ErrInternal)
-			}
-		default:
+		if err = ctx.Err(); err != nil {
+			log.Warn("context error", sl.Err(err))
+			return err
 		}
 
 		chunk = req.GetChunk()
-		writeCount, err = file.Write(chunk)
+		t1 = time.Now()
+		writeCount, err = w.Write(chunk)
+		span.measureDisk(t1)
 		if err != nil {
 			log.Error(
 				"failed to write into file",
 				sl.Err(err),
 				slog.String("file name", filepath),
 			)
-			return fmt.Errorf("%s: %w", op, ErrInternal)
+			return ErrInternal
 		}
 		totalSize += uint64(writeCount)
+		span.bytes = int64(totalSize)
 
+		t1 = time.Now()
 		req, err = recv.MyReceive()
+		span.measureStream(t1)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				log.Info("end of receiving file")
+				err = nil
 				break
 			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				log.Warn("context error", sl.Err(ctxErr))
+				err = ctxErr
+				return err
+			}
 			log.Error("failed to receive file chunk", sl.Err(err))
-			return fmt.Errorf("%s: %w", op, ErrReceiveFile)
+			return ErrReceiveFile
 		}
 	}
 
-	log.Info("successfully update file")
+	if err = w.Close(); err != nil {
+		log.Error("failed to finish encoding file", sl.Err(err))
+		return ErrInternal
+	}
+
+	closed = true
+	if err = file.Close(); err != nil {
+		log.Error("failed to close file", sl.Err(err))
+		return ErrInternal
+	}
+
+	if err = f.commit(ctx, log, tmpName, filepath, exists); err != nil {
+		return err
+	}
+	committed = true
+	if id := uploadID(ctx); id != "" && !exists {
+		if info, err := f.root.Stat(filepath); err == nil {
+			f.uploads.add(id, filepath, info)
+		}
+	}
+
+	op := OpCreate
+	if exists {
+		op = OpUpdate
+	}
+	return f.notify(ctx, log, op, filepath)
+}
+
+// createdByUpload reports whether existing file filepath is created
+// by the previous attempt of upload of ctx
+func (f *FileManager) createdByUpload(ctx context.Context, filepath string) bool {
+	id := uploadID(ctx)
+	if id == "" {
+		return false
+	}
+
+	info, err := f.root.Stat(filepath)
+	return err == nil && f.uploads.created(id, filepath, info)
+}
+
+// openReader opens regular file or member of archive for reading from
+// opts.Offset and checks that it matches preconditions of opts
+func (f *FileManager) openReader(
+	ctx context.Context,
+	log *slog.Logger,
+	fileName string,
+	opts ReadOptions,
+) (io.ReadCloser, FileInfo, error) {
+	archive, member, ok := splitMember(fileName)
+	if !ok {
+		file, info, err := f.openFile(ctx, log, fileName, opts.Offset)
+		// offset is not beyond the size required by
+		// preconditions, so the file is changed
+		if errors.Is(err, ErrOutOfRange) && opts.IfModTime != 0 {
+			err = ErrChanged
+		}
+		if err != nil {
+			return nil, FileInfo{}, err
+		}
+		if opts.changed(info.Size, info.ModTime) {
+			file.Close()
+			log.Warn("file is changed", slog.String("file name", fileName))
+			return nil, FileInfo{}, ErrChanged
+		}
+		return file, FileInfo{Name: fileName, Size: info.Size, ModTime: info.ModTime}, nil
+	}
+
+	_, span := startSpan(ctx, "filemanager.open", fileName)
+	var err error
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	b, err := f.openBrowsed(archive)
+	if err != nil {
+		log.Warn("failed to open archive", slog.String("archive", archive))
+		return nil, FileInfo{}, err
+	}
+
+	r, err := b.open(member)
+	if err != nil {
+		b.Close()
+		log.Warn("failed to open member of archive", sl.Err(err), slog.String("member", member))
+		return nil, FileInfo{}, ErrBadRequest
+	}
+	mr := &memberReader{ReadCloser: r, archive: b}
+
+	info := b.entries[member]
+	if opts.changed(info.Size, info.ModTime) {
+		mr.Close()
+		log.Warn("member of archive is changed", slog.String("member", member))
+		err = ErrChanged
+		return nil, FileInfo{}, err
+	}
+	if opts.Offset > info.Size {
+		mr.Close()
+		log.Warn("offset is out of member", slog.Int64("offset", opts.Offset))
+		err = ErrOutOfRange
+		return nil, FileInfo{}, err
+	}
+
+	// members are compressed, so they are read from the start
+	if _, err = io.CopyN(io.Discard, mr, opts.Offset); err != nil {
+		mr.Close()
+		log.Error("failed to skip member content", sl.Err(err), slog.String("member", member))
+		return nil, FileInfo{}, ErrInternal
+	}
+
+	return mr, info, nil
+}
+
+// openFile opens regular file for reading its logical content from offset
+func (f *FileManager) openFile(
+	ctx context.Context,
+	log *slog.Logger,
+	fileName string,
+	offset int64,
+) (file io.ReadCloser, info storage.Info, err error) {
+	_, span := startSpan(ctx, "filemanager.open", fileName)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if IsHidden(fileName) {
+		log.Warn("invalid file path", slog.String("file name", fileName))
+		return nil, storage.Info{}, ErrBadRequest
+	}
+
+	stat, err := f.root.Stat(fileName)
+	if err != nil {
+		log.Error("failed to get stat file",
+			sl.Err(err),
+			slog.String("file Name: ", fileName),
+		)
+		return nil, storage.Info{}, ErrBadRequest
+	}
+	if stat.IsDir() {
+		log.Warn("try open directory")
+		return nil, storage.Info{}, ErrBadRequest
+	}
+
+	file, info, err = f.store.OpenFrom(f.root, fileName, offset)
+	if errors.Is(err, storage.ErrOffset) {
+		log.Warn("offset is out of file", sl.Err(err))
+		return nil, storage.Info{}, ErrOutOfRange
+	}
+	if err != nil {
+		log.Error("failed to open file", sl.Err(err))
+		return nil, storage.Info{}, ErrInternal
+	}
+
+	return file, info, nil
+}
+
+type parentsKey struct{}
+
+// WithParents returns ctx of request which creates missing parent
+// directories of a new file. Without it the file is refused with
+// ErrBadRequest, like by a regular filesystem. Sharded storage uses it,
+// because the parents may be created only on the other filemanagers
+func WithParents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, parentsKey{}, true)
+}
+
+func createsParents(ctx context.Context) bool {
+	parents, _ := ctx.Value(parentsKey{}).(bool)
+	return parents
+}
+
+// createTemp creates temporary file next to the file with name filepath.
+// If exists is true filepath must be an existing regular file,
+// otherwise it must not exist and ErrExists is returned if it does.
+// Parents of a new file are created only with ctx of WithParents
+func (f *FileManager) createTemp(
+	ctx context.Context,
+	log *slog.Logger,
+	filepath string,
+	exists bool,
+) (file *os.File, tmpName string, err error) {
+	_, span := startSpan(ctx, "filemanager.create", filepath)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if !fs.ValidPath(filepath) || filepath == "." || IsHidden(filepath) || isMember(filepath) {
+		log.Warn("invalid file path", slog.String("file name", filepath))
+		return nil, "", ErrBadRequest
+	}
+
+	stat, err := f.root.Stat(filepath)
+	if exists {
+		if err != nil {
+			log.Error("failed to get stat file",
+				sl.Err(err),
+				slog.String("file name: ", filepath),
+			)
+			return nil, "", ErrBadRequest
+		}
+		if stat.IsDir() {
+			log.Warn("try update directory")
+			return nil, "", ErrBadRequest
+		}
+	} else if err == nil {
+		log.Warn("trying to create file with existing file name")
+		return nil, "", ErrExists
+	}
+
+	if dir := path.Dir(filepath); !exists && dir != "." && createsParents(ctx) {
+		if err = f.root.MkdirAll(dir, 0o755); err != nil {
+			log.Warn("failed to create parent directories", sl.Err(err))
+			return nil, "", ErrBadRequest
+		}
+	}
+
+	tmpName, err = tempName(filepath)
+	if err != nil {
+		log.Error("failed to generate temporary file name", sl.Err(err))
+		return nil, "", ErrInternal
+	}
+
+	file, err = f.root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		var pathError *fs.PathError
+		if errors.As(err, &pathError) {
+			log.Warn("invalid file path", sl.Err(err))
+			return nil, "", ErrBadRequest
+		}
+
+		log.Error("failed to create file", sl.Err(err))
+		return nil, "", ErrInternal
+	}
+
+	return file, tmpName, nil
+}
+
+// commit renames received temporary file to filepath. New file is
+// committed only if filepath still does not exist, otherwise ErrExists
+// is returned
+func (f *FileManager) commit(
+	ctx context.Context,
+	log *slog.Logger,
+	tmpName string,
+	filepath string,
+	exists bool,
+) (err error) {
+	_, span := startSpan(ctx, "filemanager.rename", filepath)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if exists {
+		err = f.store.Rename(f.root, tmpName, filepath)
+	} else {
+		err = f.store.RenameNoReplace(f.root, tmpName, filepath)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		log.Warn("file was created while receiving", slog.String("file name", filepath))
+		return ErrExists
+	}
+	if err != nil {
+		log.Error("failed to rename temporary file", sl.Err(err))
+		return ErrInternal
+	}
+
 	return nil
 }
 
-func checkDirToDelete(log *slog.Logger, filename string) error {
+// tempName returns name of the hidden temporary file in the directory of filepath
+func tempName(filepath string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	dir, base := path.Split(filepath)
+	return dir + tempPrefix + hex.EncodeToString(buf) + "-" + base, nil
+}
+
+// removeTemps removes temporary files left by uploads
+// which were interrupted by the previous shutdown
+func removeTemps(log *slog.Logger, root *os.Root) {
+	err := fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && storage.IsBlob(name) {
+			return fs.SkipDir
+		}
+		if d.IsDir() || !IsTemp(name) {
+			return nil
+		}
+
+		if err := root.Remove(name); err != nil {
+			log.Warn("failed to remove temporary file", sl.Err(err), slog.String("file name", name))
+			return nil
+		}
+		log.Info("removed temporary file", slog.String("file name", name))
+		return nil
+	})
+	if err != nil {
+		log.Warn("failed to look for temporary files", sl.Err(err))
+	}
+}
+
+// loadBlobs counts references to chunks of deduplicated files and
+// removes chunks which are not referenced. If references are not
+// loaded, chunks are kept, so files may not lose them
+func loadBlobs(log *slog.Logger, root *os.Root, store *storage.Store) {
+	if err := store.Load(root); err != nil {
+		log.Error("failed to load references of chunks", sl.Err(err))
+		return
+	}
+	if _, err := root.Stat(storage.BlobDir); err != nil {
+		return
+	}
+
+	res, err := store.CollectGarbage(root)
+	if err != nil {
+		log.Error("failed to collect garbage of blob store", sl.Err(err))
+		return
+	}
+	if res.Missing > 0 {
+		log.Error("referenced chunks are missing", slog.Int("count", res.Missing))
+	}
+
+	stats := store.DedupStats()
+	log.Info("blob store is loaded",
+		slog.Int("removed chunks", res.Removed),
+		slog.Int64("freed", res.Freed),
+		slog.Int("chunks", stats.Chunks),
+		slog.Int64("logical", stats.Logical),
+		slog.Int64("stored", stats.Stored),
+		slog.Float64("dedup ratio", stats.Ratio()),
+	)
+}
+
+// IsTemp reports whether name is a name of temporary file of unfinished upload
+func IsTemp(name string) bool {
+	return strings.HasPrefix(path.Base(name), tempPrefix)
+}
+
+// IsHidden reports whether name is a temporary file or belongs to the blob
+// store. Hidden files are not listed and can not be changed by clients
+func IsHidden(name string) bool {
+	return IsTemp(name) || storage.IsBlob(name)
+}
+
+func checkDirToDelete(log *slog.Logger, root *os.Root, filename string) error {
 	const op = "filemanager.checkDirToDelete"
 	log = log.With(slog.String("op", op))
 	log.Info(
@@ -404,7 +788,7 @@ func checkDirToDelete(log *slog.Logger, filename string) error {
 		slog.String("file name", filename),
 	)
 
-	dir, err := os.ReadDir(filename)
+	dir, err := fs.ReadDir(root.FS(), filename)
 	if err != nil {
 		log.Error("failed to check dir",
 			slog.String("dir name", filename),
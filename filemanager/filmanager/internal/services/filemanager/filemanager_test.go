@@ -0,0 +1,28 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestPostFileParents checks that parents of a new file are
+// created only by the request which asks for it
+func TestPostFileParents(t *testing.T) {
+	ctx := context.Background()
+	f := testFileManager(t)
+
+	if err := f.PostFile(ctx, newChunks("a/b.txt", "content")); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("PostFile() without parents error = %v, want %v", err, ErrBadRequest)
+	}
+	if _, err := f.root.Stat("a"); err == nil {
+		t.Fatalf("parent is created by PostFile() without parents")
+	}
+
+	if err := f.PostFile(WithParents(ctx), newChunks("a/b.txt", "content")); err != nil {
+		t.Fatalf("PostFile() with parents error = %v", err)
+	}
+	if _, err := f.root.Stat("a/b.txt"); err != nil {
+		t.Fatalf("file is not created: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package filemanager
+
+import (
+	"context"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"io/fs"
+	"log/slog"
+	"time"
+)
+
+// FileInfo describes file or directory. Name is a path relative to the root.
+// Size of file is size of its content, which is stored encoded on disk
+type FileInfo struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+}
+
+// ListFiles returns entries of the directory dir.
+// If recursive is true, entries of all subdirectories are returned too.
+// Temporary files of unfinished uploads are not listed. Zip and tar
+// archives are listed as directories by paths like "artifacts.zip!/bin"
+func (f *FileManager) ListFiles(
+	ctx context.Context,
+	dir string,
+	recursive bool,
+) (files []FileInfo, err error) {
+	const op = "filemanager.ListFiles"
+	log := f.log.With(slog.String("op", op))
+	log.Info("listing directory", slog.String("dir", dir), slog.Bool("recursive", recursive))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, span := startSpan(ctx, "filemanager.list", dir)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if dir == "" {
+		dir = "."
+	}
+	if !fs.ValidPath(dir) || IsHidden(dir) {
+		log.Warn("invalid directory path", slog.String("dir", dir))
+		return nil, fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	if archive, member, ok := splitMember(dir); ok {
+		files, err = f.listMembers(archive, member, recursive)
+		if err != nil {
+			log.Warn("failed to list archive", sl.Err(err), slog.String("dir", dir))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("archive is listed", slog.Int("count", len(files)))
+		return files, nil
+	}
+
+	stat, err := f.root.Stat(dir)
+	if err != nil {
+		log.Warn("failed to get directory stat", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+	if !stat.IsDir() {
+		log.Warn("try to list file", slog.String("dir", dir))
+		return nil, fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	fsys := f.root.FS()
+	err = fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if IsHidden(name) && d.IsDir() {
+			return fs.SkipDir
+		}
+		if name == dir || IsHidden(name) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Name:    name,
+			Size:    f.logicalSize(log, name, info),
+			IsDir:   d.IsDir(),
+			ModTime: info.ModTime(),
+		})
+
+		if d.IsDir() && !recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		log.Warn("context error", sl.Err(ctxErr))
+		return nil, fmt.Errorf("%s: %w", op, ctxErr)
+	}
+	if err != nil {
+		log.Error("failed to walk directory", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, ErrInternal)
+	}
+
+	log.Info("directory is listed", slog.Int("count", len(files)))
+	return files, nil
+}
+
+// listMembers returns entries of directory member of archive
+func (f *FileManager) listMembers(archive string, member string, recursive bool) ([]FileInfo, error) {
+	b, err := f.openBrowsed(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	if info, ok := b.entries[member]; !ok || !info.IsDir {
+		return nil, ErrBadRequest
+	}
+
+	return b.list(member, recursive), nil
+}
@@ -0,0 +1,235 @@
+package filemanager
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memberSep ends path of archive which is browsed, e.g. member "bin/tool"
+// of archive is "artifacts.zip!/bin/tool" and its root is "artifacts.zip!"
+const memberSep = "!"
+
+// splitMember splits name of archive member into path of the archive and
+// path of the member inside it. Only .zip and .tar files are browsed.
+// Member syntax takes precedence over real files with "!" in their path
+func splitMember(name string) (archive string, member string, ok bool) {
+	for i := range len(name) {
+		if name[i] != memberSep[0] {
+			continue
+		}
+		rest := name[i+1:]
+		if rest != "" && rest[0] != '/' || !isBrowsable(name[:i]) {
+			continue
+		}
+
+		member = strings.TrimPrefix(rest, "/")
+		if member == "" {
+			member = "."
+		}
+		return name[:i], member, true
+	}
+
+	return "", "", false
+}
+
+// isMember reports whether name is a path inside archive.
+// Members of archives are read-only
+func isMember(name string) bool {
+	_, _, ok := splitMember(name)
+	return ok
+}
+
+func isBrowsable(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".zip" || ext == ".tar"
+}
+
+// browsed is an opened archive. Entries of zip are read from its central
+// directory, tar has no index, so its headers are read one by one
+type browsed struct {
+	name string
+	r    storage.ReadAtCloser
+	size int64
+	zip  map[string]*zip.File
+	// entries describes members by their paths. Directories which are
+	// not stored in archive, but contain stored members, are added
+	entries map[string]FileInfo
+}
+
+// openBrowsed opens archive and reads its entries. Members with
+// unsafe paths, links and special files are not browsed
+func (f *FileManager) openBrowsed(name string) (*browsed, error) {
+	stat, err := f.root.Stat(name)
+	if err != nil || stat.IsDir() {
+		return nil, ErrBadRequest
+	}
+
+	r, info, err := f.store.OpenAt(f.root, name)
+	if err != nil {
+		return nil, ErrBadRequest
+	}
+
+	b := &browsed{
+		name:    name,
+		r:       r,
+		size:    info.Size,
+		entries: make(map[string]FileInfo),
+	}
+	b.entries["."] = FileInfo{Name: name + memberSep, IsDir: true, ModTime: stat.ModTime()}
+
+	if strings.ToLower(path.Ext(name)) == ".zip" {
+		err = b.readZip()
+	} else {
+		err = b.readTar()
+	}
+	if err != nil {
+		b.Close()
+		return nil, ErrBadRequest
+	}
+
+	return b, nil
+}
+
+func (b *browsed) readZip() error {
+	zr, err := zip.NewReader(b.r, b.size)
+	if err != nil {
+		return err
+	}
+
+	b.zip = make(map[string]*zip.File, len(zr.File))
+	for _, zf := range zr.File {
+		member, ok := cleanMember(zf.Name)
+		mode := zf.Mode()
+		if !ok || !(mode.IsDir() || mode.IsRegular()) {
+			continue
+		}
+
+		isDir := mode.IsDir() || strings.HasSuffix(zf.Name, "/")
+		b.add(member, int64(zf.UncompressedSize64), isDir, zf.Modified)
+		if !isDir {
+			b.zip[member] = zf
+		}
+	}
+
+	return nil
+}
+
+func (b *browsed) readTar() error {
+	tr := tar.NewReader(io.NewSectionReader(b.r, 0, b.size))
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		member, ok := cleanMember(h.Name)
+		if !ok || (h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir) {
+			continue
+		}
+		b.add(member, h.Size, h.Typeflag == tar.TypeDir, h.ModTime)
+	}
+}
+
+// add describes member and its parent directories
+func (b *browsed) add(member string, size int64, isDir bool, modTime time.Time) {
+	if isDir {
+		size = 0
+	}
+	b.entries[member] = FileInfo{
+		Name:    b.name + memberSep + "/" + member,
+		Size:    size,
+		IsDir:   isDir,
+		ModTime: modTime,
+	}
+
+	for dir := path.Dir(member); dir != "."; dir = path.Dir(dir) {
+		if _, ok := b.entries[dir]; ok {
+			break
+		}
+		b.entries[dir] = FileInfo{
+			Name:    b.name + memberSep + "/" + dir,
+			IsDir:   true,
+			ModTime: modTime,
+		}
+	}
+}
+
+// list returns entries of directory member sorted by name
+func (b *browsed) list(member string, recursive bool) []FileInfo {
+	var files []FileInfo
+	for name, info := range b.entries {
+		if name == "." || name == member {
+			continue
+		}
+		parent := path.Dir(name)
+		if parent == member || recursive && (member == "." || strings.HasPrefix(name, member+"/")) {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files
+}
+
+// open opens file member for reading
+func (b *browsed) open(member string) (io.ReadCloser, error) {
+	info, ok := b.entries[member]
+	if !ok || info.IsDir {
+		return nil, ErrBadRequest
+	}
+
+	if b.zip != nil {
+		return b.zip[member].Open()
+	}
+
+	tr := tar.NewReader(io.NewSectionReader(b.r, 0, b.size))
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := cleanMember(h.Name); ok && name == member && h.Typeflag == tar.TypeReg {
+			return io.NopCloser(tr), nil
+		}
+	}
+}
+
+func (b *browsed) Close() error {
+	return b.r.Close()
+}
+
+// memberReader reads member of archive and closes the archive with it
+type memberReader struct {
+	io.ReadCloser
+	archive *browsed
+}
+
+func (m *memberReader) Close() error {
+	return errors.Join(m.ReadCloser.Close(), m.archive.Close())
+}
+
+// cleanMember converts name of archive entry to slash-separated path.
+// Absolute paths and paths escaping the archive are not safe
+func cleanMember(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return "", false
+	}
+
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+
+	return name, true
+}
@@ -0,0 +1,175 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"io"
+	"io/fs"
+	"log/slog"
+)
+
+// MigrateResult counts files checked by migration. Before and After
+// are sizes on disk of converted files before and after conversion.
+// Rewrapped files got their data keys wrapped by the current master key.
+// Chunks counts converted and rewrapped chunks of deduplicated files
+type MigrateResult struct {
+	Files     int
+	Converted int
+	Rewrapped int
+	Chunks    int
+	Before    int64
+	After     int64
+}
+
+// Migrate converts every file which is stored with compression, encryption
+// or deduplication other than store selects for it now, so changed policy
+// applies to existing files. Data keys of files which differ only by master
+// key are rewrapped without converting the files. Chunks of deduplicated
+// files are migrated the same way. Files keep their contents and
+// modification times, observers are not notified. Nobody else may change
+// files while it runs. With dryRun files are only counted
+func (f *FileManager) Migrate(ctx context.Context, dryRun bool) (MigrateResult, error) {
+	const op = "filemanager.Migrate"
+	log := f.log.With(slog.String("op", op))
+	log.Info("starting migration", slog.Bool("dry run", dryRun))
+
+	var res MigrateResult
+	err := fs.WalkDir(f.root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if IsHidden(name) && d.IsDir() {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() || IsHidden(name) {
+			return nil
+		}
+		res.Files++
+
+		info, err := f.store.Stat(f.root, name)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		if !f.store.Outdated(name, info) {
+			if !f.store.Stale(info) {
+				return nil
+			}
+
+			log.Info("rewrapping data key", slog.String("file name", name), slog.String("key id", info.KeyID))
+			res.Rewrapped++
+			if dryRun {
+				return nil
+			}
+			if _, err := f.store.Rewrap(f.root, name); err != nil {
+				return fmt.Errorf("file %s: %w", name, err)
+			}
+			return nil
+		}
+
+		log.Info("converting file",
+			slog.String("file name", name),
+			slog.String("from", info.Compression),
+			slog.String("to", f.store.Compression(name)),
+			slog.Bool("encrypted", info.Encrypted()),
+			slog.Bool("encrypt", f.store.Encrypted()),
+			slog.Bool("deduplicated", info.Deduplicated),
+			slog.Bool("dedup", f.store.Dedup()),
+		)
+		res.Converted++
+		res.Before += info.Stored
+		if dryRun {
+			return nil
+		}
+
+		stored, err := f.convert(ctx, log, name)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		res.After += stored
+		return nil
+	})
+	if err == nil {
+		var converted, rewrapped int
+		converted, rewrapped, err = f.store.MigrateBlobs(ctx, f.root, dryRun)
+		res.Chunks = converted + rewrapped
+	}
+	if err != nil {
+		log.Error("migration is not finished", sl.Err(err), slog.Int("converted", res.Converted))
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("migration is finished",
+		slog.Int("files", res.Files),
+		slog.Int("converted", res.Converted),
+		slog.Int("rewrapped", res.Rewrapped),
+		slog.Int("chunks", res.Chunks),
+		slog.Int64("before", res.Before),
+		slog.Int64("after", res.After),
+	)
+	return res, nil
+}
+
+// convert rewrites file name encoded as store selects for it now.
+// It returns size of the converted file on disk
+func (f *FileManager) convert(ctx context.Context, log *slog.Logger, name string) (int64, error) {
+	r, info, err := f.store.Open(f.root, name)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	file, tmpName, err := f.createTemp(ctx, log, name, true)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := f.store.NewWriter(f.root, file, name)
+	if err == nil {
+		defer w.Abort()
+		_, err = io.Copy(w, r)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	err = errors.Join(err, file.Close())
+	if err == nil {
+		err = f.root.Chtimes(tmpName, info.ModTime, info.ModTime)
+	}
+	if err == nil {
+		err = f.commit(ctx, log, tmpName, name, true)
+	}
+	if err != nil {
+		if err := f.store.Remove(f.root, tmpName); err != nil {
+			log.Error("failed to remove temporary file", sl.Err(err))
+		}
+		return 0, err
+	}
+
+	stat, err := f.root.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
+
+// logicalSize returns size of content of file name. If header
+// of the file can not be read, its size on disk is returned
+func (f *FileManager) logicalSize(log *slog.Logger, name string, stat fs.FileInfo) int64 {
+	if !stat.Mode().IsRegular() {
+		return stat.Size()
+	}
+
+	info, err := f.store.Stat(f.root, name)
+	if err != nil {
+		log.Warn("failed to read header of file", sl.Err(err), slog.String("file name", name))
+		return stat.Size()
+	}
+
+	return info.Size
+}
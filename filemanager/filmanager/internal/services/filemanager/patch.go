@@ -0,0 +1,313 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+	"io"
+	"io/fs"
+	"log/slog"
+	"time"
+)
+
+// signatureBatch is the number of block signatures sent in one message
+const signatureBatch = 1024
+
+// SignatureSender sends signature of file in parts. BlockSize and Size
+// are the same in every part, Blocks continue blocks of the previous part
+type SignatureSender interface {
+	SendSignature(delta.Signature) error
+}
+
+// PatchMessage carries ops building the new content of file. Name, Size
+// and Sha256 of the new content are set in the first message only
+type PatchMessage struct {
+	Name   string
+	Size   int64
+	Sha256 []byte
+	Ops    []delta.Op
+}
+
+type PatchReceiver interface {
+	MyReceive() (PatchMessage, error)
+}
+
+// Signature sends signatures of blocks of file, which client uses to
+// compute delta of the new content. If blockSize is 0, it is chosen
+// by size of the file
+func (f *FileManager) Signature(
+	ctx context.Context,
+	fileName string,
+	blockSize int,
+	stream SignatureSender,
+) (err error) {
+	const op = "filemanager.Signature"
+	log := f.log.With(slog.String("op", op))
+	log.Info("starting to sign file", slog.String("file name", fileName))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	if blockSize != 0 && !delta.ValidBlockSize(blockSize) {
+		log.Warn("invalid block size", slog.Int("block size", blockSize))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	file, info, err := f.openFile(ctx, log, fileName, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Error("failed to close file", sl.Err(err))
+		}
+	}()
+
+	if blockSize == 0 {
+		blockSize = delta.BlockSize(info.Size)
+	}
+
+	span := startTransferSpan(ctx, "filemanager.sign", fileName)
+	defer func() {
+		span.end(err)
+	}()
+
+	sig := delta.Signature{BlockSize: blockSize, Size: info.Size}
+	send := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t1 := time.Now()
+		err := stream.SendSignature(sig)
+		span.measureStream(t1)
+		sig.Blocks = sig.Blocks[:0]
+		return err
+	}
+
+	blocks := 0
+	t1 := time.Now()
+	err = delta.Sign(io.LimitReader(file, info.Size), blockSize, func(b delta.Block) error {
+		span.measureDisk(t1)
+		sig.Blocks = append(sig.Blocks, b)
+		blocks++
+		if len(sig.Blocks) == signatureBatch {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		t1 = time.Now()
+		return nil
+	})
+	// the last part is sent even if it is empty, so empty
+	// file is described by block size and size too
+	if err == nil {
+		err = send()
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			log.Warn("context error", sl.Err(ctxErr))
+			return fmt.Errorf("%s: %w", op, ctxErr)
+		}
+		log.Error("failed to sign file", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	span.bytes = info.Size
+
+	log.Info("file is signed", slog.Int("blocks", blocks), slog.Int("block size", blockSize))
+	return nil
+}
+
+// PatchFile replaces existing file by content built from its current
+// content by received ops. Like PutFile, the new content is written into
+// temporary file which replaces the old one only when all ops are applied
+// and the content matches size and checksum of the first message
+func (f *FileManager) PatchFile(ctx context.Context, recv PatchReceiver) error {
+	const op = "filemanager.PatchFile"
+	log := f.log.With(slog.String("op", op))
+	log.Info("starting to patch file")
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	if err := f.patchFile(ctx, log, recv); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully patch file")
+	return nil
+}
+
+func (f *FileManager) patchFile(ctx context.Context, log *slog.Logger, recv PatchReceiver) (err error) {
+	msg, err := recv.MyReceive()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			log.Warn("context error", sl.Err(ctxErr))
+			return ctxErr
+		}
+		log.Error("failed to receive patch", sl.Err(err))
+		return ErrReceiveFile
+	}
+
+	name, size, sum := msg.Name, msg.Size, msg.Sha256
+	if size < 0 || len(sum) != sha256.Size {
+		log.Warn("invalid size or checksum of patched file", slog.Int64("size", size))
+		return ErrBadRequest
+	}
+
+	file, tmpName, err := f.createTemp(ctx, log, name, true)
+	if err != nil {
+		return err
+	}
+	closed, committed := false, false
+	defer func() {
+		if committed {
+			return
+		}
+
+		if !closed {
+			if err := file.Close(); err != nil {
+				log.Error("failed to close file", sl.Err(err))
+			}
+		}
+		if err := f.store.Remove(f.root, tmpName); err != nil {
+			log.Error("failed to remove temporary file", sl.Err(err))
+		}
+		log.Warn("patching is rolled back", slog.String("file name", name))
+	}()
+
+	base, info, err := f.store.OpenAt(f.root, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Warn("patched file is removed", slog.String("file name", name))
+			return ErrBadRequest
+		}
+		log.Error("failed to open patched file", sl.Err(err))
+		return ErrInternal
+	}
+	defer func() {
+		if err := base.Close(); err != nil {
+			log.Error("failed to close file", sl.Err(err))
+		}
+	}()
+
+	w, err := f.store.NewWriter(f.root, file, name)
+	if err != nil {
+		log.Error("failed to start encoding file", sl.Err(err))
+		return ErrInternal
+	}
+	defer w.Abort()
+
+	span := startTransferSpan(ctx, "filemanager.patch", name)
+	defer func() {
+		span.end(err)
+	}()
+
+	h := sha256.New()
+	out := io.MultiWriter(w, h)
+	buf := make([]byte, 32<<10)
+	written := int64(0)
+	for {
+		if err = ctx.Err(); err != nil {
+			log.Warn("context error", sl.Err(err))
+			return err
+		}
+
+		for _, o := range msg.Ops {
+			t1 := time.Now()
+			n, err := applyOp(out, base, info.Size, o, buf)
+			span.measureDisk(t1)
+			written += n
+			span.bytes = written
+			if err == nil && written > size {
+				err = fmt.Errorf("%w: content is larger than %d bytes", ErrBadRequest, size)
+			}
+			if errors.Is(err, ErrBadRequest) {
+				log.Warn("invalid op", sl.Err(err), slog.String("file name", name))
+				return ErrBadRequest
+			}
+			if err != nil {
+				log.Error("failed to apply op", sl.Err(err), slog.String("file name", name))
+				return ErrInternal
+			}
+		}
+
+		t1 := time.Now()
+		msg, err = recv.MyReceive()
+		span.measureStream(t1)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				break
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				log.Warn("context error", sl.Err(ctxErr))
+				err = ctxErr
+				return err
+			}
+			log.Error("failed to receive patch", sl.Err(err))
+			return ErrReceiveFile
+		}
+	}
+
+	if written != size || !bytes.Equal(h.Sum(nil), sum) {
+		log.Warn("patched file does not match",
+			slog.String("file name", name),
+			slog.Int64("written", written),
+			slog.Int64("size", size),
+		)
+		return ErrMismatch
+	}
+
+	if err = w.Close(); err != nil {
+		log.Error("failed to finish encoding file", sl.Err(err))
+		return ErrInternal
+	}
+
+	closed = true
+	if err = file.Close(); err != nil {
+		log.Error("failed to close file", sl.Err(err))
+		return ErrInternal
+	}
+
+	if err = f.commit(ctx, log, tmpName, name, true); err != nil {
+		return err
+	}
+	committed = true
+
+	return f.notify(ctx, log, OpUpdate, name)
+}
+
+// applyOp writes data of op or copies its range of base of size bytes
+func applyOp(w io.Writer, base io.ReaderAt, size int64, o delta.Op, buf []byte) (int64, error) {
+	if len(o.Data) > 0 {
+		if o.Length != 0 {
+			return 0, fmt.Errorf("%w: op carries both data and copy", ErrBadRequest)
+		}
+		n, err := w.Write(o.Data)
+		return int64(n), err
+	}
+
+	if o.Offset < 0 || o.Length <= 0 || o.Offset > size-o.Length {
+		return 0, fmt.Errorf("%w: copy of %d bytes at %d is out of file", ErrBadRequest, o.Length, o.Offset)
+	}
+	n, err := io.CopyBuffer(w, io.NewSectionReader(base, o.Offset, o.Length), buf)
+	if err == nil && n != o.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,60 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+)
+
+func TestApplyOp(t *testing.T) {
+	const base = "0123456789"
+	size := int64(len(base))
+
+	tests := []struct {
+		name string
+		op   delta.Op
+		want string
+		err  error
+	}{
+		{name: "data", op: delta.Op{Data: []byte("new")}, want: "new"},
+		{name: "copy", op: delta.Op{Offset: 2, Length: 3}, want: "234"},
+		{name: "copy of whole file", op: delta.Op{Offset: 0, Length: size}, want: base},
+		{name: "copy at end", op: delta.Op{Offset: size - 1, Length: 1}, want: "9"},
+		{name: "data and copy", op: delta.Op{Offset: 0, Length: 1, Data: []byte("x")}, err: ErrBadRequest},
+		{name: "empty copy", op: delta.Op{Offset: 0, Length: 0}, err: ErrBadRequest},
+		{name: "negative length", op: delta.Op{Offset: 5, Length: -1}, err: ErrBadRequest},
+		{name: "negative offset", op: delta.Op{Offset: -1, Length: 2}, err: ErrBadRequest},
+		{name: "past end", op: delta.Op{Offset: size - 2, Length: 3}, err: ErrBadRequest},
+		{name: "after end", op: delta.Op{Offset: size, Length: 1}, err: ErrBadRequest},
+		{name: "far after end", op: delta.Op{Offset: math.MaxInt64, Length: 1}, err: ErrBadRequest},
+		{name: "length overflows", op: delta.Op{Offset: 1, Length: math.MaxInt64}, err: ErrBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := applyOp(&out, strings.NewReader(base), size, tt.op, make([]byte, 4))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("applyOp() error = %v, want %v", err, tt.err)
+			}
+			if out.String() != tt.want || n != int64(len(tt.want)) {
+				t.Errorf("applyOp() wrote %q, %d bytes, want %q", out.String(), n, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyOpShortBase checks copy from base which is shorter
+// than its recorded size
+func TestApplyOpShortBase(t *testing.T) {
+	var out bytes.Buffer
+	_, err := applyOp(&out, strings.NewReader("01234"), 10, delta.Op{Offset: 3, Length: 4}, make([]byte, 4))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("applyOp() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
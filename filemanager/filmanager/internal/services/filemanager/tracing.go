@@ -0,0 +1,67 @@
+package filemanager
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/IlianBuh/filemanager-server/internal/services/filemanager")
+
+// startSpan starts child span of the file operation
+func startSpan(ctx context.Context, name string, fileName string) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		name,
+		trace.WithAttributes(attribute.String("file.name", fileName)),
+	)
+}
+
+// endSpan records error if any and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// transferSpan covers the whole read or write loop of the file and
+// splits its time between disk io and grpc stream io
+type transferSpan struct {
+	span   trace.Span
+	disk   time.Duration
+	stream time.Duration
+	bytes  int64
+}
+
+func startTransferSpan(ctx context.Context, name string, fileName string) *transferSpan {
+	_, span := startSpan(ctx, name, fileName)
+
+	return &transferSpan{span: span}
+}
+
+// measureDisk adds time elapsed since t1 to disk io time
+func (t *transferSpan) measureDisk(t1 time.Time) {
+	t.disk += time.Since(t1)
+}
+
+// measureStream adds time elapsed since t1 to stream io time
+func (t *transferSpan) measureStream(t1 time.Time) {
+	t.stream += time.Since(t1)
+}
+
+func (t *transferSpan) end(err error) {
+	t.span.SetAttributes(
+		attribute.Int64("file.bytes", t.bytes),
+		attribute.Int64("file.disk_time_ms", t.disk.Milliseconds()),
+		attribute.Int64("file.stream_time_ms", t.stream.Milliseconds()),
+	)
+
+	endSpan(t.span, err)
+}
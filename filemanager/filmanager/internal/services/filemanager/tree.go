@@ -0,0 +1,177 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"io/fs"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// Stat returns description of file or directory name. Member of archive
+// is described by its path like "artifacts.zip!/bin/tool"
+func (f *FileManager) Stat(ctx context.Context, name string) (info FileInfo, err error) {
+	const op = "filemanager.Stat"
+	log := f.log.With(slog.String("op", op))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return FileInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, span := startSpan(ctx, "filemanager.stat", name)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || IsHidden(name) {
+		log.Warn("invalid file path", slog.String("file name", name))
+		return FileInfo{}, fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	if archive, member, ok := splitMember(name); ok {
+		b, err := f.openBrowsed(archive)
+		if err != nil {
+			log.Debug("failed to open archive", sl.Err(err))
+			return FileInfo{}, fmt.Errorf("%s: %w", op, err)
+		}
+		defer b.Close()
+
+		info, ok := b.entries[member]
+		if !ok {
+			log.Debug("member of archive not found", slog.String("file name", name))
+			return FileInfo{}, fmt.Errorf("%s: %w", op, ErrBadRequest)
+		}
+		return info, nil
+	}
+
+	stat, err := f.root.Stat(name)
+	if err != nil {
+		log.Debug("failed to get file stat", sl.Err(err))
+		return FileInfo{}, fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	return FileInfo{
+		Name:    name,
+		Size:    f.logicalSize(log, name, stat),
+		IsDir:   stat.IsDir(),
+		ModTime: stat.ModTime(),
+	}, nil
+}
+
+// Mkdir creates directory name and its missing parents.
+// It fails with ErrExists if name already exists
+func (f *FileManager) Mkdir(ctx context.Context, name string) (err error) {
+	const op = "filemanager.Mkdir"
+	log := f.log.With(slog.String("op", op))
+	log.Info("creating directory", slog.String("dir", name))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, span := startSpan(ctx, "filemanager.mkdir", name)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if !fs.ValidPath(name) || name == "." || IsHidden(name) || isMember(name) {
+		log.Warn("invalid directory path", slog.String("dir", name))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	if _, err = f.root.Lstat(name); err == nil {
+		log.Warn("directory already exists", slog.String("dir", name))
+		return fmt.Errorf("%s: %w", op, ErrExists)
+	}
+
+	if err = f.root.MkdirAll(name, 0o755); err != nil {
+		log.Warn("failed to create directory", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	if err = f.notify(ctx, log, OpMkdir, name); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("directory is created")
+	return nil
+}
+
+// Move renames file or directory from to to. Missing parents of to are
+// created. It fails with ErrExists if to already exists
+func (f *FileManager) Move(ctx context.Context, from string, to string) (err error) {
+	const op = "filemanager.Move"
+	log := f.log.With(slog.String("op", op))
+	log.Info("moving file", slog.String("from", from), slog.String("to", to))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, span := startSpan(ctx, "filemanager.move", from)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	for _, name := range []string{from, to} {
+		if !fs.ValidPath(name) || name == "." || IsHidden(name) || isMember(name) {
+			log.Warn("invalid file path", slog.String("file name", name))
+			return fmt.Errorf("%s: %w", op, ErrBadRequest)
+		}
+	}
+	if to == from || isInside(to, from) {
+		log.Warn("try to move directory into itself")
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+
+	if _, err = f.root.Lstat(from); err != nil {
+		log.Warn("failed to get file stat", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrBadRequest)
+	}
+	if _, err = f.root.Lstat(to); err == nil {
+		log.Warn("destination already exists", slog.String("to", to))
+		return fmt.Errorf("%s: %w", op, ErrExists)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Error("failed to get destination stat", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInternal)
+	}
+
+	if dir := path.Dir(to); dir != "." {
+		if err = f.root.MkdirAll(dir, 0o755); err != nil {
+			log.Warn("failed to create parent directories", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrBadRequest)
+		}
+	}
+
+	err = f.store.RenameNoReplace(f.root, from, to)
+	if errors.Is(err, fs.ErrExist) {
+		log.Warn("destination is created while moving", slog.String("to", to))
+		return fmt.Errorf("%s: %w", op, ErrExists)
+	}
+	if err != nil {
+		log.Error("failed to rename file", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInternal)
+	}
+
+	err = f.propagate(ctx, log, Change{Op: OpMove, Name: to, From: from, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("file is moved")
+	return nil
+}
+
+// isInside reports whether name is inside directory dir
+func isInside(name string, dir string) bool {
+	return len(name) > len(dir) && name[:len(dir)] == dir && name[len(dir)] == '/'
+}
@@ -0,0 +1,77 @@
+package filemanager
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// uploadsLimit is the number of the last committed uploads
+// which replayed attempts are recognized of
+const uploadsLimit = 4096
+
+type uploadIDKey struct{}
+
+// WithUploadID returns ctx of upload attempt with id, which is the same
+// for every attempt of one upload. Attempt which finds the file created
+// by the previous attempt of the upload succeeds instead of ErrExists
+func WithUploadID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, uploadIDKey{}, id)
+}
+
+func uploadID(ctx context.Context) string {
+	id, _ := ctx.Value(uploadIDKey{}).(string)
+	return id
+}
+
+// uploads remembers files created by the last uploads. File is identified
+// by its inode and modification time, so the file which is replaced or
+// created again after deletion is not taken for the created one
+type uploads struct {
+	mu    sync.Mutex
+	files map[string]uploaded
+	ids   []string
+}
+
+type uploaded struct {
+	name string
+	info fs.FileInfo
+}
+
+func newUploads() *uploads {
+	return &uploads{files: make(map[string]uploaded)}
+}
+
+// add records that upload id created file name described by info
+func (u *uploads) add(id string, name string, info fs.FileInfo) {
+	if id == "" {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.files[id]; !ok {
+		if len(u.ids) == uploadsLimit {
+			delete(u.files, u.ids[0])
+			u.ids = u.ids[1:]
+		}
+		u.ids = append(u.ids, id)
+	}
+	u.files[id] = uploaded{name: name, info: info}
+}
+
+// created reports whether upload id created file name described by info
+func (u *uploads) created(id string, name string, info fs.FileInfo) bool {
+	if id == "" {
+		return false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	file, ok := u.files[id]
+	return ok && file.name == name && os.SameFile(file.info, info) &&
+		file.info.ModTime().Equal(info.ModTime())
+}
@@ -0,0 +1,110 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+)
+
+// chunks receives file name with content as one chunk
+type chunks struct {
+	reqs []*filemanagerv1.PostFileRequest
+}
+
+func newChunks(name string, content string) *chunks {
+	return &chunks{reqs: []*filemanagerv1.PostFileRequest{{FileName: name, Chunk: []byte(content)}}}
+}
+
+func (c *chunks) MyReceive() (FileProvider, error) {
+	if len(c.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := c.reqs[0]
+	c.reqs = c.reqs[1:]
+	return req, nil
+}
+
+func (c *chunks) MySend([]byte) error {
+	return nil
+}
+
+func testFileManager(t *testing.T) *FileManager {
+	t.Helper()
+
+	store, err := storage.New(storage.Policy{Default: storage.CompressionNone}, nil)
+	if err != nil {
+		t.Fatalf("storage.New() error = %v", err)
+	}
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), t.TempDir(), store, time.Minute)
+}
+
+// TestReplayedUpload checks that only the attempt of the upload which
+// created the file is accepted when the file exists
+func TestReplayedUpload(t *testing.T) {
+	ctx := context.Background()
+	first := WithUploadID(ctx, "first")
+
+	f := testFileManager(t)
+	if err := f.PostFile(first, newChunks("a.txt", "content")); err != nil {
+		t.Fatalf("PostFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		file string
+		err  error
+	}{
+		{name: "replayed attempt", ctx: first, file: "a.txt"},
+		{name: "other upload", ctx: WithUploadID(ctx, "second"), file: "a.txt", err: ErrExists},
+		{name: "upload without id", ctx: ctx, file: "a.txt", err: ErrExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := f.PostFile(tt.ctx, newChunks(tt.file, "other content"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("PostFile() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// file of the same name created again by other upload is not taken
+	// for the one created by the first upload
+	if err := f.DeleteFile(ctx, "a.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if err := f.PostFile(WithUploadID(ctx, "third"), newChunks("a.txt", "content")); err != nil {
+		t.Fatalf("PostFile() error = %v", err)
+	}
+	if err := f.PostFile(first, newChunks("a.txt", "content")); !errors.Is(err, ErrExists) {
+		t.Fatalf("PostFile() of the first upload after recreation error = %v, want %v", err, ErrExists)
+	}
+}
+
+func TestUploadsLimit(t *testing.T) {
+	f := testFileManager(t)
+	ctx := context.Background()
+	if err := f.PostFile(WithUploadID(ctx, "0"), newChunks("a.txt", "content")); err != nil {
+		t.Fatalf("PostFile() error = %v", err)
+	}
+	info, err := f.root.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range uploadsLimit {
+		f.uploads.add(string(rune('a'+i%26))+string(rune(i)), "b.txt", info)
+	}
+	if f.uploads.created("0", "a.txt", info) {
+		t.Errorf("upload is remembered after %d later uploads", uploadsLimit)
+	}
+	if len(f.uploads.files) != uploadsLimit || len(f.uploads.ids) != uploadsLimit {
+		t.Errorf("%d uploads are kept, want %d", len(f.uploads.files), uploadsLimit)
+	}
+}
@@ -0,0 +1,384 @@
+// Package journal keeps durable append-only log of committed changes,
+// so consumers can incrementally sync from a cursor.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".log"
+	maxLimit   = 10000
+)
+
+var (
+	// ErrCursorExpired means that changes after cursor are compacted
+	// and consumer has to resync from the beginning
+	ErrCursorExpired = errors.New("cursor is expired")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// Entry is a journaled change with its sequence number
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	filemanager.Change
+}
+
+type Options struct {
+	Dir string
+	// SegmentSize is a size in bytes after which new segment is started
+	SegmentSize     int64
+	Retention       time.Duration
+	CompactInterval time.Duration
+}
+
+// segment is a journal file. Its name is the sequence number of its first entry
+type segment struct {
+	first uint64
+	path  string
+}
+
+// Journal appends every committed change to the active segment.
+// Segments older than retention are removed by compaction
+type Journal struct {
+	log  *slog.Logger
+	opts Options
+
+	mu       sync.RWMutex
+	segments []segment
+	active   *os.File
+	size     int64
+	next     uint64
+
+	done chan struct{}
+}
+
+func New(log *slog.Logger, opts Options) (*Journal, error) {
+	const op = "journal.New"
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	j := &Journal{
+		log:  log,
+		opts: opts,
+		next: 1,
+		done: make(chan struct{}),
+	}
+
+	if err := j.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("journal is opened",
+		slog.String("dir", opts.Dir),
+		slog.Uint64("next seq", j.next),
+		slog.String("op", op),
+	)
+	return j, nil
+}
+
+// load finds segments and the last sequence number
+func (j *Journal) load() error {
+	dirEntries, err := os.ReadDir(j.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dirEntries {
+		name := d.Name()
+		if d.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		j.segments = append(j.segments, segment{first: first, path: filepath.Join(j.opts.Dir, name)})
+	}
+	sort.Slice(j.segments, func(a, b int) bool { return j.segments[a].first < j.segments[b].first })
+
+	if len(j.segments) == 0 {
+		return j.rotate()
+	}
+
+	last := j.segments[len(j.segments)-1]
+	j.next = last.first
+	size, err := readSegment(last.path, func(e Entry) bool {
+		j.next = e.Seq + 1
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	j.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// drop torn line left by crash, so new entries start on a new line
+	if err := j.active.Truncate(size); err != nil {
+		return err
+	}
+	j.size = size
+
+	return nil
+}
+
+// OnChange durably appends change to the journal
+func (j *Journal) OnChange(_ context.Context, change filemanager.Change) error {
+	const op = "journal.OnChange"
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.size >= j.opts.SegmentSize {
+		if err := j.rotate(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	line, err := json.Marshal(Entry{Seq: j.next, Change: change})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	line = append(line, '\n')
+
+	if _, err := j.active.Write(line); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := j.active.Sync(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	j.next++
+	j.size += int64(len(line))
+	return nil
+}
+
+// rotate starts new segment and closes the active one. Active segment
+// is replaced only when the new one is opened, so failed rotation
+// leaves the journal writable
+func (j *Journal) rotate() error {
+	path := filepath.Join(j.opts.Dir, fmt.Sprintf("%020d%s", j.next, segmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// entries of the old segment are synced, so failed close loses nothing
+	if j.active != nil {
+		if err := j.active.Close(); err != nil {
+			j.log.Error("failed to close journal segment", sl.Err(err))
+		}
+	}
+
+	j.active = file
+	j.size = 0
+	j.segments = append(j.segments, segment{first: j.next, path: path})
+	return nil
+}
+
+// ListChanges returns at most limit changes after cursor since
+// and cursor to continue from. Zero cursor means the beginning of journal.
+// Zero limit returns no changes and cursor of the last change, so consumer
+// which lists all files can get changes made after listing
+func (j *Journal) ListChanges(
+	ctx context.Context,
+	since uint64,
+	limit int,
+) (entries []Entry, cursor uint64, hasMore bool, err error) {
+	const op = "journal.ListChanges"
+
+	if limit < 0 || limit > maxLimit {
+		return nil, 0, false, fmt.Errorf("%s: %w", op, ErrInvalidLimit)
+	}
+
+	// segments are read without the lock, so appending changes is not
+	// blocked by reading. Removed segments are found missing
+	j.mu.RLock()
+	segments := slices.Clone(j.segments)
+	next := j.next
+	j.mu.RUnlock()
+
+	if limit == 0 {
+		return nil, next - 1, false, nil
+	}
+	if since >= next {
+		return nil, since, false, nil
+	}
+	if since+1 < segments[0].first {
+		return nil, 0, false, fmt.Errorf("%s: %w", op, ErrCursorExpired)
+	}
+
+	// the last segment which starts not after since+1
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].first > since+1 }) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	cursor = since
+	for ; i < len(segments); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		_, err = readSegment(segments[i].path, func(e Entry) bool {
+			if e.Seq <= since {
+				return true
+			}
+			if len(entries) == limit {
+				hasMore = true
+				return false
+			}
+
+			entries = append(entries, e)
+			cursor = e.Seq
+			return true
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			// segment is compacted while it was read
+			return nil, 0, false, fmt.Errorf("%s: %w", op, ErrCursorExpired)
+		}
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+		if hasMore {
+			break
+		}
+	}
+
+	return entries, cursor, hasMore, nil
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (j *Journal) MustRun() {
+	if err := j.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run compacts journal periodically until Stop is called
+func (j *Journal) Run() error {
+	const op = "journal.Run"
+	log := j.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(j.opts.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.compact(); err != nil {
+			log.Error("failed to compact journal", sl.Err(err))
+		}
+
+		select {
+		case <-j.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// compact removes segments which were last written before retention.
+// Active segment is never removed
+func (j *Journal) compact() error {
+	const op = "journal.compact"
+	log := j.log.With(slog.String("op", op))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deadline := time.Now().Add(-j.opts.Retention)
+	removed := 0
+	for len(j.segments) > 1 {
+		stat, err := os.Stat(j.segments[0].path)
+		if err != nil {
+			return err
+		}
+		if stat.ModTime().After(deadline) {
+			break
+		}
+
+		if err := os.Remove(j.segments[0].path); err != nil {
+			return err
+		}
+		j.segments = j.segments[1:]
+		removed++
+	}
+
+	if removed > 0 {
+		log.Info("journal is compacted",
+			slog.Int("removed segments", removed),
+			slog.Uint64("first seq", j.segments[0].first),
+		)
+	}
+	return nil
+}
+
+// Stop stops compaction and closes active segment
+func (j *Journal) Stop() {
+	const op = "journal.Stop"
+	j.log.Info("stopping journal", slog.String("op", op))
+
+	close(j.done)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.active.Close(); err != nil {
+		j.log.Error("failed to close journal segment", sl.Err(err))
+	}
+}
+
+// readSegment calls fn for every entry of the segment until fn returns false.
+// It returns size of complete lines read. Torn last line left by crash is ignored
+func readSegment(path string, fn func(Entry) bool) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var size int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return 0, fmt.Errorf("corrupted segment %s: %w", path, err)
+		}
+		size += int64(len(line))
+
+		if !fn(e) {
+			return size, nil
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/metrics"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	chunkSize = 64 * 1024
+	// createParentsKey is metadata key of upload
+	// which creates missing parent directories
+	createParentsKey = "x-fm-create-parents"
+)
+
+// peer applies queued changes on one peer filemanager
+type peer struct {
+	log           *slog.Logger
+	addr          string
+	root          *os.Root
+	store         *storage.Store
+	cc            *grpc.ClientConn
+	api           filemanagerv1.FileManagerClient
+	queue         *queue
+	timeout       time.Duration
+	retryInterval time.Duration
+	secret        string
+}
+
+func newPeer(
+	log *slog.Logger,
+	addr string,
+	root *os.Root,
+	store *storage.Store,
+	queue *queue,
+	timeout time.Duration,
+	retryInterval time.Duration,
+	secret string,
+) (*peer, error) {
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &peer{
+		log:           log.With(slog.String("peer", addr)),
+		addr:          addr,
+		root:          root,
+		store:         store,
+		cc:            cc,
+		api:           filemanagerv1.NewFileManagerClient(cc),
+		queue:         queue,
+		timeout:       timeout,
+		retryInterval: retryInterval,
+		secret:        secret,
+	}, nil
+}
+
+// run applies changes until ctx is done. Failed change is retried,
+// so the peer catches up when it is reachable again
+func (p *peer) run(ctx context.Context) {
+	const op = "replication.peer.run"
+	log := p.log.With(slog.String("op", op))
+	ctx = markReplicated(ctx, p.secret)
+
+	failing := false
+	for {
+		p.updateLag()
+
+		var err error
+		if p.queue.needsResync() {
+			err = p.resync(ctx)
+		} else if e, ok := p.queue.peek(); ok {
+			err = p.apply(ctx, e.Change)
+			metrics.CountReplicatedChange(p.addr, err)
+			if err == nil {
+				err = p.queue.ack(e.Seq)
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.queue.updates():
+			}
+			continue
+		}
+
+		if err == nil {
+			if failing {
+				log.Info("peer is reachable again, catching up")
+				failing = false
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if !failing {
+			log.Warn("failed to replicate change, retrying", sl.Err(err))
+			failing = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.retryInterval):
+		}
+	}
+}
+
+func (p *peer) updateLag() {
+	pending, oldest := p.queue.stats()
+
+	var lag time.Duration
+	if oldest != nil {
+		lag = time.Since(oldest.Time)
+	}
+	metrics.SetReplicationLag(p.addr, pending, lag)
+}
+
+// apply makes change on the peer. Files are sent as they are now,
+// because later changes of them are queued after this one
+func (p *peer) apply(ctx context.Context, change filemanager.Change) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	switch change.Op {
+	case filemanager.OpDelete:
+		return p.delete(ctx, change.Name)
+	case filemanager.OpCreate, filemanager.OpUpdate:
+		return p.send(ctx, change.Name, change.Op == filemanager.OpUpdate)
+	case filemanager.OpMkdir:
+		return p.mkdir(ctx, change.Name)
+	case filemanager.OpMove:
+		return p.move(ctx, change.From, change.Name)
+	}
+
+	return fmt.Errorf("unknown change %q", change.Op)
+}
+
+func (p *peer) delete(ctx context.Context, name string) error {
+	_, err := p.api.DeleteFile(ctx, &filemanagerv1.DeleteFileRequest{FileName: name})
+	if isMissing(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (p *peer) mkdir(ctx context.Context, name string) error {
+	_, err := p.api.Mkdir(ctx, &filemanagerv1.MkdirRequest{Name: name})
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+
+	return err
+}
+
+// move renames file on the peer. If the peer has diverged, moved file is
+// sent again and the old one is deleted. Diverged directory is resynced
+func (p *peer) move(ctx context.Context, from string, to string) error {
+	_, err := p.api.Move(ctx, &filemanagerv1.MoveRequest{From: from, To: to})
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound, codes.AlreadyExists:
+	default:
+		return err
+	}
+
+	info, err := p.root.Stat(to)
+	if errors.Is(err, fs.ErrNotExist) {
+		// file is moved or deleted later, the change is queued after this one
+		return p.delete(ctx, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		p.log.Warn("peer diverged on moved directory, requesting resync", slog.String("dir", to))
+		return p.queue.requestResync()
+	}
+
+	if err := p.send(ctx, to, true); err != nil {
+		return err
+	}
+
+	return p.delete(ctx, from)
+}
+
+// send sends file to the peer. Creating existing file turns into update
+// and vice versa, so applying change twice is harmless
+func (p *peer) send(ctx context.Context, name string, update bool) error {
+	err := p.upload(ctx, name, update)
+	// missing file is not updated and existing one is not created
+	if code := status.Code(err); code == codes.InvalidArgument || code == codes.AlreadyExists {
+		err = p.upload(ctx, name, !update)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// file is deleted later, deletion is queued after this change
+		return nil
+	}
+
+	return err
+}
+
+func (p *peer) upload(ctx context.Context, name string, update bool) error {
+	file, _, err := p.store.Open(p.root, name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if update {
+		stream, err := p.api.PutFile(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = sendFile(file, func(chunk []byte) error {
+			return stream.Send(&filemanagerv1.PutFileRequest{FileName: name, Chunk: chunk})
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// parents of file may be created by sharded upload, which is not replicated
+	stream, err := p.api.PostFile(metadata.AppendToOutgoingContext(ctx, createParentsKey, "true"))
+	if err != nil {
+		return err
+	}
+
+	err = sendFile(file, func(chunk []byte) error {
+		return stream.Send(&filemanagerv1.PostFileRequest{FileName: name, Chunk: chunk})
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// sendFile sends file by chunks. Empty file is sent as one empty chunk,
+// because file name is passed along with chunks. It stops on the first
+// failed send, the error is returned by closing the stream
+func sendFile(file io.Reader, send func([]byte) error) error {
+	buf := make([]byte, chunkSize)
+	sent := false
+	for {
+		n, err := file.Read(buf)
+		if n > 0 || (!sent && errors.Is(err, io.EOF)) {
+			if err := send(buf[:n]); err != nil {
+				return nil
+			}
+			sent = true
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resync makes files on the peer the same as local files
+func (p *peer) resync(ctx context.Context) error {
+	const op = "replication.peer.resync"
+	log := p.log.With(slog.String("op", op))
+	log.Info("starting resync")
+
+	seq := p.queue.last()
+
+	local := make(map[string]storage.Info)
+	err := fs.WalkDir(p.root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && filemanager.IsHidden(name) {
+			return fs.SkipDir
+		}
+		if d.IsDir() || filemanager.IsHidden(name) {
+			return nil
+		}
+
+		info, err := p.store.Stat(p.root, name)
+		if err != nil {
+			return err
+		}
+		local[name] = info
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	resp, err := p.api.ListFiles(listCtx, &filemanagerv1.ListFilesRequest{Path: ".", Recursive: true})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	remote := make(map[string]*filemanagerv1.FileInfo)
+	for _, f := range resp.GetFiles() {
+		if !f.GetIsDir() {
+			remote[f.GetName()] = f
+		}
+	}
+
+	sent, deleted := 0, 0
+	for name, info := range local {
+		r, ok := remote[name]
+		if ok && r.GetSize() == info.Size && r.GetModTime() >= info.ModTime.Unix() {
+			continue
+		}
+
+		if err := p.apply(ctx, filemanager.Change{Op: filemanager.OpUpdate, Name: name}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		sent++
+	}
+	for name := range remote {
+		if _, ok := local[name]; ok {
+			continue
+		}
+
+		if err := p.apply(ctx, filemanager.Change{Op: filemanager.OpDelete, Name: name}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		deleted++
+	}
+
+	if err := p.queue.resynced(seq); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("resync is finished", slog.Int("sent", sent), slog.Int("deleted", deleted))
+	return nil
+}
+
+func (p *peer) close() {
+	if err := p.cc.Close(); err != nil {
+		p.log.Error("failed to close peer connection", sl.Err(err))
+	}
+	if err := p.queue.close(); err != nil {
+		p.log.Error("failed to close queue", sl.Err(err))
+	}
+}
+
+// isMissing reports whether err means that file is not found on the peer
+func isMissing(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.InvalidArgument:
+		return true
+	}
+
+	return false
+}
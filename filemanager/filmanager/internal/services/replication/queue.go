@@ -0,0 +1,298 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const (
+	queueFile  = "queue.jsonl"
+	ackedFile  = "acked"
+	resyncFile = "resync"
+)
+
+// entry is a change waiting to be applied on the peer
+type entry struct {
+	Seq    uint64             `json:"seq"`
+	Change filemanager.Change `json:"change"`
+}
+
+// queue is a durable queue of changes of one peer.
+// Changes are appended to the queue file, sequence number of the last
+// applied change is kept in acked file. When all changes are applied,
+// queue file is truncated. If queue overflows, it is dropped and the peer
+// is marked to be fully resynced
+type queue struct {
+	mu      sync.Mutex
+	dir     string
+	file    *os.File
+	pending []entry
+	next    uint64
+	acked   uint64
+	limit   int
+	resync  bool
+	// overflowed is sequence number of the change which overflowed the queue
+	overflowed uint64
+	// changed is closed and replaced on every change of the queue
+	changed chan struct{}
+}
+
+// openQueue loads queue kept in dir. New queue is marked to be resynced,
+// so the peer receives files which were stored before
+func openQueue(dir string, limit int) (*queue, error) {
+	q := &queue{
+		dir:     dir,
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ackedFile))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		q.resync = true
+	case err != nil:
+		return nil, err
+	default:
+		q.acked, err = strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acked sequence: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, resyncFile)); err == nil {
+		q.resync = true
+	}
+
+	q.file, err = os.OpenFile(filepath.Join(dir, queueFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	q.next = q.acked + 1
+	scanner := bufio.NewScanner(q.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// the last line may be torn by crash while appending
+			break
+		}
+		if e.Seq >= q.next {
+			q.next = e.Seq + 1
+		}
+		if e.Seq > q.acked {
+			q.pending = append(q.pending, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		_ = q.file.Close()
+		return nil, err
+	}
+
+	if q.resync {
+		if err := q.markResync(); err != nil {
+			_ = q.file.Close()
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// push durably appends change and returns its sequence number
+func (q *queue) push(change filemanager.Change) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e := entry{Seq: q.next, Change: change}
+	q.next++
+	defer q.broadcast()
+
+	if len(q.pending) >= q.limit {
+		q.pending = nil
+		q.overflowed = e.Seq
+		if err := q.file.Truncate(0); err != nil {
+			return 0, err
+		}
+
+		return e.Seq, q.markResync()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	if err := q.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	q.pending = append(q.pending, e)
+	return e.Seq, nil
+}
+
+// peek returns the oldest pending change
+func (q *queue) peek() (entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return entry{}, false
+	}
+
+	return q.pending[0], true
+}
+
+// ack removes applied change with sequence number seq
+func (q *queue) ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.broadcast()
+
+	if len(q.pending) > 0 && q.pending[0].Seq == seq {
+		q.pending = q.pending[1:]
+	}
+
+	return q.setAcked(seq)
+}
+
+// needsResync reports whether all files have to be resent to the peer
+func (q *queue) needsResync() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.resync
+}
+
+// requestResync makes peer resync all files before the next change
+func (q *queue) requestResync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.broadcast()
+
+	return q.markResync()
+}
+
+// resynced acknowledges changes up to seq which was the last change
+// when resync was started. Changes pushed during resync stay pending.
+// If queue overflowed during resync, it has to be resynced again
+func (q *queue) resynced(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.broadcast()
+
+	for len(q.pending) > 0 && q.pending[0].Seq <= seq {
+		q.pending = q.pending[1:]
+	}
+	if err := q.setAcked(seq); err != nil {
+		return err
+	}
+
+	if q.overflowed > seq {
+		return nil
+	}
+
+	q.resync = false
+	return os.Remove(filepath.Join(q.dir, resyncFile))
+}
+
+// last returns sequence number of the last pushed change
+func (q *queue) last() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.next - 1
+}
+
+// wait waits until change with sequence number seq is applied
+func (q *queue) wait(ctx context.Context, seq uint64) error {
+	for {
+		q.mu.Lock()
+		acked, changed := q.acked, q.changed
+		q.mu.Unlock()
+
+		if acked >= seq {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// updates returns channel which is closed on the next change of the queue
+func (q *queue) updates() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.changed
+}
+
+// stats returns number of pending changes and the oldest of them
+func (q *queue) stats() (int, *filemanager.Change) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return 0, nil
+	}
+
+	oldest := q.pending[0].Change
+	return len(q.pending), &oldest
+}
+
+func (q *queue) close() error {
+	return q.file.Close()
+}
+
+// setAcked persists sequence number of the last applied change.
+// Queue file is truncated when nothing is pending
+func (q *queue) setAcked(seq uint64) error {
+	if seq > q.acked {
+		q.acked = seq
+	}
+
+	tmp := filepath.Join(q.dir, ackedFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(q.acked, 10)), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, ackedFile)); err != nil {
+		return err
+	}
+
+	if len(q.pending) == 0 {
+		return q.file.Truncate(0)
+	}
+
+	return nil
+}
+
+func (q *queue) markResync() error {
+	q.resync = true
+
+	return os.WriteFile(filepath.Join(q.dir, resyncFile), nil, 0o644)
+}
+
+func (q *queue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,227 @@
+// Package replication forwards committed changes of files to peer filemanagers.
+package replication
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	AckAsync = "async"
+	AckSync  = "sync"
+)
+
+var (
+	ErrUnknownAck = errors.New("unknown ack policy")
+	ErrNoSecret   = errors.New("secret of peers is not set")
+)
+
+type Options struct {
+	Peers []string
+	// Ack is "sync" to wait until every peer applies the change
+	// or "async" to return once the change is queued
+	Ack           string
+	QueueDir      string
+	QueueLimit    int
+	SyncTimeout   time.Duration
+	Timeout       time.Duration
+	RetryInterval time.Duration
+	// Secret is shared by all peers. Requests of peers carry it,
+	// so changes applied by them are recognized and not forwarded
+	Secret string
+}
+
+// Replicator queues every committed change for every peer
+// and applies queued changes on peers in background
+type Replicator struct {
+	log         *slog.Logger
+	peers       []*peer
+	root        *os.Root
+	ack         string
+	syncTimeout time.Duration
+	secret      string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// New creates replicator of files of directory rootPath, which are
+// read through store, so peers get contents of files as they are sent
+func New(
+	log *slog.Logger,
+	rootPath string,
+	store *storage.Store,
+	opts Options,
+) (*Replicator, error) {
+	const op = "replication.New"
+	log = log.With(slog.String("op", op))
+
+	if opts.Ack != AckAsync && opts.Ack != AckSync {
+		return nil, fmt.Errorf("%s: %w: %q", op, ErrUnknownAck, opts.Ack)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Replicator{
+		log:         log,
+		ack:         opts.Ack,
+		syncTimeout: opts.SyncTimeout,
+		secret:      opts.Secret,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if len(opts.Peers) == 0 {
+		return r, nil
+	}
+	if opts.Secret == "" {
+		cancel()
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSecret)
+	}
+
+	root, err := os.OpenRoot(rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	r.root = root
+
+	for _, addr := range opts.Peers {
+		q, err := openQueue(filepath.Join(opts.QueueDir, url.PathEscape(addr)), opts.QueueLimit)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("%s: peer %s: %w", op, addr, err)
+		}
+
+		p, err := newPeer(log, addr, root, store, q, opts.Timeout, opts.RetryInterval, opts.Secret)
+		if err != nil {
+			_ = q.close()
+			r.close()
+			return nil, fmt.Errorf("%s: peer %s: %w", op, addr, err)
+		}
+		r.peers = append(r.peers, p)
+	}
+
+	log.Info("replication is enabled", slog.Any("peers", opts.Peers), slog.String("ack", opts.Ack))
+	return r, nil
+}
+
+// replicatedKey is metadata key which carries secret of peers
+// in requests made by them
+const replicatedKey = "x-fm-replicated"
+
+// markReplicated marks requests made with ctx as replicated changes
+func markReplicated(ctx context.Context, secret string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, replicatedKey, secret)
+}
+
+// isReplicated reports whether request of ctx is made by a peer.
+// Any client can set the metadata key, so only the secret is trusted
+func (r *Replicator) isReplicated(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(replicatedKey)
+	if len(values) == 0 {
+		return false
+	}
+
+	if r.secret == "" || subtle.ConstantTimeCompare([]byte(values[0]), []byte(r.secret)) != 1 {
+		r.log.Warn("request is marked as replicated without secret of peers")
+		return false
+	}
+
+	return true
+}
+
+// OnChange queues change for every peer. With sync ack policy it waits
+// until every peer applies the change. Changes applied by peers are not
+// forwarded, so peers replicating to each other do not send them back,
+// and every filemanager has to list all the others as its peers
+func (r *Replicator) OnChange(ctx context.Context, change filemanager.Change) error {
+	const op = "replication.OnChange"
+
+	if len(r.peers) == 0 || r.isReplicated(ctx) {
+		return nil
+	}
+
+	seqs := make([]uint64, len(r.peers))
+	for i, p := range r.peers {
+		seq, err := p.queue.push(change)
+		if err != nil {
+			return fmt.Errorf("%s: peer %s: %w", op, p.addr, err)
+		}
+		seqs[i] = seq
+	}
+
+	if r.ack != AckSync {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.syncTimeout)
+	defer cancel()
+
+	for i, p := range r.peers {
+		if err := p.queue.wait(ctx, seqs[i]); err != nil {
+			return fmt.Errorf("%s: peer %s: %w", op, p.addr, err)
+		}
+	}
+
+	return nil
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (r *Replicator) MustRun() {
+	if err := r.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run applies queued changes on peers until Stop is called
+func (r *Replicator) Run() error {
+	const op = "replication.Run"
+	log := r.log.With(slog.String("op", op))
+	log.Info("starting replication", slog.Int("peers", len(r.peers)))
+
+	for _, p := range r.peers {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			p.run(r.ctx)
+		}()
+	}
+
+	r.wg.Wait()
+	return nil
+}
+
+// Stop stops applying changes. Pending changes stay in the queues
+func (r *Replicator) Stop() {
+	const op = "replication.Stop"
+	r.log.Info("stopping replication", slog.String("op", op))
+
+	r.cancel()
+	r.wg.Wait()
+	r.close()
+}
+
+func (r *Replicator) close() {
+	for _, p := range r.peers {
+		p.close()
+	}
+
+	if r.root != nil {
+		if err := r.root.Close(); err != nil {
+			r.log.Error("failed to close root", sl.Err(err))
+		}
+	}
+}
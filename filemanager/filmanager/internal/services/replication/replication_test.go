@@ -0,0 +1,56 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNewWithoutSecret(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := New(log, t.TempDir(), nil, Options{Peers: []string{"peer:20201"}, Ack: AckAsync})
+	if !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("New() error = %v, want %v", err, ErrNoSecret)
+	}
+}
+
+func TestIsReplicated(t *testing.T) {
+	r := &Replicator{log: slog.New(slog.NewTextHandler(io.Discard, nil)), secret: "secret"}
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		want bool
+	}{
+		{name: "client request", md: metadata.MD{}},
+		{name: "peer request", md: metadata.Pairs(replicatedKey, "secret"), want: true},
+		{name: "marked without secret", md: metadata.Pairs(replicatedKey, "true")},
+		{name: "marked with empty secret", md: metadata.Pairs(replicatedKey, "")},
+		{name: "marked with longer secret", md: metadata.Pairs(replicatedKey, "secret2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			if got := r.isReplicated(ctx); got != tt.want {
+				t.Errorf("isReplicated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// requests marked by peers are recognized by the others
+	out, _ := metadata.FromOutgoingContext(markReplicated(context.Background(), "secret"))
+	if !r.isReplicated(metadata.NewIncomingContext(context.Background(), out)) {
+		t.Errorf("request marked by peer is not recognized")
+	}
+
+	noSecret := &Replicator{log: r.log}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(replicatedKey, ""))
+	if noSecret.isReplicated(ctx) {
+		t.Errorf("request is recognized by replicator without secret")
+	}
+}
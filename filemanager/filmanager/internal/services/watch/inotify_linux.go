@@ -0,0 +1,192 @@
+//go:build linux
+
+package watch
+
+import (
+	"errors"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// notifier detects changes in the root with inotify. Inotify does not
+// watch subdirectories, so every directory is watched separately
+type notifier struct {
+	log  *slog.Logger
+	root string
+	fd   int
+	file *os.File
+	// watches maps watch descriptor to directory relative to the root
+	watches map[int32]string
+	dirs    map[string]int32
+	// created are files which are created but not closed yet
+	created map[string]struct{}
+}
+
+func newNotifier(log *slog.Logger, root string) (*notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &notifier{
+		log:     log,
+		root:    root,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]string),
+		dirs:    make(map[string]int32),
+		created: make(map[string]struct{}),
+	}
+	if err := n.addTree(".", nil); err != nil {
+		_ = n.file.Close()
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// run reads events until notifier is closed and passes changes of files to emit
+func (n *notifier) run(emit func(filemanager.Op, string)) error {
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := n.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= size; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(raw.Len)
+
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+			n.handle(raw.Wd, raw.Mask, name, emit)
+		}
+	}
+}
+
+func (n *notifier) handle(wd int32, mask uint32, base string, emit func(filemanager.Op, string)) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		n.log.Warn("inotify queue overflowed, changes in root are lost")
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		if dir, ok := n.watches[wd]; ok {
+			delete(n.watches, wd)
+			delete(n.dirs, dir)
+		}
+		return
+	}
+
+	dir, ok := n.watches[wd]
+	if !ok {
+		return
+	}
+	name := path.Join(dir, base)
+	if filemanager.IsHidden(name) {
+		return
+	}
+
+	if mask&unix.IN_ISDIR != 0 {
+		switch {
+		case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			if err := n.addTree(name, emit); err != nil {
+				n.log.Error("failed to watch directory", sl.Err(err), slog.String("dir", name))
+			}
+		case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+			n.removeTree(name)
+		}
+		return
+	}
+
+	switch {
+	case mask&unix.IN_CREATE != 0:
+		// reported once written
+		n.created[name] = struct{}{}
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		if _, ok := n.created[name]; ok {
+			delete(n.created, name)
+			emit(filemanager.OpCreate, name)
+			return
+		}
+		emit(filemanager.OpUpdate, name)
+	case mask&unix.IN_MOVED_TO != 0:
+		emit(filemanager.OpCreate, name)
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		delete(n.created, name)
+		emit(filemanager.OpDelete, name)
+	}
+}
+
+// addTree watches dir and all its subdirectories. Files found in them are
+// passed to emit, because they may be created before the watch is added
+func (n *notifier) addTree(dir string, emit func(filemanager.Op, string)) error {
+	return filepath.WalkDir(filepath.Join(n.root, dir), func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(n.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if filemanager.IsHidden(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			if emit != nil {
+				emit(filemanager.OpCreate, rel)
+			}
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(n.fd, p, inotifyMask)
+		if err != nil {
+			return err
+		}
+		n.watches[int32(wd)] = rel
+		n.dirs[rel] = int32(wd)
+		return nil
+	})
+}
+
+// removeTree stops watching dir moved out of the root and its subdirectories
+func (n *notifier) removeTree(dir string) {
+	for d, wd := range n.dirs {
+		if d != dir && !strings.HasPrefix(d, dir+"/") {
+			continue
+		}
+
+		// watch of removed directory is already removed by the kernel
+		_, _ = unix.InotifyRmWatch(n.fd, uint32(wd))
+		delete(n.dirs, d)
+		delete(n.watches, wd)
+	}
+}
+
+func (n *notifier) close() error {
+	return n.file.Close()
+}
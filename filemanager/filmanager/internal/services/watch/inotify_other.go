@@ -0,0 +1,23 @@
+//go:build !linux
+
+package watch
+
+import (
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"log/slog"
+)
+
+// notifier is not implemented, only changes made through the API are reported
+type notifier struct{}
+
+func newNotifier(*slog.Logger, string) (*notifier, error) {
+	return nil, ErrNotSupported
+}
+
+func (n *notifier) run(func(filemanager.Op, string)) error {
+	return nil
+}
+
+func (n *notifier) close() error {
+	return nil
+}
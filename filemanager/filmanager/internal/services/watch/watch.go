@@ -0,0 +1,340 @@
+// Package watch notifies subscribers about changes of files made through
+// the API and about changes made in the root directly.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IlianBuh/filemanager-server/internal/lib/logger/sl"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// SourceAPI marks changes made through the API
+	SourceAPI = "api"
+	// SourceFS marks changes made in the root directly
+	SourceFS = "fs"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrNotSupported  = errors.New("watching root is not supported on this platform")
+)
+
+// Event is a change of file and where it is made
+type Event struct {
+	filemanager.Change
+	Source string `json:"source"`
+}
+
+// Filter selects events of files under Prefix. Without Recursive only
+// files directly in Prefix match. Empty Ops matches all kinds of changes
+type Filter struct {
+	Prefix    string
+	Recursive bool
+	Ops       []filemanager.Op
+}
+
+func NewFilter(prefix string, recursive bool, ops []string) (Filter, error) {
+	prefix = path.Clean(strings.TrimPrefix(prefix, "/"))
+	if !fs.ValidPath(prefix) {
+		return Filter{}, fmt.Errorf("%w: prefix %q", ErrInvalidFilter, prefix)
+	}
+
+	f := Filter{Prefix: prefix, Recursive: recursive}
+	for _, o := range ops {
+		op := filemanager.Op(o)
+		if !op.Valid() {
+			return Filter{}, fmt.Errorf("%w: unknown op %q", ErrInvalidFilter, o)
+		}
+		f.Ops = append(f.Ops, op)
+	}
+
+	return f, nil
+}
+
+// match reports whether event matches filter. Moved file matches
+// if either its new or its previous path matches
+func (f Filter) match(e Event) bool {
+	if len(f.Ops) > 0 && !slices.Contains(f.Ops, e.Op) {
+		return false
+	}
+
+	return f.matchName(e.Name) || (e.From != "" && f.matchName(e.From))
+}
+
+func (f Filter) matchName(name string) bool {
+	if name == f.Prefix || path.Dir(name) == f.Prefix {
+		return true
+	}
+	if !f.Recursive {
+		return false
+	}
+
+	return f.Prefix == "." || strings.HasPrefix(name, f.Prefix+"/")
+}
+
+// Subscription receives matching events. Its channel is closed
+// when subscriber falls behind or watcher is stopped
+type Subscription struct {
+	filter Filter
+	events chan Event
+	lagged atomic.Bool
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Lagged reports whether subscription is closed because events
+// were not received in time
+func (s *Subscription) Lagged() bool {
+	return s.lagged.Load()
+}
+
+type Options struct {
+	// Inotify enables detection of changes made in the root directly
+	Inotify bool
+	// Buffer is a number of events kept for slow subscriber
+	Buffer int
+	// Debounce delays changes detected in the root, so repeated writes are
+	// merged and changes made through the API are not reported twice
+	Debounce time.Duration
+}
+
+// pending is a change detected in the root which is not reported yet
+type pending struct {
+	op    filemanager.Op
+	first time.Time
+}
+
+// Watcher passes changes to subscribers
+type Watcher struct {
+	log  *slog.Logger
+	opts Options
+
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	stopped bool
+	// recent are times of the last changes made through the API
+	recent  map[string]time.Time
+	pending map[string]pending
+
+	notifier *notifier
+	done     chan struct{}
+}
+
+func New(log *slog.Logger, rootPath string, opts Options) (*Watcher, error) {
+	const op = "watch.New"
+
+	w := &Watcher{
+		log:     log,
+		opts:    opts,
+		subs:    make(map[*Subscription]struct{}),
+		recent:  make(map[string]time.Time),
+		pending: make(map[string]pending),
+		done:    make(chan struct{}),
+	}
+	if !opts.Inotify {
+		return w, nil
+	}
+
+	n, err := newNotifier(log, rootPath)
+	switch {
+	case errors.Is(err, ErrNotSupported):
+		log.Warn("changes in root are not watched", sl.Err(err), slog.String("op", op))
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	default:
+		w.notifier = n
+	}
+
+	return w, nil
+}
+
+// OnChange passes change made through the API to subscribers
+func (w *Watcher) OnChange(_ context.Context, change filemanager.Change) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.recent[change.Name] = change.Time
+	if change.From != "" {
+		w.recent[change.From] = change.Time
+	}
+	w.publish(Event{Change: change, Source: SourceAPI})
+
+	return nil
+}
+
+// Subscribe starts receiving events matching filter.
+// Subscription has to be cancelled by Unsubscribe
+func (w *Watcher) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		filter: filter,
+		events: make(chan Event, w.opts.Buffer),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		close(sub.events)
+		return sub
+	}
+
+	w.subs[sub] = struct{}{}
+	return sub
+}
+
+func (w *Watcher) Unsubscribe(sub *Subscription) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.subs[sub]; ok {
+		delete(w.subs, sub)
+		close(sub.events)
+	}
+}
+
+// publish passes event to matching subscribers. Subscriber which
+// buffer is full is dropped, so it does not delay others
+func (w *Watcher) publish(e Event) {
+	for sub := range w.subs {
+		if !sub.filter.match(e) {
+			continue
+		}
+
+		select {
+		case sub.events <- e:
+		default:
+			w.log.Warn("subscriber is too slow, dropping it", slog.String("prefix", sub.filter.Prefix))
+			sub.lagged.Store(true)
+			delete(w.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (w *Watcher) MustRun() {
+	if err := w.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run watches the root until Stop is called
+func (w *Watcher) Run() error {
+	const op = "watch.Run"
+	log := w.log.With(slog.String("op", op))
+
+	if w.notifier == nil {
+		<-w.done
+		return nil
+	}
+
+	log.Info("watching root")
+	go func() {
+		if err := w.notifier.run(w.detected); err != nil {
+			log.Error("failed to watch root", sl.Err(err))
+		}
+	}()
+
+	ticker := time.NewTicker(w.opts.Debounce / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return nil
+		case now := <-ticker.C:
+			w.flush(now)
+		}
+	}
+}
+
+// detected merges change detected in the root with pending change of the file
+func (w *Watcher) detected(op filemanager.Op, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.pending[name]
+	if !ok {
+		w.pending[name] = pending{op: op, first: time.Now()}
+		return
+	}
+
+	switch {
+	case p.op == filemanager.OpCreate && op == filemanager.OpUpdate:
+	case p.op == filemanager.OpCreate && op == filemanager.OpDelete:
+		// file did not exist before and does not exist now
+		delete(w.pending, name)
+		return
+	case p.op == filemanager.OpDelete && op == filemanager.OpCreate:
+		p.op = filemanager.OpUpdate
+	default:
+		p.op = op
+	}
+	w.pending[name] = p
+}
+
+// flush reports pending changes which are not changed during debounce.
+// Changes of files changed through the API around the same time are
+// dropped, because they are already reported
+func (w *Watcher) flush(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for name, p := range w.pending {
+		if now.Sub(p.first) < w.opts.Debounce {
+			continue
+		}
+		delete(w.pending, name)
+
+		if t, ok := w.recent[name]; ok && t.After(p.first.Add(-w.opts.Debounce)) {
+			continue
+		}
+
+		w.publish(Event{
+			Change: filemanager.Change{Op: p.op, Name: name, Time: p.first},
+			Source: SourceFS,
+		})
+	}
+
+	for name, t := range w.recent {
+		if now.Sub(t) > 2*w.opts.Debounce {
+			delete(w.recent, name)
+		}
+	}
+}
+
+// Stop stops watching the root and closes all subscriptions
+func (w *Watcher) Stop() {
+	const op = "watch.Stop"
+	w.log.Info("stopping watcher", slog.String("op", op))
+
+	close(w.done)
+	if w.notifier != nil {
+		if err := w.notifier.close(); err != nil {
+			w.log.Error("failed to close notifier", sl.Err(err))
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	for sub := range w.subs {
+		delete(w.subs, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,372 @@
+// Package delta computes differences of files the way rsync does. Holder
+// of the old content cuts it into blocks and sends their signatures, which
+// are weak rolling checksum and strong hash of every block. Holder of the
+// new content looks for the blocks at every offset of it, rolling the weak
+// checksum byte by byte, and sends ops which copy found blocks from the old
+// content and carry only data which is not found:
+//
+//	sig := delta.Signature{BlockSize: bs, Size: size}
+//	err := delta.Sign(old, bs, func(b delta.Block) error {
+//		sig.Blocks = append(sig.Blocks, b)
+//		return nil
+//	})
+//	...
+//	err = delta.Diff(sig, new, func(op delta.Op) error {
+//		return send(op)
+//	})
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	MinBlockSize = 1 << 10
+	MaxBlockSize = 1 << 20
+	// StrongSize is size of strong hash of block, which is SHA-256 cut short
+	StrongSize = 16
+	// MaxLiteral is the largest data carried by one op
+	MaxLiteral = 64 << 10
+
+	minDefaultBlock = 2 << 10
+	maxDefaultBlock = 128 << 10
+)
+
+var ErrInvalidBlockSize = errors.New("invalid block size")
+
+// Block is signature of block of the old content
+type Block struct {
+	Weak   uint32
+	Strong []byte
+}
+
+// Signature describes the old content of Size bytes cut into blocks
+// of BlockSize bytes, the last block may be shorter
+type Signature struct {
+	BlockSize int
+	Size      int64
+	Blocks    []Block
+}
+
+// Op copies Length bytes of the old content at Offset,
+// or appends Data if it is not empty
+type Op struct {
+	Offset int64
+	Length int64
+	Data   []byte
+}
+
+// BlockSize returns block size for content of size bytes. It is close to
+// square root of size, so both signature and data resent around every
+// change stay small
+func BlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + MinBlockSize - 1) / MinBlockSize * MinBlockSize
+
+	return min(max(bs, minDefaultBlock), maxDefaultBlock)
+}
+
+// ValidBlockSize reports whether blocks of bs bytes may be signed
+func ValidBlockSize(bs int) bool {
+	return bs >= MinBlockSize && bs <= MaxBlockSize
+}
+
+// Sign cuts content of r into blocks of blockSize
+// bytes and passes their signatures to emit
+func Sign(r io.Reader, blockSize int, emit func(Block) error) error {
+	if !ValidBlockSize(blockSize) {
+		return ErrInvalidBlockSize
+	}
+
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := emit(Block{Weak: weak(buf[:n]), Strong: strong(buf[:n])}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// weak is rolling checksum of rsync: sum of bytes and sum of the
+// sums of prefixes, both modulo 2^16
+func weak(p []byte) uint32 {
+	var a, b uint32
+	for i, c := range p {
+		a += uint32(c)
+		b += uint32(len(p)-i) * uint32(c)
+	}
+
+	return a&0xffff | b<<16
+}
+
+// roll moves window of n bytes with checksum sum one byte forward
+func roll(sum uint32, n int, out byte, in byte) uint32 {
+	a, b := sum&0xffff, sum>>16
+	a = (a - uint32(out) + uint32(in)) & 0xffff
+	b = (b - uint32(n)*uint32(out) + a) & 0xffff
+
+	return a | b<<16
+}
+
+func tag(sum uint32) uint16 {
+	return uint16(sum ^ sum>>16)
+}
+
+func strong(p []byte) []byte {
+	sum := sha256.Sum256(p)
+	return sum[:StrongSize]
+}
+
+// Diff reads the new content from r and passes to emit ops which build
+// it from the old content described by sig. Copies of consecutive blocks
+// are merged into one op
+func Diff(sig Signature, r io.Reader, emit func(Op) error) error {
+	if len(sig.Blocks) > 0 && !ValidBlockSize(sig.BlockSize) {
+		return ErrInvalidBlockSize
+	}
+
+	d := &differ{
+		sig:   sig,
+		r:     r,
+		emit:  emit,
+		index: make(map[uint32][]int, len(sig.Blocks)),
+		next:  -1,
+	}
+	for i, b := range sig.Blocks {
+		d.index[b.Weak] = append(d.index[b.Weak], i)
+		d.tags[tag(b.Weak)] = true
+	}
+
+	if err := d.run(); err != nil {
+		return err
+	}
+	return d.flushCopy()
+}
+
+// differ keeps the new content from the start of pending literal lit
+// in buf. Window of the rolling checksum starts at pos
+type differ struct {
+	sig   Signature
+	r     io.Reader
+	emit  func(Op) error
+	index map[uint32][]int
+	// tags rejects most of checksums without looking them up in index
+	tags [1 << 16]bool
+	buf  []byte
+	lit  int
+	pos  int
+	eof  bool
+	// copy is pending copy which is extended by the following block,
+	// next is the block expected after it
+	copy Op
+	next int
+}
+
+func (d *differ) run() error {
+	if len(d.sig.Blocks) == 0 {
+		return d.literal()
+	}
+
+	bs := d.sig.BlockSize
+	var sum uint32
+	rolled := false
+	for {
+		if err := d.fill(bs + 1); err != nil {
+			return err
+		}
+
+		n := min(bs, len(d.buf)-d.pos)
+		if n < bs {
+			// window is cut by the end of content,
+			// only the last block may match there
+			return d.finish()
+		}
+
+		if !rolled {
+			sum = weak(d.buf[d.pos : d.pos+n])
+			rolled = true
+		}
+		if i, ok := d.match(sum, d.buf[d.pos:d.pos+n]); ok {
+			if err := d.addCopy(i, n); err != nil {
+				return err
+			}
+			rolled = false
+			continue
+		}
+
+		if d.pos+n < len(d.buf) {
+			sum = roll(sum, n, d.buf[d.pos], d.buf[d.pos+n])
+		} else {
+			rolled = false
+		}
+		d.pos++
+		if d.pos-d.lit >= MaxLiteral {
+			if err := d.flushLiteral(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// literal emits the whole content as data, there is nothing to copy
+func (d *differ) literal() error {
+	for {
+		if err := d.fill(MaxLiteral); err != nil {
+			return err
+		}
+		if d.pos == len(d.buf) {
+			return nil
+		}
+
+		d.pos += min(MaxLiteral, len(d.buf)-d.pos)
+		if err := d.flushLiteral(); err != nil {
+			return err
+		}
+	}
+}
+
+// finish emits the rest of content, which is shorter than block
+func (d *differ) finish() error {
+	last := len(d.sig.Blocks) - 1
+	size := int(d.sig.Size - int64(last)*int64(d.sig.BlockSize))
+	start := len(d.buf) - size
+	if size > 0 && size < d.sig.BlockSize && start >= d.pos {
+		tail := d.buf[start:]
+		b := d.sig.Blocks[last]
+		if b.Weak == weak(tail) && bytes.Equal(b.Strong, strong(tail)) {
+			d.pos = start
+			return d.addCopy(last, size)
+		}
+	}
+
+	d.pos = len(d.buf)
+	return d.flushLiteral()
+}
+
+// fill reads content until window of n bytes is in buf or content ends
+func (d *differ) fill(n int) error {
+	for !d.eof && len(d.buf)-d.pos < n {
+		if len(d.buf) == cap(d.buf) {
+			if d.lit > 0 {
+				k := copy(d.buf, d.buf[d.lit:])
+				d.buf = d.buf[:k]
+				d.pos -= d.lit
+				d.lit = 0
+				continue
+			}
+
+			grown := make([]byte, len(d.buf), max(2*cap(d.buf), MaxLiteral+2*n))
+			copy(grown, d.buf)
+			d.buf = grown
+		}
+
+		k, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+k]
+		if errors.Is(err, io.EOF) {
+			d.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// match looks for block of window p, preferring the block which
+// continues pending copy
+func (d *differ) match(sum uint32, p []byte) (int, bool) {
+	if !d.tags[tag(sum)] {
+		return 0, false
+	}
+	candidates := d.index[sum]
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	s := strong(p)
+	if d.next >= 0 && d.next < len(d.sig.Blocks) {
+		b := d.sig.Blocks[d.next]
+		if b.Weak == sum && bytes.Equal(b.Strong, s) && d.blockLen(d.next) == len(p) {
+			return d.next, true
+		}
+	}
+	for _, i := range candidates {
+		if bytes.Equal(d.sig.Blocks[i].Strong, s) && d.blockLen(i) == len(p) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (d *differ) blockLen(i int) int {
+	return int(min(int64(d.sig.BlockSize), d.sig.Size-int64(i)*int64(d.sig.BlockSize)))
+}
+
+// addCopy emits pending literal and copies block i of n bytes at window
+func (d *differ) addCopy(i int, n int) error {
+	if err := d.flushLiteral(); err != nil {
+		return err
+	}
+
+	offset := int64(i) * int64(d.sig.BlockSize)
+	if d.copy.Length > 0 && d.copy.Offset+d.copy.Length == offset {
+		d.copy.Length += int64(n)
+	} else {
+		if err := d.flushCopy(); err != nil {
+			return err
+		}
+		d.copy = Op{Offset: offset, Length: int64(n)}
+	}
+	d.next = i + 1
+
+	d.pos += n
+	d.lit = d.pos
+	return nil
+}
+
+// flushLiteral emits content before window as data
+// in ops of at most MaxLiteral bytes
+func (d *differ) flushLiteral() error {
+	if d.pos == d.lit {
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+
+	for d.lit < d.pos {
+		data := make([]byte, min(d.pos-d.lit, MaxLiteral))
+		d.lit += copy(data, d.buf[d.lit:d.pos])
+		if err := d.emit(Op{Data: data}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *differ) flushCopy() error {
+	if d.copy.Length == 0 {
+		return nil
+	}
+
+	op := d.copy
+	d.copy = Op{}
+	d.next = -1
+	return d.emit(op)
+}
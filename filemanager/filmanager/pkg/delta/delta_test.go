@@ -0,0 +1,219 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	const bs = MinBlockSize
+	old := random(1, 20*bs+100)
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+		// sent is the largest number of bytes sent as data
+		sent int
+	}{
+		{name: "both empty", old: nil, new: nil},
+		{name: "same", old: old, new: old},
+		{name: "old is empty", old: nil, new: old, sent: len(old)},
+		{name: "new is empty", old: old, new: nil},
+		{name: "shorter than block", old: old[:bs/2], new: old[:bs/2]},
+		{name: "byte changed", old: old, new: replace(old, 5*bs+7, []byte{old[5*bs+7] + 1}), sent: bs},
+		{name: "byte inserted", old: old, new: insert(old, 3*bs+1, []byte{1}), sent: bs + 1},
+		{name: "bytes removed", old: old, new: cut(old, 3*bs+1, 3*bs+11), sent: bs},
+		{name: "byte prepended", old: old, new: insert(old, 0, []byte{1}), sent: 1},
+		// the last block of old is short, so it is not found before appended data
+		{name: "data appended", old: old, new: insert(old, len(old), random(2, 3*bs)), sent: 3*bs + 100},
+		{name: "truncated", old: old, new: old[:10*bs+5], sent: 5},
+		{name: "last block removed", old: old, new: old[:20*bs]},
+		{name: "blocks swapped", old: old, new: swap(old[:20*bs], 2*bs, 12*bs, 4*bs)},
+		{name: "block repeated", old: old, new: insert(old, 15*bs, old[2*bs:3*bs])},
+		{name: "unrelated", old: old, new: random(3, len(old)), sent: len(old)},
+		{name: "large literal", old: old[:bs], new: random(4, 3*MaxLiteral+5), sent: 3*MaxLiteral + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := sign(t, tt.old, bs)
+
+			ops := diff(t, sig, tt.new)
+			got, err := apply(tt.old, ops)
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.new) {
+				t.Fatalf("applied ops build other content")
+			}
+
+			sent := 0
+			for _, op := range ops {
+				if len(op.Data) > MaxLiteral {
+					t.Errorf("op carries %d bytes, more than %d", len(op.Data), MaxLiteral)
+				}
+				sent += len(op.Data)
+			}
+			if sent > tt.sent {
+				t.Errorf("sent %d bytes as data, want at most %d", sent, tt.sent)
+			}
+		})
+	}
+}
+
+func TestRoundTripBlockSizes(t *testing.T) {
+	for _, size := range []int{0, 1, MinBlockSize, 100 << 10, 1<<20 + 3} {
+		old := random(int64(size), size)
+		changed := insert(replace(old, size/3, []byte("changed")), size/2, []byte("inserted"))
+
+		for _, bs := range []int{MinBlockSize, BlockSize(int64(size)), MaxBlockSize} {
+			t.Run(fmt.Sprintf("%d/%d", size, bs), func(t *testing.T) {
+				got, err := apply(old, diff(t, sign(t, old, bs), changed))
+				if err != nil {
+					t.Fatalf("apply() error = %v", err)
+				}
+				if !bytes.Equal(got, changed) {
+					t.Fatalf("applied ops build other content")
+				}
+			})
+		}
+	}
+}
+
+// TestWeakCollision checks that blocks with matching weak checksum
+// are copied only if their strong hashes match too
+func TestWeakCollision(t *testing.T) {
+	const bs = MinBlockSize
+	old := random(5, 4*bs)
+
+	sig := sign(t, old, bs)
+	sig.Blocks[1].Strong = make([]byte, StrongSize)
+
+	ops := diff(t, sig, old)
+	got, err := apply(old, ops)
+	if err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if !bytes.Equal(got, old) {
+		t.Fatalf("applied ops build other content")
+	}
+	for _, op := range ops {
+		if op.Length > 0 && op.Offset < 2*bs && op.Offset+op.Length > bs {
+			t.Errorf("block with other strong hash is copied by %+v", op)
+		}
+	}
+}
+
+func TestInvalidBlockSize(t *testing.T) {
+	for _, bs := range []int{0, MinBlockSize - 1, MaxBlockSize + 1} {
+		err := Sign(bytes.NewReader([]byte("content")), bs, func(Block) error { return nil })
+		if !errors.Is(err, ErrInvalidBlockSize) {
+			t.Errorf("Sign() with block size %d error = %v, want %v", bs, err, ErrInvalidBlockSize)
+		}
+
+		sig := Signature{BlockSize: bs, Size: 1, Blocks: []Block{{}}}
+		err = Diff(sig, bytes.NewReader([]byte("content")), func(Op) error { return nil })
+		if !errors.Is(err, ErrInvalidBlockSize) {
+			t.Errorf("Diff() with block size %d error = %v, want %v", bs, err, ErrInvalidBlockSize)
+		}
+	}
+}
+
+func TestEmitError(t *testing.T) {
+	errStop := errors.New("stop")
+	content := random(6, 3*MinBlockSize)
+
+	err := Sign(bytes.NewReader(content), MinBlockSize, func(Block) error { return errStop })
+	if !errors.Is(err, errStop) {
+		t.Errorf("Sign() error = %v, want %v", err, errStop)
+	}
+
+	sig := sign(t, content, MinBlockSize)
+	err = Diff(sig, bytes.NewReader(content), func(Op) error { return errStop })
+	if !errors.Is(err, errStop) {
+		t.Errorf("Diff() error = %v, want %v", err, errStop)
+	}
+}
+
+func sign(t *testing.T, content []byte, bs int) Signature {
+	t.Helper()
+
+	sig := Signature{BlockSize: bs, Size: int64(len(content))}
+	err := Sign(bytes.NewReader(content), bs, func(b Block) error {
+		sig.Blocks = append(sig.Blocks, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	return sig
+}
+
+func diff(t *testing.T, sig Signature, content []byte) []Op {
+	t.Helper()
+
+	var ops []Op
+	err := Diff(sig, bytes.NewReader(content), func(op Op) error {
+		ops = append(ops, op)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+
+	return ops
+}
+
+// apply builds the new content from old by ops
+func apply(old []byte, ops []Op) ([]byte, error) {
+	var b bytes.Buffer
+	for _, op := range ops {
+		if len(op.Data) > 0 {
+			if op.Length != 0 {
+				return nil, fmt.Errorf("op carries both data and copy: %+v", op)
+			}
+			b.Write(op.Data)
+			continue
+		}
+		if op.Offset < 0 || op.Length <= 0 || op.Offset+op.Length > int64(len(old)) {
+			return nil, fmt.Errorf("copy of %d bytes at %d is out of content", op.Length, op.Offset)
+		}
+		b.Write(old[op.Offset : op.Offset+op.Length])
+	}
+
+	return b.Bytes(), nil
+}
+
+func random(seed int64, size int) []byte {
+	p := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(p)
+	return p
+}
+
+func replace(p []byte, at int, data []byte) []byte {
+	p = bytes.Clone(p)
+	copy(p[at:], data)
+	return p
+}
+
+func insert(p []byte, at int, data []byte) []byte {
+	return bytes.Join([][]byte{p[:at], data, p[at:]}, nil)
+}
+
+func cut(p []byte, from int, to int) []byte {
+	return bytes.Join([][]byte{p[:from], p[to:]}, nil)
+}
+
+// swap swaps n bytes at i and j, which do not overlap
+func swap(p []byte, i int, j int, n int) []byte {
+	p = bytes.Clone(p)
+	tmp := bytes.Clone(p[i : i+n])
+	copy(p[i:], p[j:j+n])
+	copy(p[j:], tmp)
+	return p
+}
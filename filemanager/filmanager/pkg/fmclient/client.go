@@ -0,0 +1,222 @@
+// Package fmclient is a client of filemanager. It reads and writes files
+// over the grpc API of filemanager and exposes them as io/fs file system,
+// so the standard library works with remote files directly:
+//
+//	c, err := fmclient.New("localhost:20201")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	http.Handle("/files/", http.StripPrefix("/files", http.FileServer(http.FS(c.FS()))))
+//	tmpl, err := template.ParseFS(c.FS(), "templates/*.html")
+//
+// Names are slash-separated paths relative to the root of filemanager,
+// as accepted by fs.ValidPath. The root itself is ".".
+// Errors are *fs.PathError, missing files are reported by errors matching
+// fs.ErrNotExist and existing ones by errors matching fs.ErrExist
+package fmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize is size of chunks of uploaded files
+const chunkSize = 64 * 1024
+
+// createParentsKey is metadata key of upload
+// which creates missing parent directories
+const createParentsKey = "x-fm-create-parents"
+
+// Client reads and writes files of filemanager. It is safe for concurrent use
+type Client struct {
+	ctx context.Context
+	cc  *grpc.ClientConn
+	api filemanagerv1.FileManagerClient
+}
+
+// New connects to filemanager at target, e.g. "localhost:20201" or
+// "dns:///filemanager:20201". Connection is not encrypted unless
+// credentials are passed in opts
+func New(target string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+
+	cc, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewFromConn(cc)
+	c.cc = cc
+
+	return c, nil
+}
+
+// NewFromConn creates client using existing connection. Close of
+// such client does not close the connection
+func NewFromConn(cc grpc.ClientConnInterface) *Client {
+	return &Client{
+		ctx: context.Background(),
+		api: filemanagerv1.NewFileManagerClient(cc),
+	}
+}
+
+// WithContext returns client making calls with ctx. Methods of
+// io/fs have no context, so it is passed to them this way
+func (c *Client) WithContext(ctx context.Context) *Client {
+	cp := *c
+	cp.ctx = ctx
+
+	return &cp
+}
+
+// Close closes connection created by New
+func (c *Client) Close() error {
+	if c.cc == nil {
+		return nil
+	}
+
+	return c.cc.Close()
+}
+
+// FS returns file system of filemanager
+func (c *Client) FS() *FS {
+	return &FS{c: c}
+}
+
+// Open opens file for reading. File is streamed as it is read,
+// it implements io.ReaderAt too
+func (c *Client) Open(name string) (io.ReadSeekCloser, error) {
+	info, err := c.Stat(name)
+	if err != nil {
+		return nil, withOp("open", err)
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+
+	return newFile(c, name, info), nil
+}
+
+// Create creates file or truncates existing one and opens it for writing.
+// Missing parent directories are created. Written data is streamed to
+// filemanager and the file appears when writer is closed successfully
+func (c *Client) Create(name string) (io.WriteCloser, error) {
+	info, err := c.Stat(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, withOp("create", err)
+	}
+	if exists && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: errIsDir}
+	}
+
+	return newWriter(c, name, exists)
+}
+
+// Stat describes file or directory
+func (c *Client) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resp, err := c.api.Stat(c.ctx, &filemanagerv1.StatRequest{Name: name})
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return newFileInfo(resp.GetFile()), nil
+}
+
+// ReadDir returns entries of directory sorted by name
+func (c *Client) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resp, err := c.api.ListFiles(c.ctx, &filemanagerv1.ListFilesRequest{Path: name})
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(resp.GetFiles()))
+	for _, f := range resp.GetFiles() {
+		entries = append(entries, fs.FileInfoToDirEntry(newFileInfo(f)))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// Remove deletes file or empty directory
+func (c *Client) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	_, err := c.api.DeleteFile(c.ctx, &filemanagerv1.DeleteFileRequest{FileName: name})
+	return pathError("remove", name, err)
+}
+
+// MkdirAll creates directory and its missing parents
+func (c *Client) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	_, err := c.api.Mkdir(c.ctx, &filemanagerv1.MkdirRequest{Name: name})
+	return pathError("mkdir", name, err)
+}
+
+// Rename moves file or directory. Existing target is not replaced
+func (c *Client) Rename(from string, to string) error {
+	if !fs.ValidPath(from) || !fs.ValidPath(to) {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrInvalid}
+	}
+
+	_, err := c.api.Move(c.ctx, &filemanagerv1.MoveRequest{From: from, To: to})
+	return pathError("rename", from, err)
+}
+
+var errIsDir = errors.New("is a directory")
+
+// ErrChanged is returned by reading file which is changed since it was opened
+var ErrChanged = errors.New("file is changed")
+
+// pathError converts status of filemanager to error of file system
+func pathError(op string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		err = fs.ErrNotExist
+	case codes.AlreadyExists:
+		err = fs.ErrExist
+	case codes.InvalidArgument:
+		err = fs.ErrInvalid
+	case codes.FailedPrecondition:
+		err = ErrChanged
+	case codes.PermissionDenied:
+		err = fs.ErrPermission
+	case codes.Canceled:
+		err = context.Canceled
+	case codes.DeadlineExceeded:
+		err = context.DeadlineExceeded
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,238 @@
+package fmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	grpcfm "github.com/IlianBuh/filemanager-server/internal/grpc"
+	"github.com/IlianBuh/filemanager-server/internal/lib/storage"
+	"github.com/IlianBuh/filemanager-server/internal/services/filemanager"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testClient returns client of filemanager which is served in process
+func testClient(t *testing.T) *Client {
+	t.Helper()
+
+	store, err := storage.New(storage.Policy{Default: storage.CompressionNone}, nil)
+	if err != nil {
+		t.Fatalf("storage.New() error = %v", err)
+	}
+	fm := filemanager.New(slog.New(slog.NewTextHandler(io.Discard, nil)), t.TempDir(), store, time.Minute)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	grpcfm.Register(srv, fm, nil, nil)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient(
+		"passthrough:///filemanager",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return NewFromConn(cc)
+}
+
+func writeFile(t *testing.T, c *Client, name string, content string) {
+	t.Helper()
+
+	w, err := c.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%q) error = %v", name, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write(%q) error = %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(%q) error = %v", name, err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	c := testClient(t)
+
+	files := map[string]string{
+		"a.txt":         "hello, world",
+		"empty.txt":     "",
+		"dir/b.txt":     "content of b",
+		"dir/sub/c.txt": "content of c",
+	}
+	for name, content := range files {
+		writeFile(t, c, name, content)
+	}
+	if err := c.MkdirAll("empty"); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+
+	if err := fstest.TestFS(c.FS(), "a.txt", "empty.txt", "dir/b.txt", "dir/sub/c.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	const content = "0123456789"
+	c := testClient(t)
+	writeFile(t, c, "file.txt", content)
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		pos    int64
+		want   string
+		err    error
+	}{
+		{name: "start", offset: 3, whence: io.SeekStart, pos: 3, want: "3456789"},
+		{name: "current", offset: 2, whence: io.SeekCurrent, pos: 4, want: "456789"},
+		{name: "end", offset: -2, whence: io.SeekEnd, pos: 8, want: "89"},
+		{name: "at end", offset: 0, whence: io.SeekEnd, pos: 10, want: ""},
+		{name: "past end", offset: 5, whence: io.SeekEnd, pos: 15, want: ""},
+		{name: "negative", offset: -1, whence: io.SeekStart, err: fs.ErrInvalid},
+		{name: "negative from end", offset: -11, whence: io.SeekEnd, err: fs.ErrInvalid},
+		{name: "bad whence", offset: 0, whence: 3, err: fs.ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := c.Open("file.txt")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer f.Close()
+
+			// read starts download, which is dropped by seek
+			if _, err := f.Read(make([]byte, 2)); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+
+			pos, err := f.Seek(tt.offset, tt.whence)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Seek() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if pos != tt.pos {
+				t.Errorf("Seek() = %d, want %d", pos, tt.pos)
+			}
+
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q after seek, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	const content = "0123456789"
+	c := testClient(t)
+	writeFile(t, c, "file.txt", content)
+
+	tests := []struct {
+		name string
+		off  int64
+		size int
+		want string
+		err  error
+	}{
+		{name: "start", off: 0, size: 4, want: "0123"},
+		{name: "middle", off: 3, size: 4, want: "3456"},
+		{name: "up to end", off: 6, size: 4, want: "6789"},
+		{name: "short at end", off: 8, size: 4, want: "89", err: io.EOF},
+		{name: "at end", off: 10, size: 4, err: io.EOF},
+		{name: "past end", off: 20, size: 4, err: io.EOF},
+		{name: "empty", off: 20, size: 0},
+		{name: "negative", off: -1, size: 4, err: fs.ErrInvalid},
+	}
+
+	f, err := c.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.size)
+			n, err := f.(io.ReaderAt).ReadAt(buf, tt.off)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ReadAt() error = %v, want %v", err, tt.err)
+			}
+			if string(buf[:n]) != tt.want {
+				t.Errorf("ReadAt() read %q, want %q", buf[:n], tt.want)
+			}
+		})
+	}
+
+	// ReadAt does not move offset of Read
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("read %q after ReadAt, want %q", got, content)
+	}
+}
+
+// TestChanged checks that file changed since it was opened is not read
+// from the new content, and that reopened file reads the new content
+func TestChanged(t *testing.T) {
+	c := testClient(t)
+	writeFile(t, c, "file.txt", "old content")
+
+	f, err := c.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	writeFile(t, c, "file.txt", "new and longer content")
+
+	if _, err := f.Read(make([]byte, 4)); !errors.Is(err, ErrChanged) {
+		t.Errorf("Read() error = %v, want %v", err, ErrChanged)
+	}
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	if _, err := f.Read(make([]byte, 4)); !errors.Is(err, ErrChanged) {
+		t.Errorf("Read() after Seek() error = %v, want %v", err, ErrChanged)
+	}
+	if _, err := f.(io.ReaderAt).ReadAt(make([]byte, 4), 0); !errors.Is(err, ErrChanged) {
+		t.Errorf("ReadAt() error = %v, want %v", err, ErrChanged)
+	}
+
+	reopened, err := c.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer reopened.Close()
+
+	got, err := io.ReadAll(reopened)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != "new and longer content" {
+		t.Errorf("reopened file read %q, want %q", got, "new and longer content")
+	}
+}
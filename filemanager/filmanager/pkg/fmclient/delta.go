@@ -0,0 +1,140 @@
+package fmclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxBatchOps is the largest number of ops sent in one message
+const maxBatchOps = 1024
+
+// Patch replaces content of file by content of r, sending only parts which
+// differ from the current content of the file. Content of r is read twice,
+// to compute its checksum and its delta. If the file does not exist or
+// it is changed while delta is computed, the whole content is uploaded
+func (c *Client) Patch(name string, r io.ReadSeeker) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "patch", Path: name, Err: fs.ErrInvalid}
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return &fs.PathError{Op: "patch", Path: name, Err: err}
+	}
+
+	err = c.patch(name, r, start)
+	if err == nil {
+		return nil
+	}
+	code := status.Code(err)
+	if code != codes.NotFound && code != codes.FailedPrecondition {
+		return pathError("patch", name, err)
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return &fs.PathError{Op: "patch", Path: name, Err: err}
+	}
+	return c.upload(name, r)
+}
+
+// patch sends delta of content of r at start against signature of the file
+func (c *Client) patch(name string, r io.ReadSeeker, start int64) error {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	sig, err := c.signature(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	stream, err := c.api.PatchFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := &filemanagerv1.PatchFileRequest{FileName: name, Size: size, Sha256: h.Sum(nil)}
+	data := 0
+	send := func() error {
+		err := stream.Send(req)
+		req = &filemanagerv1.PatchFileRequest{}
+		data = 0
+		return err
+	}
+
+	err = delta.Diff(sig, io.LimitReader(r, size), func(op delta.Op) error {
+		req.Ops = append(req.Ops, &filemanagerv1.DeltaOp{Offset: op.Offset, Length: op.Length, Data: op.Data})
+		data += len(op.Data)
+		if data >= chunkSize || len(req.Ops) >= maxBatchOps {
+			return send()
+		}
+		return nil
+	})
+	// the first message is sent even without ops, because it names the file
+	if err == nil && (len(req.Ops) > 0 || req.GetFileName() != "") {
+		err = send()
+	}
+	// failed send is reported by the stream when it is closed,
+	// other errors abort the call by cancel
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// signature receives signature of all blocks of the file
+func (c *Client) signature(ctx context.Context, name string) (delta.Signature, error) {
+	stream, err := c.api.Signature(ctx, &filemanagerv1.SignatureRequest{FileName: name})
+	if err != nil {
+		return delta.Signature{}, err
+	}
+
+	var sig delta.Signature
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return sig, nil
+		}
+		if err != nil {
+			return delta.Signature{}, err
+		}
+
+		sig.BlockSize = int(resp.GetBlockSize())
+		sig.Size = resp.GetSize()
+		for _, b := range resp.GetBlocks() {
+			sig.Blocks = append(sig.Blocks, delta.Block{Weak: b.GetWeak(), Strong: b.GetStrong()})
+		}
+	}
+}
+
+// upload sends the whole content of r
+func (c *Client) upload(name string, r io.Reader) error {
+	w, err := c.Create(name)
+	if err != nil {
+		return withOp("patch", err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return withOp("patch", err)
+	}
+
+	return withOp("patch", w.Close())
+}
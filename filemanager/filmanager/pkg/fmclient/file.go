@@ -0,0 +1,411 @@
+package fmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// file is a remote file opened for reading. Download is started at offset
+// of the file as it was described when opened, so reading file which is
+// changed since then fails with ErrChanged. It is not safe for concurrent use
+type file struct {
+	c      *Client
+	name   string
+	info   fs.FileInfo
+	offset int64
+
+	// stream is the download positioned at offset, it is
+	// started by the first read after opening or seeking
+	stream grpc.ServerStreamingClient[filemanagerv1.GetFileResponse]
+	cancel context.CancelFunc
+	buf    []byte
+	closed bool
+}
+
+func newFile(c *Client, name string, info fs.FileInfo) *file {
+	return &file{c: c, name: name, info: info}
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.stream == nil {
+		if err := f.open(); err != nil {
+			f.stop()
+			return 0, err
+		}
+	}
+
+	for len(f.buf) == 0 {
+		resp, err := f.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			f.stop()
+			return 0, io.EOF
+		}
+		if err != nil {
+			f.stop()
+			return 0, pathError("read", f.name, err)
+		}
+		f.buf = resp.GetChunk()
+	}
+
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	f.offset += int64(n)
+
+	return n, nil
+}
+
+// open starts download at offset
+func (f *file) open() error {
+	ctx, cancel := context.WithCancel(f.c.ctx)
+	f.cancel = cancel
+
+	stream, err := f.c.api.GetFile(ctx, &filemanagerv1.GetFileRequest{
+		FileName:  f.name,
+		Offset:    f.offset,
+		IfSize:    f.info.Size(),
+		IfModTime: f.info.ModTime().Unix(),
+	})
+	if err != nil {
+		return pathError("read", f.name, err)
+	}
+	f.stream = stream
+
+	return nil
+}
+
+// stop cancels download, so the next read starts it again
+func (f *file) stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.stream, f.cancel, f.buf = nil, nil, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	case io.SeekStart:
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset {
+		f.stop()
+		f.offset = offset
+	}
+
+	return offset, nil
+}
+
+// ReadAt reads file from off by a separate download, so offset
+// of Read is not changed. Short read at the end returns io.EOF
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	ctx, cancel := context.WithCancel(f.c.ctx)
+	defer cancel()
+
+	stream, err := f.c.api.GetFile(ctx, &filemanagerv1.GetFileRequest{
+		FileName:  f.name,
+		Offset:    off,
+		IfSize:    f.info.Size(),
+		IfModTime: f.info.ModTime().Unix(),
+	})
+	if err != nil {
+		return 0, pathError("readat", f.name, err)
+	}
+
+	n := 0
+	for n < len(p) {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return n, io.EOF
+		}
+		if err != nil {
+			return n, pathError("readat", f.name, err)
+		}
+		n += copy(p[n:], resp.GetChunk())
+	}
+
+	return n, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.stop()
+	f.closed = true
+
+	return nil
+}
+
+// dir is a remote directory opened by FS
+type dir struct {
+	c       *Client
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+// ReadDir returns next n entries of directory, or all remaining if n <= 0
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.listed {
+		entries, err := d.c.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+
+	return nil
+}
+
+// writer uploads file as it is written. Data is sent by chunks
+// and the upload is finished by Close
+type writer struct {
+	c      *Client
+	name   string
+	cancel context.CancelFunc
+	send   func(chunk []byte) error
+	finish func() error
+	buf    []byte
+	sent   bool
+	err    error
+}
+
+func newWriter(c *Client, name string, exists bool) (*writer, error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	w := &writer{c: c, name: name, cancel: cancel, buf: make([]byte, 0, chunkSize)}
+
+	// new files are posted, existing ones are replaced
+	if exists {
+		stream, err := c.api.PutFile(ctx)
+		if err != nil {
+			cancel()
+			return nil, pathError("create", name, err)
+		}
+		w.send = func(chunk []byte) error {
+			return stream.Send(&filemanagerv1.PutFileRequest{FileName: name, Chunk: chunk})
+		}
+		w.finish = func() error {
+			_, err := stream.CloseAndRecv()
+			return err
+		}
+	} else {
+		stream, err := c.api.PostFile(metadata.AppendToOutgoingContext(ctx, createParentsKey, "true"))
+		if err != nil {
+			cancel()
+			return nil, pathError("create", name, err)
+		}
+		w.send = func(chunk []byte) error {
+			return stream.Send(&filemanagerv1.PostFileRequest{FileName: name, Chunk: chunk})
+		}
+		w.finish = func() error {
+			_, err := stream.CloseAndRecv()
+			return err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for rest := p; len(rest) > 0; {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], rest)
+		w.buf = w.buf[:len(w.buf)+n]
+		rest = rest[n:]
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+// flush sends buffered data. Failed send is reported
+// by the stream when it is closed, so it is closed at once
+func (w *writer) flush() error {
+	if err := w.send(w.buf); err != nil {
+		w.fail(w.finish())
+		return w.err
+	}
+
+	w.buf = w.buf[:0]
+	w.sent = true
+
+	return nil
+}
+
+func (w *writer) fail(err error) {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	w.err = pathError("write", w.name, err)
+	w.cancel()
+}
+
+// Close finishes upload. Empty file is sent as one empty chunk,
+// because name of file is passed along with chunks
+func (w *writer) Close() error {
+	if w.err != nil {
+		if errors.Is(w.err, fs.ErrClosed) {
+			return w.err
+		}
+		err := w.err
+		w.err = &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+		return err
+	}
+
+	if len(w.buf) > 0 || !w.sent {
+		if err := w.flush(); err != nil {
+			w.err = &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+			return err
+		}
+	}
+
+	err := w.finish()
+	w.cancel()
+	w.err = &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+
+	return pathError("close", w.name, err)
+}
+
+// fileInfo describes file of filemanager
+type fileInfo struct {
+	name    string
+	size    int64
+	isDir   bool
+	modTime time.Time
+}
+
+func newFileInfo(f *filemanagerv1.FileInfo) fileInfo {
+	return fileInfo{
+		name:    path.Base(f.GetName()),
+		size:    f.GetSize(),
+		isDir:   f.GetIsDir(),
+		modTime: time.Unix(f.GetModTime(), 0),
+	}
+}
+
+func (i fileInfo) Name() string {
+	return i.name
+}
+
+func (i fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.isDir
+}
+
+func (i fileInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,60 @@
+package fmclient
+
+import (
+	"io"
+	"io/fs"
+)
+
+// FS is file system of filemanager. It implements fs.FS,
+// fs.StatFS, fs.ReadDirFS and fs.ReadFileFS
+type FS struct {
+	c *Client
+}
+
+var (
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// Open opens file or directory. Opened file implements io.Seeker and
+// io.ReaderAt, opened directory implements fs.ReadDirFile
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.c.Stat(name)
+	if err != nil {
+		return nil, withOp("open", err)
+	}
+	if info.IsDir() {
+		return &dir{c: f.c, name: name, info: info}, nil
+	}
+
+	return newFile(f.c, name, info), nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.c.Stat(name)
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.c.ReadDir(name)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// withOp sets operation of path error, so errors of
+// file system name the operation called by user
+func withOp(op string, err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		pe.Op = op
+	}
+
+	return err
+}
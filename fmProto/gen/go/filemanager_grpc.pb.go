@@ -0,0 +1,614 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: filemanager/v1/filemanager.proto
+
+package filemanagerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	FileManager_GetFile_FullMethodName     = "/filemanager.v1.FileManager/GetFile"
+	FileManager_PostFile_FullMethodName    = "/filemanager.v1.FileManager/PostFile"
+	FileManager_DeleteFile_FullMethodName  = "/filemanager.v1.FileManager/DeleteFile"
+	FileManager_PutFile_FullMethodName     = "/filemanager.v1.FileManager/PutFile"
+	FileManager_ListFiles_FullMethodName   = "/filemanager.v1.FileManager/ListFiles"
+	FileManager_ListChanges_FullMethodName = "/filemanager.v1.FileManager/ListChanges"
+	FileManager_Watch_FullMethodName       = "/filemanager.v1.FileManager/Watch"
+	FileManager_Stat_FullMethodName        = "/filemanager.v1.FileManager/Stat"
+	FileManager_Mkdir_FullMethodName       = "/filemanager.v1.FileManager/Mkdir"
+	FileManager_Move_FullMethodName        = "/filemanager.v1.FileManager/Move"
+	FileManager_Archive_FullMethodName     = "/filemanager.v1.FileManager/Archive"
+	FileManager_Extract_FullMethodName     = "/filemanager.v1.FileManager/Extract"
+	FileManager_Signature_FullMethodName   = "/filemanager.v1.FileManager/Signature"
+	FileManager_PatchFile_FullMethodName   = "/filemanager.v1.FileManager/PatchFile"
+)
+
+// FileManagerClient is the client API for FileManager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileManagerClient interface {
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetFileResponse], error)
+	PostFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PostFileRequest, PostFileResponse], error)
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
+	PutFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutFileRequest, PutFileResponse], error)
+	// v0.0.4
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	// v0.0.5
+	ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error)
+	// v0.0.6
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
+	// v0.0.7
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error)
+	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error)
+	Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error)
+	// v0.0.8
+	Archive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ArchiveEntry], error)
+	// v0.0.9
+	Extract(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ExtractRequest, ExtractResponse], error)
+	// v0.0.10
+	Signature(ctx context.Context, in *SignatureRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SignatureResponse], error)
+	PatchFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PatchFileRequest, PatchFileResponse], error)
+}
+
+type fileManagerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileManagerClient(cc grpc.ClientConnInterface) FileManagerClient {
+	return &fileManagerClient{cc}
+}
+
+func (c *fileManagerClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetFileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[0], FileManager_GetFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetFileRequest, GetFileResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_GetFileClient = grpc.ServerStreamingClient[GetFileResponse]
+
+func (c *fileManagerClient) PostFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PostFileRequest, PostFileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[1], FileManager_PostFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PostFileRequest, PostFileResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PostFileClient = grpc.ClientStreamingClient[PostFileRequest, PostFileResponse]
+
+func (c *fileManagerClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFileResponse)
+	err := c.cc.Invoke(ctx, FileManager_DeleteFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) PutFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutFileRequest, PutFileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[2], FileManager_PutFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PutFileRequest, PutFileResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PutFileClient = grpc.ClientStreamingClient[PutFileRequest, PutFileResponse]
+
+func (c *fileManagerClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFilesResponse)
+	err := c.cc.Invoke(ctx, FileManager_ListFiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListChangesResponse)
+	err := c.cc.Invoke(ctx, FileManager_ListChanges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[3], FileManager_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
+func (c *fileManagerClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatResponse)
+	err := c.cc.Invoke(ctx, FileManager_Stat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*MkdirResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MkdirResponse)
+	err := c.cc.Invoke(ctx, FileManager_Mkdir_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) Move(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MoveResponse)
+	err := c.cc.Invoke(ctx, FileManager_Move_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileManagerClient) Archive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ArchiveEntry], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[4], FileManager_Archive_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ArchiveRequest, ArchiveEntry]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_ArchiveClient = grpc.ServerStreamingClient[ArchiveEntry]
+
+func (c *fileManagerClient) Extract(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ExtractRequest, ExtractResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[5], FileManager_Extract_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExtractRequest, ExtractResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_ExtractClient = grpc.ClientStreamingClient[ExtractRequest, ExtractResponse]
+
+func (c *fileManagerClient) Signature(ctx context.Context, in *SignatureRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SignatureResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[6], FileManager_Signature_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SignatureRequest, SignatureResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_SignatureClient = grpc.ServerStreamingClient[SignatureResponse]
+
+func (c *fileManagerClient) PatchFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PatchFileRequest, PatchFileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileManager_ServiceDesc.Streams[7], FileManager_PatchFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PatchFileRequest, PatchFileResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PatchFileClient = grpc.ClientStreamingClient[PatchFileRequest, PatchFileResponse]
+
+// FileManagerServer is the server API for FileManager service.
+// All implementations must embed UnimplementedFileManagerServer
+// for forward compatibility.
+type FileManagerServer interface {
+	GetFile(*GetFileRequest, grpc.ServerStreamingServer[GetFileResponse]) error
+	PostFile(grpc.ClientStreamingServer[PostFileRequest, PostFileResponse]) error
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
+	PutFile(grpc.ClientStreamingServer[PutFileRequest, PutFileResponse]) error
+	// v0.0.4
+	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	// v0.0.5
+	ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error)
+	// v0.0.6
+	Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error
+	// v0.0.7
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
+	Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error)
+	Move(context.Context, *MoveRequest) (*MoveResponse, error)
+	// v0.0.8
+	Archive(*ArchiveRequest, grpc.ServerStreamingServer[ArchiveEntry]) error
+	// v0.0.9
+	Extract(grpc.ClientStreamingServer[ExtractRequest, ExtractResponse]) error
+	// v0.0.10
+	Signature(*SignatureRequest, grpc.ServerStreamingServer[SignatureResponse]) error
+	PatchFile(grpc.ClientStreamingServer[PatchFileRequest, PatchFileResponse]) error
+	mustEmbedUnimplementedFileManagerServer()
+}
+
+// UnimplementedFileManagerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFileManagerServer struct{}
+
+func (UnimplementedFileManagerServer) GetFile(*GetFileRequest, grpc.ServerStreamingServer[GetFileResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method GetFile not implemented")
+}
+func (UnimplementedFileManagerServer) PostFile(grpc.ClientStreamingServer[PostFileRequest, PostFileResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PostFile not implemented")
+}
+func (UnimplementedFileManagerServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedFileManagerServer) PutFile(grpc.ClientStreamingServer[PutFileRequest, PutFileResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PutFile not implemented")
+}
+func (UnimplementedFileManagerServer) ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
+func (UnimplementedFileManagerServer) ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChanges not implemented")
+}
+func (UnimplementedFileManagerServer) Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedFileManagerServer) Stat(context.Context, *StatRequest) (*StatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileManagerServer) Mkdir(context.Context, *MkdirRequest) (*MkdirResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedFileManagerServer) Move(context.Context, *MoveRequest) (*MoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Move not implemented")
+}
+func (UnimplementedFileManagerServer) Archive(*ArchiveRequest, grpc.ServerStreamingServer[ArchiveEntry]) error {
+	return status.Errorf(codes.Unimplemented, "method Archive not implemented")
+}
+func (UnimplementedFileManagerServer) Extract(grpc.ClientStreamingServer[ExtractRequest, ExtractResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Extract not implemented")
+}
+func (UnimplementedFileManagerServer) Signature(*SignatureRequest, grpc.ServerStreamingServer[SignatureResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Signature not implemented")
+}
+func (UnimplementedFileManagerServer) PatchFile(grpc.ClientStreamingServer[PatchFileRequest, PatchFileResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PatchFile not implemented")
+}
+func (UnimplementedFileManagerServer) mustEmbedUnimplementedFileManagerServer() {}
+func (UnimplementedFileManagerServer) testEmbeddedByValue()                     {}
+
+// UnsafeFileManagerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileManagerServer will
+// result in compilation errors.
+type UnsafeFileManagerServer interface {
+	mustEmbedUnimplementedFileManagerServer()
+}
+
+func RegisterFileManagerServer(s grpc.ServiceRegistrar, srv FileManagerServer) {
+	// If the following call pancis, it indicates UnimplementedFileManagerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&FileManager_ServiceDesc, srv)
+}
+
+func _FileManager_GetFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileManagerServer).GetFile(m, &grpc.GenericServerStream[GetFileRequest, GetFileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_GetFileServer = grpc.ServerStreamingServer[GetFileResponse]
+
+func _FileManager_PostFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileManagerServer).PostFile(&grpc.GenericServerStream[PostFileRequest, PostFileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PostFileServer = grpc.ClientStreamingServer[PostFileRequest, PostFileResponse]
+
+func _FileManager_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_PutFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileManagerServer).PutFile(&grpc.GenericServerStream[PutFileRequest, PutFileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PutFileServer = grpc.ClientStreamingServer[PutFileRequest, PutFileResponse]
+
+func _FileManager_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_ListFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).ListFiles(ctx, req.(*ListFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_ListChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).ListChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_ListChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).ListChanges(ctx, req.(*ListChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileManagerServer).Watch(m, &grpc.GenericServerStream[WatchRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
+func _FileManager_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_Mkdir_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).Mkdir(ctx, req.(*MkdirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_Move_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileManagerServer).Move(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileManager_Move_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileManagerServer).Move(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileManager_Archive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileManagerServer).Archive(m, &grpc.GenericServerStream[ArchiveRequest, ArchiveEntry]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_ArchiveServer = grpc.ServerStreamingServer[ArchiveEntry]
+
+func _FileManager_Extract_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileManagerServer).Extract(&grpc.GenericServerStream[ExtractRequest, ExtractResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_ExtractServer = grpc.ClientStreamingServer[ExtractRequest, ExtractResponse]
+
+func _FileManager_Signature_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SignatureRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileManagerServer).Signature(m, &grpc.GenericServerStream[SignatureRequest, SignatureResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_SignatureServer = grpc.ServerStreamingServer[SignatureResponse]
+
+func _FileManager_PatchFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileManagerServer).PatchFile(&grpc.GenericServerStream[PatchFileRequest, PatchFileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FileManager_PatchFileServer = grpc.ClientStreamingServer[PatchFileRequest, PatchFileResponse]
+
+// FileManager_ServiceDesc is the grpc.ServiceDesc for FileManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileManager_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filemanager.v1.FileManager",
+	HandlerType: (*FileManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DeleteFile",
+			Handler:    _FileManager_DeleteFile_Handler,
+		},
+		{
+			MethodName: "ListFiles",
+			Handler:    _FileManager_ListFiles_Handler,
+		},
+		{
+			MethodName: "ListChanges",
+			Handler:    _FileManager_ListChanges_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _FileManager_Stat_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _FileManager_Mkdir_Handler,
+		},
+		{
+			MethodName: "Move",
+			Handler:    _FileManager_Move_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetFile",
+			Handler:       _FileManager_GetFile_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PostFile",
+			Handler:       _FileManager_PostFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "PutFile",
+			Handler:       _FileManager_PutFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _FileManager_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Archive",
+			Handler:       _FileManager_Archive_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Extract",
+			Handler:       _FileManager_Extract_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Signature",
+			Handler:       _FileManager_Signature_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PatchFile",
+			Handler:       _FileManager_PatchFile_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "filemanager/v1/filemanager.proto",
+}
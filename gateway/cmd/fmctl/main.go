@@ -0,0 +1,34 @@
+// Command fmctl is command-line client of the file store. It talks to
+// filemanagers over grpc or to gateway over http.
+//
+// Endpoints and credentials are read from profiles of configuration file,
+// by default fmctl/config.yaml in user configuration directory:
+//
+//	default: prod
+//	profiles:
+//	  local:
+//	    targets: ["localhost:20201"]
+//	  prod:
+//	    transport: http
+//	    url: https://files.example.com
+//	    token: secret
+//
+// Run fmctl without arguments to see its commands.
+package main
+
+import (
+	"context"
+	"lab3/internal/fmctl"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	code := fmctl.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+
+	os.Exit(code)
+}
@@ -4,6 +4,7 @@ import (
 	"lab3/internal/app"
 	"lab3/internal/config"
 	"lab3/internal/lib/logger/handler/slogpretty"
+	"lab3/internal/lib/tracing"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -24,17 +25,37 @@ func main() {
 
 	log.Info("logger and config are initialized", slog.Any("config", cfg))
 
+	tracer := setUpTracing(cfg, log)
+
 	application := app.New(
 		log,
 		cfg.FmPort,
+		cfg.FileManager,
+		cfg.Shards,
 		cfg.HTTPSrv.Port,
 		cfg.HTTPSrv.Addr,
 		cfg.HTTPSrv.IdleTimeout,
 		cfg.HTTPSrv.Timeout,
 		cfg.RetriesCount,
+		cfg.StreamRetry,
+		cfg.Share,
+		cfg.Extract,
+		cfg.RateLimit,
+		cfg.Webhooks,
+		cfg.S3,
+		cfg.SFTP,
 	)
 
 	go application.HTTPApp.MustRun()
+	if application.S3App != nil {
+		go application.S3App.MustRun()
+	}
+	if application.SFTPApp != nil {
+		go application.SFTPApp.MustRun()
+	}
+	if application.Webhooks != nil {
+		go application.Webhooks.MustRun()
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -42,8 +63,18 @@ func main() {
 	sign := <-stop
 	log.Info("received signal", slog.String("signal", sign.String()))
 
-	application.GRPCClient.Stop()
+	if application.Webhooks != nil {
+		application.Webhooks.Stop()
+	}
+	if application.S3App != nil {
+		application.S3App.Stop()
+	}
+	if application.SFTPApp != nil {
+		application.SFTPApp.Stop()
+	}
+	application.Cluster.Stop()
 	application.HTTPApp.Stop()
+	tracer.Stop()
 }
 
 func setUpLogger(cfg *config.Config) *slog.Logger {
@@ -65,6 +96,17 @@ func setUpLogger(cfg *config.Config) *slog.Logger {
 	return log
 }
 
+func setUpTracing(cfg *config.Config, log *slog.Logger) *tracing.Provider {
+	return tracing.MustNew(log, tracing.Options{
+		ServiceName: "gateway",
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		FilePath:    cfg.Tracing.FilePath,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+}
+
 func setUpPrettyLogger() *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
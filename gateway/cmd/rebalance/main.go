@@ -0,0 +1,49 @@
+// Command rebalance moves files between filemanager shard nodes after
+// nodes are added to or removed from the ring.
+//
+// Set shards.previous-ring in gateway config to the ring before the change,
+// restart gateways and run rebalance. When it is finished, remove
+// previous-ring and nodes which are not in the ring anymore.
+package main
+
+import (
+	"context"
+	"flag"
+	"lab3/internal/app"
+	"lab3/internal/config"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report files which have to be moved")
+
+	cfg := config.New()
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cluster := app.NewCluster(
+		log,
+		cfg.FmPort,
+		cfg.FileManager,
+		cfg.Shards,
+		cfg.HTTPSrv.Timeout,
+		cfg.RetriesCount,
+		cfg.StreamRetry,
+	)
+	defer cluster.Stop()
+
+	moved, err := cluster.Rebalance(ctx, *dryRun)
+	if err != nil {
+		log.Error("rebalance is not finished", sl.Err(err), slog.Int("moved", moved))
+		os.Exit(1)
+	}
+
+	log.Info("rebalance is finished", slog.Int("moved", moved))
+}
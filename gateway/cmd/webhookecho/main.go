@@ -0,0 +1,69 @@
+// Command webhookecho is a local stand-in for webhook receivers. It verifies
+// signatures of deliveries and logs them, so subscriptions can be tested
+// without the real service.
+//
+// Respond with -status 500 to see deliveries retried by the gateway.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/webhook"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8090", "address to listen on")
+	secret := flag.String("secret", "", "secret of the subscription, signatures are not checked if empty")
+	code := flag.Int("status", http.StatusOK, "status code to respond with")
+	flag.Parse()
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error("failed to read delivery", sl.Err(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if *secret != "" && !webhook.Verify(
+			*secret,
+			r.Header.Get(webhook.HeaderTimestamp),
+			body,
+			r.Header.Get(webhook.HeaderSignature),
+		) {
+			log.Warn("invalid signature", slog.String("id", r.Header.Get(webhook.HeaderID)))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload webhook.Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			log.Error("invalid delivery", sl.Err(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.Info("delivery is received",
+			slog.String("id", payload.ID),
+			slog.String("subscription", payload.Subscription),
+			slog.String("op", payload.Event.Op),
+			slog.String("name", payload.Event.Name),
+			slog.Int("attempt", payload.Attempt),
+			slog.Int("status", *code),
+		)
+		w.WriteHeader(*code)
+	})
+
+	log.Info("listening", slog.String("addr", *addr))
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Error("server is stopped", sl.Err(err))
+		os.Exit(1)
+	}
+}
@@ -2,39 +2,291 @@ package app
 
 import (
 	httpapp "lab3/internal/app/http"
+	s3app "lab3/internal/app/s3"
+	sftpapp "lab3/internal/app/sftp"
 	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/clients/fm/shard"
+	"lab3/internal/config"
+	"lab3/internal/handlers/http_handlers"
+	"lab3/internal/handlers/s3_handlers"
+	"lab3/internal/lib/archive"
+	"lab3/internal/lib/http/ratelimit"
+	"lab3/internal/lib/s3"
+	"lab3/internal/lib/sftpd"
+	"lab3/internal/lib/share"
+	"lab3/internal/lib/webhook"
 	"log/slog"
 	"time"
 )
 
+const (
+	defaultNode = "default"
+)
+
 type App struct {
-	HTTPApp    *httpapp.App
-	GRPCClient *grpclient.Client
+	HTTPApp *httpapp.App
+	// S3App is nil if s3 api is disabled
+	S3App *s3app.App
+	// SFTPApp is nil if sftp server is disabled
+	SFTPApp *sftpapp.App
+	Cluster *shard.Cluster
+	// Webhooks is nil if webhooks are disabled
+	Webhooks *webhook.Dispatcher
 }
 
 func New(
 	log *slog.Logger,
 	fmPort string,
+	fmCfg config.FileManager,
+	shardCfg config.Shards,
 	port string,
 	addr string,
 	idleTimout time.Duration,
 	timeout time.Duration,
 	retriesCount int,
+	retryCfg config.StreamRetry,
+	shareCfg config.Share,
+	extractCfg config.Extract,
+	rateCfg config.RateLimit,
+	webhooksCfg config.Webhooks,
+	s3Cfg config.S3,
+	sftpCfg config.SFTP,
 ) *App {
 
-	client, err := grpclient.New(
+	cluster := NewCluster(
 		log,
 		fmPort,
+		fmCfg,
+		shardCfg,
 		timeout,
 		retriesCount,
+		retryCfg,
+	)
+
+	downloads := share.NewDownloads(log, cluster, shareCfg.Dir)
+	signer := share.New(shareCfg.Secret, downloads)
+	shareOpts := http_handlers.ShareOptions{
+		AdminToken: shareCfg.AdminToken,
+		BaseURL:    shareCfg.BaseURL,
+		DefaultTTL: shareCfg.DefaultTTL,
+		MaxTTL:     shareCfg.MaxTTL,
+	}
+
+	extractLimits := archive.Limits{
+		MaxEntries: extractCfg.MaxEntries,
+		MaxSize:    extractCfg.MaxSize,
+		MaxRatio:   extractCfg.MaxRatio,
+	}
+
+	limiter := ratelimit.New(log, ratelimit.Options{
+		RPS:             rateCfg.RPS,
+		Burst:           rateCfg.Burst,
+		ClientTransfers: rateCfg.ClientTransfers,
+		TotalTransfers:  rateCfg.TotalTransfers,
+		RetryAfter:      rateCfg.RetryAfter,
+		ClientTTL:       rateCfg.ClientTTL,
+	})
+
+	var (
+		dispatcher *webhook.Dispatcher
+		webhooks   http_handlers.Webhooks
+	)
+	if webhooksCfg.Enabled {
+		dispatcher = NewDispatcher(log, cluster, webhooksCfg)
+		webhooks = dispatcher
+	}
+
+	application := httpapp.New(
+		log,
+		port,
+		addr,
+		idleTimout,
+		timeout,
+		cluster,
+		signer,
+		shareOpts,
+		extractLimits,
+		limiter,
+		webhooks,
+		webhooksCfg.AdminToken,
 	)
+	var s3Application *s3app.App
+	if s3Cfg.Enabled {
+		s3Application = NewS3App(log, cluster, limiter, s3Cfg)
+	}
+	var sftpApplication *sftpapp.App
+	if sftpCfg.Enabled {
+		sftpApplication = NewSFTPApp(log, cluster, sftpCfg)
+	}
+
+	return &App{
+		HTTPApp:  application,
+		S3App:    s3Application,
+		SFTPApp:  sftpApplication,
+		Cluster:  cluster,
+		Webhooks: dispatcher,
+	}
+}
+
+// NewS3App creates s3 api application over the cluster
+func NewS3App(
+	log *slog.Logger,
+	cluster *shard.Cluster,
+	limiter *ratelimit.Limiter,
+	cfg config.S3,
+) *s3app.App {
+	keys := make(map[string]string, len(cfg.AccessKeys))
+	for _, key := range cfg.AccessKeys {
+		keys[key.ID] = key.Secret
+	}
+
+	uploads, err := s3.NewUploads(cfg.Dir, cfg.UploadTTL)
 	if err != nil {
 		panic(err)
 	}
 
-	application := httpapp.New(log, port, addr, idleTimout, timeout, client)
-	return &App{
-		HTTPApp:    application,
-		GRPCClient: client,
+	handler := s3_handlers.New(
+		log,
+		cluster,
+		s3.NewVerifier(cfg.Region, keys, cfg.MaxSkew),
+		uploads,
+		s3_handlers.Options{
+			Region:        cfg.Region,
+			MaxObjectSize: cfg.MaxObjectSize,
+		},
+	)
+
+	return s3app.New(log, cfg.Port, cfg.Addr, cfg.IdleTimeout, cfg.Timeout, handler, limiter)
+}
+
+// NewSFTPApp creates sftp server over the cluster
+func NewSFTPApp(
+	log *slog.Logger,
+	cluster *shard.Cluster,
+	cfg config.SFTP,
+) *sftpapp.App {
+	users := make([]sftpd.User, 0, len(cfg.Users))
+	for _, user := range cfg.Users {
+		users = append(users, sftpd.User{
+			Name:           user.Name,
+			PasswordHash:   user.PasswordHash,
+			AuthorizedKeys: user.AuthorizedKeys,
+			Root:           user.Root,
+		})
 	}
+
+	server, err := sftpd.New(log, cluster, sftpd.Options{
+		HostKey:     cfg.HostKey,
+		IdleTimeout: cfg.IdleTimeout,
+		TempDir:     cfg.TempDir,
+		Users:       users,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return sftpapp.New(log, cfg.Port, cfg.Addr, server)
+}
+
+// NewDispatcher creates webhook dispatcher reading changes of the cluster
+func NewDispatcher(
+	log *slog.Logger,
+	cluster *shard.Cluster,
+	cfg config.Webhooks,
+) *webhook.Dispatcher {
+	subs := make([]webhook.Subscription, 0, len(cfg.Subscriptions))
+	for _, sub := range cfg.Subscriptions {
+		subs = append(subs, webhook.Subscription{
+			ID:        sub.ID,
+			URL:       sub.URL,
+			Secret:    sub.Secret,
+			Path:      sub.Path,
+			Recursive: sub.Recursive,
+			Events:    sub.Events,
+		})
+	}
+
+	dispatcher, err := webhook.New(log, cluster, webhook.Options{
+		Dir:            cfg.Dir,
+		Subscriptions:  subs,
+		PollInterval:   cfg.PollInterval,
+		BatchSize:      cfg.BatchSize,
+		Workers:        cfg.Workers,
+		Timeout:        cfg.Timeout,
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		HistoryLimit:   cfg.HistoryLimit,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return dispatcher
+}
+
+// NewCluster connects to every shard node. If no nodes are configured,
+// cluster of one node with filemanager targets is created
+func NewCluster(
+	log *slog.Logger,
+	fmPort string,
+	fmCfg config.FileManager,
+	shardCfg config.Shards,
+	timeout time.Duration,
+	retriesCount int,
+	retryCfg config.StreamRetry,
+) *shard.Cluster {
+	retryOpts := grpclient.RetryOptions{
+		InitialBackoff: retryCfg.InitialBackoff,
+		MaxBackoff:     retryCfg.MaxBackoff,
+		Multiplier:     retryCfg.Multiplier,
+		Jitter:         retryCfg.Jitter,
+		Download: grpclient.Budget{
+			MaxAttempts: retryCfg.Download.MaxAttempts,
+			Timeout:     retryCfg.Download.Timeout,
+		},
+		Upload: grpclient.Budget{
+			MaxAttempts: retryCfg.Upload.MaxAttempts,
+			Timeout:     retryCfg.Upload.Timeout,
+		},
+		UploadBuffer: retryCfg.UploadBuffer,
+	}
+
+	nodes := shardCfg.Nodes
+	if len(nodes) == 0 {
+		targets := fmCfg.Targets
+		if len(targets) == 0 {
+			targets = []string{"localhost:" + fmPort}
+		}
+
+		nodes = []config.ShardNode{{Name: defaultNode, Targets: targets}}
+	}
+
+	clients := make(map[string]*grpclient.Client, len(nodes))
+	for _, node := range nodes {
+		client, err := grpclient.New(
+			log.With(slog.String("node", node.Name)),
+			node.Targets,
+			fmCfg.Balancer,
+			timeout,
+			retriesCount,
+			retryOpts,
+		)
+		if err != nil {
+			panic(err)
+		}
+
+		clients[node.Name] = client
+	}
+
+	cluster, err := shard.New(log, clients, shard.Options{
+		VNodes:       shardCfg.VNodes,
+		Ring:         shardCfg.Ring,
+		PreviousRing: shardCfg.PreviousRing,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return cluster
 }
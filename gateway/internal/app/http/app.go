@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"github.com/go-chi/chi"
 	"lab3/internal/app/http/router"
-	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/handlers/http_handlers"
+	"lab3/internal/lib/archive"
+	"lab3/internal/lib/http/ratelimit"
 	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/share"
 	"log/slog"
 	"net"
 	"net/http"
@@ -26,9 +29,15 @@ func New(
 	addr string,
 	idleTimout time.Duration,
 	timeout time.Duration,
-	client *grpclient.Client,
+	client http_handlers.FileManager,
+	signer *share.Signer,
+	shareOpts http_handlers.ShareOptions,
+	extractLimits archive.Limits,
+	limiter *ratelimit.Limiter,
+	webhooks http_handlers.Webhooks,
+	adminToken string,
 ) *App {
-	r := router.NewRouter(log, client)
+	r := router.NewRouter(log, client, signer, shareOpts, extractLimits, limiter, webhooks, adminToken)
 
 	httpSrv := &http.Server{
 		Addr:         getAddr(addr, port),
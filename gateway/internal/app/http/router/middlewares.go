@@ -1,28 +1,39 @@
 package router
 
 import (
+	"crypto/subtle"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"lab3/internal/handlers/http_handlers"
+	httperrors "lab3/internal/lib/http/errors"
 	middlewareLogger "lab3/internal/lib/logger/middleware"
+	"lab3/internal/lib/metrics"
+	"lab3/internal/lib/tracing"
 	"log/slog"
 	"net/http"
+	"strings"
 )
 
 func bindMiddlewares(r *chi.Mux, log *slog.Logger) {
 
+	r.Use(tracing.HTTPMiddleware)
 	r.Use(middleware.RequestID)
 	r.Use(middlewareLogger.New(log))
 	r.Use(cors)
 	r.Use(middleware.Recoverer)
+	r.Use(metrics.HTTPMiddleware)
 
 }
 
+// cors answers preflight requests. Webdav clients use OPTIONS to discover
+// webdav support, so OPTIONS without preflight headers reaches webdav handler
 func cors(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST,GET,DELETE,PUT")
+		preflight := r.Header.Get("Access-Control-Request-Method") != ""
+		if r.Method == "OPTIONS" && (preflight || !isDAV(r)) {
+			w.Header().Set("Access-Control-Allow-Methods", "POST,GET,DELETE,PUT,"+strings.Join(http_handlers.DAVMethods, ","))
 			w.Header().Set("Access-Control-Allow-Headers", "*")
 			w.WriteHeader(http.StatusOK)
 			return
@@ -32,3 +43,25 @@ func cors(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
+
+func isDAV(r *http.Request) bool {
+	return r.URL.Path == http_handlers.DAVPrefix || strings.HasPrefix(r.URL.Path, http_handlers.DAVPrefix+"/")
+}
+
+// adminAuth allows requests with bearer token equal to token
+func adminAuth(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				httperrors.Error(w, http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -2,21 +2,82 @@ package router
 
 import (
 	"github.com/go-chi/chi"
-	grpclient "lab3/internal/clients/fm/grpc"
 	"lab3/internal/handlers/http_handlers"
+	"lab3/internal/lib/archive"
+	"lab3/internal/lib/http/ratelimit"
+	"lab3/internal/lib/metrics"
+	"lab3/internal/lib/share"
 	"log/slog"
+	"net/http"
 )
 
-func NewRouter(log *slog.Logger, client *grpclient.Client) *chi.Mux {
+func NewRouter(
+	log *slog.Logger,
+	client http_handlers.FileManager,
+	signer *share.Signer,
+	shareOpts http_handlers.ShareOptions,
+	extractLimits archive.Limits,
+	limiter *ratelimit.Limiter,
+	webhooks http_handlers.Webhooks,
+	adminToken string,
+) *chi.Mux {
+	for _, method := range http_handlers.DAVMethods {
+		chi.RegisterMethod(method)
+	}
+
 	r := chi.NewRouter()
 
 	bindMiddlewares(r, log)
 
-	r.Route("/filemanager", func(c chi.Router) {
-		c.Post("/", http_handlers.NewPost(log, client))
-		c.Get("/", http_handlers.NewGet(log, client))
-		c.Delete("/", http_handlers.NewDelete(log, client))
-		c.Put("/", http_handlers.NewPut(log, client))
+	// probes and metrics are not limited, so they are not
+	// throttled by clients sharing address with the prober
+	r.Handle("/metrics", metrics.Handler())
+	r.Get("/healthz", http_handlers.NewHealthz())
+	r.Get("/readyz", http_handlers.NewReadyz(log, client))
+
+	r.Group(func(r chi.Router) {
+		r.Use(limiter.Requests)
+
+		r.Route("/filemanager", func(c chi.Router) {
+			c.With(limiter.Transfers).Post("/", http_handlers.NewPost(log, client, extractLimits))
+			c.With(limiter.Transfers).Get("/", http_handlers.NewGet(log, client))
+			c.Delete("/", http_handlers.NewDelete(log, client))
+			c.With(limiter.Transfers).Put("/", http_handlers.NewPut(log, client))
+			c.Get("/list", http_handlers.NewList(log, client))
+			c.Get("/changes", http_handlers.NewChanges(log, client))
+			c.Get("/watch", http_handlers.NewWatch(log, client))
+			c.Get("/watch/ws", http_handlers.NewWatchWS(log, client))
+		})
+
+		dav := http_handlers.NewDAV(log, client)
+		r.Route(http_handlers.DAVPrefix, func(c chi.Router) {
+			for _, pattern := range []string{"/", "/*"} {
+				c.Handle(pattern, dav)
+				c.With(limiter.Transfers).Method(http.MethodGet, pattern, dav)
+				c.With(limiter.Transfers).Method(http.MethodPut, pattern, dav)
+				c.With(limiter.Transfers).Method("COPY", pattern, dav)
+			}
+		})
+
+		r.Route("/share", func(c chi.Router) {
+			// links give access to any file, so only admin mints them
+			if shareOpts.AdminToken != "" {
+				c.With(adminAuth(shareOpts.AdminToken)).Post("/", http_handlers.NewShare(log, signer, shareOpts))
+			}
+			c.With(limiter.Transfers).Get("/{token}", http_handlers.NewShareGet(log, client, signer))
+			c.With(limiter.Transfers).Post("/{token}", http_handlers.NewShareUpload(log, client, signer))
+			c.With(limiter.Transfers).Put("/{token}", http_handlers.NewShareUpload(log, client, signer))
+		})
+
+		if webhooks != nil && adminToken != "" {
+			r.Route("/admin/webhooks", func(c chi.Router) {
+				c.Use(adminAuth(adminToken))
+				c.Get("/", http_handlers.NewWebhookList(log, webhooks))
+				c.Post("/", http_handlers.NewWebhookCreate(log, webhooks))
+				c.Delete("/{id}", http_handlers.NewWebhookDelete(log, webhooks))
+				c.Get("/deliveries", http_handlers.NewWebhookDeliveries(log, webhooks))
+			})
+		}
 	})
 
 	return r
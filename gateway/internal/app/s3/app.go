@@ -0,0 +1,102 @@
+package s3app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"lab3/internal/handlers/s3_handlers"
+	"lab3/internal/lib/http/ratelimit"
+	middlewareLogger "lab3/internal/lib/logger/middleware"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/metrics"
+	"lab3/internal/lib/tracing"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// App serves S3-compatible API on its own address,
+// because S3 clients address buckets from the root path
+type App struct {
+	log     *slog.Logger
+	httpSrv *http.Server
+}
+
+func New(
+	log *slog.Logger,
+	port string,
+	addr string,
+	idleTimeout time.Duration,
+	timeout time.Duration,
+	handler *s3_handlers.Handler,
+	limiter *ratelimit.Limiter,
+) *App {
+	r := chi.NewRouter()
+
+	r.Use(tracing.HTTPMiddleware)
+	r.Use(middleware.RequestID)
+	r.Use(middlewareLogger.New(log))
+	r.Use(middleware.Recoverer)
+	r.Use(metrics.HTTPMiddleware)
+	r.Use(handler.Authenticate)
+	r.Use(limiter.Requests)
+
+	r.Handle("/*", handler)
+	r.With(limiter.Transfers).Get("/*", handler.ServeHTTP)
+	r.With(limiter.Transfers).Put("/*", handler.ServeHTTP)
+
+	return &App{
+		log: log,
+		httpSrv: &http.Server{
+			Addr:         net.JoinHostPort(addr, port),
+			Handler:      r,
+			IdleTimeout:  idleTimeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		},
+	}
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run runs s3 application
+func (a *App) Run() error {
+	const op = "s3app.Run"
+	log := a.log.With(slog.String("op", op))
+	log.Info("starting s3 application", slog.String("addr", a.httpSrv.Addr))
+
+	if err := a.httpSrv.ListenAndServe(); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		log.Error("s3 server stopped with error", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops s3 application with graceful shutdown
+func (a *App) Stop() {
+	const op = "s3app.Stop"
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping s3 application")
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.httpSrv.IdleTimeout)
+	defer cancel()
+
+	if err := a.httpSrv.Shutdown(ctx); err != nil {
+		log.Error("failed to stop s3 application", sl.Err(err))
+	}
+
+	log.Info("stopped s3 application")
+}
@@ -0,0 +1,70 @@
+package sftpapp
+
+import (
+	"fmt"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/sftpd"
+	"log/slog"
+	"net"
+)
+
+// App serves files over sftp on its own address
+type App struct {
+	log    *slog.Logger
+	addr   string
+	server *sftpd.Server
+}
+
+func New(
+	log *slog.Logger,
+	port string,
+	addr string,
+	server *sftpd.Server,
+) *App {
+	return &App{
+		log:    log,
+		addr:   net.JoinHostPort(addr, port),
+		server: server,
+	}
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run runs sftp application
+func (a *App) Run() error {
+	const op = "sftpapp.Run"
+	log := a.log.With(slog.String("op", op))
+
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("starting sftp application", slog.String("addr", l.Addr().String()))
+
+	if err := a.server.Serve(l); err != nil {
+		log.Error("sftp server stopped with error", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops sftp application. Active sessions are closed
+func (a *App) Stop() {
+	const op = "sftpapp.Stop"
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping sftp application")
+
+	if err := a.server.Close(); err != nil {
+		log.Error("failed to stop sftp application", sl.Err(err))
+	}
+
+	log.Info("stopped sftp application")
+}
@@ -0,0 +1,162 @@
+// Package affinity provides grpc load balancer which spreads reads over
+// every healthy filemanager and routes writes of the same path to one
+// filemanager chosen by rendezvous hashing over all resolved addresses.
+//
+// Balanced filemanagers must replicate changes to each other, otherwise
+// a file is stored only by its owner and reads of other filemanagers do
+// not find it. Reads which are not found are repeated on the owner by
+// the client, but listings of directories are not complete anyway.
+package affinity
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Name is a name of the balancer to be used in service config
+const Name = "fm_affinity"
+
+type keyCtx struct{}
+
+// WithKey marks call made with ctx as write of the path key, or as read
+// which has to see the last write of it. Such calls are sent only to
+// the owner of the key
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+func keyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyCtx{}).(string)
+	return key, ok
+}
+
+func init() {
+	balancer.Register(builder{})
+}
+
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{}
+
+	return &affinityBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// affinityBalancer is a base balancer which shares resolved addresses with
+// its picker builder, because only ready addresses are passed to pickers
+type affinityBalancer struct {
+	balancer.Balancer
+	pb *pickerBuilder
+}
+
+func (b *affinityBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := make([]string, 0, len(s.ResolverState.Addresses))
+	for _, a := range s.ResolverState.Addresses {
+		addrs = append(addrs, a.Addr)
+	}
+	b.pb.setAddrs(addrs)
+
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type pickerBuilder struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (pb *pickerBuilder) setAddrs(addrs []string) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	pb.addrs = addrs
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.mu.Lock()
+	addrs := pb.addrs
+	pb.mu.Unlock()
+
+	p := &picker{
+		ready: make(map[string]balancer.SubConn, len(info.ReadySCs)),
+		addrs: addrs,
+	}
+	for sc, sci := range info.ReadySCs {
+		p.ready[sci.Address.Addr] = sc
+		p.scs = append(p.scs, sc)
+	}
+	if len(p.addrs) == 0 {
+		for addr := range p.ready {
+			p.addrs = append(p.addrs, addr)
+		}
+	}
+
+	return p
+}
+
+type picker struct {
+	// ready maps address to its ready subchannel
+	ready map[string]balancer.SubConn
+	scs   []balancer.SubConn
+	// addrs are all resolved addresses including not ready ones
+	addrs []string
+	next  atomic.Uint32
+}
+
+// Pick sends writes to the owner of the path and reads to the next
+// ready subchannel in round robin order
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key, ok := keyFromContext(info.Ctx)
+	if !ok {
+		n := p.next.Add(1)
+		return balancer.PickResult{SubConn: p.scs[int(n)%len(p.scs)]}, nil
+	}
+
+	owner := Owner(key, p.addrs)
+	sc, ok := p.ready[owner]
+	if !ok {
+		return balancer.PickResult{}, status.Errorf(
+			codes.Unavailable,
+			"filemanager %s owning the path is not available", owner,
+		)
+	}
+
+	return balancer.PickResult{SubConn: sc}, nil
+}
+
+// Owner returns address with the highest rendezvous hash weight for key
+func Owner(key string, addrs []string) string {
+	var (
+		owner string
+		best  uint64
+	)
+	for _, addr := range addrs {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(addr))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+
+		if w := h.Sum64(); owner == "" || w > best {
+			owner, best = addr, w
+		}
+	}
+
+	return owner
+}
@@ -1,12 +1,17 @@
 package grpclient
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"lab3/internal/clients/fm/affinity"
 	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/metrics"
 	"log/slog"
 	"path/filepath"
 	"time"
@@ -14,9 +19,13 @@ import (
 	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -26,9 +35,11 @@ const (
 
 type Client struct {
 	api     filemanagerv1.FileManagerClient
+	health  healthpb.HealthClient
 	log     *slog.Logger
 	cnct    *grpc.ClientConn
 	timeout time.Duration
+	retry   RetryOptions
 }
 type DataProvider interface {
 	Read([]byte) (int, error)
@@ -39,11 +50,87 @@ type DataHeader interface {
 	Name() string
 }
 
+// FileInfo describes file or directory stored by filemanager.
+// Name is a path relative to the filemanager root
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Change is a journaled change of file. Seq is its position
+// in the journal of the filemanager which made it.
+// From is the previous path of moved file
+type Change struct {
+	Seq  uint64    `json:"-"`
+	Op   string    `json:"op"`
+	Name string    `json:"name"`
+	From string    `json:"from,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Event is a change of file reported by filemanager watch.
+// Source is "api" for changes made through filemanager
+// and "fs" for changes made in its root directly
+type Event struct {
+	Op     string    `json:"op"`
+	Name   string    `json:"name"`
+	From   string    `json:"from,omitempty"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+// ArchiveEntry is a file or directory of archived directory.
+// Name is a path relative to the archived directory
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+}
+
+// ExtractEntry is a file or directory of archive extracted into filemanager.
+// Name is a path relative to the filemanager root
+type ExtractEntry struct {
+	Name      string
+	Size      int64
+	IsDir     bool
+	ModTime   time.Time
+	Overwrite bool
+}
+
+// Statuses of extracted entries
+const (
+	ExtractCreated  = "created"
+	ExtractReplaced = "replaced"
+	ExtractSkipped  = "skipped"
+	ExtractFailed   = "failed"
+)
+
+// ExtractResult reports what is done with entry of extracted archive
+type ExtractResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// journalKey pins listing of changes to one filemanager,
+// because every filemanager numbers changes of its own journal
+const journalKey = "journal"
+
+// New creates client of filemanagers listed in targets.
+// Single target may be any grpc target, e.g. "dns:///filemanager:20201"
+// resolved to many addresses. Several targets must be host:port addresses.
+// Calls are balanced between healthy filemanagers by balancer
+// which is either "fm_affinity", "round_robin" or "pick_first"
 func New(
 	log *slog.Logger,
-	addr string,
+	targets []string,
+	balancerName string,
 	timeout time.Duration,
 	retriesCount int,
+	streamRetry RetryOptions,
 ) (*Client, error) {
 	const op = "grpclient.New"
 	log.Info("creating grpc client", slog.String("op", op))
@@ -58,14 +145,28 @@ func New(
 		logging.WithLogOnEvents(logging.PayloadReceived, logging.PayloadSent),
 	}
 
-	cc, err := grpc.NewClient(
-		"localhost:"+addr,
+	target, resolverOpts, err := resolveTargets(targets)
+	if err != nil {
+		log.Error("invalid filemanager targets", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dialOpts := append(
+		resolverOpts,
+		grpc.WithDefaultServiceConfig(serviceConfig(balancerName)),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		grpc.WithChainUnaryInterceptor(
 			logging.UnaryClientInterceptor(InterceptorLogger(log), logOpts...),
 			retry.UnaryClientInterceptor(retryOpts...),
+			metrics.UnaryClientInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			metrics.StreamClientInterceptor(),
 		),
 	)
+
+	cc, err := grpc.NewClient(target, dialOpts...)
 	if err != nil {
 		log.Error("failed to connect to grpc server", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
@@ -76,8 +177,10 @@ func New(
 	return &Client{
 		log:     log,
 		api:     api,
+		health:  healthpb.NewHealthClient(cc),
 		cnct:    cc,
 		timeout: timeout,
+		retry:   streamRetry,
 	}, nil
 }
 
@@ -89,52 +192,48 @@ func InterceptorLogger(l *slog.Logger) logging.Logger {
 	})
 }
 
+// GetFile downloads file. If stream is broken by transient error, download
+// is resumed from the last received byte of the same version of the file.
+// Error with code FailedPrecondition means that the file is changed
+// while it is downloaded. File which is not found is read from its owner
 func (c *Client) GetFile(ctx context.Context, filename string) ([]byte, error) {
 	const op = "grpclient.GetFile"
 	log := c.log.With(slog.String("op", op))
 	log.Info("starting getting file from grpc server")
 
-	if err := ctx.Err(); err != nil {
-		log.Error("context return error", sl.Err(ctx.Err()))
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	var res []byte
-	stream, err := c.api.GetFile(
-		ctx,
-		&filemanagerv1.GetFileRequest{FileName: filename},
-	)
+	r, err := c.OpenFile(ctx, filename, 0)
 	if err != nil {
-		log.Error("failed to get file from grpc server", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	defer r.Close()
 
-	for {
-		recv, err := stream.Recv()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-
-			log.Error("failed to get file chunk from grpc server", sl.Err(err))
-			return nil, fmt.Errorf("%s: %w", op, err)
-		}
-
-		res = append(res, recv.GetChunk()...)
+	res := make([]byte, 0, r.Info().Size)
+	buf := bytes.NewBuffer(res)
+	if _, err := buf.ReadFrom(r); err != nil {
+		log.Error("failed to get file from grpc server", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	log.Info("finished getting file from grpc server",
-		slog.Int("size", len(res)),
+		slog.Int("size", buf.Len()),
 	)
-	return res, nil
+	return buf.Bytes(), nil
+}
+
+// ownerContext routes reads of name to the filemanager which owns it by
+// affinity balancer. Reads are spread over all filemanagers, so name which
+// is created recently may be not replicated from its owner yet
+func ownerContext(ctx context.Context, name string) context.Context {
+	name, _ = filepath.Localize(name)
+	return affinity.WithKey(ctx, name)
 }
 
 func (c *Client) DeleteFile(ctx context.Context, filename string) (err error) {
 	const op = "grpclient.DeleteFile"
-	log := c.log.With(slog.With("op", op))
+	log := c.log.With(slog.String("op", op))
 	log.Info(
 		"starting to delete file",
 		slog.String("file name", filename),
@@ -149,6 +248,7 @@ func (c *Client) DeleteFile(ctx context.Context, filename string) (err error) {
 	defer cancel()
 
 	filename, _ = filepath.Localize(filename)
+	ctx = affinity.WithKey(ctx, filename)
 	_, err = c.api.DeleteFile(
 		ctx,
 		&filemanagerv1.DeleteFileRequest{FileName: filename},
@@ -161,8 +261,9 @@ func (c *Client) DeleteFile(ctx context.Context, filename string) (err error) {
 	return nil
 }
 
+// PostFile uploads new file. If stream is broken by transient error,
+// upload is replayed from the beginning
 func (c *Client) PostFile(ctx context.Context, data DataProvider, header DataHeader, filename string) (err error) {
-
 	const op = "grpclient.PostFile"
 	log := c.log.With(slog.String("op", op))
 	log.Info("uploading file",
@@ -170,19 +271,52 @@ func (c *Client) PostFile(ctx context.Context, data DataProvider, header DataHea
 		slog.Int64("size", header.Size()),
 	)
 
-	if err = ctx.Err(); err != nil {
-		log.Error("context return error", sl.Err(ctx.Err()))
+	if err = c.upload(ctx, log, data, header, filename, c.postFile); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, c.timeout)
-	defer cancel()
+	log.Info("successfully sent file")
+	return nil
+}
 
-	stream, err := c.api.PostFile(ctx)
-	if err != nil {
-		log.Error("failed to get stream from api", sl.Err(err))
+// PutFile replaces existing file. If stream is broken by transient error,
+// upload is replayed from the beginning
+func (c *Client) PutFile(ctx context.Context, data DataProvider, header DataHeader, filename string) (err error) {
+	const op = "grpclient.PutFile"
+	log := c.log.With(slog.String("op", op))
+	log.Info("starting to send file")
+
+	if err = c.upload(ctx, log, data, header, filename, c.putFile); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
+
+	log.Info("successfully sent file")
+	return nil
+}
+
+// uploadFunc sends size bytes of data as file with name filename in one stream
+type uploadFunc func(
+	ctx context.Context,
+	log *slog.Logger,
+	data io.Reader,
+	size int64,
+	filename string,
+) error
+
+// upload calls send until it succeeds or retry budget of uploads is exhausted
+func (c *Client) upload(
+	ctx context.Context,
+	log *slog.Logger,
+	data DataProvider,
+	header DataHeader,
+	filename string,
+	send uploadFunc,
+) error {
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return err
+	}
+
 	defer func() {
 		if err := data.Close(); err != nil {
 			log.Error("failed to close data provider", sl.Err(err))
@@ -191,52 +325,297 @@ func (c *Client) PostFile(ctx context.Context, data DataProvider, header DataHea
 
 	filename, _ = filepath.Localize(filename)
 	if !fs.ValidPath(filename) {
-		log.Warn("Invalid file path after join", slog.String("file path", filename))
+		log.Warn("Invalid file path", slog.String("file path", filename))
 		return status.Error(codes.InvalidArgument, "invalid file name")
 	}
 
-	size := header.Size()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	ctx = affinity.WithKey(ctx, filename)
+
+	id, err := uploadID()
+	if err != nil {
+		log.Error("failed to generate upload id", sl.Err(err))
+		return err
+	}
+	// filemanager accepts replayed upload of the file which is already
+	// created by the previous attempt with the same id
+	ctx = metadata.AppendToOutgoingContext(ctx, uploadIDKey, id)
+
+	replay := newReplayReader(data, c.retry.UploadBuffer)
+	retrier := c.retry.newRetrier(c.retry.Upload)
+	for {
+		err := send(retrier.context(ctx), log, replay, header.Size(), filename)
+		if err == nil {
+			return nil
+		}
+		if !retrier.next(ctx, err) {
+			return err
+		}
+		if !replay.rewind() {
+			log.Warn("upload cannot be replayed", sl.Err(err))
+			return err
+		}
+
+		log.Warn("replaying upload",
+			sl.Err(err),
+			slog.Int("attempt", retrier.attempt),
+		)
+	}
+}
+
+// uploadIDKey is metadata key of id which is sent with every attempt of upload
+const uploadIDKey = "x-fm-upload-id"
+
+// createParentsKey is metadata key of upload
+// which creates missing parent directories
+const createParentsKey = "x-fm-create-parents"
+
+// WithParents returns ctx of PostFile which creates missing parent
+// directories of the file. Without it filemanager refuses the file
+func WithParents(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, createParentsKey, "true")
+}
+
+// uploadID returns random id of upload
+func uploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func (c *Client) postFile(
+	ctx context.Context,
+	log *slog.Logger,
+	data io.Reader,
+	size int64,
+	filename string,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.api.PostFile(ctx)
+	if err != nil {
+		log.Error("failed to get stream from api", sl.Err(err))
+		return err
+	}
+
 	sent := int64(0)
 	read := 0
 	chunk := make([]byte, bufsize)
 
+	// empty file is sent as one empty chunk,
+	// because name of file is passed along with chunks
+	if size == 0 {
+		err = stream.Send(&filemanagerv1.PostFileRequest{FileName: filename})
+		if err != nil {
+			log.Warn("failed to send chunk", sl.Err(err))
+		}
+	}
+
 	for sent < size {
 		read, err = data.Read(chunk)
-		if err != nil {
+		if read > 0 {
+			sendErr := stream.Send(
+				&filemanagerv1.PostFileRequest{
+					FileName: filename,
+					Chunk:    chunk[:read],
+				},
+			)
+			if sendErr != nil {
+				log.Warn("failed to send chunk", sl.Err(sendErr))
+				break
+			}
+
+			sent += int64(read)
+		}
+		if errors.Is(err, io.EOF) && sent < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
 			log.Error("failed to read file", sl.Err(err))
-			_, _ = stream.CloseAndRecv()
-			return fmt.Errorf("%s: %w", op, err)
+			// cancelling the stream makes filemanager drop partial file
+			cancel()
+			return err
 		}
+	}
 
-		err = stream.Send(
-			&filemanagerv1.PostFileRequest{
-				FileName: filename,
-				Chunk:    chunk[:read],
-			},
-		)
+	if _, err = stream.CloseAndRecv(); err != nil {
+		log.Error("failed to close api stream", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) putFile(
+	ctx context.Context,
+	log *slog.Logger,
+	data io.Reader,
+	size int64,
+	filename string,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.api.PutFile(ctx)
+	if err != nil {
+		log.Error("failed to get stream from api", sl.Err(err))
+		return err
+	}
+
+	sent := int64(0)
+	read := 0
+	chunk := make([]byte, bufsize)
+
+	// empty file is sent as one empty chunk,
+	// because name of file is passed along with chunks
+	if size == 0 {
+		err = stream.Send(&filemanagerv1.PutFileRequest{FileName: filename})
 		if err != nil {
 			log.Warn("failed to send chunk", sl.Err(err))
-			break
 		}
+	}
 
-		sent += int64(read)
+	for sent < size {
+		read, err = data.Read(chunk)
+		if read > 0 {
+			sendErr := stream.Send(
+				&filemanagerv1.PutFileRequest{
+					FileName: filename,
+					Chunk:    chunk[:read],
+				},
+			)
+			if sendErr != nil {
+				log.Warn("failed to send chunk", sl.Err(sendErr))
+				break
+			}
+
+			sent += int64(read)
+		}
+		if errors.Is(err, io.EOF) && sent < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Error("failed to read file", sl.Err(err))
+			// cancelling the stream makes filemanager keep old file
+			cancel()
+			return err
+		}
 	}
 
 	if _, err = stream.CloseAndRecv(); err != nil {
 		log.Error("failed to close api stream", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListFiles returns entries of the directory dir.
+// If recursive is true, entries of all subdirectories are returned too
+func (c *Client) ListFiles(ctx context.Context, dir string, recursive bool) ([]FileInfo, error) {
+	const op = "grpclient.ListFiles"
+	log := c.log.With(slog.String("op", op))
+	log.Info("listing directory", slog.String("dir", dir))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	dir, _ = filepath.Localize(dir)
+	req := &filemanagerv1.ListFilesRequest{Path: dir, Recursive: recursive}
+	resp, err := c.api.ListFiles(ctx, req)
+	if status.Code(err) == codes.NotFound {
+		log.Debug("directory is not found, listing it on its owner")
+		resp, err = c.api.ListFiles(ownerContext(ctx, dir), req)
+	}
+	if err != nil {
+		log.Error("failed to list directory", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	files := make([]FileInfo, 0, len(resp.GetFiles()))
+	for _, f := range resp.GetFiles() {
+		files = append(files, FileInfo{
+			Name:    f.GetName(),
+			Size:    f.GetSize(),
+			IsDir:   f.GetIsDir(),
+			ModTime: time.Unix(f.GetModTime(), 0),
+		})
+	}
+
+	return files, nil
+}
+
+// Stat returns description of file or directory name
+func (c *Client) Stat(ctx context.Context, name string) (FileInfo, error) {
+	const op = "grpclient.Stat"
+	log := c.log.With(slog.String("op", op))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return FileInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	name, _ = filepath.Localize(name)
+	ctx = affinity.WithKey(ctx, name)
+	resp, err := c.api.Stat(ctx, &filemanagerv1.StatRequest{Name: name})
+	if err != nil {
+		log.Debug("failed to get file stat", sl.Err(err))
+		return FileInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	f := resp.GetFile()
+	return FileInfo{
+		Name:    f.GetName(),
+		Size:    f.GetSize(),
+		IsDir:   f.GetIsDir(),
+		ModTime: time.Unix(f.GetModTime(), 0),
+	}, nil
+}
+
+// Mkdir creates directory name and its missing parents
+func (c *Client) Mkdir(ctx context.Context, name string) error {
+	const op = "grpclient.Mkdir"
+	log := c.log.With(slog.String("op", op))
+	log.Info("creating directory", slog.String("dir", name))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	name, _ = filepath.Localize(name)
+	ctx = affinity.WithKey(ctx, name)
+	if _, err := c.api.Mkdir(ctx, &filemanagerv1.MkdirRequest{Name: name}); err != nil {
+		log.Error("failed to create directory", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("successfully sent file")
 	return nil
 }
 
-func (c *Client) PutFile(ctx context.Context, data DataProvider, header DataHeader, filename string) (err error) {
-	const op = "grpclient.PutFile"
+// Move renames file or directory from to to
+func (c *Client) Move(ctx context.Context, from string, to string) error {
+	const op = "grpclient.Move"
 	log := c.log.With(slog.String("op", op))
-	log.Info("starting to send file")
+	log.Info("moving file", slog.String("from", from), slog.String("to", to))
 
-	if err = ctx.Err(); err != nil {
+	if err := ctx.Err(); err != nil {
 		log.Error("context return error", sl.Err(ctx.Err()))
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -244,54 +623,340 @@ func (c *Client) PutFile(ctx context.Context, data DataProvider, header DataHead
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	stream, err := c.api.PutFile(ctx)
+	from, _ = filepath.Localize(from)
+	to, _ = filepath.Localize(to)
+	ctx = affinity.WithKey(ctx, from)
+	if _, err := c.api.Move(ctx, &filemanagerv1.MoveRequest{From: from, To: to}); err != nil {
+		log.Error("failed to move file", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListChanges returns at most limit changes made after cursor since,
+// cursor to continue from and whether more changes are available.
+// Zero limit returns cursor of the last change
+func (c *Client) ListChanges(
+	ctx context.Context,
+	since uint64,
+	limit int,
+) ([]Change, uint64, bool, error) {
+	const op = "grpclient.ListChanges"
+	log := c.log.With(slog.String("op", op))
+	log.Info("listing changes", slog.Uint64("since", since))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return nil, 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	ctx = affinity.WithKey(ctx, journalKey)
+	resp, err := c.api.ListChanges(
+		ctx,
+		&filemanagerv1.ListChangesRequest{Since: since, Limit: int32(limit)},
+	)
 	if err != nil {
-		log.Error("failed to get stream from api", sl.Err(err))
+		log.Error("failed to list changes", sl.Err(err))
+		return nil, 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	changes := make([]Change, 0, len(resp.GetChanges()))
+	for _, ch := range resp.GetChanges() {
+		changes = append(changes, Change{
+			Seq:  ch.GetSeq(),
+			Op:   ch.GetOp(),
+			Name: ch.GetName(),
+			From: ch.GetFrom(),
+			Time: time.Unix(ch.GetTime(), 0),
+		})
+	}
+
+	return changes, resp.GetCursor(), resp.GetHasMore(), nil
+}
+
+// Watch passes events of files under prefix to fn until ctx is done or fn
+// returns error. Without recursive only files directly in prefix are
+// watched, empty ops means all kinds of changes.
+// With several filemanagers one of them is watched, changes made through
+// others reach it by replication
+func (c *Client) Watch(
+	ctx context.Context,
+	prefix string,
+	recursive bool,
+	ops []string,
+	fn func(Event) error,
+) error {
+	const op = "grpclient.Watch"
+	log := c.log.With(slog.String("op", op))
+	log.Info("watching files", slog.String("prefix", prefix))
+
+	stream, err := c.api.Watch(
+		ctx,
+		&filemanagerv1.WatchRequest{Prefix: prefix, Recursive: recursive, Ops: ops},
+	)
+	if err != nil {
+		log.Error("failed to start watching", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	defer func() {
-		if _, err := stream.CloseAndRecv(); err != nil {
-			log.Error("failed to close api stream", sl.Err(err))
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("watch is broken", sl.Err(err))
+			}
+			return fmt.Errorf("%s: %w", op, err)
 		}
 
-		if err := data.Close(); err != nil {
-			log.Error("failed to close data provider", sl.Err(err))
+		err = fn(Event{
+			Op:     ev.GetOp(),
+			Name:   ev.GetName(),
+			From:   ev.GetFrom(),
+			Time:   time.Unix(ev.GetTime(), 0),
+			Source: ev.GetSource(),
+		})
+		if err != nil {
+			return err
 		}
-	}()
+	}
+}
 
-	filename, _ = filepath.Localize(filename)
-	if !fs.ValidPath(filename) {
-		log.Warn("Invalid file path", slog.String("file path", filename))
-		return status.Error(codes.InvalidArgument, "invalid file name")
+// Archive walks directory dir on the filemanager and passes its entries
+// selected by include and exclude patterns to fn. Data of file is read
+// from r while fn runs, the rest of it is skipped when fn returns.
+// Archive is not limited by timeout of calls, it is stopped by ctx
+func (c *Client) Archive(
+	ctx context.Context,
+	dir string,
+	include []string,
+	exclude []string,
+	fn func(e ArchiveEntry, r io.Reader) error,
+) error {
+	const op = "grpclient.Archive"
+	log := c.log.With(slog.String("op", op))
+	log.Info("archiving directory", slog.String("dir", dir))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dir, _ = filepath.Localize(dir)
+	stream, err := c.api.Archive(
+		ctx,
+		&filemanagerv1.ArchiveRequest{Path: dir, Include: include, Exclude: exclude},
+	)
+	if err != nil {
+		log.Error("failed to start archiving", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	size := header.Size()
-	sent := int64(0)
-	read := 0
-	chunk := make([]byte, bufsize)
+	for {
+		recv, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			log.Error("failed to receive entry", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if recv.GetName() == "" {
+			return fmt.Errorf("%s: %w", op, status.Error(codes.DataLoss, "data is received before entry"))
+		}
 
-	for sent < size {
-		read, err = data.Read(chunk)
+		r := &entryReader{stream: stream, left: recv.GetSize()}
+		if recv.GetIsDir() {
+			r.left = 0
+		}
+
+		err = fn(ArchiveEntry{
+			Name:    recv.GetName(),
+			Size:    recv.GetSize(),
+			IsDir:   recv.GetIsDir(),
+			ModTime: time.Unix(recv.GetModTime(), 0),
+		}, r)
 		if err != nil {
-			log.Error("failed to read file", sl.Err(err))
+			return err
+		}
+
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			log.Error("failed to receive file", sl.Err(err))
 			return fmt.Errorf("%s: %w", op, err)
 		}
+	}
+}
 
-		err = stream.Send(
-			&filemanagerv1.PutFileRequest{
-				FileName: filename,
-				Chunk:    chunk[:read],
-			},
-		)
+// entryReader reads data of archive entry from the stream
+type entryReader struct {
+	stream grpc.ServerStreamingClient[filemanagerv1.ArchiveEntry]
+	left   int64
+	buf    []byte
+}
+
+func (r *entryReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+
+	for len(r.buf) == 0 {
+		recv, err := r.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return 0, status.Error(codes.DataLoss, "archive is truncated")
+		}
 		if err != nil {
-			log.Error("failed to send chunk", sl.Err(err))
+			return 0, err
+		}
+		if recv.GetName() != "" {
+			return 0, status.Error(codes.DataLoss, "entry is received before data of file is finished")
+		}
+		r.buf = recv.GetChunk()
+	}
+
+	n := copy(p[:min(int64(len(p)), r.left)], r.buf)
+	r.buf = r.buf[n:]
+	r.left -= int64(n)
+
+	return n, nil
+}
+
+// Extractor uploads entries of archive to the filemanager by one stream.
+// It is not safe for concurrent use
+type Extractor struct {
+	log    *slog.Logger
+	stream grpc.ClientStreamingClient[filemanagerv1.ExtractRequest, filemanagerv1.ExtractResponse]
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+// Extract starts extraction of archive into the filemanager. Entries are
+// added by Add and results are returned by Close. Extraction is not limited
+// by timeout of calls, it is stopped by ctx or Abort
+func (c *Client) Extract(ctx context.Context) (*Extractor, error) {
+	const op = "grpclient.Extract"
+	log := c.log.With(slog.String("op", op))
+	log.Info("starting to extract archive")
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.api.Extract(ctx)
+	if err != nil {
+		cancel()
+		log.Error("failed to start extraction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Extractor{
+		log:    log,
+		stream: stream,
+		cancel: cancel,
+		buf:    make([]byte, bufsize),
+	}, nil
+}
+
+// Add sends entry and data of file read from r. Exactly e.Size bytes
+// have to be read, otherwise filemanager reports the entry as failed
+func (x *Extractor) Add(e ExtractEntry, r io.Reader) error {
+	const op = "grpclient.Extractor.Add"
+
+	name, _ := filepath.Localize(e.Name)
+	err := x.send(&filemanagerv1.ExtractRequest{
+		Name:      name,
+		Size:      e.Size,
+		IsDir:     e.IsDir,
+		ModTime:   e.ModTime.Unix(),
+		Overwrite: e.Overwrite,
+	})
+	if err != nil || e.IsDir {
+		return err
+	}
+
+	for {
+		n, err := r.Read(x.buf)
+		if n > 0 {
+			if err := x.send(&filemanagerv1.ExtractRequest{Chunk: x.buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			x.log.Warn("failed to read entry", sl.Err(err), slog.String("name", e.Name))
 			return fmt.Errorf("%s: %w", op, err)
 		}
+	}
+}
 
-		sent += int64(read)
+// send sends message. Stream broken by filemanager reports
+// its error only when it is closed, so it is closed at once
+func (x *Extractor) send(req *filemanagerv1.ExtractRequest) error {
+	const op = "grpclient.Extractor.send"
+
+	err := x.stream.Send(req)
+	if errors.Is(err, io.EOF) {
+		_, err = x.stream.CloseAndRecv()
+		x.cancel()
+	}
+	if err != nil {
+		x.log.Error("failed to send entry", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Close finishes extraction and returns results of added entries in order
+func (x *Extractor) Close() ([]ExtractResult, error) {
+	const op = "grpclient.Extractor.Close"
+	defer x.cancel()
+
+	resp, err := x.stream.CloseAndRecv()
+	if err != nil {
+		x.log.Error("failed to finish extraction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	results := make([]ExtractResult, 0, len(resp.GetResults()))
+	for _, r := range resp.GetResults() {
+		results = append(results, ExtractResult{
+			Name:   r.GetName(),
+			Status: r.GetStatus(),
+			Error:  r.GetError(),
+		})
+	}
+
+	x.log.Info("archive is extracted", slog.Int("entries", len(results)))
+	return results, nil
+}
+
+// Abort cancels extraction. Files which are already committed are kept
+func (x *Extractor) Abort() {
+	x.cancel()
+}
+
+// Ready checks that connection to the filemanager is established and
+// filemanager health service reports SERVING
+func (c *Client) Ready(ctx context.Context) error {
+	const op = "grpclient.Ready"
+
+	state := c.cnct.GetState()
+	switch state {
+	case connectivity.Idle:
+		c.cnct.Connect()
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return fmt.Errorf("%s: connection is %s", op, state)
+	}
+
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%s: filemanager is %s", op, resp.GetStatus())
 	}
 
-	log.Info("successfully sent file")
 	return nil
 }
 
@@ -0,0 +1,159 @@
+package grpclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lab3/internal/clients/fm/affinity"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/IlianBuh/filemanager-server/pkg/delta"
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxPatchOps is the largest number of ops sent in one message
+const maxPatchOps = 1024
+
+// PatchFile replaces existing file by size bytes of r, sending only parts
+// which differ from the current content of the file. Content of r is read
+// twice, to compute its checksum and its delta. It returns number of bytes
+// of content sent as data. Error with code FailedPrecondition means that
+// the file is changed while delta is computed and has to be sent by PutFile
+func (c *Client) PatchFile(ctx context.Context, r io.ReadSeeker, size int64, filename string) (sent int64, err error) {
+	const op = "grpclient.PatchFile"
+	log := c.log.With(slog.String("op", op))
+	log.Info("starting to patch file", slog.String("filename", filename), slog.Int64("size", size))
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	filename, _ = filepath.Localize(filename)
+	if !fs.ValidPath(filename) {
+		log.Warn("Invalid file path", slog.String("file path", filename))
+		return 0, fmt.Errorf("%s: %w", op, status.Error(codes.InvalidArgument, "invalid file name"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	ctx = affinity.WithKey(ctx, filename)
+
+	sig, err := c.signature(ctx, filename)
+	if err != nil {
+		log.Error("failed to get signature of file", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, size); err != nil {
+		log.Error("failed to read file", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sent, err = c.patchFile(ctx, log, sig, r, size, h.Sum(nil), filename)
+	if err != nil {
+		log.Error("failed to patch file", sl.Err(err))
+		return sent, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully patched file",
+		slog.Int("blocks", len(sig.Blocks)),
+		slog.Int64("sent", sent),
+	)
+	return sent, nil
+}
+
+// signature receives signature of all blocks of the file
+func (c *Client) signature(ctx context.Context, filename string) (delta.Signature, error) {
+	stream, err := c.api.Signature(ctx, &filemanagerv1.SignatureRequest{FileName: filename})
+	if err != nil {
+		return delta.Signature{}, err
+	}
+
+	var sig delta.Signature
+	for {
+		recv, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return sig, nil
+		}
+		if err != nil {
+			return delta.Signature{}, err
+		}
+
+		sig.BlockSize = int(recv.GetBlockSize())
+		sig.Size = recv.GetSize()
+		for _, b := range recv.GetBlocks() {
+			sig.Blocks = append(sig.Blocks, delta.Block{Weak: b.GetWeak(), Strong: b.GetStrong()})
+		}
+	}
+}
+
+// patchFile streams ops of delta of size bytes of r against sig
+func (c *Client) patchFile(
+	ctx context.Context,
+	log *slog.Logger,
+	sig delta.Signature,
+	r io.Reader,
+	size int64,
+	sum []byte,
+	filename string,
+) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.api.PatchFile(ctx)
+	if err != nil {
+		log.Error("failed to get stream from api", sl.Err(err))
+		return 0, err
+	}
+
+	req := &filemanagerv1.PatchFileRequest{FileName: filename, Size: size, Sha256: sum}
+	first, data, sent := true, 0, int64(0)
+	send := func() error {
+		err := stream.Send(req)
+		req, first, data = &filemanagerv1.PatchFileRequest{}, false, 0
+		return err
+	}
+
+	err = delta.Diff(sig, io.LimitReader(r, size), func(o delta.Op) error {
+		req.Ops = append(req.Ops, &filemanagerv1.DeltaOp{Offset: o.Offset, Length: o.Length, Data: o.Data})
+		data += len(o.Data)
+		sent += int64(len(o.Data))
+		if data >= bufsize || len(req.Ops) >= maxPatchOps {
+			return send()
+		}
+		return nil
+	})
+	// the first message is sent even without ops, because it names the file
+	if err == nil && (first || len(req.Ops) > 0) {
+		err = send()
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Error("failed to send delta", sl.Err(err))
+		// cancelling the stream makes filemanager keep old file
+		cancel()
+		return sent, err
+	}
+
+	if _, err = stream.CloseAndRecv(); err != nil {
+		log.Error("failed to close api stream", sl.Err(err))
+		return sent, err
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,198 @@
+package grpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"time"
+
+	filemanagerv1 "github.com/IlianBuh/fmProto/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileReader reads file streamed by filemanager. If stream is broken by
+// transient error, download is resumed from the last read byte of the
+// same version of the file. It is not safe for concurrent use
+type FileReader struct {
+	c       *Client
+	log     *slog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	name    string
+	offset  int64
+	ver     version
+	info    FileInfo
+	pinned  bool
+	retrier *retrier
+	stream  grpc.ServerStreamingClient[filemanagerv1.GetFileResponse]
+	buf     []byte
+}
+
+// version identifies content of file by its size and modification time
+// in unix seconds, which filemanager sends in the first message of GetFile
+type version struct {
+	size    int64
+	modTime int64
+}
+
+// OpenFile starts download of file from offset. Download is not limited
+// by timeout of calls, it is stopped by ctx or by Close. File which is
+// not found is read from its owner. Error of Read with code
+// FailedPrecondition means that the file is changed while it is read
+func (c *Client) OpenFile(ctx context.Context, filename string, offset int64) (*FileReader, error) {
+	const op = "grpclient.OpenFile"
+	log := c.log.With(slog.String("op", op))
+	log.Info("starting getting file from grpc server",
+		slog.String("file name", filename),
+		slog.Int64("offset", offset),
+	)
+
+	if err := ctx.Err(); err != nil {
+		log.Error("context return error", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r := &FileReader{
+		c:       c,
+		log:     log,
+		ctx:     ctx,
+		name:    filename,
+		offset:  offset,
+		retrier: c.retry.newRetrier(c.retry.Download),
+	}
+	if err := r.open(); err != nil {
+		log.Error("failed to get file from grpc server", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+// Info describes the whole file, not only its read part
+func (r *FileReader) Info() FileInfo {
+	return r.info
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.stream == nil {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(r.buf) == 0 {
+		recv, err := r.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if r.offset != r.ver.size {
+				return 0, status.Error(codes.DataLoss, "file is changed while downloading")
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			if !r.retrier.next(r.ctx, err) {
+				return 0, err
+			}
+			r.log.Warn("resuming download",
+				sl.Err(err),
+				slog.Int("attempt", r.retrier.attempt),
+				slog.Int64("offset", r.offset),
+			)
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		r.buf = recv.GetChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+// Close stops download
+func (r *FileReader) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.stream, r.cancel, r.buf = nil, nil, nil
+
+	return nil
+}
+
+// open starts stream at offset and receives description of the file.
+// The first stream records version of the file, following streams
+// require the file to have that version, so parts of different
+// contents are not mixed
+func (r *FileReader) open() error {
+	for {
+		err := r.start()
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) == codes.NotFound && !r.pinned {
+			r.log.Debug("file is not found, reading it from its owner")
+			r.ctx, r.pinned = ownerContext(r.ctx, r.name), true
+			continue
+		}
+		if !r.retrier.next(r.ctx, err) {
+			return err
+		}
+
+		r.log.Warn("retrying download",
+			sl.Err(err),
+			slog.Int("attempt", r.retrier.attempt),
+			slog.Int64("offset", r.offset),
+		)
+	}
+}
+
+func (r *FileReader) start() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.cancel = cancel
+
+	stream, err := r.c.api.GetFile(
+		r.retrier.context(ctx),
+		&filemanagerv1.GetFileRequest{
+			FileName:  r.name,
+			Offset:    r.offset,
+			IfSize:    r.ver.size,
+			IfModTime: r.ver.modTime,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	recv, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.DataLoss, "file is not described")
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.ver.modTime == 0 {
+		r.ver = version{size: recv.GetSize(), modTime: recv.GetModTime()}
+		r.info = FileInfo{
+			Name:    r.name,
+			Size:    recv.GetSize(),
+			ModTime: time.Unix(recv.GetModTime(), 0),
+		}
+	}
+	r.stream, r.buf = stream, recv.GetChunk()
+
+	return nil
+}
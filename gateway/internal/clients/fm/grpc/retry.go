@@ -0,0 +1,178 @@
+package grpclient
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryOptions configures retries of streaming calls.
+// Downloads are resumed from the last received byte,
+// uploads are replayed from the beginning
+type RetryOptions struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is a fraction of backoff by which it is randomly changed
+	Jitter   float64
+	Download Budget
+	Upload   Budget
+	// UploadBuffer is a max number of bytes kept in memory to replay upload
+	// whose source cannot be rewound. Bigger uploads are not retried
+	UploadBuffer int64
+}
+
+// Budget limits retries of one operation
+type Budget struct {
+	MaxAttempts int
+	// Timeout is a time after which no more attempts are started
+	Timeout time.Duration
+}
+
+// retrier counts attempts of one operation and waits between them
+type retrier struct {
+	opts     RetryOptions
+	budget   Budget
+	attempt  int
+	deadline time.Time
+}
+
+func (o RetryOptions) newRetrier(budget Budget) *retrier {
+	r := &retrier{opts: o, budget: budget}
+	if budget.Timeout > 0 {
+		r.deadline = time.Now().Add(budget.Timeout)
+	}
+
+	return r
+}
+
+// next reports whether operation failed with err has to be tried again.
+// It waits for backoff before returning true
+func (r *retrier) next(ctx context.Context, err error) bool {
+	if !isRetryable(err) {
+		return false
+	}
+	if r.attempt+1 >= r.budget.MaxAttempts {
+		return false
+	}
+
+	wait := r.backoff()
+	if !r.deadline.IsZero() && time.Now().Add(wait).After(r.deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+
+	r.attempt++
+	return true
+}
+
+// backoff returns exponential backoff of the next attempt with jitter
+func (r *retrier) backoff() time.Duration {
+	d := float64(r.opts.InitialBackoff) * math.Pow(r.opts.Multiplier, float64(r.attempt))
+	if limit := float64(r.opts.MaxBackoff); limit > 0 && d > limit {
+		d = limit
+	}
+	d *= 1 + r.opts.Jitter*(2*rand.Float64()-1)
+
+	return time.Duration(d)
+}
+
+// context marks retried attempts the same way as unary retry interceptor
+// so they are visible to metrics and filemanager
+func (r *retrier) context(ctx context.Context) context.Context {
+	if r.attempt == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(
+		ctx,
+		retry.AttemptMetadataKey, strconv.Itoa(r.attempt),
+	)
+}
+
+// isRetryable reports whether err is a transient error of the filemanager
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
+
+// replayReader reads upload data and allows to read it again from the
+// beginning. Data which can be seeked is rewound, otherwise read bytes
+// are kept in memory up to the limit
+type replayReader struct {
+	data     DataProvider
+	seeker   io.Seeker
+	buf      []byte
+	pos      int
+	limit    int64
+	overflow bool
+}
+
+func newReplayReader(data DataProvider, limit int64) *replayReader {
+	r := &replayReader{data: data, limit: limit}
+	if seeker, ok := data.(io.Seeker); ok {
+		r.seeker = seeker
+	}
+
+	return r
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if r.seeker != nil {
+		return r.data.Read(p)
+	}
+
+	if r.pos < len(r.buf) {
+		n := copy(p, r.buf[r.pos:])
+		r.pos += n
+		return n, nil
+	}
+
+	n, err := r.data.Read(p)
+	if !r.overflow {
+		if int64(len(r.buf)+n) > r.limit {
+			r.overflow = true
+			r.buf = nil
+		} else {
+			r.buf = append(r.buf, p[:n]...)
+		}
+	}
+	r.pos = len(r.buf)
+
+	return n, err
+}
+
+// rewind moves reader to the beginning of data.
+// It returns false if data cannot be read again
+func (r *replayReader) rewind() bool {
+	if r.seeker != nil {
+		_, err := r.seeker.Seek(0, io.SeekStart)
+		return err == nil
+	}
+	if r.overflow {
+		return false
+	}
+
+	r.pos = 0
+	return true
+}
@@ -0,0 +1,51 @@
+package grpclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	// enables client side health checking of subchannels
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+const targetsScheme = "filemanagers"
+
+var ErrNoTargets = errors.New("no filemanager targets")
+
+// resolveTargets returns grpc target of filemanagers. Several addresses
+// are served by manual resolver which is returned as dial option
+func resolveTargets(targets []string) (string, []grpc.DialOption, error) {
+	switch len(targets) {
+	case 0:
+		return "", nil, ErrNoTargets
+	case 1:
+		return targets[0], nil, nil
+	}
+
+	addrs := make([]resolver.Address, 0, len(targets))
+	for _, t := range targets {
+		if strings.Contains(t, "://") {
+			return "", nil, fmt.Errorf("target %q: only host:port is allowed in the list of targets", t)
+		}
+
+		addrs = append(addrs, resolver.Address{Addr: t})
+	}
+
+	r := manual.NewBuilderWithScheme(targetsScheme)
+	r.InitialState(resolver.State{Addresses: addrs})
+
+	return r.Scheme() + ":///filemanager", []grpc.DialOption{grpc.WithResolvers(r)}, nil
+}
+
+// serviceConfig selects balancer and turns on health checking of every
+// filemanager, so unhealthy ones are excluded from balancing
+func serviceConfig(balancerName string) string {
+	return fmt.Sprintf(
+		`{"loadBalancingConfig":[{%q:{}}],"healthCheckConfig":{"serviceName":""}}`,
+		balancerName,
+	)
+}
@@ -0,0 +1,67 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"path"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Archive walks the directory on every node one after another and passes
+// their entries to fn. Directories existing on several nodes are passed
+// once. While rebalancing, file which is not migrated yet is passed only
+// if its owner does not store it. Directory which is missing on every
+// node is reported as NotFound
+func (c *Cluster) Archive(
+	ctx context.Context,
+	dir string,
+	include []string,
+	exclude []string,
+	fn func(e grpclient.ArchiveEntry, r io.Reader) error,
+) error {
+	const op = "shard.Archive"
+
+	seen := make(map[string]bool)
+	found := false
+	for _, name := range c.names {
+		err := c.nodes[name].Archive(ctx, dir, include, exclude, func(e grpclient.ArchiveEntry, r io.Reader) error {
+			if seen[e.Name] {
+				return nil
+			}
+
+			if !e.IsDir && c.prev != nil {
+				full := key(path.Join(dir, e.Name))
+				owner, node := c.owner(full)
+				if owner != name {
+					exists, err := c.exists(ctx, node, full)
+					if err != nil {
+						return err
+					}
+					if exists {
+						return nil
+					}
+				}
+			}
+
+			seen[e.Name] = true
+			return fn(e, r)
+		})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: node %s: %w", op, name, err)
+		}
+
+		found = true
+	}
+	if !found {
+		return status.Error(codes.NotFound, "directory not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package shard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"sort"
+	"sync"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is a position in journals of all nodes. It maps node name to
+// sequence number of the last change of the node which was listed
+type cursor map[string]uint64
+
+// parseCursor decodes cursor returned by ListChanges.
+// Empty cursor means the beginning of every journal
+func parseCursor(s string) (cursor, error) {
+	c := make(cursor)
+	if s == "" {
+		return c, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	return c, nil
+}
+
+func (c cursor) String() string {
+	data, _ := json.Marshal(map[string]uint64(c))
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ListChanges lists changes of every node after cursor and merges them by
+// time. At most limit changes are returned, cursor of every node is moved
+// only past its returned changes, so the rest are listed by the next call.
+// Empty cursor means the beginning, zero limit returns cursor of the last changes.
+// Files moved by rebalance are reported as created on the new node,
+// their deletion from the previous node is not reported
+func (c *Cluster) ListChanges(
+	ctx context.Context,
+	since string,
+	limit int,
+) ([]grpclient.Change, string, bool, error) {
+	const op = "shard.ListChanges"
+
+	prev, err := parseCursor(since)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	type result struct {
+		node    string
+		changes []grpclient.Change
+		cursor  uint64
+		hasMore bool
+		err     error
+	}
+
+	results := make([]result, len(c.names))
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			changes, next, hasMore, err := c.nodes[name].ListChanges(ctx, prev[name], limit)
+			results[i] = result{node: name, changes: changes, cursor: next, hasMore: hasMore, err: err}
+		}()
+	}
+	wg.Wait()
+
+	type nodeChange struct {
+		node string
+		grpclient.Change
+	}
+
+	next := make(cursor, len(c.names))
+	hasMore := false
+	var merged []nodeChange
+	for _, res := range results {
+		if res.err != nil {
+			return nil, "", false, fmt.Errorf("%s: node %s: %w", op, res.node, res.err)
+		}
+
+		if limit == 0 {
+			next[res.node] = res.cursor
+			continue
+		}
+
+		next[res.node] = prev[res.node]
+		hasMore = hasMore || res.hasMore
+		for _, ch := range res.changes {
+			merged = append(merged, nodeChange{node: res.node, Change: ch})
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+
+	if len(merged) > limit {
+		merged = merged[:limit]
+		hasMore = true
+	}
+
+	changes := make([]grpclient.Change, 0, len(merged))
+	for _, ch := range merged {
+		next[ch.node] = ch.Seq
+
+		moved, err := c.isMoved(ctx, ch.node, ch.Change)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%s: %w", op, err)
+		}
+		if !moved {
+			changes = append(changes, ch.Change)
+		}
+	}
+
+	return changes, next.String(), hasMore, nil
+}
+
+// isMoved reports whether change is deletion of the file from node which
+// is not its owner, while the owner stores the file
+func (c *Cluster) isMoved(ctx context.Context, node string, ch grpclient.Change) (bool, error) {
+	if ch.Op != "delete" {
+		return false, nil
+	}
+
+	ownerName, owner := c.owner(ch.Name)
+	if ownerName == node {
+		return false, nil
+	}
+
+	return c.exists(ctx, owner, ch.Name)
+}
@@ -0,0 +1,414 @@
+// Package shard places files on several filemanager nodes by
+// consistent hashing of their paths.
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrUnknownNode = errors.New("unknown shard node")
+
+type Options struct {
+	VNodes int
+	// Ring lists names of nodes which paths are placed on.
+	// If empty, all nodes are in the ring
+	Ring []string
+	// PreviousRing lists names of nodes of the ring before the last change.
+	// It is set while files are migrated by rebalance, so files which are
+	// not migrated yet are still found
+	PreviousRing []string
+}
+
+// Cluster is a set of filemanager nodes, each of them stores its part of files
+type Cluster struct {
+	log   *slog.Logger
+	nodes map[string]*grpclient.Client
+	names []string
+	ring  *Ring
+	prev  *Ring
+}
+
+func New(
+	log *slog.Logger,
+	nodes map[string]*grpclient.Client,
+	opts Options,
+) (*Cluster, error) {
+	const op = "shard.New"
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ring := opts.Ring
+	if len(ring) == 0 {
+		ring = names
+	}
+	for _, name := range slices.Concat(ring, opts.PreviousRing) {
+		if _, ok := nodes[name]; !ok {
+			return nil, fmt.Errorf("%s: %w: %s", op, ErrUnknownNode, name)
+		}
+	}
+
+	c := &Cluster{
+		log:   log,
+		nodes: nodes,
+		names: names,
+		ring:  NewRing(ring, opts.VNodes),
+	}
+	if len(opts.PreviousRing) > 0 {
+		c.prev = NewRing(opts.PreviousRing, opts.VNodes)
+		log.Info("cluster is rebalancing", slog.Any("previous ring", opts.PreviousRing))
+	}
+
+	log.Info("created shard cluster", slog.Any("ring", ring), slog.String("op", op))
+	return c, nil
+}
+
+// key returns normalized path which is hashed to find its node
+func key(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
+
+// placement returns path which decides node of the file. Member of
+// archive, like "artifacts.zip!/bin/tool", is placed with its archive
+func placement(filename string) string {
+	name := key(filename)
+	for i := range len(name) {
+		if name[i] != '!' || i+1 < len(name) && name[i+1] != '/' {
+			continue
+		}
+		if ext := strings.ToLower(path.Ext(name[:i])); ext == ".zip" || ext == ".tar" {
+			return name[:i]
+		}
+	}
+
+	return name
+}
+
+// owner returns node which the file is placed on
+func (c *Cluster) owner(filename string) (string, *grpclient.Client) {
+	name := c.ring.Owner(placement(filename))
+
+	return name, c.nodes[name]
+}
+
+// previous returns node which the file was placed on before the last
+// change of the ring. It returns false if it is the current owner
+func (c *Cluster) previous(filename string) (string, *grpclient.Client, bool) {
+	if c.prev == nil {
+		return "", nil, false
+	}
+
+	name := c.prev.Owner(placement(filename))
+	if owner, _ := c.owner(filename); name == owner {
+		return "", nil, false
+	}
+
+	return name, c.nodes[name], true
+}
+
+// GetFile downloads file from its node. While rebalancing, file which
+// is not migrated yet is downloaded from its previous node
+func (c *Cluster) GetFile(ctx context.Context, filename string) ([]byte, error) {
+	const op = "shard.GetFile"
+	log := c.log.With(slog.String("op", op))
+
+	_, node := c.owner(filename)
+	res, err := node.GetFile(ctx, filename)
+	if status.Code(err) != codes.NotFound {
+		return res, err
+	}
+
+	prevName, prev, ok := c.previous(filename)
+	if !ok {
+		return nil, err
+	}
+
+	log.Info("file is not migrated yet", slog.String("node", prevName))
+	return prev.GetFile(ctx, filename)
+}
+
+// OpenFile starts download of file from offset
+// from its node, see grpclient.Client.OpenFile
+func (c *Cluster) OpenFile(ctx context.Context, filename string, offset int64) (*grpclient.FileReader, error) {
+	const op = "shard.OpenFile"
+	log := c.log.With(slog.String("op", op))
+
+	_, node := c.owner(filename)
+	r, err := node.OpenFile(ctx, filename, offset)
+	if status.Code(err) != codes.NotFound {
+		return r, err
+	}
+
+	prevName, prev, ok := c.previous(filename)
+	if !ok {
+		return nil, err
+	}
+
+	log.Info("file is not migrated yet", slog.String("node", prevName))
+	return prev.OpenFile(ctx, filename, offset)
+}
+
+// PostFile uploads new file to its node. Parent directory must exist on
+// any node, it is created on the owner if it is missing there
+func (c *Cluster) PostFile(
+	ctx context.Context,
+	data grpclient.DataProvider,
+	header grpclient.DataHeader,
+	filename string,
+) error {
+	if len(c.names) > 1 {
+		if dir := path.Dir(key(filename)); dir != "." {
+			_, info, err := c.locate(ctx, dir)
+			if status.Code(err) == codes.NotFound || err == nil && !info.IsDir {
+				return status.Error(codes.InvalidArgument, "parent directory not found")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		ctx = grpclient.WithParents(ctx)
+	}
+
+	if _, prev, ok := c.previous(filename); ok {
+		exists, err := c.exists(ctx, prev, filename)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return status.Error(codes.AlreadyExists, "file already exists")
+		}
+	}
+
+	_, node := c.owner(filename)
+	return node.PostFile(ctx, data, header, filename)
+}
+
+// PutFile replaces file on its node. While rebalancing, file which is not
+// migrated yet is created on its node and the old copy is deleted by rebalance
+func (c *Cluster) PutFile(
+	ctx context.Context,
+	data grpclient.DataProvider,
+	header grpclient.DataHeader,
+	filename string,
+) error {
+	const op = "shard.PutFile"
+	log := c.log.With(slog.String("op", op))
+
+	_, node := c.owner(filename)
+
+	prevName, prev, ok := c.previous(filename)
+	if !ok {
+		return node.PutFile(ctx, data, header, filename)
+	}
+
+	exists, err := c.exists(ctx, node, filename)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return node.PutFile(ctx, data, header, filename)
+	}
+
+	exists, err = c.exists(ctx, prev, filename)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return node.PutFile(ctx, data, header, filename)
+	}
+
+	log.Info("file is not migrated yet, creating it on new node", slog.String("previous node", prevName))
+	return node.PostFile(grpclient.WithParents(ctx), data, header, filename)
+}
+
+// DeleteFile deletes file from its node.
+// While rebalancing, copy on the previous node is deleted too.
+// Directory is deleted from every node, see deleteDir
+func (c *Cluster) DeleteFile(ctx context.Context, filename string) error {
+	if len(c.names) > 1 {
+		_, info, err := c.locate(ctx, filename)
+		if err == nil && info.IsDir {
+			return c.deleteDir(ctx, filename)
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+	}
+
+	_, node := c.owner(filename)
+	err := node.DeleteFile(ctx, filename)
+
+	_, prev, ok := c.previous(filename)
+	if !ok {
+		return err
+	}
+
+	prevErr := prev.DeleteFile(ctx, filename)
+	switch {
+	case isMissing(err):
+		return prevErr
+	case isMissing(prevErr):
+		return err
+	}
+
+	return errors.Join(err, prevErr)
+}
+
+// deleteDir deletes directory from every node which has it. Files of
+// the directory may be on any node, so it must be empty on all of them
+func (c *Cluster) deleteDir(ctx context.Context, dir string) error {
+	const op = "shard.deleteDir"
+
+	files, err := c.ListFiles(ctx, dir, false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if len(files) > 0 {
+		return status.Error(codes.InvalidArgument, "directory is not empty")
+	}
+
+	for _, name := range c.names {
+		node := c.nodes[name]
+		if _, err := node.Stat(ctx, dir); status.Code(err) == codes.NotFound {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: node %s: %w", op, name, err)
+		}
+
+		// node refuses directory which got files since the check
+		if err := node.DeleteFile(ctx, dir); err != nil {
+			return fmt.Errorf("%s: node %s: %w", op, name, err)
+		}
+	}
+
+	return nil
+}
+
+// ListFiles lists the directory on every node and merges the results.
+// Directory which is missing on every node is reported as NotFound
+func (c *Cluster) ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error) {
+	const op = "shard.ListFiles"
+
+	type result struct {
+		node  string
+		files []grpclient.FileInfo
+		err   error
+	}
+
+	results := make([]result, len(c.names))
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			files, err := c.nodes[name].ListFiles(ctx, dir, recursive)
+			results[i] = result{node: name, files: files, err: err}
+		}()
+	}
+	wg.Wait()
+
+	merged := make(map[string]grpclient.FileInfo)
+	found := false
+	for _, res := range results {
+		if status.Code(res.err) == codes.NotFound {
+			continue
+		}
+		if res.err != nil {
+			return nil, fmt.Errorf("%s: node %s: %w", op, res.node, res.err)
+		}
+
+		found = true
+		for _, f := range res.files {
+			prev, ok := merged[f.Name]
+			switch {
+			case !ok:
+				merged[f.Name] = f
+			case f.IsDir:
+				if f.ModTime.After(prev.ModTime) {
+					merged[f.Name] = f
+				}
+			default:
+				// not migrated copy is replaced by the copy on the owner
+				if owner, _ := c.owner(f.Name); owner == res.node {
+					merged[f.Name] = f
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "directory not found")
+	}
+
+	files := make([]grpclient.FileInfo, 0, len(merged))
+	for _, f := range merged {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files, nil
+}
+
+// Ready checks that every node is ready
+func (c *Cluster) Ready(ctx context.Context) error {
+	for _, name := range c.names {
+		if err := c.nodes[name].Ready(ctx); err != nil {
+			return fmt.Errorf("node %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Stop closes connections to all nodes
+func (c *Cluster) Stop() {
+	for _, name := range c.names {
+		c.nodes[name].Stop()
+	}
+}
+
+// exists reports whether node stores file
+func (c *Cluster) exists(ctx context.Context, node *grpclient.Client, filename string) (bool, error) {
+	name := key(filename)
+
+	files, err := node.ListFiles(ctx, path.Dir(name), false)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range files {
+		if f.Name == name {
+			return !f.IsDir, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isMissing reports whether err means that file is not found
+func isMissing(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.InvalidArgument:
+		return true
+	}
+
+	return false
+}
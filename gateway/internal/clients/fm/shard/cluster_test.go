@@ -0,0 +1,107 @@
+package shard
+
+import (
+	"errors"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"log/slog"
+	"testing"
+)
+
+// testCluster creates cluster of nodes without connections,
+// which is enough to place files
+func testCluster(t *testing.T, nodes []string, opts Options) *Cluster {
+	t.Helper()
+
+	clients := make(map[string]*grpclient.Client)
+	for _, n := range append(nodes, opts.PreviousRing...) {
+		clients[n] = nil
+	}
+	opts.VNodes = testVNodes
+
+	c, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), clients, opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c
+}
+
+func TestNewUnknownNode(t *testing.T) {
+	clients := map[string]*grpclient.Client{"a": nil}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, opts := range []Options{{Ring: []string{"a", "b"}}, {PreviousRing: []string{"b"}}} {
+		if _, err := New(log, clients, opts); !errors.Is(err, ErrUnknownNode) {
+			t.Errorf("New(%+v) error = %v, want %v", opts, err, ErrUnknownNode)
+		}
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "a.txt", want: "a.txt"},
+		{name: "/dir/../a.txt", want: "a.txt"},
+		{name: "dir/a.zip", want: "dir/a.zip"},
+		{name: "dir/a.zip!/bin/tool", want: "dir/a.zip"},
+		{name: "dir/a.TAR!/x", want: "dir/a.TAR"},
+		{name: "dir/a.gz!/x", want: "dir/a.gz!/x"},
+		{name: "dir/a.zip!x", want: "dir/a.zip!x"},
+	}
+
+	for _, tt := range tests {
+		if got := placement(tt.name); got != tt.want {
+			t.Errorf("placement(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrevious(t *testing.T) {
+	ring := []string{"a", "b", "c"}
+	prevRing := []string{"a", "b"}
+	c := testCluster(t, ring, Options{Ring: ring, PreviousRing: prevRing})
+	prev := NewRing(prevRing, testVNodes)
+
+	moving := 0
+	for _, k := range testKeys(1000) {
+		owner, _ := c.owner(k)
+		name, _, ok := c.previous(k)
+
+		want := prev.Owner(k)
+		if want == owner {
+			if ok {
+				t.Fatalf("previous(%q) = %q for file which stays on %s", k, name, owner)
+			}
+			continue
+		}
+
+		moving++
+		if !ok || name != want {
+			t.Fatalf("previous(%q) = %q, %v, want %q", k, name, ok, want)
+		}
+		if owner != "c" {
+			t.Fatalf("file %q moves to %s, not to added node", k, owner)
+		}
+	}
+	if moving == 0 {
+		t.Errorf("no file is moved to the added node")
+	}
+
+	// archive members are looked up with their archive
+	name, _, ok := c.previous("dir/a.zip!/x")
+	if wantName, _, wantOK := c.previous("dir/a.zip"); name != wantName || ok != wantOK {
+		t.Errorf("previous() of member = %q, %v, of archive = %q, %v", name, ok, wantName, wantOK)
+	}
+}
+
+func TestPreviousWithoutRebalance(t *testing.T) {
+	c := testCluster(t, []string{"a", "b"}, Options{})
+
+	for _, k := range testKeys(100) {
+		if name, _, ok := c.previous(k); ok {
+			t.Fatalf("previous(%q) = %q without previous ring", k, name)
+		}
+	}
+}
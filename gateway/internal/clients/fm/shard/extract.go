@@ -0,0 +1,100 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Extract extracts archive which entries are added by fn. Every entry is
+// sent to its node, each node receives its entries by its own stream.
+// Results are returned in order of added entries. While rebalancing,
+// file which is not migrated yet is skipped unless it is overwritten,
+// then the old copy is deleted by rebalance
+func (c *Cluster) Extract(
+	ctx context.Context,
+	fn func(add func(e grpclient.ExtractEntry, r io.Reader) error) error,
+) ([]grpclient.ExtractResult, error) {
+	const op = "shard.Extract"
+
+	// streams which are not closed because of error are aborted
+	streams := make(map[string]*grpclient.Extractor)
+	defer func() {
+		for _, x := range streams {
+			x.Abort()
+		}
+	}()
+
+	// nodes lists node of every added entry,
+	// entries resolved here have no node
+	var nodes []string
+	local := make(map[int]grpclient.ExtractResult)
+
+	err := fn(func(e grpclient.ExtractEntry, r io.Reader) error {
+		if !e.IsDir && !e.Overwrite {
+			if _, prev, ok := c.previous(e.Name); ok {
+				exists, err := c.exists(ctx, prev, e.Name)
+				if err != nil {
+					return err
+				}
+				if exists {
+					local[len(nodes)] = grpclient.ExtractResult{
+						Name:   e.Name,
+						Status: grpclient.ExtractSkipped,
+						Error:  "file already exists",
+					}
+					nodes = append(nodes, "")
+					return nil
+				}
+			}
+		}
+
+		name, node := c.owner(e.Name)
+		x, ok := streams[name]
+		if !ok {
+			var err error
+			if x, err = node.Extract(ctx); err != nil {
+				return fmt.Errorf("%s: node %s: %w", op, name, err)
+			}
+			streams[name] = x
+		}
+
+		nodes = append(nodes, name)
+		if err := x.Add(e, r); err != nil {
+			return fmt.Errorf("%s: node %s: %w", op, name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byNode := make(map[string][]grpclient.ExtractResult, len(streams))
+	for name, x := range streams {
+		res, err := x.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: node %s: %w", op, name, err)
+		}
+		byNode[name] = res
+	}
+
+	results := make([]grpclient.ExtractResult, 0, len(nodes))
+	for i, name := range nodes {
+		if name == "" {
+			results = append(results, local[i])
+			continue
+		}
+		if len(byNode[name]) == 0 {
+			return nil, status.Errorf(codes.DataLoss, "%s: node %s reported less entries than added", op, name)
+		}
+
+		results = append(results, byNode[name][0])
+		byNode[name] = byNode[name][1:]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,138 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rebalance moves every file which is stored not on its owner to the owner.
+// Gateways keep serving reads while it runs if they are configured with
+// the previous ring. If file was already written to the owner, that copy
+// is kept if it is not older. With dryRun files are only counted.
+// It returns number of moved files
+func (c *Cluster) Rebalance(ctx context.Context, dryRun bool) (int, error) {
+	const op = "shard.Rebalance"
+	log := c.log.With(slog.String("op", op))
+	log.Info("starting rebalance", slog.Bool("dry run", dryRun))
+
+	var (
+		moved int
+		errs  []error
+	)
+	for _, name := range c.names {
+		files, err := c.nodes[name].ListFiles(ctx, ".", true)
+		if err != nil {
+			log.Error("failed to list node", sl.Err(err), slog.String("node", name))
+			errs = append(errs, fmt.Errorf("node %s: %w", name, err))
+			continue
+		}
+
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return moved, fmt.Errorf("%s: %w", op, err)
+			}
+			owner, ok := c.misplaced(name, f)
+			if !ok {
+				continue
+			}
+
+			log.Info("moving file",
+				slog.String("file", f.Name),
+				slog.String("from", name),
+				slog.String("to", owner),
+			)
+			if dryRun {
+				moved++
+				continue
+			}
+
+			if err := c.move(ctx, log, f, name, owner); err != nil {
+				log.Error("failed to move file", sl.Err(err), slog.String("file", f.Name))
+				errs = append(errs, fmt.Errorf("file %s: %w", f.Name, err))
+				continue
+			}
+			moved++
+		}
+	}
+
+	log.Info("rebalance is finished", slog.Int("moved", moved), slog.Int("failed", len(errs)))
+	if len(errs) > 0 {
+		return moved, fmt.Errorf("%s: %w", op, errors.Join(errs...))
+	}
+
+	return moved, nil
+}
+
+// misplaced returns owner of file stored on node if the file has to be
+// moved to it. Directories are never moved, they are created with files
+func (c *Cluster) misplaced(node string, f grpclient.FileInfo) (string, bool) {
+	if f.IsDir {
+		return "", false
+	}
+
+	owner, _ := c.owner(f.Name)
+	return owner, owner != node
+}
+
+// errCopyDiffers means that copy of moved file on its owner
+// is neither the written one nor newer than the moved file
+var errCopyDiffers = errors.New("copy on owner differs, file is kept")
+
+// move copies file from node src to node dst and deletes it from src.
+// File is deleted only if dst has the written copy or a newer file
+func (c *Cluster) move(
+	ctx context.Context,
+	log *slog.Logger,
+	f grpclient.FileInfo,
+	src string,
+	dst string,
+) error {
+	r, err := c.nodes[src].OpenFile(ctx, f.Name, 0)
+	if err != nil {
+		return err
+	}
+	moved := r.Info()
+
+	err = c.nodes[dst].PostFile(grpclient.WithParents(ctx), r, fileHeader{name: f.Name, size: moved.Size}, f.Name)
+	written := err == nil
+	if status.Code(err) == codes.AlreadyExists {
+		log.Info("file is already written to owner", slog.String("file", f.Name))
+	} else if err != nil {
+		return err
+	}
+
+	info, err := c.nodes[dst].Stat(ctx, f.Name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir:
+		return fmt.Errorf("%w: owner has directory", errCopyDiffers)
+	case written && info.Size != moved.Size:
+		return fmt.Errorf("%w: written %d bytes, owner has %d", errCopyDiffers, moved.Size, info.Size)
+	case !written && info.ModTime.Before(moved.ModTime):
+		return fmt.Errorf("%w: owner has older file", errCopyDiffers)
+	}
+
+	return c.nodes[src].DeleteFile(ctx, f.Name)
+}
+
+type fileHeader struct {
+	name string
+	size int64
+}
+
+func (h fileHeader) Name() string {
+	return h.name
+}
+
+func (h fileHeader) Size() int64 {
+	return h.size
+}
@@ -0,0 +1,82 @@
+package shard
+
+import (
+	grpclient "lab3/internal/clients/fm/grpc"
+	"testing"
+)
+
+func TestMisplaced(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	c := testCluster(t, nodes, Options{})
+
+	// find one file owned by every node
+	files := make(map[string]string)
+	for _, k := range testKeys(1000) {
+		owner, _ := c.owner(k)
+		if _, ok := files[owner]; !ok {
+			files[owner] = k
+		}
+	}
+	if len(files) != len(nodes) {
+		t.Fatalf("keys are owned by %d nodes, want %d", len(files), len(nodes))
+	}
+
+	otherNode := func(n string) string {
+		for _, o := range nodes {
+			if o != n {
+				return o
+			}
+		}
+		return ""
+	}
+
+	for owner, name := range files {
+		other := otherNode(owner)
+		archive := name + ".zip"
+		archiveOwner, _ := c.owner(archive)
+
+		tests := []struct {
+			name  string
+			node  string
+			file  grpclient.FileInfo
+			owner string
+			move  bool
+		}{
+			{name: "file on owner", node: owner, file: grpclient.FileInfo{Name: name}, owner: owner},
+			{name: "file on other node", node: other, file: grpclient.FileInfo{Name: name}, owner: owner, move: true},
+			{name: "directory on other node", node: other, file: grpclient.FileInfo{Name: name, IsDir: true}},
+			{name: "archive member path", node: otherNode(archiveOwner), file: grpclient.FileInfo{Name: archive + "!/x"}, owner: archiveOwner, move: true},
+		}
+		for _, tt := range tests {
+			t.Run(owner+"/"+tt.name, func(t *testing.T) {
+				got, move := c.misplaced(tt.node, tt.file)
+				if got != tt.owner || move != tt.move {
+					t.Errorf("misplaced(%s, %q) = %q, %v, want %q, %v", tt.node, tt.file.Name, got, move, tt.owner, tt.move)
+				}
+			})
+		}
+	}
+}
+
+// TestMisplacedAfterChange checks that rebalance after adding
+// a node moves files only to that node
+func TestMisplacedAfterChange(t *testing.T) {
+	before := testCluster(t, []string{"a", "b"}, Options{})
+	after := testCluster(t, []string{"a", "b", "c"}, Options{})
+
+	moved := 0
+	for _, k := range testKeys(1000) {
+		node, _ := before.owner(k)
+		owner, move := after.misplaced(node, grpclient.FileInfo{Name: k})
+		if !move {
+			continue
+		}
+		moved++
+		if owner != "c" {
+			t.Fatalf("file %q is moved from %s to %s, not to added node", k, node, owner)
+		}
+	}
+	if moved == 0 {
+		t.Errorf("no file is moved to added node")
+	}
+}
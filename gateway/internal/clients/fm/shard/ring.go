@@ -0,0 +1,64 @@
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring of shard nodes.
+// Every node is placed on the ring several times to spread paths evenly
+type Ring struct {
+	hashes []uint64
+	owners map[uint64]string
+}
+
+// NewRing places nodes on the ring vnodes times each
+func NewRing(nodes []string, vnodes int) *Ring {
+	if vnodes < 1 {
+		vnodes = 1
+	}
+
+	r := &Ring{
+		hashes: make([]uint64, 0, len(nodes)*vnodes),
+		owners: make(map[uint64]string, len(nodes)*vnodes),
+	}
+	for _, node := range nodes {
+		for i := 0; i < vnodes; i++ {
+			h := hash(node + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+// Owner returns node which the path is placed on
+func (r *Ring) Owner(path string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hash(path)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+// hash has to mix every byte well, because virtual nodes of one node
+// differ only in the suffix
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+
+	return binary.BigEndian.Uint64(sum[:8])
+}
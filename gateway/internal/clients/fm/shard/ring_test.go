@@ -0,0 +1,103 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testVNodes = 100
+
+// testKeys returns n distinct paths
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("dir%d/file%d.txt", i%17, i)
+	}
+	return keys
+}
+
+func TestRingEmpty(t *testing.T) {
+	if got := NewRing(nil, testVNodes).Owner("a.txt"); got != "" {
+		t.Errorf("Owner() of empty ring = %q, want empty", got)
+	}
+}
+
+func TestRingSingleNode(t *testing.T) {
+	for _, vnodes := range []int{-1, 0, 1, testVNodes} {
+		r := NewRing([]string{"a"}, vnodes)
+		for _, k := range testKeys(100) {
+			if got := r.Owner(k); got != "a" {
+				t.Fatalf("vnodes %d: Owner(%q) = %q, want a", vnodes, k, got)
+			}
+		}
+	}
+}
+
+func TestRingOrder(t *testing.T) {
+	r1 := NewRing([]string{"a", "b", "c"}, testVNodes)
+	r2 := NewRing([]string{"c", "a", "b"}, testVNodes)
+
+	for _, k := range testKeys(1000) {
+		if o1, o2 := r1.Owner(k), r2.Owner(k); o1 != o2 {
+			t.Fatalf("Owner(%q) depends on order of nodes: %q and %q", k, o1, o2)
+		}
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	r := NewRing(nodes, testVNodes)
+	keys := testKeys(10000)
+
+	count := make(map[string]int)
+	for _, k := range keys {
+		count[r.Owner(k)]++
+	}
+
+	fair := len(keys) / len(nodes)
+	for _, n := range nodes {
+		if count[n] < fair/2 || count[n] > fair*3/2 {
+			t.Errorf("node %s owns %d keys, fair share is %d", n, count[n], fair)
+		}
+	}
+}
+
+// TestRingChange checks that only keys of added or removed node change owner
+func TestRingChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  []string
+		after   []string
+		changed string
+	}{
+		{name: "node added", before: []string{"a", "b", "c"}, after: []string{"a", "b", "c", "d"}, changed: "d"},
+		{name: "node removed", before: []string{"a", "b", "c", "d"}, after: []string{"a", "b", "d"}, changed: "c"},
+		{name: "second node added", before: []string{"a"}, after: []string{"a", "b"}, changed: "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := NewRing(tt.before, testVNodes)
+			after := NewRing(tt.after, testVNodes)
+			keys := testKeys(10000)
+
+			moved := 0
+			for _, k := range keys {
+				b, a := before.Owner(k), after.Owner(k)
+				if a == b {
+					continue
+				}
+				moved++
+				if a != tt.changed && b != tt.changed {
+					t.Fatalf("key %q moved from %s to %s, neither is %s", k, b, a, tt.changed)
+				}
+			}
+
+			// about one share of keys moves, the others stay
+			nodes := max(len(tt.before), len(tt.after))
+			if fair := len(keys) / nodes; moved == 0 || moved > fair*3/2 {
+				t.Errorf("%d keys moved, fair share is %d", moved, fair)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Stat returns description of file or directory. Directories exist on
+// every node which stores files in them, so the first found one is returned
+func (c *Cluster) Stat(ctx context.Context, name string) (grpclient.FileInfo, error) {
+	_, info, err := c.locate(ctx, name)
+
+	return info, err
+}
+
+// Mkdir creates directory on the node which owns its path.
+// Directory which exists on any node is reported as AlreadyExists
+func (c *Cluster) Mkdir(ctx context.Context, name string) error {
+	_, _, err := c.locate(ctx, name)
+	if err == nil {
+		return status.Error(codes.AlreadyExists, "file already exists")
+	}
+	if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	_, node := c.owner(name)
+	return node.Mkdir(ctx, name)
+}
+
+// Move renames file or directory. File which changes its owner is copied
+// to the new owner and deleted from the old one. Files of directory are
+// moved one by one, so the move is not atomic when they are on several nodes
+func (c *Cluster) Move(ctx context.Context, from string, to string) error {
+	const op = "shard.Move"
+	log := c.log.With(slog.String("op", op))
+
+	from, to = key(from), key(to)
+	if to == from || strings.HasPrefix(to, from+"/") {
+		return status.Error(codes.InvalidArgument, "can not move directory into itself")
+	}
+
+	src, info, err := c.locate(ctx, from)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.locate(ctx, to); err == nil {
+		return status.Error(codes.AlreadyExists, "destination already exists")
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	if !info.IsDir {
+		return c.moveFile(ctx, src, from, to)
+	}
+	if len(c.names) == 1 {
+		return c.nodes[src].Move(ctx, from, to)
+	}
+
+	files, err := c.ListFiles(ctx, from, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	dirs := []string{from}
+	for _, f := range files {
+		target := to + strings.TrimPrefix(f.Name, from)
+		if f.IsDir {
+			dirs = append(dirs, f.Name)
+			continue
+		}
+
+		node, _, err := c.locate(ctx, f.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := c.moveFile(ctx, node, f.Name, target); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// empty directories are not moved with files
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		target := to + strings.TrimPrefix(dir, from)
+		_, node := c.owner(target)
+		err := node.Mkdir(ctx, target)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// the deepest directories are deleted first, so every one is empty
+	for i := len(dirs) - 1; i >= 0; i-- {
+		for _, name := range c.names {
+			err := c.nodes[name].DeleteFile(ctx, dirs[i])
+			if err != nil && !isMissing(err) {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+
+	log.Info("directory is moved", slog.Int("entries", len(files)))
+	return nil
+}
+
+// moveFile moves file stored on node src to the owner of the new path
+func (c *Cluster) moveFile(ctx context.Context, src string, from string, to string) error {
+	dst, _ := c.owner(to)
+	if dst == src {
+		return c.nodes[src].Move(ctx, from, to)
+	}
+
+	r, err := c.nodes[src].OpenFile(ctx, from, 0)
+	if err != nil {
+		return err
+	}
+
+	err = c.nodes[dst].PostFile(grpclient.WithParents(ctx), r, fileHeader{name: to, size: r.Info().Size}, to)
+	if err != nil {
+		return err
+	}
+
+	return c.nodes[src].DeleteFile(ctx, from)
+}
+
+// locate returns node which stores file and its description. Owner of
+// the path is asked first, then its previous node and then all the others
+func (c *Cluster) locate(ctx context.Context, name string) (string, grpclient.FileInfo, error) {
+	order := make([]string, 0, len(c.names))
+	owner, _ := c.owner(name)
+	order = append(order, owner)
+	if prev, _, ok := c.previous(name); ok {
+		order = append(order, prev)
+	}
+	for _, node := range c.names {
+		if node != owner && (len(order) < 2 || node != order[1]) {
+			order = append(order, node)
+		}
+	}
+
+	for _, node := range order {
+		info, err := c.nodes[node].Stat(ctx, name)
+		if err == nil {
+			return node, info, nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return "", grpclient.FileInfo{}, err
+		}
+	}
+
+	return "", grpclient.FileInfo{}, status.Error(codes.NotFound, "file not found")
+}
@@ -0,0 +1,52 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"sync"
+)
+
+// Watch watches every node and passes their events to fn one at a time.
+// It returns when ctx is done, fn fails or watch of any node is broken
+func (c *Cluster) Watch(
+	ctx context.Context,
+	prefix string,
+	recursive bool,
+	ops []string,
+	fn func(grpclient.Event) error,
+) error {
+	const op = "shard.Watch"
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for _, name := range c.names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := c.nodes[name].Watch(ctx, prefix, recursive, ops, func(e grpclient.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				return fn(e)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: node %s: %w", op, name, err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
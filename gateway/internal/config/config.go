@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"os"
 	"time"
@@ -13,10 +14,20 @@ const (
 )
 
 type Config struct {
-	Env          string     `yaml:"env" env-default:"local"`
-	FmPort       string     `yaml:"fm-port" env-required:"true"`
-	RetriesCount int        `yaml:"retries-count" env-default:"5"`
-	HTTPSrv      HTTPServer `yaml:"http-server"`
+	Env          string      `yaml:"env" env-default:"local"`
+	FmPort       string      `yaml:"fm-port" env-default:"20201"`
+	FileManager  FileManager `yaml:"filemanager"`
+	Shards       Shards      `yaml:"shards"`
+	RetriesCount int         `yaml:"retries-count" env-default:"5"`
+	StreamRetry  StreamRetry `yaml:"stream-retry"`
+	HTTPSrv      HTTPServer  `yaml:"http-server"`
+	Share        Share       `yaml:"share"`
+	Extract      Extract     `yaml:"extract"`
+	RateLimit    RateLimit   `yaml:"rate-limit"`
+	Tracing      Tracing     `yaml:"tracing"`
+	Webhooks     Webhooks    `yaml:"webhooks"`
+	S3           S3          `yaml:"s3"`
+	SFTP         SFTP        `yaml:"sftp"`
 }
 
 type HTTPServer struct {
@@ -26,6 +37,165 @@ type HTTPServer struct {
 	IdleTimeout time.Duration `yaml:"idle-timeout" env-default:"60s"`
 }
 
+// FileManager lists filemanager backends. If targets are empty,
+// filemanager on localhost:fm-port is used
+type FileManager struct {
+	Targets []string `yaml:"targets" env:"FM_TARGETS" env-separator:","`
+	// Balancer is either "fm_affinity", "round_robin" or "pick_first".
+	// Filemanagers balanced by "fm_affinity" or "round_robin" have to
+	// replicate changes to each other
+	Balancer string `yaml:"balancer" env-default:"fm_affinity"`
+}
+
+// Shards places files on several filemanager nodes by consistent hashing.
+// If nodes are empty, filemanager targets are used as the only node
+type Shards struct {
+	VNodes int         `yaml:"vnodes" env-default:"128"`
+	Nodes  []ShardNode `yaml:"nodes"`
+	// Ring lists names of nodes which files are placed on. Empty ring means all nodes
+	Ring []string `yaml:"ring"`
+	// PreviousRing is the ring before nodes were added or removed.
+	// It is set until rebalance migrates all files
+	PreviousRing []string `yaml:"previous-ring"`
+}
+
+type ShardNode struct {
+	Name    string   `yaml:"name"`
+	Targets []string `yaml:"targets"`
+}
+
+// StreamRetry configures retries of file downloads and uploads
+// broken by transient filemanager errors
+type StreamRetry struct {
+	InitialBackoff time.Duration `yaml:"initial-backoff" env-default:"100ms"`
+	MaxBackoff     time.Duration `yaml:"max-backoff" env-default:"5s"`
+	Multiplier     float64       `yaml:"multiplier" env-default:"2"`
+	Jitter         float64       `yaml:"jitter" env-default:"0.2"`
+	Download       RetryBudget   `yaml:"download"`
+	Upload         RetryBudget   `yaml:"upload"`
+	UploadBuffer   int64         `yaml:"upload-buffer" env-default:"33554432"`
+}
+
+// RetryBudget limits number of attempts and total time of retrying one operation
+type RetryBudget struct {
+	MaxAttempts int           `yaml:"max-attempts" env-default:"5"`
+	Timeout     time.Duration `yaml:"timeout" env-default:"1m"`
+}
+
+type Share struct {
+	Secret     string        `yaml:"secret" env:"SHARE_SECRET" env-required:"true" json:"-"`
+	BaseURL    string        `yaml:"base-url" env:"SHARE_BASE_URL"`
+	DefaultTTL time.Duration `yaml:"default-ttl" env-default:"24h"`
+	MaxTTL     time.Duration `yaml:"max-ttl" env-default:"168h"`
+	// AdminToken enables minting of links, which is disabled if it is empty
+	AdminToken string `yaml:"admin-token" env:"SHARE_ADMIN_TOKEN" json:"-"`
+	// Dir is a filemanager directory with download counters of links
+	Dir string `yaml:"dir" env-default:".share"`
+}
+
+// Extract limits archives which are uploaded to be extracted. MaxSize is
+// total size of extracted files in bytes, MaxRatio is the most it may be
+// relative to size of compressed data. Zero value of a limit disables it
+type Extract struct {
+	MaxEntries int     `yaml:"max-entries" env-default:"10000"`
+	MaxSize    int64   `yaml:"max-size" env-default:"10737418240"`
+	MaxRatio   float64 `yaml:"max-ratio" env-default:"100"`
+}
+
+// RateLimit configures per-client request rate and concurrent transfer limits.
+// Zero value of a limit disables it
+type RateLimit struct {
+	RPS             float64       `yaml:"rps" env-default:"20"`
+	Burst           int           `yaml:"burst" env-default:"40"`
+	ClientTransfers int           `yaml:"client-transfers" env-default:"4"`
+	TotalTransfers  int           `yaml:"total-transfers" env-default:"64"`
+	RetryAfter      time.Duration `yaml:"retry-after" env-default:"1s"`
+	ClientTTL       time.Duration `yaml:"client-ttl" env-default:"10m"`
+}
+
+// Webhooks delivers changes of files to subscribed urls. Subscriptions are
+// configured here or created by admin API, which is enabled by admin token.
+// Only one gateway has to dispatch webhooks, otherwise each of them delivers
+// every change
+type Webhooks struct {
+	Enabled        bool                  `yaml:"enabled" env:"WEBHOOKS_ENABLED" env-default:"false"`
+	Dir            string                `yaml:"dir" env-default:"./webhooks"`
+	AdminToken     string                `yaml:"admin-token" env:"WEBHOOKS_ADMIN_TOKEN" json:"-"`
+	Subscriptions  []WebhookSubscription `yaml:"subscriptions"`
+	PollInterval   time.Duration         `yaml:"poll-interval" env-default:"1s"`
+	BatchSize      int                   `yaml:"batch-size" env-default:"100"`
+	Workers        int                   `yaml:"workers" env-default:"4"`
+	Timeout        time.Duration         `yaml:"timeout" env-default:"10s"`
+	MaxAttempts    int                   `yaml:"max-attempts" env-default:"10"`
+	InitialBackoff time.Duration         `yaml:"initial-backoff" env-default:"1s"`
+	MaxBackoff     time.Duration         `yaml:"max-backoff" env-default:"10m"`
+	HistoryLimit   int                   `yaml:"history-limit" env-default:"1000"`
+}
+
+// WebhookSubscription selects changes of files under path.
+// Empty events means all kinds of changes
+type WebhookSubscription struct {
+	ID        string   `yaml:"id"`
+	URL       string   `yaml:"url"`
+	Secret    string   `yaml:"secret" json:"-"`
+	Path      string   `yaml:"path"`
+	Recursive bool     `yaml:"recursive"`
+	Events    []string `yaml:"events"`
+}
+
+// S3 serves files by S3-compatible API on its own address. Top-level
+// directories are buckets. Requests are signed by SigV4 with one of
+// access keys. Parts of multipart uploads are kept in dir until upload
+// is completed, abandoned uploads are removed after upload ttl
+type S3 struct {
+	Enabled       bool          `yaml:"enabled" env:"S3_ENABLED" env-default:"false"`
+	Port          string        `yaml:"port" env-default:"9000"`
+	Addr          string        `yaml:"address" env-default:"localhost"`
+	Timeout       time.Duration `yaml:"timeout" env-default:"10m"`
+	IdleTimeout   time.Duration `yaml:"idle-timeout" env-default:"60s"`
+	Region        string        `yaml:"region" env-default:"us-east-1"`
+	MaxSkew       time.Duration `yaml:"max-skew" env-default:"15m"`
+	MaxObjectSize int64         `yaml:"max-object-size" env-default:"5368709120"`
+	Dir           string        `yaml:"dir" env-default:"./s3"`
+	UploadTTL     time.Duration `yaml:"upload-ttl" env-default:"24h"`
+	AccessKeys    []S3AccessKey `yaml:"access-keys"`
+}
+
+type S3AccessKey struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret" json:"-"`
+}
+
+// SFTP serves files over SFTP on its own address. Every user is
+// chrooted onto root directory of the store and signs in with password,
+// which is kept as bcrypt hash, or with a key of its authorized keys file.
+// Host key is generated if the file does not exist
+type SFTP struct {
+	Enabled     bool          `yaml:"enabled" env:"SFTP_ENABLED" env-default:"false"`
+	Port        string        `yaml:"port" env-default:"2022"`
+	Addr        string        `yaml:"address" env-default:"localhost"`
+	HostKey     string        `yaml:"host-key" env-default:"./sftp/host_key"`
+	IdleTimeout time.Duration `yaml:"idle-timeout" env-default:"10m"`
+	TempDir     string        `yaml:"temp-dir"`
+	Users       []SFTPUser    `yaml:"users"`
+}
+
+type SFTPUser struct {
+	Name           string `yaml:"name"`
+	PasswordHash   string `yaml:"password-hash" json:"-"`
+	AuthorizedKeys string `yaml:"authorized-keys"`
+	Root           string `yaml:"root"`
+}
+
+// Tracing configures span exporter: "none", "otlp" or "file"
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" env-default:"localhost:4317"`
+	Insecure    bool    `yaml:"insecure" env-default:"true"`
+	FilePath    string  `yaml:"file-path" env-default:"./traces.json"`
+	SampleRatio float64 `yaml:"sample-ratio" env-default:"1"`
+}
+
 // New creates new config
 func New() *Config {
 	var cfg Config
@@ -40,9 +210,59 @@ func New() *Config {
 		panic(errors.New("failed to read config: " + err.Error()))
 	}
 
+	if err := cfg.validate(); err != nil {
+		panic(errors.New("invalid config: " + err.Error()))
+	}
+
 	return &cfg
 }
 
+// validate rejects settings which are accepted by types of fields,
+// but leave gateway insecure or unusable
+func (c *Config) validate() error {
+	if err := c.Share.validate(); err != nil {
+		return fmt.Errorf("share: %w", err)
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return fmt.Errorf("rate-limit: %w", err)
+	}
+
+	return nil
+}
+
+// minShareSecret is the shortest secret of share links, in bytes
+const minShareSecret = 32
+
+// validate rejects missing, example and short secrets, because anyone
+// knowing the secret forges share links to any file
+func (s Share) validate() error {
+	switch {
+	case s.Secret == "":
+		return errors.New("secret is not set, set SHARE_SECRET")
+	case s.Secret == "change-me":
+		return errors.New("secret is the example value, set SHARE_SECRET to a random one")
+	case len(s.Secret) < minShareSecret:
+		return fmt.Errorf("secret is shorter than %d bytes", minShareSecret)
+	}
+
+	return nil
+}
+
+// validate rejects limits which reject every request or
+// make limiter sweep its clients on every request
+func (l RateLimit) validate() error {
+	switch {
+	case l.RPS < 0 || l.Burst < 0 || l.ClientTransfers < 0 || l.TotalTransfers < 0:
+		return errors.New("limits must not be negative")
+	case l.RPS > 0 && l.Burst == 0:
+		return errors.New("burst must be positive if rps is set")
+	case l.ClientTTL <= 0:
+		return errors.New("client-ttl must be positive")
+	}
+
+	return nil
+}
+
 // fetchConfigPath tries to fetch config path from flag "config-path" or environment variable
 // If unable to fetch, default value will be returned
 //
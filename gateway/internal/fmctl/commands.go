@@ -0,0 +1,323 @@
+package fmctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ls lists directories and describes files
+func (c *cli) ls(args []string) error {
+	flags := c.flags()
+	recursive := flags.Bool("r", false, "list subdirectories recursively")
+	long := flags.Bool("l", false, "print size and modification time")
+	if err := c.parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	for _, pattern := range patterns {
+		names, err := expandRemote(c.ctx, c.store, pattern)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			info, err := c.store.Stat(c.ctx, name)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir {
+				c.printFile(info, *long)
+				continue
+			}
+
+			files, err := c.store.List(c.ctx, name, *recursive)
+			if err != nil {
+				return err
+			}
+			sortFiles(files)
+
+			for _, f := range files {
+				c.printFile(f, *long)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) printFile(info grpclient.FileInfo, long bool) {
+	if c.json {
+		c.printJSON(info)
+		return
+	}
+
+	name := info.Name
+	if info.IsDir {
+		name += "/"
+	}
+	if !long {
+		fmt.Fprintln(c.stdout, name)
+		return
+	}
+
+	kind := "-"
+	if info.IsDir {
+		kind = "d"
+	}
+	fmt.Fprintf(c.stdout, "%s %9s  %s  %s\n", kind, humanSize(info.Size), formatTime(info.ModTime), name)
+}
+
+// stat describes files
+func (c *cli) stat(args []string) error {
+	flags := c.flags()
+	if err := c.parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	for _, pattern := range flags.Args() {
+		names, err := expandRemote(c.ctx, c.store, pattern)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			info, err := c.store.Stat(c.ctx, name)
+			if err != nil {
+				return err
+			}
+			if c.json {
+				c.printJSON(info)
+				continue
+			}
+
+			kind := "file"
+			if info.IsDir {
+				kind = "directory"
+			}
+			fmt.Fprintf(c.stdout, "name:     %s\n", info.Name)
+			fmt.Fprintf(c.stdout, "type:     %s\n", kind)
+			fmt.Fprintf(c.stdout, "size:     %d (%s)\n", info.Size, humanSize(info.Size))
+			fmt.Fprintf(c.stdout, "modified: %s\n", formatTime(info.ModTime))
+		}
+	}
+
+	return nil
+}
+
+// rm deletes files. Directories are deleted only with their contents if -r is set
+func (c *cli) rm(args []string) error {
+	flags := c.flags()
+	recursive := flags.Bool("r", false, "delete directories and their contents")
+	force := flags.Bool("f", false, "ignore missing files")
+	if err := c.parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	for _, pattern := range flags.Args() {
+		names, err := expandRemote(c.ctx, c.store, pattern)
+		if *force && errors.Is(err, errNoMatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if name == "." {
+				return errors.New("refusing to delete root directory")
+			}
+
+			info, err := c.store.Stat(c.ctx, name)
+			if *force && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir {
+				if !*recursive {
+					return fmt.Errorf("%s is a directory, use -r to delete it", name)
+				}
+				if err := c.removeContents(name); err != nil {
+					return err
+				}
+			}
+
+			if err := c.store.Delete(c.ctx, name); err != nil {
+				return err
+			}
+			c.report(action{Op: "rm", Name: name})
+		}
+	}
+
+	return nil
+}
+
+// removeContents deletes everything in directory dir. Filemanager does not
+// delete directories which are not empty, so the deepest files go first
+func (c *cli) removeContents(dir string) error {
+	files, err := c.store.List(c.ctx, dir, true)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	sortDeepestFirst(names)
+
+	for _, name := range names {
+		if err := c.store.Delete(c.ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mv moves files. Several files are moved into directory
+func (c *cli) mv(args []string) error {
+	flags := c.flags()
+	if err := c.parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	sources, err := c.expandSources(flags.Args()[:flags.NArg()-1])
+	if err != nil {
+		return err
+	}
+	dst := flags.Arg(flags.NArg() - 1)
+
+	for _, src := range sources {
+		to, err := c.remoteTarget(src, dst, len(sources))
+		if err != nil {
+			return err
+		}
+
+		if err := c.store.Move(c.ctx, src, to); err != nil {
+			return err
+		}
+		c.report(action{Op: "mv", From: src, To: to})
+	}
+
+	return nil
+}
+
+// mkdir creates directories. Parents are created only if -p is set
+func (c *cli) mkdir(args []string) error {
+	flags := c.flags()
+	parents := flags.Bool("p", false, "create missing parents, existing directories are not an error")
+	if err := c.parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	for _, arg := range flags.Args() {
+		name := remoteName(arg)
+
+		if !*parents {
+			if _, err := c.store.Stat(c.ctx, name); err == nil {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+			}
+			if parent := path.Dir(name); parent != "." {
+				info, err := c.store.Stat(c.ctx, parent)
+				if err != nil {
+					return err
+				}
+				if !info.IsDir {
+					return fmt.Errorf("%s is not a directory", parent)
+				}
+			}
+		}
+
+		err := c.store.Mkdir(c.ctx, name)
+		if *parents && errors.Is(err, fs.ErrExist) {
+			info, serr := c.store.Stat(c.ctx, name)
+			if serr == nil && info.IsDir {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		c.report(action{Op: "mkdir", Name: name})
+	}
+
+	return nil
+}
+
+// expandSources expands patterns of remote files
+func (c *cli) expandSources(patterns []string) ([]string, error) {
+	var sources []string
+	for _, pattern := range patterns {
+		names, err := expandRemote(c.ctx, c.store, pattern)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, names...)
+	}
+
+	return sources, nil
+}
+
+// remoteTarget returns name which src gets when it is copied or moved to dst.
+// Files are put into dst if it is a directory, several files require it to be one
+func (c *cli) remoteTarget(src string, dst string, count int) (string, error) {
+	dir, err := c.isRemoteDir(dst)
+	if err != nil {
+		return "", err
+	}
+	if !dir && count > 1 {
+		return "", fmt.Errorf("target %s is not a directory", dst)
+	}
+	if !dir {
+		return remoteName(dst), nil
+	}
+	if src == "." {
+		return "", errors.New("root directory can not be put into directory")
+	}
+
+	return joinName(remoteName(dst), path.Base(src)), nil
+}
+
+// isRemoteDir reports whether name is existing directory in the store.
+// Name ending with slash is treated as directory even if it is missing
+func (c *cli) isRemoteDir(name string) (bool, error) {
+	info, err := c.store.Stat(c.ctx, remoteName(name))
+	switch {
+	case err == nil:
+		return info.IsDir, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return strings.HasSuffix(name, "/"), nil
+	}
+
+	return false, err
+}
+
+func sortFiles(files []grpclient.FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+}
+
+// sortDeepestFirst sorts names, so files go before directories containing them
+func sortDeepestFirst(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.Count(names[i], "/"), strings.Count(names[j], "/")
+		if di != dj {
+			return di > dj
+		}
+		return names[i] < names[j]
+	})
+}
@@ -0,0 +1,236 @@
+// Package fmctl implements fmctl, command-line client of the file store.
+// It talks to filemanagers over grpc or to gateway over http.
+package fmctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errUsage is returned if command is called with invalid arguments.
+// Usage is already printed when it is returned
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":    {"[-r] [-l] [path...]", (*cli).ls},
+	"stat":  {"path...", (*cli).stat},
+	"get":   {"[-r] remote... local|-", (*cli).get},
+	"put":   {"[-r] [-delta] local|-... remote", (*cli).put},
+	"rm":    {"[-r] [-f] path...", (*cli).rm},
+	"mv":    {"from... to", (*cli).mv},
+	"cp":    {"[-r] from... to", (*cli).cp},
+	"mkdir": {"[-p] path...", (*cli).mkdir},
+	"sync":  {"[-delete] [-delta] [-dry-run] src dst", (*cli).sync},
+}
+
+// cli is state of one run of fmctl
+type cli struct {
+	ctx      context.Context
+	name     string
+	usage    string
+	log      *slog.Logger
+	store    Store
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	json     bool
+	quiet    bool
+	progress *progress
+	// delta is set by commands uploading only changed parts of files
+	delta bool
+}
+
+// Run runs fmctl with command-line arguments args and returns exit code
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: fmctl [flags] command [args]")
+		fmt.Fprintln(stderr, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %s %s\n", name, commands[name].usage)
+		}
+		fmt.Fprintln(stderr, "\nflags:")
+		flags.PrintDefaults()
+	}
+
+	configPath := flags.String("config", "", "path to configuration file (default $FMCTL_CONFIG or fmctl/config.yaml in user config directory)")
+	profileName := flags.String("profile", os.Getenv("FMCTL_PROFILE"), "profile of configuration file to use")
+	targets := flags.String("grpc", "", "comma-separated addresses of filemanagers, overrides profile")
+	gatewayURL := flags.String("http", "", "url of gateway, overrides profile")
+	token := flags.String("token", os.Getenv("FMCTL_TOKEN"), "bearer token sent to gateway")
+	timeout := flags.Duration("timeout", 0, "timeout of one request")
+	jsonOutput := flags.Bool("json", false, "print results as json lines")
+	quiet := flags.Bool("q", false, "do not print progress and performed actions")
+	verbose := flags.Bool("v", false, "log requests to stderr")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	name, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "fmctl: unknown command %q\n", name)
+		flags.Usage()
+		return 2
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if *verbose {
+		log = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	path, required := *configPath, true
+	if path == "" {
+		path, required = DefaultConfigPath(), false
+	}
+	cfg, err := LoadConfig(path, required)
+	if err != nil {
+		fmt.Fprintf(stderr, "fmctl: %v\n", err)
+		return 1
+	}
+
+	profile, err := cfg.Profile(*profileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "fmctl: %v\n", err)
+		return 1
+	}
+	switch {
+	case *targets != "":
+		profile.Transport, profile.Targets = transportGRPC, strings.Split(*targets, ",")
+	case *gatewayURL != "":
+		profile.Transport, profile.URL = transportHTTP, *gatewayURL
+	}
+	if *token != "" {
+		profile.Token = *token
+	}
+	if *timeout > 0 {
+		profile.Timeout = *timeout
+	}
+
+	store, err := profile.Open(log)
+	if err != nil {
+		fmt.Fprintf(stderr, "fmctl: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	c := &cli{
+		ctx:      ctx,
+		name:     name,
+		usage:    cmd.usage,
+		log:      log,
+		store:    store,
+		stdin:    stdin,
+		stdout:   stdout,
+		stderr:   stderr,
+		json:     *jsonOutput,
+		quiet:    *quiet,
+		progress: newProgress(stderr, !*quiet && !*jsonOutput),
+	}
+
+	err = cmd.run(c, cmdArgs)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+
+	fmt.Fprintf(stderr, "fmctl: %v\n", err)
+	return 1
+}
+
+// flags returns flag set of the command
+func (c *cli) flags() *flag.FlagSet {
+	flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: fmctl %s %s\n", c.name, c.usage)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// parse parses arguments of command and checks that at least min of them remain
+func (c *cli) parse(flags *flag.FlagSet, args []string, min int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < min {
+		flags.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+// action describes performed change. Sent is size of content
+// sent by delta upload
+type action struct {
+	Op     string `json:"op"`
+	Name   string `json:"name,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Sent   int64  `json:"sent,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// report prints performed action unless fmctl is quiet
+func (c *cli) report(a action) {
+	if c.json {
+		c.printJSON(a)
+		return
+	}
+	if c.quiet {
+		return
+	}
+
+	prefix := ""
+	if a.DryRun {
+		prefix = "(dry run) "
+	}
+	if a.From != "" {
+		fmt.Fprintf(c.stdout, "%s%s %s -> %s\n", prefix, a.Op, a.From, a.To)
+		return
+	}
+	fmt.Fprintf(c.stdout, "%s%s %s\n", prefix, a.Op, a.Name)
+}
+
+func (c *cli) printJSON(v any) {
+	_ = json.NewEncoder(c.stdout).Encode(v)
+}
+
+// formatTime formats modification time of file in listings
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
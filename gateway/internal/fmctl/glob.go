@@ -0,0 +1,102 @@
+package fmctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var errNoMatch = errors.New("no files match")
+
+// hasMeta reports whether path contains any of the magic characters of path.Match
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// expandRemote returns names in the store matching pattern. Pattern
+// without magic characters is returned as is, so missing files are
+// reported by the command using it
+func expandRemote(ctx context.Context, store Store, pattern string) ([]string, error) {
+	name := remoteName(pattern)
+	if !hasMeta(name) {
+		return []string{name}, nil
+	}
+
+	parts := strings.Split(name, "/")
+	matches := []string{"."}
+	for i, part := range parts {
+		var next []string
+		last := i == len(parts)-1
+
+		for _, dir := range matches {
+			if !hasMeta(part) {
+				next = append(next, joinName(dir, part))
+				continue
+			}
+
+			files, err := store.List(ctx, dir, false)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range files {
+				if !last && !f.IsDir {
+					continue
+				}
+
+				base := path.Base(f.Name)
+
+				ok, err := path.Match(part, base)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+				}
+				if ok {
+					next = append(next, joinName(dir, base))
+				}
+			}
+		}
+
+		matches = next
+	}
+
+	if len(matches) == 0 {
+		return nil, &fs.PathError{Op: "glob", Path: pattern, Err: errNoMatch}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// expandLocal returns local paths matching pattern
+func expandLocal(pattern string) ([]string, error) {
+	if !hasMeta(pattern) {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if len(matches) == 0 {
+		return nil, &fs.PathError{Op: "glob", Path: pattern, Err: errNoMatch}
+	}
+
+	return matches, nil
+}
+
+// joinName joins name of directory in the store and name of file in it
+func joinName(dir string, name string) string {
+	if dir == "." {
+		return name
+	}
+
+	return dir + "/" + name
+}
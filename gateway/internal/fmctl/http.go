@@ -0,0 +1,330 @@
+package fmctl
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/handlers/http_handlers"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// propfindBody requests properties of files needed to describe them
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/>
+</D:prop></D:propfind>`
+
+// httpStore talks to gateway by webdav, which exposes
+// every operation of filemanager over http
+type httpStore struct {
+	client *http.Client
+	base   *url.URL
+	token  string
+}
+
+func newHTTPStore(baseURL string, token string, timeout time.Duration) (*httpStore, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("gateway url %q is not http or https url", baseURL)
+	}
+
+	return &httpStore{
+		client: &http.Client{Timeout: timeout},
+		base:   base,
+		token:  token,
+	}, nil
+}
+
+// url returns url of file in webdav file system of gateway
+func (s *httpStore) url(name string, dir bool) string {
+	u := *s.base
+	u.Path += http_handlers.DAVPrefix + "/"
+	if name != "." {
+		u.Path += name
+		if dir {
+			u.Path += "/"
+		}
+	}
+
+	return u.String()
+}
+
+func (s *httpStore) do(
+	ctx context.Context,
+	method string,
+	target string,
+	body io.Reader,
+	header http.Header,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	return s.client.Do(req)
+}
+
+// call sends request and checks its status. Body of response is closed
+func (s *httpStore) call(
+	ctx context.Context,
+	op string,
+	name string,
+	method string,
+	target string,
+	header http.Header,
+	want ...int,
+) error {
+	resp, err := s.do(ctx, method, target, nil, header)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	return statusError(op, name, resp, want...)
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind describes file and, if depth is not "0", its contents
+func (s *httpStore) propfind(ctx context.Context, op string, name string, depth string) ([]grpclient.FileInfo, error) {
+	resp, err := s.do(ctx, "PROPFIND", s.url(name, false), strings.NewReader(propfindBody), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml"},
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(op, name, resp, http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+
+	files := make([]grpclient.FileInfo, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		p := strings.TrimPrefix(href.Path, s.base.Path+http_handlers.DAVPrefix)
+
+		info := grpclient.FileInfo{Name: remoteName(p)}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			info.IsDir = ps.Prop.ResourceType.Collection != nil
+			info.Size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			info.ModTime, _ = http.ParseTime(ps.Prop.LastModified)
+		}
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+func (s *httpStore) List(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error) {
+	depth := "1"
+	if recursive {
+		depth = "infinity"
+	}
+
+	files, err := s.propfind(ctx, "ls", dir, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	// listing of webdav contains the directory itself
+	res := files[:0]
+	for _, f := range files {
+		if f.Name != dir {
+			res = append(res, f)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *httpStore) Stat(ctx context.Context, name string) (grpclient.FileInfo, error) {
+	files, err := s.propfind(ctx, "stat", name, "0")
+	if err != nil {
+		return grpclient.FileInfo{}, err
+	}
+	if len(files) == 0 {
+		return grpclient.FileInfo{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return files[0], nil
+}
+
+func (s *httpStore) Get(ctx context.Context, name string, w io.Writer) error {
+	resp, err := s.do(ctx, http.MethodGet, s.url(name, false), nil, nil)
+	if err != nil {
+		return &fs.PathError{Op: "get", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := statusError("get", name, resp, http.StatusOK); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Put uploads file. Webdav does not create parent directories,
+// so they are created when gateway reports they are missing
+func (s *httpStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	err := s.put(ctx, name, r, size)
+	seeker, ok := r.(io.Seeker)
+	if !errors.Is(err, errConflict) || !ok {
+		return err
+	}
+
+	if err := s.Mkdir(ctx, path.Dir(name)); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return s.put(ctx, name, r, size)
+}
+
+func (s *httpStore) put(ctx context.Context, name string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(name, false), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &fs.PathError{Op: "put", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	return statusError("put", name, resp, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+}
+
+func (s *httpStore) Delete(ctx context.Context, name string) error {
+	return s.call(ctx, "rm", name, http.MethodDelete, s.url(name, false), nil, http.StatusNoContent, http.StatusOK)
+}
+
+// Mkdir creates directory with missing parents. Existing
+// directory is reported only if it is the requested one
+func (s *httpStore) Mkdir(ctx context.Context, name string) error {
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+
+		err := s.call(ctx, "mkdir", dir, "MKCOL", s.url(dir, true), nil, http.StatusCreated)
+		if errors.Is(err, errMethodNotAllowed) {
+			// webdav answers MKCOL of existing file so
+			err = &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+		if err != nil && (i == len(parts)-1 || !errors.Is(err, fs.ErrExist)) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *httpStore) Move(ctx context.Context, from string, to string) error {
+	err := s.call(ctx, "mv", from, "MOVE", s.url(from, false), http.Header{
+		"Destination": {s.url(to, false)},
+		"Overwrite":   {"F"},
+	}, http.StatusCreated, http.StatusNoContent)
+	if !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+
+	// webdav forbids every failed move, missing source is the usual reason
+	if _, serr := s.Stat(ctx, from); errors.Is(serr, fs.ErrNotExist) {
+		return &fs.PathError{Op: "mv", Path: from, Err: fs.ErrNotExist}
+	}
+
+	return err
+}
+
+func (s *httpStore) Close() error {
+	s.client.CloseIdleConnections()
+
+	return nil
+}
+
+var (
+	errConflict         = errors.New("parent directory does not exist")
+	errMethodNotAllowed = errors.New("method is not allowed")
+)
+
+// statusError returns nil if status of response is one of want,
+// otherwise error describing the status
+func statusError(op string, name string, resp *http.Response, want ...int) error {
+	for _, code := range want {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = fs.ErrNotExist
+	case http.StatusPreconditionFailed:
+		err = fs.ErrExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = fs.ErrPermission
+	case http.StatusConflict:
+		err = errConflict
+	case http.StatusMethodNotAllowed:
+		err = errMethodNotAllowed
+	default:
+		err = errors.New("gateway responded " + resp.Status)
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
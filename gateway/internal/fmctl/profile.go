@@ -0,0 +1,154 @@
+package fmctl
+
+import (
+	"errors"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+const (
+	transportGRPC = "grpc"
+	transportHTTP = "http"
+
+	defaultTarget   = "localhost:20201"
+	defaultBalancer = "fm_affinity"
+	defaultTimeout  = 10 * time.Minute
+)
+
+// Config is configuration file of fmctl. Profiles describe endpoints and
+// credentials, Default is a name of profile used if no profile is chosen
+type Config struct {
+	Default  string             `yaml:"default"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile describes how to reach the store. Transport "grpc" talks to
+// filemanagers listed in Targets, "http" talks to gateway at URL. Token
+// is sent to gateway as bearer token, e.g. for proxy in front of it
+type Profile struct {
+	Transport string        `yaml:"transport"`
+	Targets   []string      `yaml:"targets"`
+	Balancer  string        `yaml:"balancer"`
+	URL       string        `yaml:"url"`
+	Token     string        `yaml:"token" json:"-"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// DefaultConfigPath returns path of configuration file: FMCTL_CONFIG
+// or fmctl/config.yaml in user configuration directory
+func DefaultConfigPath() string {
+	if p := os.Getenv("FMCTL_CONFIG"); p != "" {
+		return p
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "fmctl", "config.yaml")
+}
+
+// LoadConfig reads configuration file. Missing file is an
+// empty configuration unless it is required
+func LoadConfig(path string, required bool) (Config, error) {
+	const op = "fmctl.LoadConfig"
+
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && !required {
+		return cfg, nil
+	}
+
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return cfg, nil
+}
+
+// Profile returns profile by name. Empty name means default
+// profile, which is local filemanager if it is not configured
+func (c Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		if p, ok := c.Profiles["default"]; ok {
+			return p, nil
+		}
+		return Profile{Transport: transportGRPC}, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q is not configured", name)
+	}
+
+	return p, nil
+}
+
+// Open connects to the store described by profile
+func (p Profile) Open(log *slog.Logger) (Store, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	transport := p.Transport
+	if transport == "" && p.URL != "" {
+		transport = transportHTTP
+	}
+
+	switch transport {
+	case "", transportGRPC:
+		targets := p.Targets
+		if len(targets) == 0 {
+			targets = []string{defaultTarget}
+		}
+
+		balancer := p.Balancer
+		if balancer == "" {
+			balancer = defaultBalancer
+		}
+
+		client, err := grpclient.New(log, targets, balancer, timeout, 0, retryOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		return newGRPCStore(client), nil
+	case transportHTTP:
+		if p.URL == "" {
+			return nil, errors.New("url of gateway is not configured")
+		}
+
+		return newHTTPStore(p.URL, p.Token, timeout)
+	}
+
+	return nil, fmt.Errorf("unknown transport %q", p.Transport)
+}
+
+// retryOptions are retries of broken transfers, the same as gateway uses by default
+func retryOptions() grpclient.RetryOptions {
+	budget := grpclient.Budget{MaxAttempts: 5, Timeout: time.Minute}
+
+	return grpclient.RetryOptions{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Download:       budget,
+		Upload:         budget,
+		UploadBuffer:   32 << 20,
+	}
+}
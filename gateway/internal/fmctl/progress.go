@@ -0,0 +1,175 @@
+package fmctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	barWidth       = 30
+	redrawInterval = 100 * time.Millisecond
+)
+
+// progress draws progress bars of transfers. Bars are drawn only
+// if output is a terminal, otherwise progress does nothing
+type progress struct {
+	out     io.Writer
+	enabled bool
+}
+
+func newProgress(out io.Writer, enabled bool) *progress {
+	if f, ok := out.(*os.File); !ok || !isatty.IsTerminal(f.Fd()) {
+		enabled = false
+	}
+
+	return &progress{out: out, enabled: enabled}
+}
+
+// bar tracks one transfer of name of size bytes. Negative size is unknown size
+func (p *progress) bar(name string, size int64) *bar {
+	return &bar{p: p, name: name, size: size, start: time.Now()}
+}
+
+type bar struct {
+	p     *progress
+	name  string
+	size  int64
+	start time.Time
+
+	mu    sync.Mutex
+	done  int64
+	drawn time.Time
+}
+
+func (b *bar) add(n int) {
+	if !b.p.enabled || n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.done += int64(n)
+	if time.Since(b.drawn) >= redrawInterval {
+		b.draw()
+	}
+}
+
+// finish draws the final state of the bar and ends its line
+func (b *bar) finish() {
+	if !b.p.enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.draw()
+	fmt.Fprintln(b.p.out)
+}
+
+func (b *bar) draw() {
+	b.drawn = time.Now()
+
+	speed := float64(b.done) / time.Since(b.start).Seconds()
+
+	if b.size <= 0 {
+		fmt.Fprintf(b.p.out, "\r%s  %s  %s/s\033[K", b.name, humanSize(b.done), humanSize(int64(speed)))
+		return
+	}
+
+	ratio := float64(b.done) / float64(b.size)
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * barWidth)
+
+	fmt.Fprintf(
+		b.p.out,
+		"\r%s [%s%s] %3.0f%%  %s/%s  %s/s\033[K",
+		b.name,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", barWidth-filled),
+		ratio*100,
+		humanSize(b.done),
+		humanSize(b.size),
+		humanSize(int64(speed)),
+	)
+}
+
+// reader counts bytes read through it. Seeking is passed to the
+// underlying reader, so retried uploads are reported correctly
+func (b *bar) reader(r io.Reader) io.Reader {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &barReadSeeker{barReader{r: rs, b: b}, rs}
+	}
+
+	return &barReader{r: r, b: b}
+}
+
+// writer counts bytes written through it
+func (b *bar) writer(w io.Writer) io.Writer {
+	return &barWriter{w: w, b: b}
+}
+
+type barReader struct {
+	r io.Reader
+	b *bar
+}
+
+func (r *barReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.b.add(n)
+
+	return n, err
+}
+
+type barReadSeeker struct {
+	barReader
+	s io.Seeker
+}
+
+func (r *barReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.s.Seek(offset, whence)
+	if err == nil {
+		r.b.mu.Lock()
+		r.b.done = pos
+		r.b.mu.Unlock()
+	}
+
+	return pos, err
+}
+
+type barWriter struct {
+	w io.Writer
+	b *bar
+}
+
+func (w *barWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.b.add(n)
+
+	return n, err
+}
+
+// humanSize formats size in bytes with binary units
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
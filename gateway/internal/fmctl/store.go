@@ -0,0 +1,203 @@
+package fmctl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store is the remote storage fmctl works with. Names are paths
+// relative to the root of the storage, the root itself is ".".
+// Missing files are reported by errors matching fs.ErrNotExist,
+// existing ones by errors matching fs.ErrExist
+type Store interface {
+	List(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Stat(ctx context.Context, name string) (grpclient.FileInfo, error)
+	// Get writes contents of file to w
+	Get(ctx context.Context, name string, w io.Writer) error
+	// Put creates file or replaces existing one. Missing parent
+	// directories are created
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Delete(ctx context.Context, name string) error
+	// Mkdir creates directory with missing parents
+	Mkdir(ctx context.Context, name string) error
+	Move(ctx context.Context, from string, to string) error
+	Close() error
+}
+
+// Patcher is implemented by stores which update files by delta
+type Patcher interface {
+	// Patch creates file or replaces existing one like Put, but sends only
+	// parts of existing file which are changed. It returns number of bytes
+	// of content sent, which is size if the whole file is sent
+	Patch(ctx context.Context, name string, r io.ReadSeeker, size int64) (int64, error)
+}
+
+// grpcStore talks to filemanagers directly
+type grpcStore struct {
+	client *grpclient.Client
+}
+
+func newGRPCStore(client *grpclient.Client) *grpcStore {
+	return &grpcStore{client: client}
+}
+
+func (s *grpcStore) List(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error) {
+	files, err := s.client.ListFiles(ctx, dir, recursive)
+	if err != nil {
+		return nil, grpcError("ls", dir, err)
+	}
+
+	return files, nil
+}
+
+func (s *grpcStore) Stat(ctx context.Context, name string) (grpclient.FileInfo, error) {
+	info, err := s.client.Stat(ctx, name)
+	if err != nil {
+		return grpclient.FileInfo{}, grpcError("stat", name, err)
+	}
+
+	return info, nil
+}
+
+// Get streams file into w as it is received, so the file is not kept in
+// memory. Broken stream is resumed by the reader of filemanager client
+func (s *grpcStore) Get(ctx context.Context, name string, w io.Writer) error {
+	r, err := s.client.OpenFile(ctx, name, 0)
+	if err != nil {
+		return grpcError("get", name, err)
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return grpcError("get", name, err)
+	}
+
+	return nil
+}
+
+func (s *grpcStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	header := fileHeader{name: path.Base(name), size: size}
+
+	_, err := s.client.Stat(ctx, name)
+	switch {
+	case err == nil:
+		err = s.client.PutFile(ctx, dataProvider(r), header, name)
+	case status.Code(err) == codes.NotFound:
+		// missing parents are created, as by the http store
+		err = s.client.PostFile(grpclient.WithParents(ctx), dataProvider(r), header, name)
+	}
+
+	return grpcError("put", name, err)
+}
+
+// Patch sends delta of existing file. New file and file which is changed
+// while delta is computed are sent whole
+func (s *grpcStore) Patch(ctx context.Context, name string, r io.ReadSeeker, size int64) (int64, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := s.client.PatchFile(ctx, r, size, name)
+	switch status.Code(err) {
+	case codes.OK:
+		return sent, nil
+	case codes.NotFound, codes.FailedPrecondition:
+	default:
+		return 0, grpcError("put", name, err)
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := s.Put(ctx, name, r, size); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (s *grpcStore) Delete(ctx context.Context, name string) error {
+	return grpcError("rm", name, s.client.DeleteFile(ctx, name))
+}
+
+func (s *grpcStore) Mkdir(ctx context.Context, name string) error {
+	return grpcError("mkdir", name, s.client.Mkdir(ctx, name))
+}
+
+func (s *grpcStore) Move(ctx context.Context, from string, to string) error {
+	return grpcError("mv", from, s.client.Move(ctx, from, to))
+}
+
+func (s *grpcStore) Close() error {
+	s.client.Stop()
+
+	return nil
+}
+
+// grpcError converts status of filemanager to error of the store
+func grpcError(op string, name string, err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	case codes.AlreadyExists:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return &fs.PathError{Op: op, Path: name, Err: errors.New(s.Message())}
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type fileHeader struct {
+	name string
+	size int64
+}
+
+func (h fileHeader) Name() string {
+	return h.name
+}
+
+func (h fileHeader) Size() int64 {
+	return h.size
+}
+
+// dataProvider adapts reader to upload of filemanager client.
+// Seekable readers stay seekable, so broken uploads are resumed
+func dataProvider(r io.Reader) grpclient.DataProvider {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return readSeekNopCloser{rs}
+	}
+
+	return io.NopCloser(r)
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// remoteName converts path given by user to name in the store
+func remoteName(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
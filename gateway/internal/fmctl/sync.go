@@ -0,0 +1,254 @@
+package fmctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remotePrefix marks side of sync which is in the store
+const remotePrefix = "fm:"
+
+// entry describes file of one side of sync
+type entry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// sync makes destination directory the same as source one. Files are copied
+// if they are missing, differ in size or source one is modified later
+func (c *cli) sync(args []string) error {
+	flags := c.flags()
+	del := flags.Bool("delete", false, "delete files of destination which are missing in source")
+	flags.BoolVar(&c.delta, "delta", false, "send only changed parts of updated files (grpc only)")
+	dryRun := flags.Bool("dry-run", false, "only report what would be done")
+	if err := c.parse(flags, args, 2); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+
+	src, dst := flags.Arg(0), flags.Arg(1)
+	upload := !strings.HasPrefix(src, remotePrefix) && strings.HasPrefix(dst, remotePrefix)
+	download := strings.HasPrefix(src, remotePrefix) && !strings.HasPrefix(dst, remotePrefix)
+	if !upload && !download {
+		return fmt.Errorf("exactly one of source and destination has to be in the store, mark it with %q", remotePrefix)
+	}
+
+	var (
+		srcFiles, dstFiles map[string]entry
+		err                error
+	)
+	if upload {
+		dst = remoteName(strings.TrimPrefix(dst, remotePrefix))
+		if srcFiles, err = listLocal(src, true); err != nil {
+			return err
+		}
+		if dstFiles, err = c.listRemote(dst, false); err != nil {
+			return err
+		}
+	} else {
+		src = remoteName(strings.TrimPrefix(src, remotePrefix))
+		if srcFiles, err = c.listRemote(src, true); err != nil {
+			return err
+		}
+		if dstFiles, err = listLocal(dst, false); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(srcFiles))
+	for name := range srcFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := srcFiles[name]
+		d, exists := dstFiles[name]
+
+		if exists && s.isDir != d.isDir {
+			return fmt.Errorf("%s is a file on one side and a directory on the other", name)
+		}
+		if exists && (s.isDir || !changed(s, d)) {
+			continue
+		}
+
+		if upload {
+			err = c.syncUpload(filepath.Join(src, filepath.FromSlash(name)), joinName(dst, name), s, *dryRun)
+		} else {
+			err = c.syncDownload(joinName(src, name), filepath.Join(dst, filepath.FromSlash(name)), s, *dryRun)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !*del {
+		return nil
+	}
+
+	var extra []string
+	for name := range dstFiles {
+		if _, ok := srcFiles[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	sortDeepestFirst(extra)
+
+	for _, name := range extra {
+		if upload {
+			name = joinName(dst, name)
+		} else {
+			name = filepath.Join(dst, filepath.FromSlash(name))
+		}
+
+		c.report(action{Op: "rm", Name: name, DryRun: *dryRun})
+		if *dryRun {
+			continue
+		}
+
+		if upload {
+			err = c.store.Delete(c.ctx, name)
+		} else {
+			err = os.Remove(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) syncUpload(local string, remote string, e entry, dryRun bool) error {
+	if e.isDir {
+		c.report(action{Op: "mkdir", Name: remote, DryRun: dryRun})
+		if dryRun {
+			return nil
+		}
+
+		err := c.store.Mkdir(c.ctx, remote)
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	}
+
+	if dryRun {
+		c.report(action{Op: "put", From: local, To: remote, Size: e.size, DryRun: true})
+		return nil
+	}
+
+	return c.putFile(local, remote)
+}
+
+func (c *cli) syncDownload(remote string, local string, e entry, dryRun bool) error {
+	if e.isDir {
+		c.report(action{Op: "mkdir", Name: local, DryRun: dryRun})
+		if dryRun {
+			return nil
+		}
+
+		return os.MkdirAll(local, 0o755)
+	}
+
+	if dryRun {
+		c.report(action{Op: "get", From: remote, To: local, Size: e.size, DryRun: true})
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return err
+	}
+
+	return c.getFile(grpclient.FileInfo{Name: remote, Size: e.size, ModTime: e.modTime}, local)
+}
+
+// changed reports whether file of destination has to be replaced by source one.
+// The store keeps modification time in seconds, so times are compared in seconds
+func changed(src entry, dst entry) bool {
+	if src.size != dst.size {
+		return true
+	}
+
+	return src.modTime.Truncate(time.Second).After(dst.modTime.Truncate(time.Second))
+}
+
+// listLocal describes contents of local directory by paths relative to it
+// with forward slashes. Missing directory is empty unless it is required
+func listLocal(root string, required bool) (map[string]entry, error) {
+	files := make(map[string]entry)
+
+	stat, err := os.Stat(root)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = entry{size: info.Size(), modTime: info.ModTime(), isDir: d.IsDir()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// listRemote describes contents of directory of the store by names relative
+// to it. Missing directory is empty unless it is required
+func (c *cli) listRemote(root string, required bool) (map[string]entry, error) {
+	files := make(map[string]entry)
+
+	info, err := c.store.Stat(c.ctx, root)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	list, err := c.store.List(c.ctx, root, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range list {
+		files[relName(root, f.Name)] = entry{size: f.Size, modTime: f.ModTime, isDir: f.IsDir}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,416 @@
+package fmctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// get downloads files. "-" as target writes files to stdout
+func (c *cli) get(args []string) error {
+	flags := c.flags()
+	recursive := flags.Bool("r", false, "download directories recursively")
+	if err := c.parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	sources, err := c.expandSources(flags.Args()[:flags.NArg()-1])
+	if err != nil {
+		return err
+	}
+	local := flags.Arg(flags.NArg() - 1)
+
+	if local == "-" {
+		for _, src := range sources {
+			info, err := c.store.Stat(c.ctx, src)
+			if err != nil {
+				return err
+			}
+			if info.IsDir {
+				return fmt.Errorf("%s is a directory, it can not be written to stdout", src)
+			}
+
+			bar := c.progress.bar(src, info.Size)
+			err = c.store.Get(c.ctx, src, bar.writer(c.stdout))
+			bar.finish()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stat, err := os.Stat(local)
+	localDir := err == nil && stat.IsDir()
+	if len(sources) > 1 && !localDir {
+		return fmt.Errorf("target %s is not a directory", local)
+	}
+
+	for _, src := range sources {
+		info, err := c.store.Stat(c.ctx, src)
+		if err != nil {
+			return err
+		}
+
+		dst := local
+		if localDir && src != "." {
+			dst = filepath.Join(local, path.Base(src))
+		}
+
+		if !info.IsDir {
+			if err := c.getFile(info, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if !*recursive {
+			return fmt.Errorf("%s is a directory, use -r to download it", src)
+		}
+		if err := c.getDir(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDir downloads contents of directory src into local directory dst
+func (c *cli) getDir(src string, dst string) error {
+	files, err := c.store.List(c.ctx, src, true)
+	if err != nil {
+		return err
+	}
+	sortFiles(files)
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		local := filepath.Join(dst, filepath.FromSlash(relName(src, f.Name)))
+
+		if f.IsDir {
+			if err := os.MkdirAll(local, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.getFile(f, local); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getFile downloads file into local path dst. File is written to temporary
+// file first, so broken download does not leave partly written file
+func (c *cli) getFile(info grpclient.FileInfo, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".fmctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bar := c.progress.bar(info.Name, info.Size)
+	err = c.store.Get(c.ctx, info.Name, bar.writer(tmp))
+	bar.finish()
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	// modification time of the remote file lets sync skip unchanged files
+	if err := os.Chtimes(dst, info.ModTime, info.ModTime); err != nil {
+		return err
+	}
+
+	c.report(action{Op: "get", From: info.Name, To: dst, Size: info.Size})
+	return nil
+}
+
+// put uploads files. "-" as source uploads stdin
+func (c *cli) put(args []string) error {
+	flags := c.flags()
+	recursive := flags.Bool("r", false, "upload directories recursively")
+	flags.BoolVar(&c.delta, "delta", false, "send only changed parts of existing files (grpc only)")
+	if err := c.parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	var sources []string
+	for _, pattern := range flags.Args()[:flags.NArg()-1] {
+		if pattern == "-" {
+			sources = append(sources, pattern)
+			continue
+		}
+
+		matches, err := expandLocal(pattern)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, matches...)
+	}
+	remote := flags.Arg(flags.NArg() - 1)
+
+	remoteDir, err := c.isRemoteDir(remote)
+	if err != nil {
+		return err
+	}
+	if len(sources) > 1 && !remoteDir {
+		return fmt.Errorf("target %s is not a directory", remote)
+	}
+
+	for _, src := range sources {
+		dst := remoteName(remote)
+
+		if src == "-" {
+			if remoteDir {
+				return fmt.Errorf("target %s is a directory, name of file is required to upload stdin", remote)
+			}
+			if err := c.putStdin(dst); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stat, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if remoteDir {
+			dst = joinName(dst, filepath.Base(src))
+		}
+
+		if !stat.IsDir() {
+			if err := c.putFile(src, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if !*recursive {
+			return fmt.Errorf("%s is a directory, use -r to upload it", src)
+		}
+		if err := c.putDir(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putDir uploads contents of local directory src into directory dst.
+// Empty directories are created too
+func (c *cli) putDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		name := dst
+		if rel != "." {
+			name = joinName(dst, filepath.ToSlash(rel))
+		}
+
+		switch {
+		case d.IsDir():
+			if name == "." {
+				return nil
+			}
+			err := c.store.Mkdir(c.ctx, name)
+			if errors.Is(err, fs.ErrExist) {
+				return nil
+			}
+			return err
+		case d.Type().IsRegular():
+			return c.putFile(p, name)
+		}
+
+		// symbolic links and special files are not uploaded
+		return nil
+	})
+}
+
+// putFile uploads local file src as dst
+func (c *cli) putFile(src string, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	sent, err := c.upload(dst, f, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	c.report(action{Op: "put", From: src, To: dst, Size: stat.Size(), Sent: sent})
+	return nil
+}
+
+// putStdin uploads stdin as dst. Stdin is spooled to temporary
+// file, so its size is known and broken upload can be resumed
+func (c *cli) putStdin(dst string) error {
+	tmp, err := os.CreateTemp("", "fmctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, c.stdin)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	sent, err := c.upload(dst, tmp, size)
+	if err != nil {
+		return err
+	}
+
+	c.report(action{Op: "put", From: "-", To: dst, Size: size, Sent: sent})
+	return nil
+}
+
+// upload puts size bytes of r as dst. With -delta only changed parts
+// of existing file are sent if the store supports it. It returns
+// number of bytes of content sent, which is 0 if it is not known
+func (c *cli) upload(dst string, r io.ReadSeeker, size int64) (int64, error) {
+	bar := c.progress.bar(dst, size)
+	defer bar.finish()
+
+	if p, ok := c.store.(Patcher); ok && c.delta {
+		return p.Patch(c.ctx, dst, bar.reader(r).(io.ReadSeeker), size)
+	}
+
+	return 0, c.store.Put(c.ctx, dst, bar.reader(r), size)
+}
+
+// cp copies files inside the store
+func (c *cli) cp(args []string) error {
+	flags := c.flags()
+	recursive := flags.Bool("r", false, "copy directories recursively")
+	if err := c.parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	sources, err := c.expandSources(flags.Args()[:flags.NArg()-1])
+	if err != nil {
+		return err
+	}
+	dst := flags.Arg(flags.NArg() - 1)
+
+	for _, src := range sources {
+		to, err := c.remoteTarget(src, dst, len(sources))
+		if err != nil {
+			return err
+		}
+
+		info, err := c.store.Stat(c.ctx, src)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			if err := c.copyFile(info, to); err != nil {
+				return err
+			}
+			continue
+		}
+		if !*recursive {
+			return fmt.Errorf("%s is a directory, use -r to copy it", src)
+		}
+		if to == src || strings.HasPrefix(to, src+"/") {
+			return fmt.Errorf("%s can not be copied into itself", src)
+		}
+
+		files, err := c.store.List(c.ctx, src, true)
+		if err != nil {
+			return err
+		}
+		sortFiles(files)
+
+		if err := c.store.Mkdir(c.ctx, to); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		for _, f := range files {
+			name := joinName(to, relName(src, f.Name))
+
+			if f.IsDir {
+				if err := c.store.Mkdir(c.ctx, name); err != nil && !errors.Is(err, fs.ErrExist) {
+					return err
+				}
+				continue
+			}
+			if err := c.copyFile(f, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies file of the store through temporary local file
+func (c *cli) copyFile(info grpclient.FileInfo, dst string) error {
+	tmp, err := os.CreateTemp("", "fmctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bar := c.progress.bar(info.Name, info.Size)
+	err = c.store.Get(c.ctx, info.Name, bar.writer(tmp))
+	bar.finish()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	bar = c.progress.bar(dst, info.Size)
+	err = c.store.Put(c.ctx, dst, bar.reader(tmp), info.Size)
+	bar.finish()
+	if err != nil {
+		return err
+	}
+
+	c.report(action{Op: "cp", From: info.Name, To: dst, Size: info.Size})
+	return nil
+}
+
+// relName returns name of file of the store relative to directory dir
+func relName(dir string, name string) string {
+	if dir == "." {
+		return name
+	}
+
+	return strings.TrimPrefix(name, dir+"/")
+}
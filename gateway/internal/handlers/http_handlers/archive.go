@@ -0,0 +1,146 @@
+package http_handlers
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/archive"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+)
+
+var errInvalidArchive = errors.New("invalid archive parameters")
+
+// archiveParams are query parameters of archive download
+type archiveParams struct {
+	format  string
+	include []string
+	exclude []string
+}
+
+func parseArchive(r *http.Request) (archiveParams, error) {
+	q := r.URL.Query()
+	p := archiveParams{
+		format:  q.Get("archive"),
+		include: q["include"],
+		exclude: q["exclude"],
+	}
+
+	if p.format != archive.FormatZip && p.format != archive.FormatTarGz {
+		return archiveParams{}, fmt.Errorf("%w: unknown format %q", errInvalidArchive, p.format)
+	}
+	for _, pattern := range append(p.include, p.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return archiveParams{}, fmt.Errorf("%w: invalid pattern %q", errInvalidArchive, pattern)
+		}
+	}
+
+	return p, nil
+}
+
+// lazyWriter writes header of response on the first write, so errors
+// occurred before any data is archived are still answered by status
+type lazyWriter struct {
+	w       http.ResponseWriter
+	header  func()
+	started bool
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.header()
+		l.started = true
+	}
+
+	return l.w.Write(p)
+}
+
+// serveArchive streams directory dir as archive. Entries are written as they
+// come from filemanager. If archiving fails after response is started, archive
+// is left unfinished, so client sees it is broken
+func serveArchive(log *slog.Logger, w http.ResponseWriter, r *http.Request, client FileManager, dir string) {
+	params, err := parseArchive(r)
+	if err != nil {
+		log.Warn("invalid archive parameters", sl.Err(err))
+		httperrors.Error(w, http.StatusBadRequest)
+		return
+	}
+
+	// large directories are archived longer than write timeout of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Error("failed to reset write deadline", sl.Err(err))
+		httperrors.Error(w, http.StatusInternalServerError)
+		return
+	}
+
+	name := path.Base(dir)
+	if dir == "." {
+		name = "root"
+	}
+
+	out := &lazyWriter{w: w, header: func() {
+		w.Header().Set("Content-Type", archive.ContentType(params.format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+params.format))
+		w.WriteHeader(http.StatusOK)
+	}}
+	aw, err := archive.NewWriter(params.format, out)
+	if err != nil {
+		log.Error("failed to create archive", sl.Err(err))
+		httperrors.Error(w, http.StatusInternalServerError)
+		return
+	}
+
+	files := 0
+	err = client.Archive(r.Context(), dir, params.include, params.exclude,
+		func(e grpclient.ArchiveEntry, data io.Reader) error {
+			if !e.IsDir {
+				files++
+			}
+			return aw.Add(e, data)
+		})
+	if err != nil {
+		if out.started {
+			log.Error("archiving is broken after response is started", sl.Err(err))
+			return
+		}
+
+		var httpErrCode int
+		switch status.Code(err) {
+		case codes.NotFound:
+			log.Warn("directory not found", sl.Err(err))
+			httpErrCode = http.StatusNotFound
+		case codes.InvalidArgument:
+			log.Warn("invalid archive request", sl.Err(err))
+			httpErrCode = http.StatusBadRequest
+		case codes.Canceled:
+			log.Warn("archiving is canceled", sl.Err(err))
+			return
+		default:
+			log.Error("failed to archive directory", sl.Err(err))
+			httpErrCode = http.StatusInternalServerError
+		}
+
+		httperrors.Error(w, httpErrCode)
+		return
+	}
+
+	if err := aw.Close(); err != nil {
+		log.Error("failed to finish archive", sl.Err(err))
+		return
+	}
+
+	log.Info(
+		"directory successfully archived",
+		slog.String("dir", dir),
+		slog.String("format", params.format),
+		slog.Int("files", files),
+	)
+}
@@ -0,0 +1,78 @@
+package http_handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/clients/fm/shard"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const defaultChangesLimit = 1000
+
+type changesResponse struct {
+	Changes []grpclient.Change `json:"changes"`
+	Cursor  string             `json:"cursor"`
+	HasMore bool               `json:"has_more"`
+}
+
+// NewChanges lists changes made after query parameter cursor.
+// Consumer passes returned cursor to the next call. Empty cursor means
+// the beginning, limit=0 returns only cursor of the last changes.
+// Expired cursor is reported as 410 Gone, then consumer has to resync
+func NewChanges(log *slog.Logger, client FileManager) http.HandlerFunc {
+	const method = "CHANGES"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to list changes")
+		var httpErrCode int
+
+		limit := defaultChangesLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 0 {
+				log.Warn("invalid limit parameter", slog.String("limit", v))
+				httperrors.Error(w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		changes, cursor, hasMore, err := client.ListChanges(r.Context(), r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			switch {
+			case errors.Is(err, shard.ErrInvalidCursor):
+				log.Warn("invalid cursor", sl.Err(err))
+				httpErrCode = http.StatusBadRequest
+			case status.Code(err) == codes.InvalidArgument:
+				log.Warn("invalid limit", sl.Err(err))
+				httpErrCode = http.StatusBadRequest
+			case status.Code(err) == codes.OutOfRange:
+				log.Warn("cursor is expired", sl.Err(err))
+				httpErrCode = http.StatusGone
+			default:
+				log.Error("failed to list changes", sl.Err(err))
+				httpErrCode = http.StatusInternalServerError
+			}
+
+			httperrors.Error(w, httpErrCode)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		resp := changesResponse{Changes: changes, Cursor: cursor, HasMore: hasMore}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Error("failed to write response", sl.Err(err))
+			return
+		}
+
+		log.Info("changes successfully listed", slog.Int("count", len(changes)))
+	})
+}
@@ -0,0 +1,49 @@
+package http_handlers
+
+import (
+	"errors"
+	"io/fs"
+	"lab3/internal/lib/dav"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/net/webdav"
+)
+
+// DAVPrefix is a path which webdav file system is mounted on
+const DAVPrefix = "/dav"
+
+// DAVMethods are methods of webdav which are not standard http methods
+var DAVMethods = []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// NewDAV serves files as webdav (class 1 and 2) file system. Locks are
+// kept in memory of the gateway, so clients sharing files have to use
+// the same gateway
+func NewDAV(log *slog.Logger, client FileManager) http.Handler {
+	const method = "DAV"
+	log = log.With(slog.String("method", method))
+
+	return &webdav.Handler{
+		Prefix:     DAVPrefix,
+		FileSystem: dav.New(client, ""),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			switch {
+			case err == nil:
+			case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrExist):
+				log.Debug("webdav request is rejected",
+					slog.String("request method", r.Method),
+					slog.String("path", r.URL.Path),
+					sl.Err(err),
+				)
+			default:
+				log.Warn("webdav request failed",
+					slog.String("request method", r.Method),
+					slog.String("path", r.URL.Path),
+					sl.Err(err),
+				)
+			}
+		},
+	}
+}
@@ -1,18 +1,16 @@
 package http_handlers
 
 import (
-	"context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io/fs"
-	grpclient "lab3/internal/clients/fm/grpc"
 	httperrors "lab3/internal/lib/http/errors"
 	"lab3/internal/lib/logger/sl"
 	"log/slog"
 	"net/http"
 )
 
-func NewDelete(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
+func NewDelete(log *slog.Logger, client FileManager) http.HandlerFunc {
 	const method = "DELETE"
 	log = log.With(slog.String("method", method))
 
@@ -25,7 +23,7 @@ func NewDelete(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
 			httperrors.Error(w, http.StatusBadRequest)
 		}
 
-		err := client.DeleteFile(context.Background(), filepath)
+		err := client.DeleteFile(r.Context(), filepath)
 		if err != nil {
 			switch status.Code(err) {
 			case codes.InvalidArgument:
@@ -0,0 +1,284 @@
+package http_handlers
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/archive"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// extractReport is a response to extraction. Error is set
+// if extraction is stopped before the end of archive
+type extractReport struct {
+	Dir     string                    `json:"dir"`
+	Summary map[string]int            `json:"summary"`
+	Entries []grpclient.ExtractResult `json:"entries"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// archiveError marks error of reading uploaded archive, which
+// stops extraction, but keeps entries which are extracted before
+type archiveError struct {
+	err error
+}
+
+func (e *archiveError) Error() string {
+	return e.err.Error()
+}
+
+func (e *archiveError) Unwrap() error {
+	return e.err
+}
+
+// entryReader marks errors of reading data of archive entry
+type entryReader struct {
+	r io.Reader
+}
+
+func (e entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = &archiveError{err: err}
+	}
+
+	return n, err
+}
+
+// sourceReader remembers error of reading uploaded data
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+
+	return n, err
+}
+
+// serveExtract extracts uploaded archive into directory given by query
+// parameter filepath. Archive is the body of request or the part "file"
+// of multipart form. Existing files are replaced if overwrite=true.
+// Every entry is reported, entries which are unsafe or are not regular
+// files and directories are not extracted
+func serveExtract(log *slog.Logger, w http.ResponseWriter, r *http.Request, client FileManager, limits archive.Limits) {
+	q := r.URL.Query()
+
+	dir := q.Get("filepath")
+	if dir == "" {
+		dir = "."
+	}
+	if !fs.ValidPath(dir) {
+		log.Warn("invalid directory path", slog.String("filepath", dir))
+		httperrors.Error(w, http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("extract")
+	switch format {
+	case archive.FormatZip, archive.FormatTar, archive.FormatTarGz, archive.FormatTarZst:
+	default:
+		log.Warn("unknown archive format", slog.String("format", format))
+		httperrors.Error(w, http.StatusBadRequest)
+		return
+	}
+
+	overwrite := false
+	if v := q.Get("overwrite"); v != "" {
+		var err error
+		overwrite, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Warn("invalid overwrite parameter", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// large archive is uploaded longer than timeouts of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Error("failed to reset read deadline", sl.Err(err))
+		httperrors.Error(w, http.StatusInternalServerError)
+		return
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Error("failed to reset write deadline", sl.Err(err))
+		httperrors.Error(w, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := uploadedArchive(r)
+	if err != nil {
+		log.Warn("failed to get archive from request", sl.Err(err))
+		httperrors.Error(w, http.StatusBadRequest)
+		return
+	}
+
+	src := &sourceReader{r: body}
+	ar, err := archive.NewReader(format, src, limits)
+	if err != nil {
+		log.Warn("failed to open archive", sl.Err(err))
+		httperrors.Error(w, archiveStatus(err, src))
+		return
+	}
+	defer ar.Close()
+
+	// results holds results of entries rejected here, results
+	// of sent entries are filled in when extraction is finished
+	var (
+		results []grpclient.ExtractResult
+		sent    []int
+		stopErr error
+	)
+	extracted, err := client.Extract(r.Context(), func(add func(grpclient.ExtractEntry, io.Reader) error) error {
+		for {
+			e, data, err := ar.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				stopErr = err
+				return nil
+			}
+
+			name, ok := archive.SafeName(e.Name)
+			if !ok {
+				log.Warn("unsafe archive entry", slog.String("name", e.Name))
+				results = append(results, grpclient.ExtractResult{
+					Name:   e.Name,
+					Status: grpclient.ExtractFailed,
+					Error:  "unsafe path",
+				})
+				continue
+			}
+			if e.Type != 0 && e.Type != fs.ModeDir {
+				results = append(results, grpclient.ExtractResult{
+					Name:   e.Name,
+					Status: grpclient.ExtractSkipped,
+					Error:  "not a regular file or directory",
+				})
+				continue
+			}
+
+			sent = append(sent, len(results))
+			results = append(results, grpclient.ExtractResult{})
+
+			err = add(grpclient.ExtractEntry{
+				Name:      path.Join(dir, name),
+				Size:      e.Size,
+				IsDir:     e.Type == fs.ModeDir,
+				ModTime:   e.ModTime,
+				Overwrite: overwrite,
+			}, entryReader{r: data})
+			var aerr *archiveError
+			if errors.As(err, &aerr) {
+				// entry which is sent partly is reported as failed by filemanager
+				stopErr = aerr.err
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		var httpErrCode int
+		switch status.Code(err) {
+		case codes.Canceled:
+			log.Warn("extraction is canceled", sl.Err(err))
+			return
+		case codes.InvalidArgument:
+			log.Warn("bad request", sl.Err(err))
+			httpErrCode = http.StatusBadRequest
+		default:
+			log.Error("failed to extract archive", sl.Err(err))
+			httpErrCode = http.StatusInternalServerError
+		}
+
+		httperrors.Error(w, httpErrCode)
+		return
+	}
+
+	for i, idx := range sent {
+		if i < len(extracted) {
+			results[idx] = extracted[i]
+		}
+	}
+
+	report := extractReport{Dir: dir, Summary: make(map[string]int), Entries: results}
+	for _, res := range results {
+		report.Summary[res.Status]++
+	}
+
+	code := http.StatusOK
+	if stopErr != nil {
+		log.Warn("extraction is stopped", sl.Err(stopErr))
+		report.Error = stopErr.Error()
+		code = archiveStatus(stopErr, src)
+	}
+
+	writeJSON(w, log, code, report)
+	log.Info(
+		"archive is extracted",
+		slog.String("dir", dir),
+		slog.String("format", format),
+		slog.Int("entries", len(results)),
+	)
+}
+
+// uploadedArchive returns body of request or, if it is a multipart
+// form, its part "file". Form is read as it comes, it is not buffered
+func uploadedArchive(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("no file in form: %w", err)
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// archiveStatus returns http status of error which stopped reading archive.
+// Broken upload is a bad request, broken archive can not be processed
+func archiveStatus(err error, src *sourceReader) int {
+	switch {
+	case isLimit(err):
+		return http.StatusRequestEntityTooLarge
+	case src.err != nil:
+		return http.StatusBadRequest
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+func isLimit(err error) bool {
+	return errors.Is(err, archive.ErrTooManyEntries) ||
+		errors.Is(err, archive.ErrTooLarge) ||
+		errors.Is(err, archive.ErrRatio)
+}
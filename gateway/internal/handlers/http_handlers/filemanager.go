@@ -0,0 +1,50 @@
+package http_handlers
+
+import (
+	"context"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+)
+
+// FileManager stores files served by handlers
+type FileManager interface {
+	GetFile(ctx context.Context, filename string) ([]byte, error)
+	OpenFile(ctx context.Context, filename string, offset int64) (*grpclient.FileReader, error)
+	PostFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	PutFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	DeleteFile(ctx context.Context, filename string) error
+	ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Stat(ctx context.Context, name string) (grpclient.FileInfo, error)
+	Mkdir(ctx context.Context, name string) error
+	Move(ctx context.Context, from string, to string) error
+	ListChanges(ctx context.Context, cursor string, limit int) ([]grpclient.Change, string, bool, error)
+	Watch(
+		ctx context.Context,
+		prefix string,
+		recursive bool,
+		ops []string,
+		fn func(grpclient.Event) error,
+	) error
+	Archive(
+		ctx context.Context,
+		dir string,
+		include []string,
+		exclude []string,
+		fn func(e grpclient.ArchiveEntry, r io.Reader) error,
+	) error
+	Extract(
+		ctx context.Context,
+		fn func(add func(e grpclient.ExtractEntry, r io.Reader) error) error,
+	) ([]grpclient.ExtractResult, error)
+	Ready(ctx context.Context) error
+}
@@ -1,19 +1,20 @@
 package http_handlers
 
 import (
-	"context"
 	"fmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io/fs"
-	grpclient "lab3/internal/clients/fm/grpc"
 	httperrors "lab3/internal/lib/http/errors"
 	"lab3/internal/lib/logger/sl"
 	"log/slog"
 	"net/http"
 )
 
-func NewGet(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
+// NewGet serves file given by query parameter filepath. With archive=zip
+// or archive=tar.gz the filepath is a directory, which is served as archive
+// of its files selected by repeated include and exclude glob patterns
+func NewGet(log *slog.Logger, client FileManager) http.HandlerFunc {
 	const method = "GET"
 	log = log.With(slog.String("method", method))
 
@@ -22,13 +23,22 @@ func NewGet(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
 		log.Info("attempting to get file from grpc-server")
 
 		filepath := r.URL.Query().Get("filepath")
+		archived := r.URL.Query().Has("archive")
+		if filepath == "" && archived {
+			filepath = "."
+		}
 		if !fs.ValidPath(filepath) {
 			log.Warn("invalid file path", slog.String("filepath", filepath))
 			httperrors.Error(w, http.StatusBadRequest)
 			return
 		}
 
-		res, err := client.GetFile(context.Background(), filepath)
+		if archived {
+			serveArchive(log, w, r, client, filepath)
+			return
+		}
+
+		res, err := client.GetFile(r.Context(), filepath)
 		if err != nil {
 			switch status.Code(err) {
 			case codes.NotFound:
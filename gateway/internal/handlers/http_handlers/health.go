@@ -0,0 +1,42 @@
+package http_handlers
+
+import (
+	"context"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	readinessTimeout = 2 * time.Second
+)
+
+// NewHealthz reports that gateway process is alive
+func NewHealthz() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// NewReadyz reports that gateway is able to serve requests:
+// grpc connection is up and filemanager health probe succeeds
+func NewReadyz(log *slog.Logger, client FileManager) http.HandlerFunc {
+	const method = "READYZ"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := client.Ready(ctx); err != nil {
+			log.Warn("gateway is not ready", sl.Err(err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+}
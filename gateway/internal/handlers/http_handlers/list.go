@@ -0,0 +1,69 @@
+package http_handlers
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/fs"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// NewList lists directory given by query parameter path.
+// With recursive=true entries of all subdirectories are listed too
+func NewList(log *slog.Logger, client FileManager) http.HandlerFunc {
+	const method = "LIST"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to list directory")
+		var httpErrCode int
+
+		dir := r.URL.Query().Get("path")
+		if dir == "" {
+			dir = "."
+		}
+		if !fs.ValidPath(dir) {
+			log.Warn("invalid directory path", slog.String("path", dir))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		recursive := false
+		if v := r.URL.Query().Get("recursive"); v != "" {
+			var err error
+			recursive, err = strconv.ParseBool(v)
+			if err != nil {
+				log.Warn("invalid recursive parameter", sl.Err(err))
+				httperrors.Error(w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		files, err := client.ListFiles(r.Context(), dir, recursive)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.NotFound:
+				log.Warn("directory not found", sl.Err(err))
+				httpErrCode = http.StatusNotFound
+			default:
+				log.Error("failed to list directory", sl.Err(err))
+				httpErrCode = http.StatusInternalServerError
+			}
+
+			httperrors.Error(w, httpErrCode)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(files); err != nil {
+			log.Error("failed to write response", sl.Err(err))
+			return
+		}
+
+		log.Info("directory successfully listed", slog.Int("count", len(files)))
+	})
+}
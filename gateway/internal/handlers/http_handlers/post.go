@@ -1,11 +1,10 @@
 package http_handlers
 
 import (
-	"context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io/fs"
-	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/archive"
 	httperrors "lab3/internal/lib/http/errors"
 	"lab3/internal/lib/logger/sl"
 	"log/slog"
@@ -24,7 +23,10 @@ func (m *MyHeader) Size() int64 {
 	return m.header.Size
 }
 
-func NewPost(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
+// NewPost uploads new file given by form value filepath. With query
+// parameter extract=zip|tar|tar.gz|tar.zst uploaded archive is extracted
+// into directory filepath, see serveExtract
+func NewPost(log *slog.Logger, client FileManager, extractLimits archive.Limits) http.HandlerFunc {
 	const method = "POST"
 	log = log.With(slog.String("method", method))
 
@@ -32,6 +34,12 @@ func NewPost(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
 		log.Info("attempting to post file on the grpc-server")
 		var httpErrCode int
 
+		// form is not parsed before extraction, archive is read as it comes
+		if r.URL.Query().Has("extract") {
+			serveExtract(log, w, r, client, extractLimits)
+			return
+		}
+
 		filepath := r.FormValue("filepath")
 		if !fs.ValidPath(filepath) {
 			log.Warn("invalid filepath", slog.String("filepath", filepath))
@@ -47,12 +55,15 @@ func NewPost(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
 		}
 		defer file.Close()
 
-		err = client.PostFile(context.Background(), file, &MyHeader{fileHeader}, filepath)
+		err = client.PostFile(r.Context(), file, &MyHeader{fileHeader}, filepath)
 		if err != nil {
 			switch status.Code(err) {
 			case codes.InvalidArgument:
 				log.Warn("bad request", sl.Err(err))
 				httpErrCode = http.StatusBadRequest
+			case codes.AlreadyExists:
+				log.Warn("file already exists", sl.Err(err))
+				httpErrCode = http.StatusConflict
 			case codes.DataLoss:
 				log.Error("data was loss", sl.Err(err))
 				httpErrCode = http.StatusInternalServerError
@@ -1,18 +1,16 @@
 package http_handlers
 
 import (
-	"context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"io/fs"
-	grpclient "lab3/internal/clients/fm/grpc"
 	httperrors "lab3/internal/lib/http/errors"
 	"lab3/internal/lib/logger/sl"
 	"log/slog"
 	"net/http"
 )
 
-func NewPut(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
+func NewPut(log *slog.Logger, client FileManager) http.HandlerFunc {
 	const method = "PUT"
 	log = log.With(slog.String("method", method))
 
@@ -35,7 +33,7 @@ func NewPut(log *slog.Logger, client *grpclient.Client) http.HandlerFunc {
 		}
 		defer file.Close()
 
-		err = client.PutFile(context.Background(), file, &MyHeader{fileHeader}, filepath)
+		err = client.PutFile(r.Context(), file, &MyHeader{fileHeader}, filepath)
 		if err != nil {
 			switch status.Code(err) {
 			case codes.InvalidArgument:
@@ -0,0 +1,277 @@
+package http_handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/fs"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/share"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ShareOptions struct {
+	// AdminToken is a bearer token required to mint links.
+	// Links are not minted if it is empty
+	AdminToken string
+	BaseURL    string
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+type shareRequest struct {
+	Filepath     string `json:"filepath"`
+	Method       string `json:"method"`
+	TTL          string `json:"ttl"`
+	MaxDownloads int    `json:"max_downloads"`
+}
+
+type shareResponse struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewShare mints signed share link for the file. It is served only to
+// bearer of the admin token, see ShareOptions.
+// Request body is json with fields filepath, method (GET, POST or PUT),
+// ttl (e.g. "2h") and max_downloads
+func NewShare(log *slog.Logger, signer *share.Signer, opts ShareOptions) http.HandlerFunc {
+	const method = "SHARE"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to create share link")
+
+		var req shareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("failed to decode request", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if !fs.ValidPath(req.Filepath) {
+			log.Warn("invalid filepath", slog.String("filepath", req.Filepath))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+		switch req.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut:
+		default:
+			log.Warn("unsupported share method", slog.String("share method", req.Method))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		ttl := opts.DefaultTTL
+		if req.TTL != "" {
+			var err error
+			ttl, err = time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				log.Warn("invalid ttl", slog.String("ttl", req.TTL))
+				httperrors.Error(w, http.StatusBadRequest)
+				return
+			}
+		}
+		if ttl > opts.MaxTTL {
+			log.Warn("ttl exceeds maximum", slog.String("ttl", ttl.String()))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if req.MaxDownloads < 0 {
+			log.Warn("invalid max downloads", slog.Int("max downloads", req.MaxDownloads))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		token, err := signer.Sign(share.Link{
+			Path:         req.Filepath,
+			Method:       req.Method,
+			ExpiresAt:    expiresAt.Unix(),
+			MaxDownloads: req.MaxDownloads,
+		})
+		if err != nil {
+			log.Error("failed to sign share link", sl.Err(err))
+			httperrors.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		baseURL := opts.BaseURL
+		if baseURL == "" {
+			baseURL = "http://" + r.Host
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(w).Encode(shareResponse{
+			URL:       baseURL + "/share/" + token,
+			Token:     token,
+			ExpiresAt: time.Unix(expiresAt.Unix(), 0).UTC(),
+		})
+		if err != nil {
+			log.Error("failed to write response", sl.Err(err))
+			return
+		}
+
+		log.Info(
+			"share link created",
+			slog.String("filepath", req.Filepath),
+			slog.String("share method", req.Method),
+		)
+	})
+}
+
+// NewShareGet streams file of the share link without any other authentication
+func NewShareGet(log *slog.Logger, client FileManager, signer *share.Signer) http.HandlerFunc {
+	const method = "SHARE GET"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var httpErrCode int
+		log.Info("attempting to get shared file")
+
+		link, ok := verifyLink(log, w, r, signer)
+		if !ok {
+			return
+		}
+
+		slot, err := signer.Acquire(r.Context(), link, time.Now())
+		if errors.Is(err, share.ErrLimitExceeded) {
+			log.Warn("share link is exhausted", sl.Err(err))
+			httperrors.Error(w, http.StatusGone)
+			return
+		}
+		if err != nil {
+			log.Error("failed to count download", sl.Err(err))
+			httperrors.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		file, err := client.OpenFile(r.Context(), link.Path, 0)
+		if err != nil {
+			if err := signer.Release(r.Context(), slot); err != nil {
+				log.Error("failed to release download", sl.Err(err))
+			}
+
+			switch status.Code(err) {
+			case codes.NotFound:
+				log.Warn("shared file is not found", sl.Err(err))
+				httpErrCode = http.StatusNotFound
+			default:
+				log.Error("unexpected error from gRPC server", sl.Err(err))
+				httpErrCode = http.StatusInternalServerError
+			}
+
+			httperrors.Error(w, httpErrCode)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", link.Path))
+		w.Header().Set("Content-Length", strconv.FormatInt(file.Info().Size, 10))
+		w.WriteHeader(http.StatusOK)
+
+		// download is counted even if it is broken, the file may be read
+		_, err = io.Copy(w, file)
+		if err != nil {
+			log.Error("failed to write response", sl.Err(err))
+			return
+		}
+
+		log.Info("shared file successfully served", slog.String("filepath", link.Path))
+	})
+}
+
+// NewShareUpload receives file from multipart form field "file" and
+// uploads it into the path fixed by the share link
+func NewShareUpload(log *slog.Logger, client FileManager, signer *share.Signer) http.HandlerFunc {
+	const method = "SHARE UPLOAD"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var httpErrCode int
+		log.Info("attempting to upload shared file")
+
+		link, ok := verifyLink(log, w, r, signer)
+		if !ok {
+			return
+		}
+
+		file, fileHeader, err := r.FormFile("file")
+		if err != nil {
+			log.Error("failed to get file from form", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if link.Method == http.MethodPost {
+			err = client.PostFile(r.Context(), file, &MyHeader{fileHeader}, link.Path)
+		} else {
+			err = client.PutFile(r.Context(), file, &MyHeader{fileHeader}, link.Path)
+		}
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				log.Warn("bad request", sl.Err(err))
+				httpErrCode = http.StatusBadRequest
+			case codes.AlreadyExists:
+				log.Warn("file already exists", sl.Err(err))
+				httpErrCode = http.StatusConflict
+			default:
+				log.Error("unexpected error from grpc server", sl.Err(err))
+				httpErrCode = http.StatusInternalServerError
+			}
+
+			httperrors.Error(w, httpErrCode)
+			return
+		}
+
+		if link.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		log.Info("shared file successfully uploaded", slog.String("filepath", link.Path))
+	})
+}
+
+// verifyLink verifies token from url and writes error response if it is invalid
+func verifyLink(
+	log *slog.Logger,
+	w http.ResponseWriter,
+	r *http.Request,
+	signer *share.Signer,
+) (share.Link, bool) {
+	link, err := signer.Verify(chi.URLParam(r, "token"), r.Method, time.Now())
+	if err != nil {
+		log.Warn("share link is rejected", sl.Err(err))
+
+		switch {
+		case errors.Is(err, share.ErrExpired):
+			httperrors.Error(w, http.StatusGone)
+		case errors.Is(err, share.ErrMethod):
+			httperrors.Error(w, http.StatusMethodNotAllowed)
+		default:
+			httperrors.Error(w, http.StatusForbidden)
+		}
+		return share.Link{}, false
+	}
+
+	return link, true
+}
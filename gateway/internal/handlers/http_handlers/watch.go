@@ -0,0 +1,231 @@
+package http_handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// watchKeepAlive is an interval of comments sent to idle event stream,
+// so proxies do not close it
+const watchKeepAlive = 15 * time.Second
+
+var (
+	errInvalidWatch = errors.New("invalid watch parameters")
+
+	watchOps = []string{"create", "update", "delete", "move", "mkdir"}
+)
+
+type watchParams struct {
+	prefix    string
+	recursive bool
+	ops       []string
+}
+
+// parseWatch reads query parameters path, recursive and events.
+// Events is a comma separated list of kinds of changes, empty means all
+func parseWatch(r *http.Request) (watchParams, error) {
+	q := r.URL.Query()
+	p := watchParams{prefix: q.Get("path")}
+
+	if p.prefix == "" {
+		p.prefix = "."
+	}
+	if !fs.ValidPath(p.prefix) {
+		return watchParams{}, fmt.Errorf("%w: path %q", errInvalidWatch, p.prefix)
+	}
+
+	if v := q.Get("recursive"); v != "" {
+		var err error
+		p.recursive, err = strconv.ParseBool(v)
+		if err != nil {
+			return watchParams{}, fmt.Errorf("%w: %w", errInvalidWatch, err)
+		}
+	}
+
+	if v := q.Get("events"); v != "" {
+		for _, op := range strings.Split(v, ",") {
+			if !slices.Contains(watchOps, op) {
+				return watchParams{}, fmt.Errorf("%w: unknown event %q", errInvalidWatch, op)
+			}
+			p.ops = append(p.ops, op)
+		}
+	}
+
+	return p, nil
+}
+
+// NewWatch streams changes of files as server-sent events. Event name
+// is a kind of change, data is the change in json. If watch is broken,
+// "error" event is sent and stream is closed, client reconnects
+func NewWatch(log *slog.Logger, client FileManager) http.HandlerFunc {
+	const method = "WATCH"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to watch files")
+
+		params, err := parseWatch(r)
+		if err != nil {
+			log.Warn("invalid watch parameters", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		// event stream lives longer than write timeout of the server
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Error("failed to reset write deadline", sl.Err(err))
+			httperrors.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		var mu sync.Mutex
+		send := func(event string, data any) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			payload, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+				return err
+			}
+
+			return rc.Flush()
+		}
+		if err := rc.Flush(); err != nil {
+			log.Error("failed to start event stream", sl.Err(err))
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			ticker := time.NewTicker(watchKeepAlive)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				mu.Lock()
+				_, err := fmt.Fprint(w, ": keep-alive\n\n")
+				if err == nil {
+					err = rc.Flush()
+				}
+				mu.Unlock()
+				if err != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+
+		err = client.Watch(ctx, params.prefix, params.recursive, params.ops, func(e grpclient.Event) error {
+			return send(e.Op, e)
+		})
+		if ctx.Err() != nil {
+			log.Info("watch is finished")
+			return
+		}
+
+		log.Error("watch is broken", sl.Err(err))
+		_ = send("error", map[string]string{"error": watchError(err)})
+	})
+}
+
+// NewWatchWS streams changes of files over websocket, every message
+// is the change in json. Watch parameters are the same as of NewWatch
+func NewWatchWS(log *slog.Logger, client FileManager) http.HandlerFunc {
+	const method = "WATCH_WS"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to watch files over websocket")
+
+		params, err := parseWatch(r)
+		if err != nil {
+			log.Warn("invalid watch parameters", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		srv := websocket.Server{
+			// origin is not checked, like cors of other endpoints
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				// connection is hijacked with deadlines of the server
+				if err := ws.SetDeadline(time.Time{}); err != nil {
+					log.Error("failed to reset deadline", sl.Err(err))
+					return
+				}
+
+				ctx, cancel := context.WithCancel(r.Context())
+				defer cancel()
+
+				// messages of the client are not expected,
+				// reading only detects closed connection
+				go func() {
+					defer cancel()
+
+					var msg []byte
+					for websocket.Message.Receive(ws, &msg) == nil {
+					}
+				}()
+
+				err := client.Watch(ctx, params.prefix, params.recursive, params.ops, func(e grpclient.Event) error {
+					return websocket.JSON.Send(ws, e)
+				})
+				if ctx.Err() != nil {
+					log.Info("watch is finished")
+					return
+				}
+
+				log.Error("watch is broken", sl.Err(err))
+				_ = websocket.JSON.Send(ws, map[string]string{"error": watchError(err)})
+			},
+		}
+
+		srv.ServeHTTP(w, r)
+	})
+}
+
+// watchError describes why watch is broken for the client
+func watchError(err error) string {
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		return "events are not received in time"
+	case codes.Unavailable:
+		return "filemanager is unavailable"
+	}
+
+	return "internal error"
+}
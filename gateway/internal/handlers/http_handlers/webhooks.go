@@ -0,0 +1,144 @@
+package http_handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	httperrors "lab3/internal/lib/http/errors"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/webhook"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const defaultDeliveriesLimit = 100
+
+// Webhooks manages webhook subscriptions and keeps history of deliveries
+type Webhooks interface {
+	Subscriptions() []webhook.Subscription
+	Subscribe(sub webhook.Subscription) (webhook.Subscription, error)
+	Unsubscribe(id string) error
+	Deliveries(subscription string, status string, limit int) []webhook.Delivery
+}
+
+// NewWebhookList lists subscriptions without their secrets
+func NewWebhookList(log *slog.Logger, webhooks Webhooks) http.HandlerFunc {
+	const method = "WEBHOOK_LIST"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, log, http.StatusOK, webhooks.Subscriptions())
+	})
+}
+
+// NewWebhookCreate creates subscription. Request body is json with fields
+// id, url, secret, path, recursive and events. Missing id and secret are
+// generated, the secret is returned only in this response
+func NewWebhookCreate(log *slog.Logger, webhooks Webhooks) http.HandlerFunc {
+	const method = "WEBHOOK_CREATE"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("attempting to create webhook subscription")
+
+		var req webhook.Subscription
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Warn("failed to decode request", sl.Err(err))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		sub, err := webhooks.Subscribe(req)
+		if err != nil {
+			switch {
+			case errors.Is(err, webhook.ErrInvalidSubscription):
+				log.Warn("invalid subscription", sl.Err(err))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, webhook.ErrExists):
+				log.Warn("subscription already exists", sl.Err(err))
+				httperrors.Error(w, http.StatusConflict)
+			default:
+				log.Error("failed to create subscription", sl.Err(err))
+				httperrors.Error(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeJSON(w, log, http.StatusCreated, sub)
+		log.Info("webhook subscription is created", slog.String("id", sub.ID))
+	})
+}
+
+// NewWebhookDelete removes subscription given by path parameter id
+func NewWebhookDelete(log *slog.Logger, webhooks Webhooks) http.HandlerFunc {
+	const method = "WEBHOOK_DELETE"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		log.Info("attempting to delete webhook subscription", slog.String("id", id))
+
+		if err := webhooks.Unsubscribe(id); err != nil {
+			switch {
+			case errors.Is(err, webhook.ErrNotFound):
+				log.Warn("subscription not found", sl.Err(err))
+				httperrors.Error(w, http.StatusNotFound)
+			case errors.Is(err, webhook.ErrStatic):
+				log.Warn("subscription is configured statically", sl.Err(err))
+				httperrors.Error(w, http.StatusForbidden)
+			default:
+				log.Error("failed to delete subscription", sl.Err(err))
+				httperrors.Error(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// NewWebhookDeliveries lists the latest deliveries, pending ones first.
+// Query parameters subscription and status (pending, delivered, failed)
+// filter them, limit is a maximum number of deliveries
+func NewWebhookDeliveries(log *slog.Logger, webhooks Webhooks) http.HandlerFunc {
+	const method = "WEBHOOK_DELIVERIES"
+	log = log.With(slog.String("method", method))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		limit := defaultDeliveriesLimit
+		if v := q.Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				log.Warn("invalid limit parameter", slog.String("limit", v))
+				httperrors.Error(w, http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch q.Get("status") {
+		case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+		default:
+			log.Warn("invalid status parameter", slog.String("status", q.Get("status")))
+			httperrors.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		deliveries := webhooks.Deliveries(q.Get("subscription"), q.Get("status"), limit)
+		if deliveries == nil {
+			deliveries = []webhook.Delivery{}
+		}
+		writeJSON(w, log, http.StatusOK, deliveries)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, log *slog.Logger, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("failed to write response", sl.Err(err))
+	}
+}
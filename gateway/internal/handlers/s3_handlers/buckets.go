@@ -0,0 +1,394 @@
+package s3_handlers
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/s3"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+	maxKeys = 1000
+)
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// listBuckets lists top-level directories of the store
+func (h *Handler) listBuckets(w http.ResponseWriter, r *request) error {
+	files, err := h.fm.ListFiles(r.Context(), ".", false)
+	if err != nil {
+		return err
+	}
+
+	res := listBucketsResult{
+		Xmlns:   xmlns,
+		Owner:   owner{ID: r.auth.AccessKey, DisplayName: r.auth.AccessKey},
+		Buckets: []bucket{},
+	}
+	for _, f := range files {
+		if f.IsDir {
+			res.Buckets = append(res.Buckets, bucket{Name: f.Name, CreationDate: isoTime(f.ModTime)})
+		}
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, res)
+	return nil
+}
+
+func (h *Handler) headBucket(w http.ResponseWriter, r *request) error {
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	w.Header().Set("X-Amz-Bucket-Region", h.opts.Region)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type locationResult struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+func (h *Handler) bucketLocation(w http.ResponseWriter, r *request) error {
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	// us-east-1 is reported as empty location constraint
+	location := h.opts.Region
+	if location == "us-east-1" {
+		location = ""
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, locationResult{Xmlns: xmlns, Location: location})
+	return nil
+}
+
+// createBucket creates top-level directory. Location
+// constraint in the body is ignored
+func (h *Handler) createBucket(w http.ResponseWriter, r *request) error {
+	err := h.fm.Mkdir(r.Context(), r.bucket)
+	if status.Code(err) == codes.AlreadyExists {
+		return s3.ErrBucketAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", "/"+r.bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteBucket deletes empty top-level directory
+func (h *Handler) deleteBucket(w http.ResponseWriter, r *request) error {
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	files, err := h.fm.ListFiles(r.Context(), r.bucket, false)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return s3.ErrBucketNotEmpty
+	}
+
+	if err := h.fm.DeleteFile(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *owner `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listObjectsV2Result struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listObjectsResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []object       `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listing is one page of objects of bucket
+type listing struct {
+	objects   []object
+	prefixes  []commonPrefix
+	truncated bool
+	// last is the last key or common prefix of the page
+	last string
+}
+
+// list returns page of objects with keys starting with prefix which
+// follow after. Keys containing delimiter after prefix are grouped into
+// common prefixes. Empty directories are listed as keys ending with slash
+func (h *Handler) list(
+	r *request,
+	prefix string,
+	delimiter string,
+	after string,
+	limit int,
+	fetchOwner bool,
+) (listing, error) {
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return listing{}, err
+	}
+
+	files, err := h.fm.ListFiles(r.Context(), r.bucket, true)
+	if err != nil {
+		return listing{}, err
+	}
+
+	keys := make([]grpclient.FileInfo, 0, len(files))
+	for _, f := range files {
+		key := strings.TrimPrefix(f.Name, r.bucket+"/")
+		if f.IsDir {
+			key += "/"
+		}
+		if strings.HasPrefix(key, prefix) {
+			f.Name = key
+			keys = append(keys, f)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+
+	var res listing
+	for i, f := range keys {
+		// directory is listed only if it is empty
+		if f.IsDir && i+1 < len(keys) && strings.HasPrefix(keys[i+1].Name, f.Name) {
+			continue
+		}
+
+		entry, isPrefix := f.Name, false
+		if delimiter != "" {
+			if idx := strings.Index(f.Name[len(prefix):], delimiter); idx >= 0 {
+				entry, isPrefix = f.Name[:len(prefix)+idx+len(delimiter)], true
+			}
+		}
+		if entry <= after {
+			continue
+		}
+
+		if len(res.objects)+len(res.prefixes) == limit {
+			res.truncated = true
+			break
+		}
+		res.last = entry
+
+		if isPrefix {
+			res.prefixes = append(res.prefixes, commonPrefix{Prefix: entry})
+			after = entry
+			continue
+		}
+
+		obj := object{
+			Key:          f.Name,
+			LastModified: isoTime(f.ModTime),
+			ETag:         etag(f),
+			Size:         f.Size,
+			StorageClass: "STANDARD",
+		}
+		if f.IsDir {
+			obj.Size = 0
+			obj.ETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+		}
+		if fetchOwner {
+			obj.Owner = &owner{ID: r.auth.AccessKey, DisplayName: r.auth.AccessKey}
+		}
+		res.objects = append(res.objects, obj)
+	}
+
+	return res, nil
+}
+
+// listParams reads max-keys and encoding-type query parameters
+func listParams(r *request) (int, bool, error) {
+	q := r.URL.Query()
+
+	limit := maxKeys
+	if v := q.Get("max-keys"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return 0, false, s3.ErrInvalidArgument.WithMessage("max-keys must be a non-negative integer")
+		}
+		limit = min(limit, maxKeys)
+	}
+
+	switch q.Get("encoding-type") {
+	case "":
+		return limit, false, nil
+	case "url":
+		return limit, true, nil
+	}
+
+	return 0, false, s3.ErrInvalidArgument.WithMessage("Invalid Encoding Method specified in Request")
+}
+
+func (h *Handler) listObjectsV2(w http.ResponseWriter, r *request) error {
+	q := r.URL.Query()
+
+	limit, encode, err := listParams(r)
+	if err != nil {
+		return err
+	}
+
+	after := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return s3.ErrInvalidArgument.WithMessage("The continuation token provided is incorrect")
+		}
+		after = max(after, string(decoded))
+	}
+
+	page, err := h.list(r, q.Get("prefix"), q.Get("delimiter"), after, limit, q.Get("fetch-owner") == "true")
+	if err != nil {
+		return err
+	}
+
+	res := listObjectsV2Result{
+		Xmlns:             xmlns,
+		Name:              r.bucket,
+		Prefix:            q.Get("prefix"),
+		Delimiter:         q.Get("delimiter"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		KeyCount:          len(page.objects) + len(page.prefixes),
+		MaxKeys:           limit,
+		IsTruncated:       page.truncated,
+		Contents:          page.objects,
+		CommonPrefixes:    page.prefixes,
+	}
+	if page.truncated {
+		res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(page.last))
+	}
+	if encode {
+		res.EncodingType = "url"
+		res.Prefix = encodeKey(res.Prefix)
+		res.Delimiter = encodeKey(res.Delimiter)
+		res.StartAfter = encodeKey(res.StartAfter)
+		encodeListing(res.Contents, res.CommonPrefixes)
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, res)
+	return nil
+}
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *request) error {
+	q := r.URL.Query()
+
+	limit, encode, err := listParams(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.list(r, q.Get("prefix"), q.Get("delimiter"), q.Get("marker"), limit, true)
+	if err != nil {
+		return err
+	}
+
+	res := listObjectsResult{
+		Xmlns:          xmlns,
+		Name:           r.bucket,
+		Prefix:         q.Get("prefix"),
+		Marker:         q.Get("marker"),
+		Delimiter:      q.Get("delimiter"),
+		MaxKeys:        limit,
+		IsTruncated:    page.truncated,
+		Contents:       page.objects,
+		CommonPrefixes: page.prefixes,
+	}
+	if page.truncated {
+		res.NextMarker = page.last
+	}
+	if encode {
+		res.EncodingType = "url"
+		res.Prefix = encodeKey(res.Prefix)
+		res.Marker = encodeKey(res.Marker)
+		res.NextMarker = encodeKey(res.NextMarker)
+		res.Delimiter = encodeKey(res.Delimiter)
+		encodeListing(res.Contents, res.CommonPrefixes)
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, res)
+	return nil
+}
+
+func encodeListing(objects []object, prefixes []commonPrefix) {
+	for i := range objects {
+		objects[i].Key = encodeKey(objects[i].Key)
+	}
+	for i := range prefixes {
+		prefixes[i].Prefix = encodeKey(prefixes[i].Prefix)
+	}
+}
+
+// encodeKey encodes key for listings requested with encoding-type=url
+func encodeKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
@@ -0,0 +1,414 @@
+// Package s3_handlers serves files by S3-compatible API. Top-level
+// directories of the store are buckets, keys are paths inside them.
+// Only path-style requests are supported: /bucket/key.
+package s3_handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/http/ratelimit"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/s3"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileManager stores objects
+type FileManager interface {
+	OpenFile(ctx context.Context, filename string, offset int64) (*grpclient.FileReader, error)
+	PostFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	PutFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	DeleteFile(ctx context.Context, filename string) error
+	ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Stat(ctx context.Context, name string) (grpclient.FileInfo, error)
+	Mkdir(ctx context.Context, name string) error
+}
+
+type Options struct {
+	Region string
+	// MaxObjectSize limits size of object and of part of multipart upload
+	MaxObjectSize int64
+}
+
+// Handler serves S3 API. Every request has to be signed
+// by one of access keys known to verifier
+type Handler struct {
+	log      *slog.Logger
+	fm       FileManager
+	verifier *s3.Verifier
+	uploads  *s3.Uploads
+	opts     Options
+}
+
+func New(
+	log *slog.Logger,
+	fm FileManager,
+	verifier *s3.Verifier,
+	uploads *s3.Uploads,
+	opts Options,
+) *Handler {
+	return &Handler{
+		log:      log,
+		fm:       fm,
+		verifier: verifier,
+		uploads:  uploads,
+		opts:     opts,
+	}
+}
+
+// request is verified S3 request
+type request struct {
+	*http.Request
+	id     string
+	auth   *s3.Auth
+	body   io.Reader
+	bucket string
+	key    string
+}
+
+// name returns path of the object in the store.
+// Directory marker is the directory itself
+func (r *request) name() string {
+	return r.bucket + "/" + strings.TrimSuffix(r.key, "/")
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const method = "S3"
+	log := h.log.With(slog.String("method", method))
+
+	req := &request{Request: r, id: middleware.GetReqID(r.Context())}
+	if req.id == "" {
+		req.id = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	v, ok := r.Context().Value(verifiedKey{}).(verified)
+	if !ok {
+		v.auth, v.err = h.verifier.Verify(r)
+	}
+	auth, err := v.auth, v.err
+	if err != nil {
+		log.Warn("request is not authenticated", sl.Err(err))
+		s3.WriteError(w, r, req.id, err)
+		return
+	}
+	req.auth = auth
+	req.body = auth.Body(r.Body)
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	req.bucket, req.key, _ = strings.Cut(p, "/")
+
+	op, handle := h.route(req)
+	log = log.With(
+		slog.String("s3 op", op),
+		slog.String("bucket", req.bucket),
+		slog.String("key", req.key),
+		slog.String("access key", auth.AccessKey),
+	)
+
+	if err := h.validate(req); err != nil {
+		log.Warn("invalid request", sl.Err(err))
+		s3.WriteError(w, r, req.id, err)
+		return
+	}
+
+	if err := handle(w, req); err != nil {
+		var s3err *s3.Error
+		if errors.As(err, &s3err) {
+			log.Warn("request is rejected", sl.Err(err))
+		} else {
+			log.Error("request failed", sl.Err(err))
+		}
+		s3.WriteError(w, r, req.id, err)
+		return
+	}
+
+	log.Debug("request is served")
+}
+
+type verifiedKey struct{}
+
+// verified is result of verification of signature of the request
+type verified struct {
+	auth *s3.Auth
+	err  error
+}
+
+// Authenticate is a middleware that verifies signature of the request
+// before the following middlewares, so rate limiter keys signed requests
+// by access key. Requests which fail verification are passed on keyed
+// by ip address and rejected by the handler
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v verified
+		v.auth, v.err = h.verifier.Verify(r)
+
+		ctx := context.WithValue(r.Context(), verifiedKey{}, v)
+		if v.err == nil {
+			ctx = ratelimit.WithPrincipal(ctx, "s3:"+v.auth.AccessKey)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type handleFunc func(w http.ResponseWriter, r *request) error
+
+// route returns name of S3 operation of the request and its handler
+func (h *Handler) route(r *request) (string, handleFunc) {
+	q := r.URL.Query()
+
+	if r.bucket == "" {
+		if r.Method == http.MethodGet {
+			return "ListBuckets", h.listBuckets
+		}
+		return "", notAllowed
+	}
+
+	if r.key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			switch {
+			case q.Has("location"):
+				return "GetBucketLocation", h.bucketLocation
+			case q.Get("list-type") == "2":
+				return "ListObjectsV2", h.listObjectsV2
+			case hasSubresource(q):
+				return "", notImplemented
+			}
+			return "ListObjects", h.listObjects
+		case http.MethodHead:
+			return "HeadBucket", h.headBucket
+		case http.MethodPut:
+			if hasSubresource(q) {
+				return "", notImplemented
+			}
+			return "CreateBucket", h.createBucket
+		case http.MethodDelete:
+			if hasSubresource(q) {
+				return "", notImplemented
+			}
+			return "DeleteBucket", h.deleteBucket
+		case http.MethodPost:
+			if q.Has("delete") {
+				return "DeleteObjects", h.deleteObjects
+			}
+			return "", notImplemented
+		}
+		return "", notAllowed
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case q.Has("uploadId"):
+			return "ListParts", h.listParts
+		case hasSubresource(q):
+			return "", notImplemented
+		}
+		return "GetObject", h.getObject
+	case http.MethodHead:
+		return "HeadObject", h.headObject
+	case http.MethodPut:
+		switch {
+		case q.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+			return "UploadPartCopy", notImplemented
+		case q.Has("uploadId"):
+			return "UploadPart", h.uploadPart
+		case hasSubresource(q):
+			return "", notImplemented
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			return "CopyObject", h.copyObject
+		}
+		return "PutObject", h.putObject
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			return "AbortMultipartUpload", h.abortUpload
+		}
+		return "DeleteObject", h.deleteObject
+	case http.MethodPost:
+		switch {
+		case q.Has("uploads"):
+			return "CreateMultipartUpload", h.createUpload
+		case q.Has("uploadId"):
+			return "CompleteMultipartUpload", h.completeUpload
+		}
+		return "", notImplemented
+	}
+
+	return "", notAllowed
+}
+
+// subresources are query parameters selecting not supported operations
+var subresources = []string{
+	"accelerate", "acl", "analytics", "cors", "encryption", "inventory",
+	"lifecycle", "logging", "metrics", "notification", "object-lock",
+	"ownershipControls", "policy", "policyStatus", "publicAccessBlock",
+	"replication", "requestPayment", "restore", "retention", "select",
+	"tagging", "torrent", "uploads", "versioning", "versions", "website",
+	"legal-hold", "attributes",
+}
+
+func hasSubresource(q map[string][]string) bool {
+	for _, s := range subresources {
+		if _, ok := q[s]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func notAllowed(http.ResponseWriter, *request) error {
+	return s3.ErrMethodNotAllowed
+}
+
+func notImplemented(http.ResponseWriter, *request) error {
+	return s3.ErrNotImplemented.WithMessage("This operation is not supported")
+}
+
+// validate checks that bucket is one directory of the store and
+// key is a valid path inside it. Key of directory marker ends with slash
+func (h *Handler) validate(r *request) error {
+	if r.bucket == "" {
+		return nil
+	}
+	if !fs.ValidPath(r.bucket) || strings.Contains(r.bucket, "/") || r.bucket == "." {
+		return s3.ErrInvalidBucketName
+	}
+	if r.key == "" {
+		return nil
+	}
+
+	if !fs.ValidPath(strings.TrimSuffix(r.key, "/")) {
+		return s3.ErrInvalidArgument.WithMessage("Object key is not a valid path")
+	}
+
+	return nil
+}
+
+// objectError converts error of the store to S3 error
+func (h *Handler) objectError(ctx context.Context, bucket string, err error) error {
+	if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	if err := h.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+
+	return s3.ErrNoSuchKey
+}
+
+// checkBucket returns ErrNoSuchBucket if bucket does not exist
+func (h *Handler) checkBucket(ctx context.Context, bucket string) error {
+	info, err := h.fm.Stat(ctx, bucket)
+	if status.Code(err) == codes.NotFound || (err == nil && !info.IsDir) {
+		return s3.ErrNoSuchBucket
+	}
+
+	return err
+}
+
+// contentLength returns length of body which is decoded if it is chunked
+func contentLength(r *request) (int64, error) {
+	if !r.auth.Chunked() {
+		if r.ContentLength < 0 {
+			return 0, s3.ErrMissingContentLength
+		}
+		return r.ContentLength, nil
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return 0, s3.ErrMissingContentLength.WithMessage("You must provide the X-Amz-Decoded-Content-Length header")
+	}
+
+	return size, nil
+}
+
+// etag returns entity tag of object. Contents of files are not hashed, so
+// it is built of modification time and size. It is not a valid MD5, so
+// clients do not compare it with checksum of contents
+func etag(info grpclient.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime.Unix(), info.Size)
+}
+
+// contentType returns type of object by extension of its key
+func contentType(key string) string {
+	if ctype := mime.TypeByExtension(path.Ext(key)); ctype != "" {
+		return ctype
+	}
+
+	return "binary/octet-stream"
+}
+
+// isoTime formats time as S3 does in xml responses
+func isoTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// store uploads data as object name. Existing object is replaced and
+// directories of prefixes of the new one are created.
+// It returns description of the stored object
+func (h *Handler) store(
+	ctx context.Context,
+	name string,
+	data grpclient.DataProvider,
+	size int64,
+) (grpclient.FileInfo, error) {
+	header := fileHeader{name: path.Base(name), size: size}
+
+	info, err := h.fm.Stat(ctx, name)
+	switch {
+	case err == nil && info.IsDir:
+		return grpclient.FileInfo{}, s3.ErrInvalidRequest.WithMessage("Object key is a directory")
+	case err == nil:
+		err = h.fm.PutFile(ctx, data, header, name)
+	case status.Code(err) == codes.NotFound:
+		err = h.fm.Mkdir(ctx, path.Dir(name))
+		if err == nil || status.Code(err) == codes.AlreadyExists {
+			err = h.fm.PostFile(ctx, data, header, name)
+		}
+	}
+	if err != nil {
+		return grpclient.FileInfo{}, err
+	}
+
+	return h.fm.Stat(ctx, name)
+}
+
+type fileHeader struct {
+	name string
+	size int64
+}
+
+func (h fileHeader) Name() string {
+	return h.name
+}
+
+func (h fileHeader) Size() int64 {
+	return h.size
+}
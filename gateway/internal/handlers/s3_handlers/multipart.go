@@ -0,0 +1,218 @@
+package s3_handlers
+
+import (
+	"encoding/xml"
+	"lab3/internal/lib/s3"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type initiateUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// createUpload starts multipart upload. Parts are kept
+// by the gateway until upload is completed
+func (h *Handler) createUpload(w http.ResponseWriter, r *request) error {
+	if strings.HasSuffix(r.key, "/") {
+		return s3.ErrInvalidRequest.WithMessage("Directory marker can not be uploaded by parts")
+	}
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	upload, err := h.uploads.Create(r.bucket, r.key)
+	if err != nil {
+		return err
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, initiateUploadResult{
+		Xmlns:    xmlns,
+		Bucket:   r.bucket,
+		Key:      r.key,
+		UploadID: upload.ID,
+	})
+	return nil
+}
+
+// upload returns upload given by uploadId query parameter,
+// which has to be an upload of the requested object
+func (h *Handler) upload(r *request) (s3.Upload, error) {
+	upload, err := h.uploads.Get(r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return s3.Upload{}, err
+	}
+	if upload.Bucket != r.bucket || upload.Key != r.key {
+		return s3.Upload{}, s3.ErrNoSuchUpload
+	}
+
+	return upload, nil
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *request) error {
+	upload, err := h.upload(r)
+	if err != nil {
+		return err
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		return s3.ErrInvalidArgument.WithMessage("Part number must be an integer")
+	}
+
+	size, err := contentLength(r)
+	if err != nil {
+		return err
+	}
+	if size > h.opts.MaxObjectSize {
+		return s3.ErrEntityTooLarge
+	}
+
+	part, err := h.uploads.PutPart(upload.ID, number, r.body, r.Header.Get("Content-Md5"), size)
+	if err != nil {
+		return err
+	}
+	if part.Size != size {
+		return s3.ErrIncompleteBody
+	}
+
+	w.Header().Set("ETag", part.ETag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name `xml:"ListPartsResult"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Bucket      string   `xml:"Bucket"`
+	Key         string   `xml:"Key"`
+	UploadID    string   `xml:"UploadId"`
+	Initiator   owner    `xml:"Initiator"`
+	Owner       owner    `xml:"Owner"`
+	MaxParts    int      `xml:"MaxParts"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Parts       []part   `xml:"Part"`
+}
+
+type part struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+// listParts lists all uploaded parts at once
+func (h *Handler) listParts(w http.ResponseWriter, r *request) error {
+	upload, err := h.upload(r)
+	if err != nil {
+		return err
+	}
+
+	parts, err := h.uploads.Parts(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	res := listPartsResult{
+		Xmlns:     xmlns,
+		Bucket:    r.bucket,
+		Key:       r.key,
+		UploadID:  upload.ID,
+		Initiator: owner{ID: r.auth.AccessKey, DisplayName: r.auth.AccessKey},
+		Owner:     owner{ID: r.auth.AccessKey, DisplayName: r.auth.AccessKey},
+		MaxParts:  s3.MaxParts,
+	}
+	for _, p := range parts {
+		res.Parts = append(res.Parts, part{
+			PartNumber:   p.Number,
+			LastModified: isoTime(p.LastModified),
+			ETag:         p.ETag,
+			Size:         p.Size,
+		})
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, res)
+	return nil
+}
+
+type completeUploadRequest struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// completeUpload uploads concatenation of listed parts as the object
+// and removes the upload
+func (h *Handler) completeUpload(w http.ResponseWriter, r *request) error {
+	upload, err := h.upload(r)
+	if err != nil {
+		return err
+	}
+
+	var req completeUploadRequest
+	if err := decodeXML(r, &req); err != nil {
+		return err
+	}
+
+	completed := make([]s3.CompletedPart, 0, len(req.Parts))
+	for _, p := range req.Parts {
+		completed = append(completed, s3.CompletedPart{Number: p.PartNumber, ETag: p.ETag})
+	}
+
+	data, size, err := h.uploads.Open(upload.ID, completed)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if size > h.opts.MaxObjectSize {
+		return s3.ErrEntityTooLarge
+	}
+
+	info, err := h.store(r.Context(), r.name(), data, size)
+	if err != nil {
+		return err
+	}
+
+	if err := h.uploads.Remove(upload.ID); err != nil {
+		return err
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, completeUploadResult{
+		Xmlns:    xmlns,
+		Location: "/" + r.bucket + "/" + r.key,
+		Bucket:   r.bucket,
+		Key:      r.key,
+		ETag:     etag(info),
+	})
+	return nil
+}
+
+func (h *Handler) abortUpload(w http.ResponseWriter, r *request) error {
+	upload, err := h.upload(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.uploads.Remove(upload.ID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
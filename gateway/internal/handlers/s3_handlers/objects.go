@@ -0,0 +1,432 @@
+package s3_handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/logger/sl"
+	"lab3/internal/lib/s3"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// emptyETag is MD5 of empty object
+	emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+	maxDeleteObjects = 1000
+	maxXMLBody       = 2 << 20
+)
+
+// responseHeaders are query parameters of GetObject which override
+// headers of response, e.g. to download object of presigned url as attachment
+var responseHeaders = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// stat returns description of object. Directories are not objects
+func (h *Handler) stat(r *request) (grpclient.FileInfo, error) {
+	info, err := h.fm.Stat(r.Context(), r.name())
+	if err != nil {
+		return grpclient.FileInfo{}, h.objectError(r.Context(), r.bucket, err)
+	}
+	if info.IsDir != strings.HasSuffix(r.key, "/") {
+		return grpclient.FileInfo{}, s3.ErrNoSuchKey
+	}
+
+	return info, nil
+}
+
+// setObjectHeaders sets headers describing object
+func (h *Handler) setObjectHeaders(w http.ResponseWriter, r *request, info grpclient.FileInfo) {
+	w.Header().Set("X-Amz-Request-Id", r.id)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", contentType(r.key))
+
+	if info.IsDir {
+		w.Header().Set("ETag", emptyETag)
+	} else {
+		w.Header().Set("ETag", etag(info))
+	}
+
+	q := r.URL.Query()
+	for param, header := range responseHeaders {
+		if v := q.Get(param); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+}
+
+// getObject downloads object. Range and conditional headers are handled
+// by http.ServeContent, which reads only the requested ranges of object
+func (h *Handler) getObject(w http.ResponseWriter, r *request) error {
+	info, err := h.stat(r)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		info.Size = 0
+	}
+
+	content := &objectContent{h: h, r: r, info: info}
+	defer content.Close()
+
+	h.setObjectHeaders(w, r, info)
+	http.ServeContent(w, r.Request, "", info.ModTime, content)
+	if content.err != nil {
+		// headers are already sent, so the response is only cut short
+		h.log.Error("failed to download object",
+			sl.Err(content.err),
+			slog.String("bucket", r.bucket),
+			slog.String("key", r.key),
+		)
+	}
+	return nil
+}
+
+// objectContent is content of object which is downloaded from the
+// offset it is seeked to by the first read after the seek
+type objectContent struct {
+	h      *Handler
+	r      *request
+	info   grpclient.FileInfo
+	offset int64
+	file   *grpclient.FileReader
+	err    error
+}
+
+func (c *objectContent) Read(p []byte) (int, error) {
+	if c.offset >= c.info.Size {
+		return 0, io.EOF
+	}
+
+	if c.file == nil {
+		file, err := c.h.fm.OpenFile(c.r.Context(), c.r.name(), c.offset)
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+		// ranges of one response have to be parts of the same content
+		got := file.Info()
+		if got.Size != c.info.Size || !got.ModTime.Equal(c.info.ModTime) {
+			file.Close()
+			c.err = status.Error(codes.FailedPrecondition, "object is changed while downloading")
+			return 0, c.err
+		}
+		c.file = file
+	}
+
+	n, err := c.file.Read(p)
+	c.offset += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.err = err
+	}
+
+	return n, err
+}
+
+func (c *objectContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.info.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if offset != c.offset {
+		c.Close()
+		c.offset = offset
+	}
+
+	return offset, nil
+}
+
+// Close stops download
+func (c *objectContent) Close() error {
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+
+	return nil
+}
+
+// headObject describes object without downloading it
+func (h *Handler) headObject(w http.ResponseWriter, r *request) error {
+	info, err := h.stat(r)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		info.Size = 0
+	}
+
+	h.setObjectHeaders(w, r, info)
+	http.ServeContent(w, r.Request, "", info.ModTime, &sizeOnly{size: info.Size})
+	return nil
+}
+
+// sizeOnly is content of known size which is never read,
+// so http.ServeContent answers HEAD requests without contents
+type sizeOnly struct {
+	size   int64
+	offset int64
+}
+
+func (s *sizeOnly) Read([]byte) (int, error) {
+	return 0, errors.New("contents of object are not available")
+}
+
+func (s *sizeOnly) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.offset = offset
+	case io.SeekCurrent:
+		s.offset += offset
+	case io.SeekEnd:
+		s.offset = s.size + offset
+	}
+
+	return s.offset, nil
+}
+
+// putObject uploads object. Key ending with slash with empty body
+// creates directory
+func (h *Handler) putObject(w http.ResponseWriter, r *request) error {
+	size, err := contentLength(r)
+	if err != nil {
+		return err
+	}
+	if size > h.opts.MaxObjectSize {
+		return s3.ErrEntityTooLarge
+	}
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(r.key, "/") {
+		if size != 0 {
+			return s3.ErrInvalidRequest.WithMessage("Directory marker has to be empty")
+		}
+		if _, err := io.Copy(io.Discard, r.body); err != nil {
+			return err
+		}
+
+		err := h.fm.Mkdir(r.Context(), r.name())
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return err
+		}
+
+		w.Header().Set("ETag", emptyETag)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	body, err := s3.Spool("", r.body, r.Header.Get("Content-Md5"), h.opts.MaxObjectSize)
+	if err != nil {
+		return err
+	}
+	defer body.Discard()
+
+	if body.Size != size {
+		return s3.ErrIncompleteBody
+	}
+
+	info, err := h.store(r.Context(), r.name(), body, body.Size)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", etag(info))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteObject deletes object. Missing object is not an error
+func (h *Handler) deleteObject(w http.ResponseWriter, r *request) error {
+	if err := h.deleteKey(r, r.key); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) deleteKey(r *request, key string) error {
+	name := (&request{bucket: r.bucket, key: key}).name()
+
+	info, err := h.fm.Stat(r.Context(), name)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir != strings.HasSuffix(key, "/") {
+		return nil
+	}
+
+	err = h.fm.DeleteFile(r.Context(), name)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return nil
+	case status.Code(err) == codes.InvalidArgument && info.IsDir:
+		// S3 does not have directories, so deleting marker of
+		// directory which is not empty keeps objects in it
+		return nil
+	}
+
+	return err
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// copyObject copies object given by X-Amz-Copy-Source header
+// "bucket/key", which may be url-encoded
+func (h *Handler) copyObject(w http.ResponseWriter, r *request) error {
+	source := r.Header.Get("X-Amz-Copy-Source")
+	source, _, _ = strings.Cut(source, "?versionId=")
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return s3.ErrInvalidArgument.WithMessage("Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+
+	src := &request{Request: r.Request}
+	src.bucket, src.key, _ = strings.Cut(source, "/")
+	if src.key == "" || strings.HasSuffix(src.key, "/") {
+		return s3.ErrInvalidArgument.WithMessage("Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+	if err := h.validate(src); err != nil {
+		return err
+	}
+	if strings.HasSuffix(r.key, "/") {
+		return s3.ErrInvalidRequest.WithMessage("Directory marker can not be copied")
+	}
+
+	if _, err := h.stat(src); err != nil {
+		return err
+	}
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	file, err := h.fm.OpenFile(r.Context(), src.name(), 0)
+	if err != nil {
+		return h.objectError(r.Context(), src.bucket, err)
+	}
+	defer file.Close()
+	if file.Info().Size > h.opts.MaxObjectSize {
+		return s3.ErrEntityTooLarge
+	}
+
+	info, err := h.store(r.Context(), r.name(), file, file.Info().Size)
+	if err != nil {
+		return err
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, copyObjectResult{
+		Xmlns:        xmlns,
+		LastModified: isoTime(info.ModTime),
+		ETag:         etag(info),
+	})
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+// deleteObjects deletes objects listed in the body
+func (h *Handler) deleteObjects(w http.ResponseWriter, r *request) error {
+	if err := h.checkBucket(r.Context(), r.bucket); err != nil {
+		return err
+	}
+
+	var req deleteRequest
+	if err := decodeXML(r, &req); err != nil {
+		return err
+	}
+	if len(req.Objects) > maxDeleteObjects {
+		return s3.ErrMalformedXML.WithMessage("The request must contain no more than 1000 keys")
+	}
+
+	res := deleteResult{Xmlns: xmlns}
+	for _, obj := range req.Objects {
+		err := h.validate(&request{bucket: r.bucket, key: obj.Key})
+		if err == nil {
+			err = h.deleteKey(r, obj.Key)
+		}
+		if err != nil {
+			var s3err *s3.Error
+			if !errors.As(err, &s3err) {
+				s3err = s3.ErrInternal
+			}
+			res.Errors = append(res.Errors, deleteError{Key: obj.Key, Code: s3err.Code, Message: s3err.Message})
+			continue
+		}
+
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+
+	s3.WriteXML(w, r.id, http.StatusOK, res)
+	return nil
+}
+
+// decodeXML decodes body of the request into v
+func decodeXML(r *request, v any) error {
+	data, err := io.ReadAll(io.LimitReader(r.body, maxXMLBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBody {
+		return s3.ErrMalformedXML.WithMessage("The XML you provided was too large")
+	}
+
+	if err := xml.Unmarshal(data, v); err != nil {
+		return s3.ErrMalformedXML
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ratioThreshold is expanded size below which compression ratio
+// is not checked, small files of zeros are compressed too well
+const ratioThreshold = 1 << 20
+
+var (
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	ErrTooLarge       = errors.New("archive expands to too much data")
+	ErrRatio          = errors.New("archive is compressed too well")
+)
+
+// Limits protect from archive bombs. MaxSize limits total size of
+// extracted files, MaxRatio limits it relative to compressed size.
+// Zero value of a limit disables it
+type Limits struct {
+	MaxEntries int
+	MaxSize    int64
+	MaxRatio   float64
+}
+
+// Entry is a file of read archive. Name is a path as it is stored in the
+// archive, Type is fs.ModeDir for directories, zero for regular files and
+// other type bits for links and special files
+type Entry struct {
+	Name    string
+	Size    int64
+	Type    fs.FileMode
+	ModTime time.Time
+}
+
+// Reader reads archive entry by entry
+type Reader interface {
+	// Next returns next entry and reader of its data. Data of the previous
+	// entry which is not read is skipped. At the end of archive io.EOF
+	// is returned. Exceeded limit is returned as error, reading stops then
+	Next() (Entry, io.Reader, error)
+	Close() error
+}
+
+// NewReader creates reader of archive of format from r. Tar archives are
+// read as they come. Zip is read from its central directory at the end,
+// so it is stored in temporary file first
+func NewReader(format string, r io.Reader, limits Limits) (Reader, error) {
+	l := &limiter{limits: limits}
+
+	switch format {
+	case FormatZip:
+		return newZipReader(r, l)
+	case FormatTar:
+		l.compressed = &countReader{r: r}
+		return &tarReader{tr: tar.NewReader(l.compressed), limiter: l}, nil
+	case FormatTarGz:
+		l.compressed = &countReader{r: r}
+		gz, err := gzip.NewReader(l.compressed)
+		if err != nil {
+			return nil, err
+		}
+		return &tarReader{tr: tar.NewReader(gz), limiter: l, closer: gz}, nil
+	case FormatTarZst:
+		l.compressed = &countReader{r: r}
+		zr, err := zstd.NewReader(l.compressed, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(64<<20))
+		if err != nil {
+			return nil, err
+		}
+		return &tarReader{tr: tar.NewReader(zr), limiter: l, closer: zr.IOReadCloser()}, nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// SafeName converts name of archive entry to path relative to directory
+// which archive is extracted into. Names which escape the directory,
+// absolute names and names with backslashes are not safe
+func SafeName(name string) (string, bool) {
+	if strings.Contains(name, `\`) || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+
+	return name, true
+}
+
+// limiter counts entries and extracted data against limits
+type limiter struct {
+	limits     Limits
+	entries    int
+	expanded   int64
+	compressed *countReader
+	// zipped is compressed size of zip entries opened so far
+	zipped int64
+}
+
+// entry counts next entry of size bytes
+func (l *limiter) entry(size int64) error {
+	l.entries++
+	if l.limits.MaxEntries > 0 && l.entries > l.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d", ErrTooManyEntries, l.limits.MaxEntries)
+	}
+	if l.limits.MaxSize > 0 && size > l.limits.MaxSize-l.expanded {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limits.MaxSize)
+	}
+
+	return nil
+}
+
+// read counts n bytes of extracted data
+func (l *limiter) read(n int) error {
+	l.expanded += int64(n)
+	if l.limits.MaxSize > 0 && l.expanded > l.limits.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limits.MaxSize)
+	}
+
+	compressed := l.zipped
+	if l.compressed != nil {
+		compressed = l.compressed.n
+	}
+	if l.limits.MaxRatio > 0 && l.expanded > ratioThreshold &&
+		float64(l.expanded) > l.limits.MaxRatio*float64(compressed) {
+		return fmt.Errorf("%w: more than %g times", ErrRatio, l.limits.MaxRatio)
+	}
+
+	return nil
+}
+
+// dataReader counts data of entry read from r
+type dataReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (d *dataReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if lerr := d.l.read(n); lerr != nil {
+		return n, lerr
+	}
+
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+type tarReader struct {
+	tr *tar.Reader
+	*limiter
+	closer io.Closer
+}
+
+func (t *tarReader) Next() (Entry, io.Reader, error) {
+	h, err := t.tr.Next()
+	if err != nil {
+		return Entry{}, nil, err
+	}
+
+	e := Entry{Name: h.Name, ModTime: h.ModTime}
+	switch h.Typeflag {
+	case tar.TypeReg:
+		e.Size = h.Size
+	case tar.TypeDir:
+		e.Type = fs.ModeDir
+	case tar.TypeSymlink:
+		e.Type = fs.ModeSymlink
+	default:
+		e.Type = fs.ModeIrregular
+	}
+
+	if err := t.entry(e.Size); err != nil {
+		return Entry{}, nil, err
+	}
+
+	return e, &dataReader{r: t.tr, l: t.limiter}, nil
+}
+
+func (t *tarReader) Close() error {
+	if t.closer == nil {
+		return nil
+	}
+
+	return t.closer.Close()
+}
+
+type zipReader struct {
+	file *os.File
+	zr   *zip.Reader
+	next int
+	data io.ReadCloser
+	*limiter
+}
+
+func newZipReader(r io.Reader, l *limiter) (*zipReader, error) {
+	file, err := os.CreateTemp("", "extract-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	z := &zipReader{file: file, limiter: l}
+
+	src := r
+	if l.limits.MaxSize > 0 {
+		src = io.LimitReader(r, l.limits.MaxSize+1)
+	}
+	size, err := io.Copy(file, src)
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+	if l.limits.MaxSize > 0 && size > l.limits.MaxSize {
+		z.Close()
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limits.MaxSize)
+	}
+
+	if z.zr, err = zip.NewReader(file, size); err != nil {
+		z.Close()
+		return nil, err
+	}
+	if l.limits.MaxEntries > 0 && len(z.zr.File) > l.limits.MaxEntries {
+		z.Close()
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyEntries, l.limits.MaxEntries)
+	}
+
+	return z, nil
+}
+
+func (z *zipReader) Next() (Entry, io.Reader, error) {
+	if z.data != nil {
+		z.data.Close()
+		z.data = nil
+	}
+	if z.next == len(z.zr.File) {
+		return Entry{}, nil, io.EOF
+	}
+
+	f := z.zr.File[z.next]
+	z.next++
+
+	e := Entry{Name: f.Name, ModTime: f.Modified}
+	mode := f.Mode()
+	switch {
+	case mode.IsDir() || strings.HasSuffix(f.Name, "/"):
+		e.Type = fs.ModeDir
+	case mode.IsRegular():
+		e.Size = int64(f.UncompressedSize64)
+	default:
+		e.Type = mode.Type()
+	}
+
+	if err := z.entry(e.Size); err != nil {
+		return Entry{}, nil, err
+	}
+	if e.Type != 0 {
+		return e, eofReader{}, nil
+	}
+
+	data, err := f.Open()
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	z.data = data
+	z.zipped += int64(f.CompressedSize64)
+
+	return e, &dataReader{r: data, l: z.limiter}, nil
+}
+
+func (z *zipReader) Close() error {
+	if z.data != nil {
+		z.data.Close()
+	}
+	z.file.Close()
+
+	return os.Remove(z.file.Name())
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
@@ -0,0 +1,342 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{name: "a.txt", want: "a.txt", ok: true},
+		{name: "dir/a.txt", want: "dir/a.txt", ok: true},
+		{name: "dir/", want: "dir", ok: true},
+		{name: "./dir/a.txt", want: "dir/a.txt", ok: true},
+		{name: "..a/b..", want: "..a/b..", ok: true},
+		{name: ""},
+		{name: "."},
+		{name: "./"},
+		{name: ".."},
+		{name: "../a.txt"},
+		{name: "dir/../../a.txt"},
+		{name: "dir/../a.txt"},
+		{name: "dir/./a.txt"},
+		{name: "dir//a.txt"},
+		{name: "/etc/passwd"},
+		{name: "//host/share"},
+		{name: `dir\a.txt`},
+		{name: `..\a.txt`},
+		{name: `C:\a.txt`},
+		{name: "dir//"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := SafeName(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("SafeName(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// file is entry of built archive, its content is size zero bytes
+type file struct {
+	name string
+	size int
+	dir  bool
+}
+
+var formats = []string{FormatZip, FormatTar, FormatTarGz, FormatTarZst}
+
+func TestLimits(t *testing.T) {
+	small := []file{
+		{name: "dir", dir: true},
+		{name: "dir/a", size: 1000},
+		{name: "dir/b", size: 2000},
+		{name: "c", size: 3000},
+	}
+	const smallSize = 6000
+	// zeros of large file are compressed more than 10 times by every
+	// compression, but tar without compression keeps them as they are
+	large := []file{{name: "zeros", size: 4 << 20}}
+	// compression ratio of files below threshold is not checked
+	belowThreshold := []file{{name: "zeros", size: ratioThreshold / 2}}
+
+	tests := []struct {
+		name   string
+		files  []file
+		limits Limits
+		want   map[string]error
+	}{
+		{name: "no limits", files: large},
+		{name: "entries at limit", files: small, limits: Limits{MaxEntries: len(small)}},
+		{
+			name:   "too many entries",
+			files:  small,
+			limits: Limits{MaxEntries: len(small) - 1},
+			want:   allFormats(ErrTooManyEntries),
+		},
+		{name: "size at limit", files: small, limits: Limits{MaxSize: smallSize}},
+		{
+			name:   "too large",
+			files:  small,
+			limits: Limits{MaxSize: smallSize - 1},
+			want:   allFormats(ErrTooLarge),
+		},
+		{
+			name:   "compressed too well",
+			files:  large,
+			limits: Limits{MaxRatio: 10},
+			want: map[string]error{
+				FormatZip:    ErrRatio,
+				FormatTarGz:  ErrRatio,
+				FormatTarZst: ErrRatio,
+			},
+		},
+		{name: "ratio below threshold", files: belowThreshold, limits: Limits{MaxRatio: 10}},
+		{name: "ratio within limit", files: large, limits: Limits{MaxRatio: 100000}},
+	}
+
+	for _, tt := range tests {
+		for _, format := range formats {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				data := build(t, format, tt.files)
+
+				err := extract(format, bytes.NewReader(data), tt.limits)
+				if want := tt.want[format]; !errors.Is(err, want) {
+					t.Fatalf("extract() error = %v, want %v", err, want)
+				}
+			})
+		}
+	}
+}
+
+// TestCraftedArchives checks limits against archives whose headers
+// do not describe their content
+func TestCraftedArchives(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   func(t *testing.T) []byte
+		limits Limits
+		want   error
+	}{
+		{
+			name:   "tar entry declares more than limit",
+			format: FormatTar,
+			data: func(t *testing.T) []byte {
+				var b bytes.Buffer
+				tw := tar.NewWriter(&b)
+				if err := tw.WriteHeader(&tar.Header{Name: "a", Size: 1 << 40, Typeflag: tar.TypeReg}); err != nil {
+					t.Fatal(err)
+				}
+				// data of the entry is not written
+				return b.Bytes()
+			},
+			limits: Limits{MaxSize: 1 << 30},
+			want:   ErrTooLarge,
+		},
+		{
+			name:   "zip entry declares more than limit",
+			format: FormatZip,
+			data: func(t *testing.T) []byte {
+				return lyingZip(t, 1<<40, 1000)
+			},
+			limits: Limits{MaxSize: 1 << 30},
+			want:   ErrTooLarge,
+		},
+		{
+			// data beyond the declared size is not extracted
+			name:   "zip entry is larger than declared",
+			format: FormatZip,
+			data: func(t *testing.T) []byte {
+				return lyingZip(t, 10, 4<<20)
+			},
+			limits: Limits{MaxSize: 1 << 20},
+			want:   zip.ErrFormat,
+		},
+		{
+			name:   "zip archive is larger than limit",
+			format: FormatZip,
+			data: func(t *testing.T) []byte {
+				return build(t, FormatZip, []file{{name: "a", size: 1000}})
+			},
+			limits: Limits{MaxSize: 100},
+			want:   ErrTooLarge,
+		},
+		{
+			name:   "zip with too many entries in central directory",
+			format: FormatZip,
+			data: func(t *testing.T) []byte {
+				files := make([]file, 100)
+				for i := range files {
+					files[i] = file{name: strings.Repeat("a", i+1)}
+				}
+				return build(t, FormatZip, files)
+			},
+			limits: Limits{MaxEntries: 99},
+			want:   ErrTooManyEntries,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := extract(tt.format, bytes.NewReader(tt.data(t)), tt.limits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("extract() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func allFormats(err error) map[string]error {
+	m := make(map[string]error, len(formats))
+	for _, f := range formats {
+		m[f] = err
+	}
+	return m
+}
+
+// extract reads all entries of archive and their data
+func extract(format string, r io.Reader, limits Limits) error {
+	ar, err := NewReader(format, r, limits)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+
+	for {
+		_, data, err := ar.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+	}
+}
+
+// build creates archive of format with files
+func build(t *testing.T, format string, files []file) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if format == FormatZip {
+		zw := zip.NewWriter(&b)
+		for _, f := range files {
+			if f.dir {
+				if _, err := zw.Create(f.name + "/"); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			w, err := zw.Create(f.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(make([]byte, f.size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	var w io.WriteCloser = nopCloser{&b}
+	switch format {
+	case FormatTarGz:
+		w = gzip.NewWriter(&b)
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Size: int64(f.size), Typeflag: tar.TypeReg, Mode: 0o644}
+		if f.dir {
+			h = &tar.Header{Name: f.name + "/", Typeflag: tar.TypeDir, Mode: 0o755}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, f.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+// lyingZip creates zip of one entry of size zero bytes
+// which declares declared bytes
+func lyingZip(t *testing.T, declared uint64, size int) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "zeros",
+		Method:             zip.Deflate,
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: declared,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(compressed.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
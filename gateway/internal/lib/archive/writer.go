@@ -0,0 +1,121 @@
+// Package archive writes and reads zip and tar.gz archives of files
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+)
+
+const (
+	FormatZip    = "zip"
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// Writer writes entries into archive as they come, nothing is buffered
+// except the current chunk. Close finishes archive, archive which is not
+// closed is broken, so clients see that it is not complete
+type Writer interface {
+	// Add writes entry. Data of file is read from r,
+	// its size has to be e.Size exactly
+	Add(e grpclient.ArchiveEntry, r io.Reader) error
+	Close() error
+}
+
+// NewWriter creates writer of archive of format into w.
+// Archives are written as zip or tar.gz only
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns media type of archive of format
+func ContentType(format string) string {
+	if format == FormatZip {
+		return "application/zip"
+	}
+
+	return "application/gzip"
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Add(e grpclient.ArchiveEntry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     e.Name,
+		Method:   zip.Deflate,
+		Modified: e.ModTime,
+	}
+	if e.IsDir {
+		header.Name += "/"
+		header.Method = zip.Store
+		header.SetMode(0o755 | 1<<31)
+	} else {
+		header.SetMode(0o644)
+	}
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil || e.IsDir {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) Add(e grpclient.ArchiveEntry, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     e.Name,
+		Size:     e.Size,
+		Mode:     0o644,
+		ModTime:  e.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	if e.IsDir {
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Size = 0
+		header.Mode = 0o755
+	}
+
+	if err := t.tw.WriteHeader(header); err != nil || e.IsDir {
+		return err
+	}
+
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+
+	return t.gz.Close()
+}
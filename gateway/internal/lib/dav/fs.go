@@ -0,0 +1,462 @@
+// Package dav exposes files of filemanagers as webdav file system.
+package dav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Storage stores files of the file system
+type Storage interface {
+	GetFile(ctx context.Context, filename string) ([]byte, error)
+	PostFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	PutFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	DeleteFile(ctx context.Context, filename string) error
+	ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Stat(ctx context.Context, name string) (grpclient.FileInfo, error)
+	Mkdir(ctx context.Context, name string) error
+	Move(ctx context.Context, from string, to string) error
+}
+
+// FS is webdav file system over storage. Files are read into memory
+// when they are read the first time, written files are buffered in
+// temporary files and uploaded when they are closed
+type FS struct {
+	storage Storage
+	tempDir string
+}
+
+// New creates file system. Written files are buffered in tempDir,
+// empty tempDir means the default directory for temporary files
+func New(storage Storage, tempDir string) *FS {
+	return &FS{storage: storage, tempDir: tempDir}
+}
+
+var _ webdav.FileSystem = (*FS)(nil)
+
+// Mkdir creates directory. Parent directory has to exist
+func (f *FS) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	name = clean(name)
+	if name == "." {
+		return pathError("mkdir", name, fs.ErrExist)
+	}
+
+	if err := f.checkParent(ctx, "mkdir", name); err != nil {
+		return err
+	}
+
+	return pathError("mkdir", name, f.storage.Mkdir(ctx, name))
+}
+
+// OpenFile opens file for reading or, if flag allows writing, for writing
+func (f *FS) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	name = clean(name)
+
+	info, err := f.storage.Stat(ctx, name)
+	exists := err == nil
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, pathError("open", name, err)
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if !exists {
+			return nil, pathError("open", name, fs.ErrNotExist)
+		}
+		return &readFile{ctx: ctx, storage: f.storage, info: fileInfo{info}}, nil
+	}
+
+	switch {
+	case exists && info.IsDir:
+		return nil, pathError("open", name, fs.ErrInvalid)
+	case exists && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, fs.ErrExist)
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, fs.ErrNotExist)
+	case !exists:
+		if err := f.checkParent(ctx, "open", name); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := os.CreateTemp(f.tempDir, "dav-*")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &writeFile{File: tmp, ctx: ctx, storage: f.storage, name: name, exists: exists}
+	if exists && flag&os.O_TRUNC == 0 {
+		if err := w.prefill(); err != nil {
+			_ = w.discard()
+			return nil, pathError("open", name, err)
+		}
+	}
+
+	return w, nil
+}
+
+// RemoveAll deletes file or directory with everything in it
+func (f *FS) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	if name == "." {
+		return pathError("remove", name, fs.ErrPermission)
+	}
+
+	info, err := f.storage.Stat(ctx, name)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	if !info.IsDir {
+		return pathError("remove", name, f.storage.DeleteFile(ctx, name))
+	}
+
+	files, err := f.storage.ListFiles(ctx, name, true)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+
+	var dirs []string
+	for _, file := range files {
+		if file.IsDir {
+			dirs = append(dirs, file.Name)
+			continue
+		}
+		if err := f.storage.DeleteFile(ctx, file.Name); err != nil && status.Code(err) != codes.NotFound {
+			return pathError("remove", file.Name, err)
+		}
+	}
+
+	// the deepest directories are deleted first, so every one is empty
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range append(dirs, name) {
+		if err := f.storage.DeleteFile(ctx, dir); err != nil && status.Code(err) != codes.NotFound {
+			return pathError("remove", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// Rename moves file or directory. Webdav handler removes overwritten
+// destination before, so existing destination is an error
+func (f *FS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	if oldName == "." || newName == "." {
+		return pathError("rename", oldName, fs.ErrPermission)
+	}
+
+	if err := f.checkParent(ctx, "rename", newName); err != nil {
+		return err
+	}
+
+	return pathError("rename", oldName, f.storage.Move(ctx, oldName, newName))
+}
+
+// Stat returns description of file or directory
+func (f *FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = clean(name)
+
+	info, err := f.storage.Stat(ctx, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return fileInfo{info}, nil
+}
+
+// checkParent returns not exist error if parent directory of name is
+// missing, so webdav handler responds with Conflict as webdav requires
+func (f *FS) checkParent(ctx context.Context, op string, name string) error {
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+
+	info, err := f.storage.Stat(ctx, parent)
+	if err != nil {
+		return pathError(op, name, err)
+	}
+	if !info.IsDir {
+		return pathError(op, name, fs.ErrNotExist)
+	}
+
+	return nil
+}
+
+// readFile is opened for reading file or directory. File is downloaded
+// on the first read, because webdav opens files to get their properties
+type readFile struct {
+	ctx     context.Context
+	storage Storage
+	info    fileInfo
+	data    *bytes.Reader
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (r *readFile) load() error {
+	if r.data != nil {
+		return nil
+	}
+	if r.info.IsDir() {
+		return pathError("read", r.info.FileInfo.Name, fs.ErrInvalid)
+	}
+
+	data, err := r.storage.GetFile(r.ctx, r.info.FileInfo.Name)
+	if err != nil {
+		return pathError("read", r.info.FileInfo.Name, err)
+	}
+	r.data = bytes.NewReader(data)
+
+	return nil
+}
+
+func (r *readFile) Read(p []byte) (int, error) {
+	if err := r.load(); err != nil {
+		return 0, err
+	}
+
+	return r.data.Read(p)
+}
+
+func (r *readFile) Seek(offset int64, whence int) (int64, error) {
+	if err := r.load(); err != nil {
+		return 0, err
+	}
+
+	return r.data.Seek(offset, whence)
+}
+
+func (r *readFile) Write([]byte) (int, error) {
+	return 0, pathError("write", r.info.FileInfo.Name, fs.ErrPermission)
+}
+
+// Readdir returns next count entries of directory, all of them if count
+// is not positive
+func (r *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !r.info.IsDir() {
+		return nil, pathError("readdir", r.info.FileInfo.Name, fs.ErrInvalid)
+	}
+
+	if !r.listed {
+		files, err := r.storage.ListFiles(r.ctx, r.info.FileInfo.Name, false)
+		if err != nil {
+			return nil, pathError("readdir", r.info.FileInfo.Name, err)
+		}
+		for _, f := range files {
+			r.entries = append(r.entries, fileInfo{f})
+		}
+		r.listed = true
+	}
+
+	if count <= 0 {
+		res := r.entries
+		r.entries = nil
+		return res, nil
+	}
+	if len(r.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n := min(count, len(r.entries))
+	res := r.entries[:n]
+	r.entries = r.entries[n:]
+	return res, nil
+}
+
+func (r *readFile) Stat() (os.FileInfo, error) {
+	return r.info, nil
+}
+
+func (r *readFile) Close() error {
+	return nil
+}
+
+// writeFile buffers written file in temporary file
+// and uploads it when it is closed
+type writeFile struct {
+	*os.File
+	ctx     context.Context
+	storage Storage
+	name    string
+	exists  bool
+}
+
+func (w *writeFile) prefill() error {
+	data, err := w.storage.GetFile(w.ctx, w.name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Write(data); err != nil {
+		return err
+	}
+
+	_, err = w.File.Seek(0, io.SeekStart)
+	return err
+}
+
+func (w *writeFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", w.name, fs.ErrInvalid)
+}
+
+// Stat returns description of written file, which is used as ETag of it
+func (w *writeFile) Stat() (os.FileInfo, error) {
+	stat, err := w.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{grpclient.FileInfo{
+		Name:    w.name,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}}, nil
+}
+
+// Close uploads written file. New file is created, existing one is replaced
+func (w *writeFile) Close() error {
+	defer w.discard()
+
+	stat, err := w.File.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := fileHeader{name: path.Base(w.name), size: stat.Size()}
+	if w.exists {
+		err = w.storage.PutFile(w.ctx, w.File, header, w.name)
+	} else {
+		err = w.storage.PostFile(w.ctx, w.File, header, w.name)
+	}
+
+	return pathError("write", w.name, err)
+}
+
+func (w *writeFile) discard() error {
+	_ = w.File.Close()
+
+	return os.Remove(w.File.Name())
+}
+
+type fileHeader struct {
+	name string
+	size int64
+}
+
+func (h fileHeader) Name() string {
+	return h.name
+}
+
+func (h fileHeader) Size() int64 {
+	return h.size
+}
+
+// fileInfo adapts file description of filemanager to os.FileInfo
+type fileInfo struct {
+	grpclient.FileInfo
+}
+
+func (i fileInfo) Name() string {
+	if i.FileInfo.Name == "." || i.FileInfo.Name == "" {
+		return "/"
+	}
+
+	return path.Base(i.FileInfo.Name)
+}
+
+func (i fileInfo) Size() int64 {
+	return i.FileInfo.Size
+}
+
+func (i fileInfo) Mode() os.FileMode {
+	if i.FileInfo.IsDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return i.FileInfo.ModTime
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.FileInfo.IsDir
+}
+
+func (i fileInfo) Sys() any {
+	return nil
+}
+
+// ContentType returns type by extension of file, so files are not
+// downloaded to sniff their types when directory is listed
+func (i fileInfo) ContentType(context.Context) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(i.FileInfo.Name)); ctype != "" {
+		return ctype, nil
+	}
+
+	return "application/octet-stream", nil
+}
+
+// clean converts webdav name to path relative to filemanager root
+func clean(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// pathError converts error of filemanager to error which webdav handler
+// maps to status code. Nil error stays nil
+func pathError(op string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		err = fs.ErrNotExist
+	case codes.AlreadyExists:
+		err = fs.ErrExist
+	case codes.PermissionDenied:
+		err = fs.ErrPermission
+	}
+
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return err
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"context"
+	httperrors "lab3/internal/lib/http/errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type Options struct {
+	// RPS is an average rate of requests per client. Zero disables rate limit
+	RPS float64
+	// Burst is a maximum number of requests which client can make at once
+	Burst int
+	// ClientTransfers is a maximum number of concurrent transfers per client.
+	// Zero disables the limit
+	ClientTransfers int
+	// TotalTransfers is a maximum number of concurrent transfers of all clients.
+	// Zero disables the limit
+	TotalTransfers int
+	// RetryAfter is sent to the clients which exceeded transfer limits
+	RetryAfter time.Duration
+	// ClientTTL is a time after which idle client state is forgotten
+	ClientTTL time.Duration
+}
+
+type client struct {
+	limiter   *rate.Limiter
+	transfers int
+	lastSeen  time.Time
+}
+
+// Limiter limits request rate and number of concurrent transfers.
+// Clients are keyed by principal if it is known, otherwise by ip address.
+type Limiter struct {
+	log  *slog.Logger
+	opts Options
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	transfers int
+	lastSweep time.Time
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns context which makes limiter to key the request
+// by principal instead of ip address
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func New(log *slog.Logger, opts Options) *Limiter {
+	log = log.With(slog.String("component", "middleware/ratelimit"))
+	log.Info(
+		"rate limit middleware is enabled",
+		slog.Float64("rps", opts.RPS),
+		slog.Int("burst", opts.Burst),
+		slog.Int("client transfers", opts.ClientTransfers),
+		slog.Int("total transfers", opts.TotalTransfers),
+	)
+
+	return &Limiter{
+		log:       log,
+		opts:      opts,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// Requests is a middleware that limits request rate of every client
+// with token bucket
+func (l *Limiter) Requests(next http.Handler) http.Handler {
+	if l.opts.RPS <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(r)
+		now := time.Now()
+
+		l.mu.Lock()
+		c := l.client(key, now)
+		res := c.limiter.ReserveN(now, 1)
+		l.mu.Unlock()
+
+		if !res.OK() {
+			l.reject(w, key, l.opts.RetryAfter)
+			return
+		}
+
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			l.reject(w, key, delay)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transfers is a middleware that limits number of concurrent streaming
+// transfers per client and globally
+func (l *Limiter) Transfers(next http.Handler) http.Handler {
+	if l.opts.ClientTransfers <= 0 && l.opts.TotalTransfers <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(r)
+
+		if !l.acquire(key) {
+			l.reject(w, key, l.opts.RetryAfter)
+			return
+		}
+		defer l.release(key)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.client(key, time.Now())
+
+	if l.opts.TotalTransfers > 0 && l.transfers >= l.opts.TotalTransfers {
+		return false
+	}
+	if l.opts.ClientTransfers > 0 && c.transfers >= l.opts.ClientTransfers {
+		return false
+	}
+
+	c.transfers++
+	l.transfers++
+	return true
+}
+
+func (l *Limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.client(key, time.Now())
+	c.transfers--
+	l.transfers--
+}
+
+// client returns state of the client and forgets idle ones.
+// Must be called with mu held
+func (l *Limiter) client(key string, now time.Time) *client {
+	if now.Sub(l.lastSweep) >= l.opts.ClientTTL {
+		for k, c := range l.clients {
+			if c.transfers == 0 && now.Sub(c.lastSeen) >= l.opts.ClientTTL {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{
+			limiter: rate.NewLimiter(rate.Limit(l.opts.RPS), l.opts.Burst),
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	return c
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, key string, retryAfter time.Duration) {
+	l.log.Warn(
+		"client exceeded limit",
+		slog.String("client", key),
+		slog.String("retry after", retryAfter.String()),
+	)
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	httperrors.Error(w, http.StatusTooManyRequests)
+}
+
+func clientKey(r *http.Request) string {
+	if principal, ok := r.Context().Value(principalKey{}).(string); ok && principal != "" {
+		return "principal:" + principal
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
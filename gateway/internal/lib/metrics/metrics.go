@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	namespace = "gateway"
+
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of handled http requests.",
+		},
+		[]string{"route", "method", "code"},
+	)
+	httpDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of handled http requests.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+		},
+		[]string{"route", "method"},
+	)
+
+	grpcRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "requests_total",
+			Help:      "Number of finished grpc calls by status code.",
+		},
+		[]string{"grpc_method", "grpc_code"},
+	)
+	grpcDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of grpc calls.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+		},
+		[]string{"grpc_method"},
+	)
+	grpcInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "in_flight_streams",
+			Help:      "Number of open grpc streams.",
+		},
+		[]string{"grpc_method"},
+	)
+	grpcRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "retries_total",
+			Help:      "Number of grpc call attempts made by retry interceptor.",
+		},
+		[]string{"grpc_method"},
+	)
+
+	transferBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfer_bytes_total",
+			Help:      "Number of file bytes uploaded to and downloaded from filemanager.",
+		},
+		[]string{"grpc_method", "direction"},
+	)
+	transferSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transfer_size_bytes",
+			Help:      "Size of files transferred in one grpc stream.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 12),
+		},
+		[]string{"grpc_method", "direction"},
+	)
+)
+
+// chunkProvider is implemented by every file transfer message
+type chunkProvider interface {
+	GetChunk() []byte
+}
+
+// Handler returns handler which serves metrics in prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// HTTPMiddleware counts http requests and measures their latency by route
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		t1 := time.Now()
+		defer func() {
+			route := "unknown"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+
+			httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(code)).Inc()
+			httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(t1).Seconds())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// UnaryClientInterceptor collects metrics of unary calls.
+// It has to be chained after retry interceptor to see every attempt
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		countRetry(ctx, method)
+
+		t1 := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+		grpcDuration.WithLabelValues(method).Observe(time.Since(t1).Seconds())
+
+		return err
+	}
+}
+
+// StreamClientInterceptor collects metrics of streaming calls
+// including number of transferred file bytes
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		countRetry(ctx, method)
+
+		t1 := time.Now()
+		cs := &clientStream{method: method}
+
+		grpcInFlight.WithLabelValues(method).Inc()
+		opts = append(opts, grpc.OnFinish(func(err error) {
+			grpcInFlight.WithLabelValues(method).Dec()
+			grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+			grpcDuration.WithLabelValues(method).Observe(time.Since(t1).Seconds())
+
+			if sent := cs.sent.Load(); sent > 0 {
+				transferSize.WithLabelValues(method, directionUpload).Observe(float64(sent))
+			}
+			if received := cs.received.Load(); received > 0 {
+				transferSize.WithLabelValues(method, directionDownload).Observe(float64(received))
+			}
+		}))
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		cs.ClientStream = stream
+
+		return cs, nil
+	}
+}
+
+func countRetry(ctx context.Context, method string) {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok && len(md.Get(retry.AttemptMetadataKey)) > 0 {
+		grpcRetries.WithLabelValues(method).Inc()
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	method   string
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if c, ok := m.(chunkProvider); ok && err == nil {
+		n := len(c.GetChunk())
+		s.sent.Add(int64(n))
+		transferBytes.WithLabelValues(s.method, directionUpload).Add(float64(n))
+	}
+
+	return err
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if c, ok := m.(chunkProvider); ok && err == nil {
+		n := len(c.GetChunk())
+		s.received.Add(int64(n))
+		transferBytes.WithLabelValues(s.method, directionDownload).Add(float64(n))
+	}
+
+	return err
+}
@@ -0,0 +1,149 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize limits chunk of aws-chunked body kept in memory
+// while its signature is checked
+const maxChunkSize = 16 << 20
+
+// hashReader reports ErrContentSHA256Mismatch at the end of body
+// if SHA-256 of the body is not the signed one
+type hashReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want string
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) && hex.EncodeToString(h.hash.Sum(nil)) != h.want {
+		return n, ErrContentSHA256Mismatch
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes aws-chunked body. Every chunk is
+// "hex-size[;chunk-signature=sig]\r\n" followed by data and "\r\n".
+// The last chunk is empty and may be followed by trailing headers,
+// which are skipped: checksums in them are not verified
+type chunkedReader struct {
+	r *bufio.Reader
+	// auth is nil if chunks are not signed
+	auth    *Auth
+	prevSig string
+	buf     []byte
+	chunk   []byte
+	err     error
+}
+
+func newChunkedReader(body io.Reader, auth *Auth) *chunkedReader {
+	c := &chunkedReader{r: bufio.NewReader(body), auth: auth}
+	if auth != nil {
+		c.prevSig = auth.signature
+	}
+
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads the next chunk. It returns io.EOF after the last one
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return ErrInvalidRequest.WithMessage("Invalid chunk size")
+	}
+	if size > maxChunkSize {
+		return ErrEntityTooLarge.WithMessage("Chunk is too large")
+	}
+
+	if int64(cap(c.buf)) < size {
+		c.buf = make([]byte, size)
+	}
+	data := c.buf[:size]
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return ErrIncompleteBody
+	}
+
+	if c.auth != nil {
+		sig, ok := strings.CutPrefix(strings.TrimSpace(ext), "chunk-signature=")
+		if !ok {
+			return ErrSignatureDoesNotMatch.WithMessage("Chunk signature is missing")
+		}
+
+		want := c.auth.chunkSignature(c.prevSig, data)
+		if !hmac.Equal([]byte(sig), []byte(want)) {
+			return ErrSignatureDoesNotMatch
+		}
+		c.prevSig = sig
+	}
+
+	if size == 0 {
+		return c.skipTrailers()
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return ErrIncompleteBody
+	}
+
+	c.chunk = data
+	return nil
+}
+
+// skipTrailers reads trailing headers up to the empty line
+func (c *chunkedReader) skipTrailers() error {
+	for {
+		line, err := c.readLine()
+		if errors.Is(err, ErrIncompleteBody) {
+			// the last chunk may be not followed by the empty line
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return io.EOF
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return "", ErrInvalidRequest.WithMessage("Chunk header is too long")
+	case err != nil:
+		return "", ErrIncompleteBody
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
@@ -0,0 +1,180 @@
+// Package s3 implements parts of S3 protocol which do not depend on
+// the storage: signature verification, chunked payloads and multipart
+// uploads.
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+)
+
+// Error is an error reported to S3 clients with its code and status
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithMessage returns copy of error with another message
+func (e *Error) WithMessage(msg string) *Error {
+	c := *e
+	c.Message = msg
+	return &c
+}
+
+var (
+	ErrAccessDenied = &Error{"AccessDenied", "Access Denied", http.StatusForbidden}
+
+	ErrAuthorizationHeaderMalformed = &Error{
+		"AuthorizationHeaderMalformed",
+		"The authorization header is malformed",
+		http.StatusBadRequest,
+	}
+	ErrBadDigest = &Error{
+		"BadDigest",
+		"The Content-MD5 you specified did not match what was received",
+		http.StatusBadRequest,
+	}
+	ErrBucketAlreadyExists = &Error{
+		"BucketAlreadyOwnedByYou",
+		"The bucket you tried to create already exists",
+		http.StatusConflict,
+	}
+	ErrBucketNotEmpty = &Error{
+		"BucketNotEmpty",
+		"The bucket you tried to delete is not empty",
+		http.StatusConflict,
+	}
+	ErrEntityTooLarge = &Error{
+		"EntityTooLarge",
+		"Your proposed upload exceeds the maximum allowed size",
+		http.StatusBadRequest,
+	}
+	ErrIncompleteBody = &Error{
+		"IncompleteBody",
+		"You did not provide the number of bytes specified by the Content-Length HTTP header",
+		http.StatusBadRequest,
+	}
+	ErrInternal = &Error{
+		"InternalError",
+		"We encountered an internal error. Please try again",
+		http.StatusInternalServerError,
+	}
+	ErrInvalidAccessKeyID = &Error{
+		"InvalidAccessKeyId",
+		"The access key Id you provided does not exist in our records",
+		http.StatusForbidden,
+	}
+	ErrInvalidArgument   = &Error{"InvalidArgument", "Invalid Argument", http.StatusBadRequest}
+	ErrInvalidBucketName = &Error{
+		"InvalidBucketName",
+		"The specified bucket is not valid",
+		http.StatusBadRequest,
+	}
+	ErrInvalidDigest = &Error{
+		"InvalidDigest",
+		"The Content-MD5 you specified is not valid",
+		http.StatusBadRequest,
+	}
+	ErrInvalidPart = &Error{
+		"InvalidPart",
+		"One or more of the specified parts could not be found",
+		http.StatusBadRequest,
+	}
+	ErrInvalidPartOrder = &Error{
+		"InvalidPartOrder",
+		"The list of parts was not in ascending order",
+		http.StatusBadRequest,
+	}
+	ErrInvalidRequest = &Error{"InvalidRequest", "Invalid Request", http.StatusBadRequest}
+	ErrMalformedXML   = &Error{
+		"MalformedXML",
+		"The XML you provided was not well-formed or did not validate against our published schema",
+		http.StatusBadRequest,
+	}
+	ErrMethodNotAllowed = &Error{
+		"MethodNotAllowed",
+		"The specified method is not allowed against this resource",
+		http.StatusMethodNotAllowed,
+	}
+	ErrMissingContentLength = &Error{
+		"MissingContentLength",
+		"You must provide the Content-Length HTTP header",
+		http.StatusLengthRequired,
+	}
+	ErrNoSuchBucket = &Error{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	ErrNoSuchKey    = &Error{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	ErrNoSuchUpload = &Error{
+		"NoSuchUpload",
+		"The specified multipart upload does not exist",
+		http.StatusNotFound,
+	}
+	ErrNotImplemented = &Error{
+		"NotImplemented",
+		"A header you provided implies functionality that is not implemented",
+		http.StatusNotImplemented,
+	}
+	ErrRequestTimeTooSkewed = &Error{
+		"RequestTimeTooSkewed",
+		"The difference between the request time and the server's time is too large",
+		http.StatusForbidden,
+	}
+	ErrSignatureDoesNotMatch = &Error{
+		"SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided",
+		http.StatusForbidden,
+	}
+	ErrContentSHA256Mismatch = &Error{
+		"XAmzContentSHA256Mismatch",
+		"The provided 'x-amz-content-sha256' header does not match what was computed",
+		http.StatusBadRequest,
+	}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+// WriteError writes err as S3 error response. Errors which are not
+// *Error are reported as internal errors
+func WriteError(w http.ResponseWriter, r *http.Request, requestID string, err error) {
+	var s3err *Error
+	if !errors.As(err, &s3err) {
+		s3err = ErrInternal
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.WriteHeader(s3err.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(errorResponse{
+		Code:      s3err.Code,
+		Message:   s3err.Message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	})
+}
+
+// WriteXML writes successful response. Failed write means
+// that client is gone, so it is not reported
+func WriteXML(w http.ResponseWriter, requestID string, code int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.WriteHeader(code)
+
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,436 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	service    = "s3"
+	terminator = "aws4_request"
+
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// UnsignedPayload is a payload hash of requests which body is not signed
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// emptySHA256 is hex of SHA-256 of empty string
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Verifier checks AWS Signature Version 4 of requests, given either in
+// Authorization header or in query of presigned url
+type Verifier struct {
+	region  string
+	keys    map[string]string
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewVerifier creates verifier of requests signed for region with one
+// of keys, which maps access key id to secret access key. Requests made
+// more than maxSkew ago or ahead are rejected
+func NewVerifier(region string, keys map[string]string, maxSkew time.Duration) *Verifier {
+	return &Verifier{
+		region:  region,
+		keys:    keys,
+		maxSkew: maxSkew,
+		now:     time.Now,
+	}
+}
+
+// Auth is a verified signature of request
+type Auth struct {
+	AccessKey string
+
+	payload   string
+	key       []byte
+	scope     string
+	amzDate   string
+	signature string
+}
+
+// signedRequest is signature of request parsed from its header or query
+type signedRequest struct {
+	accessKey     string
+	date          string
+	region        string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payload       string
+	presigned     bool
+}
+
+// Verify checks signature of the request. Anonymous requests are denied
+func (v *Verifier) Verify(r *http.Request) (*Auth, error) {
+	var (
+		req *signedRequest
+		err error
+	)
+	if r.URL.Query().Has("X-Amz-Algorithm") {
+		req, err = v.parsePresigned(r)
+	} else {
+		req, err = v.parseHeader(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := v.keys[req.accessKey]
+	if !ok {
+		return nil, ErrInvalidAccessKeyID
+	}
+
+	scope := strings.Join([]string{req.date, req.region, service, terminator}, "/")
+	canonical := canonicalRequest(r, req)
+	stringToSign := strings.Join([]string{algorithm, req.amzDate, scope, hashHex([]byte(canonical))}, "\n")
+
+	key := signingKey(secret, req.date, req.region)
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(signature), []byte(req.signature)) {
+		return nil, ErrSignatureDoesNotMatch
+	}
+
+	return &Auth{
+		AccessKey: req.accessKey,
+		payload:   req.payload,
+		key:       key,
+		scope:     scope,
+		amzDate:   req.amzDate,
+		signature: req.signature,
+	}, nil
+}
+
+// parseHeader parses Authorization header like
+// "AWS4-HMAC-SHA256 Credential=AK/20060102/us-east-1/s3/aws4_request,
+// SignedHeaders=host;x-amz-date, Signature=hex"
+func (v *Verifier) parseHeader(r *http.Request) (*signedRequest, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrAccessDenied
+	}
+
+	fields, ok := strings.CutPrefix(header, algorithm+" ")
+	if !ok {
+		return nil, ErrAuthorizationHeaderMalformed.WithMessage("Unsupported authorization type")
+	}
+
+	params := make(map[string]string)
+	for _, field := range strings.Split(fields, ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, ErrAuthorizationHeaderMalformed
+		}
+		params[k] = val
+	}
+
+	req := &signedRequest{
+		signature: params["Signature"],
+		amzDate:   r.Header.Get("X-Amz-Date"),
+		payload:   r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	if err := req.parseCredential(params["Credential"]); err != nil {
+		return nil, err
+	}
+	if params["SignedHeaders"] == "" || req.signature == "" {
+		return nil, ErrAuthorizationHeaderMalformed
+	}
+	req.signedHeaders = strings.Split(params["SignedHeaders"], ";")
+
+	if req.payload == "" {
+		return nil, ErrInvalidRequest.WithMessage("Missing required header for this request: x-amz-content-sha256")
+	}
+	if !validPayload(req.payload) {
+		return nil, ErrInvalidArgument.WithMessage("x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-* or a valid sha256 value")
+	}
+
+	t, err := req.time(r)
+	if err != nil {
+		return nil, err
+	}
+	if d := v.now().Sub(t); d > v.maxSkew || d < -v.maxSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	return req, v.check(req)
+}
+
+// parsePresigned parses signature given by X-Amz-* query parameters
+func (v *Verifier) parsePresigned(r *http.Request) (*signedRequest, error) {
+	q := r.URL.Query()
+
+	if q.Get("X-Amz-Algorithm") != algorithm {
+		return nil, ErrAuthorizationHeaderMalformed.WithMessage("Unsupported signature algorithm")
+	}
+
+	req := &signedRequest{
+		signature: q.Get("X-Amz-Signature"),
+		amzDate:   q.Get("X-Amz-Date"),
+		payload:   UnsignedPayload,
+		presigned: true,
+	}
+	if err := req.parseCredential(q.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	if q.Get("X-Amz-SignedHeaders") == "" || req.signature == "" {
+		return nil, ErrAuthorizationHeaderMalformed.WithMessage("Query-string authentication requires X-Amz-SignedHeaders and X-Amz-Signature")
+	}
+	req.signedHeaders = strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+
+	t, err := time.Parse(timeFormat, req.amzDate)
+	if err != nil || !strings.HasPrefix(req.amzDate, req.date) {
+		return nil, ErrAuthorizationHeaderMalformed.WithMessage("X-Amz-Date is invalid")
+	}
+
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+		return nil, ErrAuthorizationHeaderMalformed.WithMessage("X-Amz-Expires is invalid")
+	}
+
+	now := v.now()
+	if t.Sub(now) > v.maxSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+	if now.After(t.Add(time.Duration(expires) * time.Second)) {
+		return nil, ErrAccessDenied.WithMessage("Request has expired")
+	}
+
+	return req, v.check(req)
+}
+
+// parseCredential parses "AK/20060102/us-east-1/s3/aws4_request"
+func (req *signedRequest) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" {
+		return ErrAuthorizationHeaderMalformed.WithMessage("The Credential is mal-formed")
+	}
+	if parts[3] != service || parts[4] != terminator {
+		return ErrAuthorizationHeaderMalformed.WithMessage("The Credential has invalid scope")
+	}
+	if _, err := time.Parse(dateFormat, parts[1]); err != nil {
+		return ErrAuthorizationHeaderMalformed.WithMessage("The Credential has invalid date")
+	}
+
+	req.accessKey, req.date, req.region = parts[0], parts[1], parts[2]
+	return nil
+}
+
+// time returns time of signing given by X-Amz-Date or Date header
+func (req *signedRequest) time(r *http.Request) (time.Time, error) {
+	if req.amzDate != "" {
+		t, err := time.Parse(timeFormat, req.amzDate)
+		if err != nil || !strings.HasPrefix(req.amzDate, req.date) {
+			return time.Time{}, ErrAuthorizationHeaderMalformed.WithMessage("X-Amz-Date is invalid")
+		}
+		return t, nil
+	}
+
+	t, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, ErrAccessDenied.WithMessage("AWS authentication requires a valid Date or x-amz-date header")
+	}
+	req.amzDate = t.UTC().Format(timeFormat)
+	if !strings.HasPrefix(req.amzDate, req.date) {
+		return time.Time{}, ErrAuthorizationHeaderMalformed.WithMessage("Date does not match the Credential")
+	}
+
+	return t, nil
+}
+
+// check checks region of the signature and that host is signed
+func (v *Verifier) check(req *signedRequest) error {
+	if v.region != "" && req.region != v.region {
+		return ErrAuthorizationHeaderMalformed.WithMessage(
+			"The authorization header is malformed; the region '" + req.region + "' is wrong; expecting '" + v.region + "'",
+		)
+	}
+
+	for _, h := range req.signedHeaders {
+		if h == "host" {
+			return nil
+		}
+	}
+
+	return ErrAccessDenied.WithMessage("Host header is not signed")
+}
+
+func validPayload(payload string) bool {
+	switch payload {
+	case UnsignedPayload, streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		return true
+	}
+
+	_, err := hex.DecodeString(payload)
+	return err == nil && len(payload) == sha256.Size*2
+}
+
+// canonicalRequest builds canonical form of the request which is signed
+func canonicalRequest(r *http.Request, req *signedRequest) string {
+	var b strings.Builder
+
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+
+	uri := escape(r.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+	b.WriteString(uri)
+	b.WriteByte('\n')
+
+	b.WriteString(canonicalQuery(r.URL.RawQuery, req.presigned))
+	b.WriteByte('\n')
+
+	for _, h := range req.signedHeaders {
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(headerValue(r, h))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+
+	b.WriteString(strings.Join(req.signedHeaders, ";"))
+	b.WriteByte('\n')
+	b.WriteString(req.payload)
+
+	return b.String()
+}
+
+func canonicalQuery(rawQuery string, presigned bool) string {
+	query, _ := url.ParseQuery(rawQuery)
+
+	pairs := make([]string, 0, len(query))
+	for k, values := range query {
+		if presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		for _, val := range values {
+			pairs = append(pairs, escape(k, true)+"="+escape(val, true))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// headerValue returns trimmed values of header joined by comma
+func headerValue(r *http.Request, name string) string {
+	if name == "host" {
+		return r.Host
+	}
+
+	values := r.Header.Values(name)
+	if name == "content-length" && len(values) == 0 && r.ContentLength >= 0 {
+		values = []string{strconv.FormatInt(r.ContentLength, 10)}
+	}
+
+	trimmed := make([]string, 0, len(values))
+	for _, val := range values {
+		trimmed = append(trimmed, strings.Join(strings.Fields(val), " "))
+	}
+
+	return strings.Join(trimmed, ",")
+}
+
+// escape encodes s as AWS does: every byte except unreserved characters
+// is percent-encoded. Slash is kept unless escapeSlash is set
+func escape(s string, escapeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		}
+	}
+
+	return b.String()
+}
+
+func signingKey(secret string, date string, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+
+	return hmacSHA256(key, []byte(terminator))
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Body returns body of the request checked against the signed payload.
+// Chunked payloads are decoded and their chunk signatures are checked.
+// Mismatch is reported by the read which reaches the end of the body
+func (a *Auth) Body(body io.Reader) io.Reader {
+	switch a.payload {
+	case UnsignedPayload:
+		return body
+	case streamingPayload, streamingPayloadTrailer:
+		return newChunkedReader(body, a)
+	case streamingUnsignedTrailer:
+		return newChunkedReader(body, nil)
+	}
+
+	return &hashReader{r: body, hash: sha256.New(), want: a.payload}
+}
+
+// Chunked reports whether body of the request is in aws-chunked encoding,
+// so its length is given by X-Amz-Decoded-Content-Length header
+func (a *Auth) Chunked() bool {
+	switch a.payload {
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		return true
+	}
+
+	return false
+}
+
+// chunkSignature returns signature of chunk following chunk signed by prev
+func (a *Auth) chunkSignature(prev string, chunk []byte) string {
+	stringToSign := strings.Join([]string{
+		algorithm + "-PAYLOAD",
+		a.amzDate,
+		a.scope,
+		prev,
+		emptySHA256,
+		hashHex(chunk),
+	}, "\n")
+
+	return hex.EncodeToString(hmacSHA256(a.key, []byte(stringToSign)))
+}
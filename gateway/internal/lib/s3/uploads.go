@@ -0,0 +1,416 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadFile = "upload.json"
+	partPrefix = "part-"
+
+	// MaxParts is the maximum number of parts of multipart upload
+	MaxParts = 10000
+)
+
+// Spooled is a body of request written to temporary file
+type Spooled struct {
+	*os.File
+	Size int64
+	MD5  []byte
+}
+
+// ETag returns quoted hex of MD5 of the body
+func (s *Spooled) ETag() string {
+	return `"` + hex.EncodeToString(s.MD5) + `"`
+}
+
+// Discard closes and removes the file
+func (s *Spooled) Discard() {
+	_ = s.File.Close()
+	_ = os.Remove(s.File.Name())
+}
+
+// Spool writes body to temporary file in dir, empty dir means the
+// default directory for temporary files. Body larger than limit is
+// rejected. If contentMD5 is not empty, it is base64 of MD5 which body
+// has to match. Returned file is positioned at its beginning
+func Spool(dir string, body io.Reader, contentMD5 string, limit int64) (*Spooled, error) {
+	var want []byte
+	if contentMD5 != "" {
+		var err error
+		want, err = base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(want) != md5.Size {
+			return nil, ErrInvalidDigest
+		}
+	}
+
+	file, err := os.CreateTemp(dir, "s3-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &Spooled{File: file}
+
+	hash := md5.New()
+	s.Size, err = io.Copy(io.MultiWriter(file, hash), io.LimitReader(body, limit+1))
+	if err != nil {
+		s.Discard()
+		return nil, err
+	}
+	if s.Size > limit {
+		s.Discard()
+		return nil, ErrEntityTooLarge
+	}
+
+	s.MD5 = hash.Sum(nil)
+	if want != nil && !bytes.Equal(want, s.MD5) {
+		s.Discard()
+		return nil, ErrBadDigest
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		s.Discard()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Upload is multipart upload which is not completed yet
+type Upload struct {
+	ID        string    `json:"id"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// Part is uploaded part of multipart upload
+type Part struct {
+	Number       int
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+// CompletedPart is a part listed by client completing upload
+type CompletedPart struct {
+	Number int
+	ETag   string
+}
+
+// Uploads keeps parts of multipart uploads in a directory, every upload
+// in its own subdirectory. Uploads which are neither completed nor aborted
+// are removed after ttl
+type Uploads struct {
+	dir string
+	ttl time.Duration
+	mu  sync.Mutex
+}
+
+// NewUploads creates store of uploads in dir
+func NewUploads(dir string, ttl time.Duration) (*Uploads, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Uploads{dir: dir, ttl: ttl}, nil
+}
+
+// Create starts upload of key in bucket. Expired uploads are removed
+func (u *Uploads) Create(bucket string, key string) (Upload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.sweep()
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Upload{}, err
+	}
+
+	upload := Upload{
+		ID:        hex.EncodeToString(buf),
+		Bucket:    bucket,
+		Key:       key,
+		Initiated: time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	dir := filepath.Join(u.dir, upload.ID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return Upload{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, uploadFile), data, 0o644); err != nil {
+		_ = os.RemoveAll(dir)
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// Get returns upload with id
+func (u *Uploads) Get(id string) (Upload, error) {
+	if !validID(id) {
+		return Upload{}, ErrNoSuchUpload
+	}
+
+	data, err := os.ReadFile(filepath.Join(u.dir, id, uploadFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Upload{}, ErrNoSuchUpload
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// PutPart stores part of upload, replacing part with the same number
+func (u *Uploads) PutPart(id string, number int, body io.Reader, contentMD5 string, limit int64) (Part, error) {
+	if _, err := u.Get(id); err != nil {
+		return Part{}, err
+	}
+	if number < 1 || number > MaxParts {
+		return Part{}, ErrInvalidArgument.WithMessage(fmt.Sprintf("Part number must be an integer between 1 and %d", MaxParts))
+	}
+
+	dir := filepath.Join(u.dir, id)
+	s, err := Spool(dir, body, contentMD5, limit)
+	if err != nil {
+		return Part{}, err
+	}
+	if err := s.File.Close(); err != nil {
+		s.Discard()
+		return Part{}, err
+	}
+
+	name := partPrefix + strconv.Itoa(number) + "-" + hex.EncodeToString(s.MD5)
+	if err := u.removePart(id, number); err != nil {
+		s.Discard()
+		return Part{}, err
+	}
+	if err := os.Rename(s.File.Name(), filepath.Join(dir, name)); err != nil {
+		s.Discard()
+		return Part{}, err
+	}
+
+	return Part{
+		Number:       number,
+		ETag:         s.ETag(),
+		Size:         s.Size,
+		LastModified: time.Now().UTC(),
+	}, nil
+}
+
+// Parts returns uploaded parts ordered by their numbers
+func (u *Uploads) Parts(id string) ([]Part, error) {
+	if _, err := u.Get(id); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(u.dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for _, e := range entries {
+		number, sum, ok := parsePartName(e.Name())
+		if !ok {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{
+			Number:       number,
+			ETag:         `"` + sum + `"`,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	return parts, nil
+}
+
+// Open returns concatenation of completed parts and its size. Parts have
+// to be listed in ascending order and match uploaded ones by their ETags
+func (u *Uploads) Open(id string, completed []CompletedPart) (io.ReadCloser, int64, error) {
+	parts, err := u.Parts(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(completed) == 0 {
+		return nil, 0, ErrMalformedXML.WithMessage("You must specify at least one part")
+	}
+
+	byNumber := make(map[int]Part, len(parts))
+	for _, p := range parts {
+		byNumber[p.Number] = p
+	}
+
+	files := make(multiFile, 0, len(completed))
+	var size int64
+	for i, c := range completed {
+		if i > 0 && c.Number <= completed[i-1].Number {
+			_ = files.Close()
+			return nil, 0, ErrInvalidPartOrder
+		}
+
+		p, ok := byNumber[c.Number]
+		if !ok || strings.Trim(c.ETag, `"`) != strings.Trim(p.ETag, `"`) {
+			_ = files.Close()
+			return nil, 0, ErrInvalidPart
+		}
+
+		name := partPrefix + strconv.Itoa(p.Number) + "-" + strings.Trim(p.ETag, `"`)
+		file, err := os.Open(filepath.Join(u.dir, id, name))
+		if err != nil {
+			_ = files.Close()
+			return nil, 0, err
+		}
+		files = append(files, file)
+		size += p.Size
+	}
+
+	return &files, size, nil
+}
+
+// Remove removes upload with its parts
+func (u *Uploads) Remove(id string) error {
+	if _, err := u.Get(id); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(u.dir, id))
+}
+
+func (u *Uploads) removePart(id string, number int) error {
+	entries, err := os.ReadDir(filepath.Join(u.dir, id))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if n, _, ok := parsePartName(e.Name()); ok && n == number {
+			if err := os.Remove(filepath.Join(u.dir, id, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sweep removes expired uploads
+func (u *Uploads) sweep() {
+	if u.ttl <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || !validID(e.Name()) {
+			continue
+		}
+
+		upload, err := u.Get(e.Name())
+		if err == nil && time.Since(upload.Initiated) < u.ttl {
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(u.dir, e.Name()))
+	}
+}
+
+// parsePartName parses "part-<number>-<md5>"
+func parsePartName(name string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(name, partPrefix)
+	if !ok {
+		return 0, "", false
+	}
+
+	numStr, sum, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, "", false
+	}
+
+	number, err := strconv.Atoi(numStr)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return number, sum, true
+}
+
+// validID reports whether id is generated by Create,
+// so it is safe to use it as a directory name
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+
+	return err == nil
+}
+
+// multiFile reads files one after another
+type multiFile []*os.File
+
+func (m *multiFile) Read(p []byte) (int, error) {
+	for len(*m) > 0 {
+		n, err := (*m)[0].Read(p)
+		if errors.Is(err, io.EOF) {
+			_ = (*m)[0].Close()
+			*m = (*m)[1:]
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+
+	return 0, io.EOF
+}
+
+func (m *multiFile) Close() error {
+	var errs []error
+	for _, f := range *m {
+		errs = append(errs, f.Close())
+	}
+	*m = nil
+
+	return errors.Join(errs...)
+}
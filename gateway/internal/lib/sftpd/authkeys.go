@@ -0,0 +1,119 @@
+package sftpd
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// expiryFormats are layouts of expiry-time option of authorized key
+var expiryFormats = []string{"20060102", "200601021504", "20060102150405"}
+
+// checkKeyOptions checks options of authorized key for connection from
+// remote at now. Server serves only sftp subsystem, so restrictions of
+// forwarding, pty and user rc are always satisfied. Options which
+// can not be honoured, like forced command, make the key unusable
+func checkKeyOptions(options []string, remote net.Addr, now time.Time) error {
+	for _, opt := range options {
+		name, value, hasValue := strings.Cut(opt, "=")
+		name = strings.ToLower(name)
+		value = strings.Trim(value, `"`)
+
+		switch {
+		case !hasValue && isRestriction(name):
+		case hasValue && name == "from":
+			if !matchFrom(value, remote) {
+				return fmt.Errorf("connection from %s is not allowed", remote)
+			}
+		case hasValue && name == "expiry-time":
+			expiry, err := parseExpiry(value)
+			if err != nil {
+				return err
+			}
+			if !now.Before(expiry) {
+				return fmt.Errorf("key expired at %s", expiry)
+			}
+		default:
+			return fmt.Errorf("option %q is not supported", name)
+		}
+	}
+
+	return nil
+}
+
+// isRestriction reports whether option without value only restricts
+// or permits features which the server does not provide
+func isRestriction(name string) bool {
+	switch name {
+	case "restrict", "no-agent-forwarding", "no-port-forwarding", "no-pty",
+		"no-user-rc", "no-x11-forwarding", "agent-forwarding",
+		"port-forwarding", "pty", "user-rc", "x11-forwarding":
+		return true
+	}
+
+	return false
+}
+
+// matchFrom matches ip address of remote with comma-separated patterns
+// of from option. Patterns are addresses with wildcards or CIDR masks,
+// negated pattern denies address even if it matches other patterns.
+// Host names are not resolved, so they never match
+func matchFrom(patterns string, remote net.Addr) bool {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		host = remote.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	matched := false
+	for _, p := range strings.Split(patterns, ",") {
+		p, negated := strings.CutPrefix(strings.TrimSpace(p), "!")
+		if !matchAddr(p, ip) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+func matchAddr(pattern string, ip net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && network.Contains(ip)
+	}
+	if strings.ContainsAny(pattern, "[]\\") {
+		return false
+	}
+
+	ok, err := path.Match(pattern, ip.String())
+	return err == nil && ok
+}
+
+// parseExpiry parses YYYYMMDD[HHMM[SS]] in local time or in UTC
+// if it is followed by Z
+func parseExpiry(value string) (time.Time, error) {
+	loc := time.Local
+	if v, ok := strings.CutSuffix(value, "Z"); ok {
+		value, loc = v, time.UTC
+	}
+
+	for _, layout := range expiryFormats {
+		if len(layout) != len(value) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry time %q", value)
+}
@@ -0,0 +1,460 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Storage stores files served over sftp
+type Storage interface {
+	GetFile(ctx context.Context, filename string) ([]byte, error)
+	PostFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	PutFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	DeleteFile(ctx context.Context, filename string) error
+	ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Stat(ctx context.Context, name string) (grpclient.FileInfo, error)
+	Mkdir(ctx context.Context, name string) error
+	Move(ctx context.Context, from string, to string) error
+}
+
+// handlers serve sftp requests of one user. Paths of requests
+// are resolved inside root directory of the user
+type handlers struct {
+	log     *slog.Logger
+	storage Storage
+	root    string
+	tempDir string
+}
+
+func newHandlers(log *slog.Logger, storage Storage, root string, tempDir string) sftp.Handlers {
+	h := &handlers{log: log, storage: storage, root: root, tempDir: tempDir}
+
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// name returns path in the store of path requested by client
+func (h *handlers) name(p string) string {
+	name := path.Join(h.root, strings.TrimPrefix(path.Clean("/"+p), "/"))
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// isRoot reports whether path requested by client is root of the user
+func (h *handlers) isRoot(p string) bool {
+	return path.Clean("/"+p) == "/"
+}
+
+// Fileread opens file for reading. File is downloaded at once
+func (h *handlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name := h.name(r.Filepath)
+
+	info, err := h.storage.Stat(r.Context(), name)
+	if err != nil {
+		return nil, h.error(r, err)
+	}
+	if info.IsDir {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	data, err := h.storage.GetFile(r.Context(), name)
+	if err != nil {
+		return nil, h.error(r, err)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// Filewrite opens file for writing. Written file is buffered in
+// temporary file and uploaded when client closes it
+func (h *handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	name := h.name(r.Filepath)
+	flags := r.Pflags()
+
+	info, err := h.storage.Stat(r.Context(), name)
+	exists := err == nil
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, h.error(r, err)
+	}
+
+	switch {
+	case exists && info.IsDir:
+		return nil, sftp.ErrSSHFxFailure
+	case exists && flags.Excl:
+		return nil, sftp.ErrSSHFxFailure
+	case !exists && !flags.Creat:
+		return nil, sftp.ErrSSHFxNoSuchFile
+	case !exists:
+		if err := h.checkParent(r, r.Filepath); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := os.CreateTemp(h.tempDir, "sftp-*")
+	if err != nil {
+		return nil, h.error(r, err)
+	}
+
+	w := &writeFile{
+		File:   tmp,
+		h:      h,
+		ctx:    r.Context(),
+		name:   name,
+		exists: exists,
+		append: flags.Append,
+	}
+	if exists && !flags.Trunc {
+		if err := w.prefill(); err != nil {
+			w.discard()
+			return nil, h.error(r, err)
+		}
+	}
+
+	return w, nil
+}
+
+// Filecmd runs commands changing files
+func (h *handlers) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// only size can not be kept as requested, times and
+		// permissions are not stored, so they are ignored
+		if r.AttrFlags().Size {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		_, err := h.storage.Stat(r.Context(), h.name(r.Filepath))
+		return h.error(r, err)
+	case "Rename":
+		return h.rename(r)
+	case "Rmdir":
+		return h.remove(r, true)
+	case "Remove":
+		return h.remove(r, false)
+	case "Mkdir":
+		return h.mkdir(r)
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// rename moves file or directory. Existing target is not replaced
+func (h *handlers) rename(r *sftp.Request) error {
+	if h.isRoot(r.Filepath) || h.isRoot(r.Target) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	from, to := h.name(r.Filepath), h.name(r.Target)
+
+	_, err := h.storage.Stat(r.Context(), to)
+	if err == nil {
+		return sftp.ErrSSHFxFailure
+	}
+	if status.Code(err) != codes.NotFound {
+		return h.error(r, err)
+	}
+	if err := h.checkParent(r, r.Target); err != nil {
+		return err
+	}
+
+	if err := h.storage.Move(r.Context(), from, to); err != nil {
+		return h.error(r, err)
+	}
+
+	h.log.Info("file is moved", slog.String("from", from), slog.String("to", to))
+	return nil
+}
+
+// remove deletes file or, if dir is set, empty directory
+func (h *handlers) remove(r *sftp.Request, dir bool) error {
+	if h.isRoot(r.Filepath) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	name := h.name(r.Filepath)
+
+	info, err := h.storage.Stat(r.Context(), name)
+	if err != nil {
+		return h.error(r, err)
+	}
+	if info.IsDir != dir {
+		return sftp.ErrSSHFxFailure
+	}
+
+	err = h.storage.DeleteFile(r.Context(), name)
+	if status.Code(err) == codes.InvalidArgument {
+		// directory is not empty
+		return sftp.ErrSSHFxFailure
+	}
+	if err != nil {
+		return h.error(r, err)
+	}
+
+	h.log.Info("file is deleted", slog.String("name", name))
+	return nil
+}
+
+// mkdir creates directory. Parent directory has to exist
+func (h *handlers) mkdir(r *sftp.Request) error {
+	if h.isRoot(r.Filepath) {
+		return sftp.ErrSSHFxFailure
+	}
+	if err := h.checkParent(r, r.Filepath); err != nil {
+		return err
+	}
+
+	err := h.storage.Mkdir(r.Context(), h.name(r.Filepath))
+	if status.Code(err) == codes.AlreadyExists {
+		return sftp.ErrSSHFxFailure
+	}
+
+	return h.error(r, err)
+}
+
+// Filelist lists directory or describes one file
+func (h *handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := h.name(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		info, err := h.storage.Stat(r.Context(), name)
+		if err != nil {
+			return nil, h.error(r, err)
+		}
+		if !info.IsDir {
+			return nil, sftp.ErrSSHFxFailure
+		}
+
+		files, err := h.storage.ListFiles(r.Context(), name, false)
+		if err != nil {
+			return nil, h.error(r, err)
+		}
+
+		entries := make(listerAt, 0, len(files))
+		for _, f := range files {
+			entries = append(entries, fileInfo{f})
+		}
+		return entries, nil
+	case "Stat", "Lstat":
+		info, err := h.storage.Stat(r.Context(), name)
+		if err != nil {
+			return nil, h.error(r, err)
+		}
+		return listerAt{fileInfo{info}}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// checkParent returns ErrSSHFxNoSuchFile if parent
+// directory of path requested by client is missing
+func (h *handlers) checkParent(r *sftp.Request, p string) error {
+	parent := path.Dir(path.Clean("/" + p))
+	if parent == "/" {
+		return nil
+	}
+
+	info, err := h.storage.Stat(r.Context(), h.name(parent))
+	if err != nil {
+		return h.error(r, err)
+	}
+	if !info.IsDir {
+		return sftp.ErrSSHFxNoSuchFile
+	}
+
+	return nil
+}
+
+// error converts error of the store to sftp status.
+// Unexpected errors are logged and reported as failures
+func (h *handlers) error(r *sftp.Request, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case status.Code(err) == codes.NotFound:
+		return sftp.ErrSSHFxNoSuchFile
+	case status.Code(err) == codes.AlreadyExists:
+		return sftp.ErrSSHFxFailure
+	case errors.Is(err, context.Canceled):
+		return sftp.ErrSSHFxConnectionLost
+	}
+
+	h.log.Error(
+		"request failed",
+		slog.String("method", r.Method),
+		slog.String("path", r.Filepath),
+		sl.Err(err),
+	)
+	return sftp.ErrSSHFxFailure
+}
+
+// writeFile buffers written file in temporary file
+// and uploads it when it is closed
+type writeFile struct {
+	*os.File
+	h      *handlers
+	ctx    context.Context
+	name   string
+	exists bool
+	append bool
+	failed bool
+
+	mu sync.Mutex
+}
+
+func (w *writeFile) prefill() error {
+	data, err := w.h.storage.GetFile(w.ctx, w.name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.File.Write(data)
+	return err
+}
+
+// WriteAt writes p at offset. Files opened for appending
+// are written at the end whatever offset is requested
+func (w *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	if !w.append {
+		return w.File.WriteAt(p, off)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.File.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+
+	return w.File.Write(p)
+}
+
+// TransferError is called if transfer is broken,
+// so partly written file is not uploaded
+func (w *writeFile) TransferError(error) {
+	w.failed = true
+}
+
+// Close uploads written file. New file is created, existing one is replaced
+func (w *writeFile) Close() error {
+	defer w.discard()
+
+	if w.failed {
+		return nil
+	}
+
+	stat, err := w.File.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := fileHeader{name: path.Base(w.name), size: stat.Size()}
+	if w.exists {
+		err = w.h.storage.PutFile(w.ctx, w.File, header, w.name)
+	} else {
+		err = w.h.storage.PostFile(w.ctx, w.File, header, w.name)
+	}
+	if err != nil {
+		w.h.log.Error("failed to upload file", slog.String("name", w.name), sl.Err(err))
+		return sftp.ErrSSHFxFailure
+	}
+
+	w.h.log.Info("file is uploaded", slog.String("name", w.name), slog.Int64("size", stat.Size()))
+	return nil
+}
+
+func (w *writeFile) discard() {
+	_ = w.File.Close()
+	_ = os.Remove(w.File.Name())
+}
+
+type fileHeader struct {
+	name string
+	size int64
+}
+
+func (h fileHeader) Name() string {
+	return h.name
+}
+
+func (h fileHeader) Size() int64 {
+	return h.size
+}
+
+// listerAt lists described files
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// fileInfo adapts file description of filemanager to os.FileInfo
+type fileInfo struct {
+	grpclient.FileInfo
+}
+
+func (i fileInfo) Name() string {
+	return path.Base(i.FileInfo.Name)
+}
+
+func (i fileInfo) Size() int64 {
+	return i.FileInfo.Size
+}
+
+func (i fileInfo) Mode() os.FileMode {
+	if i.FileInfo.IsDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return i.FileInfo.ModTime
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.FileInfo.IsDir
+}
+
+func (i fileInfo) Sys() any {
+	return nil
+}
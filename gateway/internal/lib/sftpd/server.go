@@ -0,0 +1,446 @@
+// Package sftpd serves files of filemanagers over SFTP. Every user
+// signs in with password or public key and sees only its root
+// directory of the store.
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serverVersion = "SSH-2.0-filemanager"
+	maxAuthTries  = 6
+
+	// userExtension keeps name of authenticated user in ssh permissions
+	userExtension = "user"
+)
+
+// User is a user of sftp server
+type User struct {
+	Name string
+	// PasswordHash is bcrypt hash of password,
+	// empty hash disables password authentication
+	PasswordHash string
+	// AuthorizedKeys is path of file with public keys in
+	// authorized_keys format. It is read on every sign in. Options from,
+	// expiry-time and restrictions are honoured, keys with other options
+	// like command are refused
+	AuthorizedKeys string
+	// Root is directory of the store which is root of the user
+	Root string
+}
+
+type Options struct {
+	HostKey     string
+	IdleTimeout time.Duration
+	// TempDir keeps uploaded files until they are closed
+	TempDir string
+	Users   []User
+}
+
+// Server serves sftp subsystem over ssh connections
+type Server struct {
+	log     *slog.Logger
+	storage Storage
+	opts    Options
+	users   map[string]User
+	config  *ssh.ServerConfig
+	// dummyHash is compared with passwords of unknown users,
+	// so they are rejected as slowly as wrong passwords
+	dummyHash []byte
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*ssh.ServerConn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// New creates sftp server. Host key is loaded from opts.HostKey,
+// it is generated and saved if the file does not exist
+func New(log *slog.Logger, storage Storage, opts Options) (*Server, error) {
+	const op = "sftpd.New"
+
+	users := make(map[string]User, len(opts.Users))
+	for _, u := range opts.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("%s: user without name", op)
+		}
+		if _, ok := users[u.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicated user %q", op, u.Name)
+		}
+		u.Root = strings.Trim(path.Clean("/"+u.Root), "/")
+		users[u.Name] = u
+	}
+
+	hostKey, err := loadHostKey(opts.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dummyHash, err := newDummyHash(opts.Users)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		log:       log,
+		storage:   storage,
+		opts:      opts,
+		users:     users,
+		dummyHash: dummyHash,
+		conns:     make(map[*ssh.ServerConn]struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+		MaxAuthTries:      maxAuthTries,
+		ServerVersion:     serverVersion,
+		AuthLogCallback: func(conn ssh.ConnMetadata, method string, err error) {
+			if err != nil && method != "none" {
+				log.Warn(
+					"authentication failed",
+					slog.String("user", conn.User()),
+					slog.String("method", method),
+					slog.String("remote", conn.RemoteAddr().String()),
+				)
+			}
+		},
+	}
+	s.config.AddHostKey(hostKey)
+
+	return s, nil
+}
+
+// loadHostKey reads private host key. Missing key is generated
+func loadHostKey(file string) (ssh.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+// newDummyHash hashes random password with the highest cost of
+// password hashes of users
+func newDummyHash(users []User) ([]byte, error) {
+	cost := bcrypt.DefaultCost
+	for _, u := range users {
+		if c, err := bcrypt.Cost([]byte(u.PasswordHash)); err == nil && c > cost {
+			cost = c
+		}
+	}
+
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+
+	return bcrypt.GenerateFromPassword(password, cost)
+}
+
+func (s *Server) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user, ok := s.users[conn.User()]
+	if !ok || user.PasswordHash == "" {
+		// the password is checked anyway, so time of the answer
+		// does not tell whether the user exists
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash, password)
+		return nil, errors.New("password authentication is not allowed")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), password); err != nil {
+		return nil, errors.New("wrong password")
+	}
+
+	return permissions(user), nil
+}
+
+func (s *Server) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user, ok := s.users[conn.User()]
+	if !ok || user.AuthorizedKeys == "" {
+		return nil, errors.New("public key authentication is not allowed")
+	}
+
+	data, err := os.ReadFile(user.AuthorizedKeys)
+	if err != nil {
+		s.log.Error("failed to read authorized keys", slog.String("user", user.Name), sl.Err(err))
+		return nil, err
+	}
+
+	marshaled := key.Marshal()
+	for len(data) > 0 {
+		authorized, _, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		data = rest
+		if !bytes.Equal(authorized.Marshal(), marshaled) {
+			continue
+		}
+
+		if err := checkKeyOptions(options, conn.RemoteAddr(), time.Now()); err != nil {
+			s.log.Warn("authorized key is refused", slog.String("user", user.Name), sl.Err(err))
+			continue
+		}
+		return permissions(user), nil
+	}
+
+	return nil, errors.New("unknown public key")
+}
+
+func permissions(user User) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{userExtension: user.Name}}
+}
+
+// Serve accepts connections until server is closed
+func (s *Server) Serve(l net.Listener) error {
+	const op = "sftpd.Serve"
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and closes active ones.
+// Files which are being uploaded are discarded
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+
+	return err
+}
+
+// serveConn serves one ssh connection
+func (s *Server) serveConn(netConn net.Conn) {
+	const op = "sftpd.serveConn"
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("remote", netConn.RemoteAddr().String()),
+	)
+
+	if s.opts.IdleTimeout > 0 {
+		netConn = &idleConn{Conn: netConn, timeout: s.opts.IdleTimeout}
+	}
+
+	conn, channels, requests, err := ssh.NewServerConn(netConn, s.config)
+	if err != nil {
+		log.Debug("ssh handshake failed", sl.Err(err))
+		_ = netConn.Close()
+		return
+	}
+	defer conn.Close()
+
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+
+	user := s.users[conn.Permissions.Extensions[userExtension]]
+	log = log.With(slog.String("user", user.Name))
+	log.Info("user connected")
+	defer log.Info("user disconnected")
+
+	if err := s.ensureRoot(user.Root); err != nil {
+		log.Error("failed to create root directory of user", sl.Err(err))
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, chanRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Warn("failed to accept channel", sl.Err(err))
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveSession(log, user, channel, chanRequests)
+		}()
+	}
+}
+
+// serveSession runs sftp subsystem in session channel.
+// Shells and commands are refused
+func (s *Server) serveSession(
+	log *slog.Logger,
+	user User,
+	channel ssh.Channel,
+	requests <-chan *ssh.Request,
+) {
+	defer channel.Close()
+
+	for req := range requests {
+		var payload struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+
+		go ssh.DiscardRequests(requests)
+
+		server := sftp.NewRequestServer(
+			channel,
+			newHandlers(log, s.storage, user.Root, s.opts.TempDir),
+			sftp.WithStartDirectory("/"),
+		)
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Warn("sftp session ended with error", sl.Err(err))
+		}
+		_ = server.Close()
+		return
+	}
+}
+
+// ensureRoot creates root directory of user with its parents
+func (s *Server) ensureRoot(root string) error {
+	if root == "" {
+		return nil
+	}
+
+	dir := ""
+	for _, part := range strings.Split(root, "/") {
+		dir = path.Join(dir, part)
+		err := s.storage.Mkdir(s.ctx, dir)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) track(conn *ssh.ServerConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (s *Server) untrack(conn *ssh.ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// idleConn is closed if nothing is read or written during timeout
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,210 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultDir is a directory of filemanager which stores download counters
+	DefaultDir    = ".share"
+	purgeInterval = time.Hour
+	purgeTimeout  = time.Minute
+)
+
+// Files stores download counters, it is filemanager shared by all gateways
+type Files interface {
+	PostFile(
+		ctx context.Context,
+		data grpclient.DataProvider,
+		header grpclient.DataHeader,
+		filename string,
+	) error
+	DeleteFile(ctx context.Context, filename string) error
+	ListFiles(ctx context.Context, dir string, recursive bool) ([]grpclient.FileInfo, error)
+	Mkdir(ctx context.Context, name string) error
+}
+
+// Downloads counts downloads of the links in filemanager, so the limit
+// is shared by every gateway. Each link has directory named by its
+// expiry and id, and every download holds an empty slot file in it.
+// Slot is taken by creating its file, which fails if it already exists
+type Downloads struct {
+	log   *slog.Logger
+	files Files
+	dir   string
+
+	mu       sync.Mutex
+	purgedAt time.Time
+}
+
+func NewDownloads(log *slog.Logger, files Files, dir string) *Downloads {
+	if dir == "" {
+		dir = DefaultDir
+	}
+
+	return &Downloads{
+		log:   log,
+		files: files,
+		dir:   dir,
+	}
+}
+
+// Acquire takes free download slot of the link and returns its name
+func (d *Downloads) Acquire(ctx context.Context, link Link, now time.Time) (string, error) {
+	const op = "share.Downloads.Acquire"
+
+	d.purgeLater(now)
+
+	dir := d.linkDir(link)
+	taken := make(map[string]bool)
+	files, err := d.files.ListFiles(ctx, dir, false)
+	switch status.Code(err) {
+	case codes.OK:
+		for _, f := range files {
+			taken[f.Name] = true
+		}
+	case codes.NotFound:
+		err = d.files.Mkdir(ctx, dir)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	default:
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if len(taken) >= link.MaxDownloads {
+		return "", fmt.Errorf("%s: %w", op, ErrLimitExceeded)
+	}
+
+	for i := range link.MaxDownloads {
+		slot := path.Join(dir, strconv.Itoa(i))
+		if taken[slot] {
+			continue
+		}
+
+		err := d.files.PostFile(ctx, emptyFile{}, emptyFile{name: slot}, slot)
+		if status.Code(err) == codes.AlreadyExists {
+			// taken by another download since listing
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		return slot, nil
+	}
+
+	return "", fmt.Errorf("%s: %w", op, ErrLimitExceeded)
+}
+
+// Release frees slot taken by Acquire
+func (d *Downloads) Release(ctx context.Context, slot string) error {
+	const op = "share.Downloads.Release"
+
+	if err := d.files.DeleteFile(ctx, slot); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// linkDir returns directory of the link counters.
+// Expiry is a prefix of the name, so purge does not parse links
+func (d *Downloads) linkDir(link Link) string {
+	return path.Join(d.dir, strconv.FormatInt(link.ExpiresAt, 10)+"-"+link.ID)
+}
+
+// purgeLater starts purge if it was not done for purgeInterval
+func (d *Downloads) purgeLater(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.purgedAt) < purgeInterval {
+		return
+	}
+	d.purgedAt = now
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+		defer cancel()
+
+		d.purge(ctx, now)
+	}()
+}
+
+// purge deletes counters of the expired links
+func (d *Downloads) purge(ctx context.Context, now time.Time) {
+	const op = "share.Downloads.purge"
+	log := d.log.With(slog.String("op", op))
+
+	dirs, err := d.files.ListFiles(ctx, d.dir, false)
+	if status.Code(err) == codes.NotFound {
+		return
+	}
+	if err != nil {
+		log.Warn("failed to list share links", sl.Err(err))
+		return
+	}
+
+	purged := 0
+	for _, dir := range dirs {
+		exp, _, _ := strings.Cut(path.Base(dir.Name), "-")
+		expiresAt, err := strconv.ParseInt(exp, 10, 64)
+		if !dir.IsDir || err != nil || now.Unix() < expiresAt {
+			continue
+		}
+
+		slots, err := d.files.ListFiles(ctx, dir.Name, false)
+		if err != nil {
+			log.Warn("failed to list download slots", slog.String("dir", dir.Name), sl.Err(err))
+			continue
+		}
+		for _, slot := range slots {
+			if err := d.files.DeleteFile(ctx, slot.Name); err != nil {
+				log.Warn("failed to delete download slot", slog.String("slot", slot.Name), sl.Err(err))
+			}
+		}
+		if err := d.files.DeleteFile(ctx, dir.Name); err != nil {
+			log.Warn("failed to delete share link", slog.String("dir", dir.Name), sl.Err(err))
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		log.Info("expired share links are purged", slog.Int("count", purged))
+	}
+}
+
+// emptyFile is a content and a header of slot file
+type emptyFile struct {
+	name string
+}
+
+func (emptyFile) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (emptyFile) Close() error {
+	return nil
+}
+
+func (f emptyFile) Name() string {
+	return f.name
+}
+
+func (emptyFile) Size() int64 {
+	return 0
+}
@@ -0,0 +1,138 @@
+package share
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid share token")
+	ErrExpired       = errors.New("share link is expired")
+	ErrMethod        = errors.New("method is not allowed by share link")
+	ErrLimitExceeded = errors.New("share link download limit is exceeded")
+)
+
+// Link is a payload of the share link. It is signed as is, so every
+// field of the link is protected from modification.
+type Link struct {
+	ID           string `json:"id"`
+	Path         string `json:"path"`
+	Method       string `json:"method"`
+	ExpiresAt    int64  `json:"exp"`
+	MaxDownloads int    `json:"max,omitempty"`
+}
+
+// Signer mints and verifies HMAC-signed share tokens and counts
+// downloads of the links with a download limit.
+type Signer struct {
+	secret    []byte
+	downloads *Downloads
+}
+
+func New(secret string, downloads *Downloads) *Signer {
+	return &Signer{
+		secret:    []byte(secret),
+		downloads: downloads,
+	}
+}
+
+// Sign returns token for the link. If link has no ID, a random one is generated
+func (s *Signer) Sign(link Link) (string, error) {
+	const op = "share.Sign"
+
+	if link.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		link.ID = id
+	}
+
+	payload, err := json.Marshal(link)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// Verify checks the token signature, expiry and allowed method and
+// returns the link encoded into the token.
+func (s *Signer) Verify(token string, method string, now time.Time) (Link, error) {
+	const op = "share.Verify"
+
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	var link Link
+	if err = json.Unmarshal(payload, &link); err != nil {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if now.Unix() >= link.ExpiresAt {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrExpired)
+	}
+
+	if link.Method != method {
+		return Link{}, fmt.Errorf("%s: %w", op, ErrMethod)
+	}
+
+	return link, nil
+}
+
+// Acquire reserves one download of the link and returns its slot, which
+// is passed to Release. Links without download limit are always acquired
+func (s *Signer) Acquire(ctx context.Context, link Link, now time.Time) (string, error) {
+	if link.MaxDownloads <= 0 {
+		return "", nil
+	}
+
+	return s.downloads.Acquire(ctx, link, now)
+}
+
+// Release returns download reserved by Acquire, e.g. if it was failed
+func (s *Signer) Release(ctx context.Context, slot string) error {
+	if slot == "" {
+		return nil
+	}
+
+	return s.downloads.Release(ctx, slot)
+}
+
+func (s *Signer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+type Options struct {
+	ServiceName string
+	// Exporter is one of "none", "otlp" or "file"
+	Exporter string
+	// Endpoint is an address of OTLP gRPC collector
+	Endpoint string
+	Insecure bool
+	// FilePath is a file which spans are written into by file exporter
+	FilePath    string
+	SampleRatio float64
+}
+
+// Provider owns global tracer provider and its exporter
+type Provider struct {
+	log  *slog.Logger
+	tp   *sdktrace.TracerProvider
+	file *os.File
+}
+
+// MustNew is New wrapper.
+// If New ends with error panic occurs
+func MustNew(log *slog.Logger, opts Options) *Provider {
+	p, err := New(log, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// New sets up global W3C trace context propagator and tracer provider
+// with the exporter selected in options.
+func New(log *slog.Logger, opts Options) (*Provider, error) {
+	const op = "tracing.New"
+	log = log.With(slog.String("op", op))
+
+	otel.SetTextMapPropagator(
+		propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		),
+	)
+
+	p := &Provider{log: log}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch opts.Exporter {
+	case "", ExporterNone:
+		log.Info("tracing is disabled")
+		return p, nil
+	case ExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err = otlptracegrpc.New(context.Background(), clientOpts...)
+	case ExporterFile:
+		p.file, err = os.OpenFile(opts.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(p.file))
+	default:
+		return nil, fmt.Errorf("%s: unknown exporter %q", op, opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	p.tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(
+			sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio)),
+		),
+		sdktrace.WithResource(
+			resource.NewSchemaless(attribute.String("service.name", opts.ServiceName)),
+		),
+	)
+	otel.SetTracerProvider(p.tp)
+
+	log.Info("tracing is enabled", slog.String("exporter", opts.Exporter))
+	return p, nil
+}
+
+// Stop flushes remaining spans and stops exporter
+func (p *Provider) Stop() {
+	const op = "tracing.Stop"
+	log := p.log.With(slog.String("op", op))
+
+	if p.tp == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := p.tp.Shutdown(ctx); err != nil {
+		log.Error("failed to shutdown tracer provider", sl.Err(err))
+	}
+
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			log.Error("failed to close trace file", sl.Err(err))
+		}
+	}
+}
+
+// HTTPMiddleware starts server span for every request and names it
+// by chi route pattern once the request is routed
+func HTTPMiddleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil || rctx.RoutePattern() == "" {
+			return
+		}
+
+		route := rctx.RoutePattern()
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+	})
+
+	return otelhttp.NewHandler(named, "http.request")
+}
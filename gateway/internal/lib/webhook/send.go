@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature is "sha256=" followed by hex of HMAC-SHA256
+	// of timestamp, "." and the body, keyed by subscription secret
+	HeaderSignature = "X-Webhook-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// Payload is a body of delivery. ID is the same for every attempt,
+// so receiver can drop repeated deliveries
+type Payload struct {
+	ID           string           `json:"id"`
+	Subscription string           `json:"subscription"`
+	Event        grpclient.Change `json:"event"`
+	Attempt      int              `json:"attempt"`
+}
+
+// Sign returns signature of the body sent at timestamp
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature of the body is valid
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// send posts delivery to the subscription url. Any 2xx response means
+// the delivery is accepted. It returns status code of the response
+func (d *Dispatcher) send(ctx context.Context, sub Subscription, dl Delivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		ID:           dl.ID,
+		Subscription: sub.ID,
+		Event:        dl.Change,
+		Attempt:      dl.Attempts,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, dl.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay before the next attempt. It grows exponentially
+// up to MaxBackoff and is randomized by 20%, so failed deliveries are spread
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := float64(d.opts.InitialBackoff) * math.Pow(2, float64(attempts-1))
+	delay = math.Min(delay, float64(d.opts.MaxBackoff))
+	delay *= 1 + 0.2*(2*mrand.Float64()-1)
+
+	return time.Duration(delay)
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
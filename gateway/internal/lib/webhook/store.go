@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	subscriptionsFile = "subscriptions.json"
+	pendingDir        = "pending"
+	historyFile       = "history.jsonl"
+	cursorFile        = "cursor"
+)
+
+// store keeps state of the dispatcher in a directory. Every pending delivery
+// is a separate file, finished deliveries are appended to history file
+type store struct {
+	dir          string
+	historyLimit int
+	history      *os.File
+}
+
+func openStore(dir string, historyLimit int) (*store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, pendingDir), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &store{dir: dir, historyLimit: historyLimit}, nil
+}
+
+// loadSubscriptions returns subscriptions created by API
+func (s *store) loadSubscriptions() ([]Subscription, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, subscriptionsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// saveSubscriptions stores subscriptions which are not static
+func (s *store) saveSubscriptions(subs map[string]Subscription) error {
+	stored := make([]Subscription, 0, len(subs))
+	for _, sub := range subs {
+		if !sub.Static {
+			stored = append(stored, sub)
+		}
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(s.dir, subscriptionsFile), data, 0o600)
+}
+
+func (s *store) loadPending() ([]*Delivery, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, pendingDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Delivery
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, pendingDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var dl Delivery
+		if err := json.Unmarshal(data, &dl); err != nil {
+			return nil, err
+		}
+		res = append(res, &dl)
+	}
+
+	return res, nil
+}
+
+func (s *store) savePending(dl *Delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(s.pendingPath(dl.ID), data, 0o644)
+}
+
+func (s *store) removePending(id string) error {
+	err := os.Remove(s.pendingPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *store) pendingPath(id string) string {
+	return filepath.Join(s.dir, pendingDir, id+".json")
+}
+
+// loadHistory returns the latest finished deliveries and rewrites
+// history file with them, so it does not grow without limit
+func (s *store) loadHistory() ([]Delivery, error) {
+	path := filepath.Join(s.dir, historyFile)
+
+	var history []Delivery
+	file, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var dl Delivery
+			if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+				// the last line may be torn by crash while appending
+				break
+			}
+			history = append(history, dl)
+		}
+		_ = file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if over := len(history) - s.historyLimit; over > 0 {
+		history = history[over:]
+	}
+
+	var buf bytes.Buffer
+	for _, dl := range history {
+		line, err := json.Marshal(dl)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := writeFile(path, buf.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+
+	s.history, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (s *store) appendHistory(dl Delivery) error {
+	line, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.history.Write(append(line, '\n'))
+	return err
+}
+
+func (s *store) loadCursor() (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *store) saveCursor(cursor string) error {
+	return writeFile(filepath.Join(s.dir, cursorFile), []byte(cursor), 0o644)
+}
+
+func (s *store) close() error {
+	if s.history == nil {
+		return nil
+	}
+
+	return s.history.Close()
+}
+
+// writeFile replaces file atomically
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
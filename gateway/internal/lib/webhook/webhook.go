@@ -0,0 +1,625 @@
+// Package webhook delivers changes of files to subscribed urls.
+// Changes are read from filemanager journals, so deliveries survive
+// restarts of the gateway and are made at least once.
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	grpclient "lab3/internal/clients/fm/grpc"
+	"lab3/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrInvalidSubscription = errors.New("invalid subscription")
+	ErrExists              = errors.New("subscription already exists")
+	ErrNotFound            = errors.New("subscription not found")
+	ErrStatic              = errors.New("subscription is configured statically")
+
+	events = []string{"create", "update", "delete", "move", "mkdir"}
+)
+
+// Source lists journaled changes of files
+type Source interface {
+	ListChanges(ctx context.Context, cursor string, limit int) ([]grpclient.Change, string, bool, error)
+}
+
+// Subscription selects changes of files under Path which are delivered to URL.
+// Without Recursive only files directly in Path match. Empty Events matches
+// all kinds of changes. Deliveries are signed with Secret
+type Subscription struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Path      string   `json:"path"`
+	Recursive bool     `json:"recursive"`
+	Events    []string `json:"events,omitempty"`
+	// Static subscriptions are configured in yaml and cannot be removed by API
+	Static bool `json:"static"`
+}
+
+func (s Subscription) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url %q", ErrInvalidSubscription, s.URL)
+	}
+	if s.Path == "" || path.Clean(s.Path) != s.Path || strings.HasPrefix(s.Path, "/") {
+		return fmt.Errorf("%w: path %q", ErrInvalidSubscription, s.Path)
+	}
+	for _, e := range s.Events {
+		if !slices.Contains(events, e) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidSubscription, e)
+		}
+	}
+
+	return nil
+}
+
+func (s Subscription) match(ch grpclient.Change) bool {
+	if len(s.Events) > 0 && !slices.Contains(s.Events, ch.Op) {
+		return false
+	}
+
+	// moved file matches by either its new or its previous path
+	return s.matchName(ch.Name) || (ch.From != "" && s.matchName(ch.From))
+}
+
+func (s Subscription) matchName(name string) bool {
+	if name == s.Path || path.Dir(name) == s.Path {
+		return true
+	}
+	if !s.Recursive {
+		return false
+	}
+
+	return s.Path == "." || strings.HasPrefix(name, s.Path+"/")
+}
+
+// public returns subscription without its secret
+func (s Subscription) public() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Delivery is a change sent to one subscription
+type Delivery struct {
+	ID           string           `json:"id"`
+	Subscription string           `json:"subscription"`
+	Change       grpclient.Change `json:"change"`
+	Status       string           `json:"status"`
+	Attempts     int              `json:"attempts"`
+	LastStatus   int              `json:"last_status,omitempty"`
+	LastError    string           `json:"last_error,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	NextAttempt  time.Time        `json:"next_attempt"`
+	FinishedAt   *time.Time       `json:"finished_at,omitempty"`
+}
+
+type Options struct {
+	// Dir keeps subscriptions created by API, pending deliveries,
+	// delivery history and journal cursor
+	Dir           string
+	Subscriptions []Subscription
+	PollInterval  time.Duration
+	// BatchSize is a number of changes read from journals at once
+	BatchSize      int
+	Workers        int
+	Timeout        time.Duration
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// HistoryLimit is a number of finished deliveries kept for inspection
+	HistoryLimit int
+}
+
+// Dispatcher reads changes from journals, queues deliveries for matching
+// subscriptions and sends them, retrying failed ones with backoff
+type Dispatcher struct {
+	log    *slog.Logger
+	source Source
+	client *http.Client
+	opts   Options
+	store  *store
+
+	mu       sync.Mutex
+	subs     map[string]Subscription
+	pending  map[string]*Delivery
+	inflight map[string]struct{}
+	history  []Delivery
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(log *slog.Logger, source Source, opts Options) (*Dispatcher, error) {
+	const op = "webhook.New"
+
+	st, err := openStore(opts.Dir, opts.HistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		log:      log,
+		source:   source,
+		client:   &http.Client{Timeout: opts.Timeout},
+		opts:     opts,
+		store:    st,
+		subs:     make(map[string]Subscription),
+		pending:  make(map[string]*Delivery),
+		inflight: make(map[string]struct{}),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	for _, sub := range opts.Subscriptions {
+		sub.Static = true
+		if err := sub.validate(); err != nil {
+			return nil, fmt.Errorf("%s: subscription %s: %w", op, sub.ID, err)
+		}
+		d.subs[sub.ID] = sub
+	}
+
+	subs, err := st.loadSubscriptions()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for _, sub := range subs {
+		if _, ok := d.subs[sub.ID]; ok {
+			log.Warn("subscription is configured statically, ignoring stored one", slog.String("id", sub.ID))
+			continue
+		}
+		d.subs[sub.ID] = sub
+	}
+
+	pending, err := st.loadPending()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for _, dl := range pending {
+		d.pending[dl.ID] = dl
+	}
+
+	d.history, err = st.loadHistory()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("webhooks are enabled",
+		slog.Int("subscriptions", len(d.subs)),
+		slog.Int("pending deliveries", len(d.pending)),
+		slog.String("op", op),
+	)
+	return d, nil
+}
+
+// Subscriptions returns all subscriptions without secrets
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		subs = append(subs, sub.public())
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+
+	return subs
+}
+
+// Subscribe stores new subscription. Missing id and secret are generated.
+// Returned subscription contains the secret, it is not shown later
+func (d *Dispatcher) Subscribe(sub Subscription) (Subscription, error) {
+	const op = "webhook.Subscribe"
+
+	sub.Static = false
+	if sub.Path == "" {
+		sub.Path = "."
+	}
+	if err := sub.validate(); err != nil {
+		return Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var err error
+	if sub.ID == "" {
+		if sub.ID, err = newID(); err != nil {
+			return Subscription{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if sub.Secret == "" {
+		if sub.Secret, err = newSecret(); err != nil {
+			return Subscription{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subs[sub.ID]; ok {
+		return Subscription{}, fmt.Errorf("%s: %w", op, ErrExists)
+	}
+
+	d.subs[sub.ID] = sub
+	if err := d.store.saveSubscriptions(d.subs); err != nil {
+		delete(d.subs, sub.ID)
+		return Subscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	d.log.Info("subscription is created", slog.String("id", sub.ID), slog.String("url", sub.URL))
+	return sub, nil
+}
+
+// Unsubscribe removes subscription. Its pending deliveries fail
+func (d *Dispatcher) Unsubscribe(id string) error {
+	const op = "webhook.Unsubscribe"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sub, ok := d.subs[id]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrNotFound)
+	}
+	if sub.Static {
+		return fmt.Errorf("%s: %w", op, ErrStatic)
+	}
+
+	delete(d.subs, id)
+	if err := d.store.saveSubscriptions(d.subs); err != nil {
+		d.subs[id] = sub
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	d.log.Info("subscription is removed", slog.String("id", id))
+	return nil
+}
+
+// Deliveries returns at most limit latest deliveries, pending ones first.
+// Empty subscription or status matches all deliveries
+func (d *Dispatcher) Deliveries(subscription string, status string, limit int) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	match := func(dl Delivery) bool {
+		return (subscription == "" || dl.Subscription == subscription) &&
+			(status == "" || dl.Status == status)
+	}
+
+	var res []Delivery
+	pending := make([]Delivery, 0, len(d.pending))
+	for _, dl := range d.pending {
+		pending = append(pending, *dl)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.After(pending[j].CreatedAt) })
+
+	for _, dl := range pending {
+		if len(res) == limit {
+			return res
+		}
+		if match(dl) {
+			res = append(res, dl)
+		}
+	}
+	for i := len(d.history) - 1; i >= 0; i-- {
+		if len(res) == limit {
+			return res
+		}
+		if match(d.history[i]) {
+			res = append(res, d.history[i])
+		}
+	}
+
+	return res
+}
+
+// MustRun is Run wrapper.
+// If Run ends with error panic occurs
+func (d *Dispatcher) MustRun() {
+	if err := d.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run reads changes and sends deliveries until Stop is called
+func (d *Dispatcher) Run() error {
+	const op = "webhook.Run"
+	log := d.log.With(slog.String("op", op))
+	log.Info("starting webhook dispatcher")
+
+	d.wg.Add(2)
+	go func() {
+		defer d.wg.Done()
+		d.poll(d.ctx)
+	}()
+	go func() {
+		defer d.wg.Done()
+		d.dispatch(d.ctx)
+	}()
+
+	d.wg.Wait()
+	return nil
+}
+
+// poll reads changes after stored cursor and queues deliveries of them.
+// Cursor is stored after deliveries are queued, so a change is not lost
+// by restart, but may be queued twice
+func (d *Dispatcher) poll(ctx context.Context) {
+	const op = "webhook.poll"
+	log := d.log.With(slog.String("op", op))
+
+	cursor, err := d.store.loadCursor()
+	if err != nil {
+		log.Error("failed to load cursor, starting from the last change", sl.Err(err))
+	}
+
+	failing := false
+	for {
+		var hasMore bool
+		cursor, hasMore, err = d.pollOnce(ctx, log, cursor)
+		switch {
+		case err == nil:
+			failing = false
+		case ctx.Err() != nil:
+			return
+		case !failing:
+			log.Error("failed to read changes, retrying", sl.Err(err))
+			failing = true
+		}
+
+		if hasMore && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.opts.PollInterval):
+		}
+	}
+}
+
+func (d *Dispatcher) pollOnce(ctx context.Context, log *slog.Logger, cursor string) (string, bool, error) {
+	if cursor == "" {
+		// deliveries start from the moment the dispatcher is enabled
+		_, head, _, err := d.source.ListChanges(ctx, "", 0)
+		if err != nil {
+			return "", false, err
+		}
+
+		return head, true, d.store.saveCursor(head)
+	}
+
+	changes, next, hasMore, err := d.source.ListChanges(ctx, cursor, d.opts.BatchSize)
+	if status.Code(err) == codes.OutOfRange {
+		log.Error("changes are compacted before delivery, they are lost", sl.Err(err))
+		return "", true, nil
+	}
+	if err != nil {
+		return cursor, false, err
+	}
+
+	for _, ch := range changes {
+		if err := d.enqueue(ch); err != nil {
+			return cursor, false, err
+		}
+	}
+	if len(changes) > 0 {
+		d.notify()
+	}
+
+	return next, hasMore, d.store.saveCursor(next)
+}
+
+// enqueue queues delivery of change for every matching subscription
+func (d *Dispatcher) enqueue(ch grpclient.Change) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for _, sub := range d.subs {
+		if !sub.match(ch) {
+			continue
+		}
+
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+
+		dl := &Delivery{
+			ID:           id,
+			Subscription: sub.ID,
+			Change:       ch,
+			Status:       StatusPending,
+			CreatedAt:    now,
+			NextAttempt:  now,
+		}
+		if err := d.store.savePending(dl); err != nil {
+			return err
+		}
+		d.pending[id] = dl
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch sends due deliveries by at most Workers at once
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	sem := make(chan struct{}, d.opts.Workers)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		for _, dl := range d.due() {
+			select {
+			case <-ctx.Done():
+				d.release(dl.ID)
+				return
+			case sem <- struct{}{}:
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				d.attempt(ctx, dl)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// due returns copies of pending deliveries which have to be sent now
+// and marks them in flight
+func (d *Dispatcher) due() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var res []Delivery
+	for id, dl := range d.pending {
+		if _, ok := d.inflight[id]; ok || dl.NextAttempt.After(now) {
+			continue
+		}
+
+		d.inflight[id] = struct{}{}
+		res = append(res, *dl)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+
+	return res
+}
+
+func (d *Dispatcher) release(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.inflight, id)
+}
+
+// attempt sends delivery once and records the result
+func (d *Dispatcher) attempt(ctx context.Context, dl Delivery) {
+	const op = "webhook.attempt"
+	log := d.log.With(slog.String("op", op), slog.String("delivery", dl.ID))
+	defer d.release(dl.ID)
+
+	d.mu.Lock()
+	sub, ok := d.subs[dl.Subscription]
+	d.mu.Unlock()
+
+	dl.Attempts++
+	if !ok {
+		dl.LastError = ErrNotFound.Error()
+		d.finish(log, dl, StatusFailed)
+		return
+	}
+
+	code, err := d.send(ctx, sub, dl)
+	if ctx.Err() != nil {
+		// stopped, delivery is retried after restart
+		return
+	}
+	dl.LastStatus = code
+	dl.LastError = ""
+	if err != nil {
+		dl.LastError = err.Error()
+	}
+
+	switch {
+	case err == nil:
+		d.finish(log, dl, StatusDelivered)
+	case dl.Attempts >= d.opts.MaxAttempts:
+		log.Warn("delivery failed", sl.Err(err), slog.Int("attempts", dl.Attempts))
+		d.finish(log, dl, StatusFailed)
+	default:
+		dl.NextAttempt = time.Now().Add(d.backoff(dl.Attempts))
+		d.retry(log, dl)
+	}
+}
+
+func (d *Dispatcher) retry(log *slog.Logger, dl Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.store.savePending(&dl); err != nil {
+		log.Error("failed to save delivery", sl.Err(err))
+	}
+	d.pending[dl.ID] = &dl
+}
+
+// finish moves delivery from pending to history
+func (d *Dispatcher) finish(log *slog.Logger, dl Delivery, status string) {
+	now := time.Now()
+	dl.Status = status
+	dl.FinishedAt = &now
+	dl.NextAttempt = time.Time{}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.store.appendHistory(dl); err != nil {
+		log.Error("failed to save delivery history", sl.Err(err))
+	}
+	if err := d.store.removePending(dl.ID); err != nil {
+		log.Error("failed to remove delivery", sl.Err(err))
+	}
+
+	delete(d.pending, dl.ID)
+	d.history = append(d.history, dl)
+	if over := len(d.history) - d.opts.HistoryLimit; over > 0 {
+		d.history = slices.Delete(d.history, 0, over)
+	}
+}
+
+// Stop stops reading changes and sending deliveries.
+// Pending deliveries are sent after restart
+func (d *Dispatcher) Stop() {
+	const op = "webhook.Stop"
+	d.log.Info("stopping webhook dispatcher", slog.String("op", op))
+
+	d.cancel()
+	d.wg.Wait()
+
+	if err := d.store.close(); err != nil {
+		d.log.Error("failed to close webhook store", sl.Err(err))
+	}
+}